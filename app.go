@@ -2,9 +2,15 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
 	"errors"
+	"io"
 	"os"
+	"os/signal"
 	"path"
+	"sort"
+	"strconv"
 
 	"gopkg.in/dedis/cothority.v1/cosi/check"
 	_ "gopkg.in/dedis/cothority.v1/cosi/protocol"
@@ -13,9 +19,8 @@ import (
 	"fmt"
 	"io/ioutil"
 
-	"net"
-
 	"strings"
+	"time"
 
 	"bufio"
 	"bytes"
@@ -24,7 +29,6 @@ import (
 	_ "github.com/dedis/cothority/pop/service"
 	"github.com/dedis/student_17_pop/service"
 	"gopkg.in/dedis/crypto.v0/abstract"
-	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
@@ -51,6 +55,9 @@ type Config struct {
 	// Map of Final statements of the parties.
 	// indexed by hash of party desciption
 	Parties map[string]*PartyConfig
+	// Map of the parties the attendee pre-joined and that are not
+	// finalized yet, indexed by hash of party description
+	Pending map[string]*PartyConfig
 	// config-file name
 	name string
 }
@@ -71,6 +78,11 @@ type PartyConfig struct {
 }
 
 func main() {
+	newApp().Run(os.Args)
+}
+
+// newApp returns the pop command line application.
+func newApp() *cli.App {
 	appCli := cli.NewApp()
 	appCli.Name = "Proof-of-personhood party"
 	appCli.Usage = "Handles party-creation, finalizing, pop-token creation, and verification"
@@ -80,6 +92,46 @@ func main() {
 		commandOrg,
 		commandAttendee,
 		commandAuth,
+		{
+			Name:      "diff",
+			Usage:     "Reports the differences between two final statements",
+			ArgsUsage: "final1.toml final2.toml",
+			Action:    diffStatements,
+		},
+		{
+			Name:      "hash",
+			Usage:     "Prints the hash of a party description, or compares it to the hash of another one",
+			ArgsUsage: "pop_desc.toml [merge_party.toml]",
+			Action:    descHash,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "compare,c",
+					Usage: "pop_desc2.toml of another organizer, with the same parties to merge",
+				},
+			},
+		},
+		{
+			Name:   "template",
+			Usage:  "Writes the skeleton of a pop_desc.toml to fill in",
+			Action: popTemplate,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "merge,m",
+					Usage: "add the parties to merge",
+				},
+			},
+		},
+		{
+			Name:      "check-roster",
+			Usage:     "Checks that the servers of a group definition are the conodes that signed a final statement",
+			ArgsUsage: "group.toml final.toml",
+			Action:    checkGroupRoster,
+		},
+		{
+			Name:   "selftest",
+			Usage:  "Runs a party on two local conodes to check the installation",
+			Action: selftest,
+		},
 		{
 			Name:      "check",
 			Aliases:   []string{"c"},
@@ -101,12 +153,21 @@ func main() {
 			Value: "~/.config/cothority/pop",
 			Usage: "The configuration-directory of pop",
 		},
+		cli.StringFlag{
+			Name:  "output-dir",
+			Usage: "org final and org merge write the statement to {hash}.toml in this directory",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			EnvVar: "POP_TOKEN",
+			Usage:  "the AuthToken of the conodes, if they require one",
+		},
 	}
 	appCli.Before = func(c *cli.Context) error {
 		log.SetDebugVisible(c.Int("debug"))
 		return nil
 	}
-	appCli.Run(os.Args)
+	return appCli
 }
 
 // links this pop to a cothority
@@ -117,25 +178,25 @@ func orgLink(c *cli.Context) error {
 	}
 	cfg, client := getConfigClient(c)
 
-	host, port, err := net.SplitHostPort(c.Args().First())
+	addr, err := service.ResolveAddress(c.Args().First())
 	if err != nil {
 		return err
 	}
-	addrs, err := net.LookupHost(host)
-	if err != nil {
-		return err
+	reply, cerr := client.Link(c.Args().First(), c.Args().Get(1), cfg.OrgPublic)
+	if cerr != nil {
+		return cerr
 	}
-	addr := network.NewTCPAddress(fmt.Sprintf("%s:%s", addrs[0], port))
-	pin := c.Args().Get(1)
-	if err := client.PinRequest(addr, pin, cfg.OrgPublic); err != nil {
-		if err.ErrorCode() == service.ErrorWrongPIN && pin == "" {
-			log.Info("Please read PIN in server-log")
-			return nil
-		}
-		return err
+	if reply == nil {
+		log.Info("Please read PIN in server-log")
+		return nil
 	}
 	cfg.Address = addr
-	log.Info("Successfully linked with", addr)
+	if reply.Relink {
+		log.Info("Successfully linked with", reply.Conode, "- it is not "+
+			"linked to its previous organizer anymore")
+	} else {
+		log.Info("Successfully linked with", reply.Conode)
+	}
 	cfg.write()
 	return nil
 }
@@ -152,20 +213,10 @@ func orgConfig(c *cli.Context) error {
 		log.Fatal("No address")
 		return errors.New("No address found - please link first")
 	}
-	desc := &service.PopDesc{}
-	pdFile := c.Args().First()
-	buf, err := ioutil.ReadFile(pdFile)
-	log.ErrFatal(err, "While reading", pdFile)
-	err = decodePopDesc(string(buf), desc)
-	log.ErrFatal(err, "While decoding", pdFile)
+	desc, err := readDesc(c.Args().First(), c.Args().Get(1))
+	log.ErrFatal(err)
 	//desc.Roster = readGroup(c.Args().Get(1))
 	if c.NArg() == 2 {
-		mergeFile := c.Args().Get(1)
-		buf, err = ioutil.ReadFile(mergeFile)
-		log.ErrFatal(err, "While reading", mergeFile)
-		desc.Parties, err = decodeGroups(string(buf))
-		log.ErrFatal(err, "While decoding ", mergeFile)
-
 		// Check that current party is included in merge config
 		found := false
 		for _, party := range desc.Parties {
@@ -177,6 +228,9 @@ func orgConfig(c *cli.Context) error {
 		if !found {
 			log.Fatal("party is not included in merge config")
 		}
+		if c.Bool("compact") {
+			desc = desc.Compact()
+		}
 	}
 	hash := base64.StdEncoding.EncodeToString(desc.Hash())
 	log.Infof("Hash of config: %s", hash)
@@ -203,6 +257,23 @@ func orgConfig(c *cli.Context) error {
 
 // adds a public key to the list
 func orgPublic(c *cli.Context) error {
+	if csvFile := c.String("csv"); csvFile != "" {
+		if c.NArg() < 1 {
+			log.Fatal("Please give the hash of a party")
+		}
+		cfg, _ := getConfigClient(c)
+		party, err := cfg.getPartybyHash(c.Args().First())
+		log.ErrFatal(err)
+		f, err := os.Open(csvFile)
+		log.ErrFatal(err)
+		defer f.Close()
+		pubs, err := readRegistrations(f, party.Final.Desc.Hash())
+		log.ErrFatal(err, "While reading", csvFile)
+		log.Info("Org: Adding", len(pubs), "verified public keys")
+		log.ErrFatal(addAttendees(party, pubs))
+		cfg.write()
+		return nil
+	}
 	if c.NArg() < 2 {
 		log.Fatal("Please give a public key and hash of a party")
 	}
@@ -221,22 +292,136 @@ func orgPublic(c *cli.Context) error {
 	cfg, _ := getConfigClient(c)
 	party, err := cfg.getPartybyHash(c.Args().Get(1))
 	log.ErrFatal(err)
+	var pubs []abstract.Point
 	for _, k := range keys {
 		pub, err := crypto.String64ToPub(network.Suite, k)
 		if err != nil {
 			log.Fatal("Couldn't parse public key:", k, err)
 		}
-		for _, p := range party.Final.Attendees {
-			if p.Equal(pub) {
-				log.Fatal("This key already exists")
-			}
-		}
-		party.Final.Attendees = append(party.Final.Attendees, pub)
+		pubs = append(pubs, pub)
 	}
+	log.ErrFatal(addAttendees(party, pubs))
 	cfg.write()
 	return nil
 }
 
+// addAttendees adds the public keys to the attendees of the party. It
+// fails if a key is already present.
+func addAttendees(party *PartyConfig, pubs []abstract.Point) error {
+	for _, pub := range pubs {
+		if err := party.AddAttendee(pub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registrationMsg returns the message an attendee signs to prove that it
+// controls its key when registering for the party with the given hash.
+func registrationMsg(partyHash []byte) []byte {
+	return append([]byte("PoP registration:"), partyHash...)
+}
+
+// registrationRow returns a csv-row with the public key of the attendee
+// and its Schnorr signature of registrationMsg, both encoded in base64.
+func registrationRow(priv abstract.Scalar, partyHash []byte) (string, error) {
+	pub, err := crypto.PubToString64(nil, network.Suite.Point().Mul(nil, priv))
+	if err != nil {
+		return "", err
+	}
+	sig, err := crypto.SignSchnorr(network.Suite, priv, registrationMsg(partyHash))
+	if err != nil {
+		return "", err
+	}
+	return pub + "," + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// readRegistrations reads the csv-rows created by registrationRow and
+// returns the public keys. It fails if any row is malformed or holds an
+// invalid signature, so that no key is registered without the consent of
+// its owner.
+func readRegistrations(r io.Reader, partyHash []byte) ([]abstract.Point, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = 2
+	rd.TrimLeadingSpace = true
+	rows, err := rd.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	pubs := make([]abstract.Point, len(rows))
+	for i, row := range rows {
+		pubs[i], err = crypto.String64ToPub(network.Suite, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid public key: %s", i+1, err)
+		}
+		sig, err := base64.StdEncoding.DecodeString(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid signature: %s", i+1, err)
+		}
+		err = crypto.VerifySchnorr(network.Suite, pubs[i], registrationMsg(partyHash),
+			sig)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: wrong signature: %s", i+1, err)
+		}
+	}
+	return pubs, nil
+}
+
+// prints a signed registration for the organizers
+func attRegister(c *cli.Context) error {
+	log.Info("att: register")
+	if c.NArg() < 2 {
+		log.Fatal("Please give private key and party hash")
+	}
+	privBuf, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	priv := network.Suite.Scalar()
+	log.ErrFatal(priv.UnmarshalBinary(privBuf))
+	partyHash, err := base64.StdEncoding.DecodeString(c.Args().Get(1))
+	log.ErrFatal(err)
+	row, err := registrationRow(priv, partyHash)
+	log.ErrFatal(err)
+	log.Infof("Registration:\n%s", row)
+	return nil
+}
+
+// creates a new key pair and signs the rotation to it with the old key
+func attRotate(c *cli.Context) error {
+	log.Info("att: rotate")
+	if c.NArg() < 2 {
+		log.Fatal("Please give private key and party hash")
+	}
+	privBuf, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	oldPriv := network.Suite.Scalar()
+	log.ErrFatal(oldPriv.UnmarshalBinary(privBuf))
+	hashStr := c.Args().Get(1)
+	partyHash, err := base64.StdEncoding.DecodeString(hashStr)
+	log.ErrFatal(err)
+	priv := network.Suite.NewKey(random.Stream)
+	pub := network.Suite.Point().Mul(nil, priv)
+	msg, err := service.RotationMsg(partyHash, pub)
+	log.ErrFatal(err)
+	proof, err := crypto.SignSchnorr(network.Suite, oldPriv, msg)
+	log.ErrFatal(err)
+	oldStr, err := crypto.PubToString64(nil, network.Suite.Point().Mul(nil, oldPriv))
+	log.ErrFatal(err)
+	privStr, err := crypto.ScalarToString64(nil, priv)
+	log.ErrFatal(err)
+	pubStr, err := crypto.PubToString64(nil, pub)
+	log.ErrFatal(err)
+	cfg, _ := getConfigClient(c)
+	if party, ok := cfg.Pending[hashStr]; ok && party.Private.Equal(oldPriv) {
+		party.Private = priv
+		party.Public = pub
+		cfg.write()
+	}
+	log.Infof("Private: %s\nPublic: %s\nRotation:\n%s %s %s %s", privStr,
+		pubStr, hashStr, oldStr, pubStr,
+		base64.StdEncoding.EncodeToString(proof))
+	return nil
+}
+
 // finalizes the statement
 func orgFinal(c *cli.Context) error {
 	log.Info("Org: Final")
@@ -254,19 +439,51 @@ func orgFinal(c *cli.Context) error {
 	party, err := cfg.getPartybyHash(c.Args().First())
 	log.ErrFatal(err)
 	if len(party.Final.Signature) > 0 {
-		finst, err := party.Final.ToToml()
+		finst, err := encodeFinal(party.Final, "toml")
 		log.ErrFatal(err)
 		log.Info("Final statement already here:\n", "\n"+string(finst))
+		log.ErrFatal(writeStatement(statementPath(c), party.Final))
 		return nil
 	}
-	fs, cerr := client.Finalize(cfg.Address, party.Final.Desc,
-		party.Final.Attendees, cfg.OrgPrivate)
+	if file := c.String("attendees-file"); file != "" {
+		atts, err := service.ReadAttendeesFile(file)
+		log.ErrFatal(err)
+		log.Infof("Read %d attendees from %s", len(atts), file)
+		party.Final.Attendees = atts
+	}
+	if c.Bool("preview") {
+		kept, dropped, cerr := client.PreviewFinalize(cfg.Address,
+			party.Final.Desc, party.Final.Attendees, cfg.OrgPrivate,
+			c.Bool("strict"))
+		log.ErrFatal(cerr)
+		log.Infof("Finalizing would keep %d attendees and drop %d:",
+			len(kept), len(dropped))
+		if len(kept) < c.Int("min") {
+			log.Warnf("Less than the minimum of %d attendees would be kept",
+				c.Int("min"))
+		}
+		return writeAttendees(os.Stdout, dropped)
+	}
+	res, cerr := client.FinalizeWithMin(cfg.Address, party.Final.Desc,
+		party.Final.Attendees, cfg.OrgPrivate, c.Bool("strict"), c.Int("min"))
+	if cerr != nil {
+		switch cerr.ErrorCode() {
+		case service.ErrorOtherFinals:
+			log.Info("Use 'org status' to see which conodes didn't finalize yet")
+		case service.ErrorTooFewAttendees:
+			log.Info("Use 'org final --preview' to see which attendees are dropped")
+		}
+	}
 	log.ErrFatal(cerr)
-	party.Final = fs
+	fs := res.Final
+	log.ErrFatal(party.SetFinal(fs))
 	cfg.write()
-	finst, err := fs.ToToml()
+	finst, err := encodeFinal(fs, "toml")
 	log.ErrFatal(err)
 	log.Info("Created final statement:\n", "\n"+string(finst))
+	log.ErrFatal(writeStatement(statementPath(c), fs))
+	log.Infof("Signed with %d conodes, %d attendees", res.NumConodes,
+		res.NumAttendees)
 	return nil
 }
 
@@ -290,195 +507,1243 @@ func orgMerge(c *cli.Context) error {
 		if len(fs.Signature) <= 0 || fs.Verify() != nil {
 			log.Fatal("Fetched final statement is invalid")
 		}
-		party.Final = fs
+		log.ErrFatal(party.SetFinal(fs))
 		cfg.write()
 	}
 	if party.Final.Merged {
-		finst, err := party.Final.ToToml()
+		finst, err := encodeFinal(party.Final, "toml")
 		log.ErrFatal(err)
 		log.Info("Merged final statement:\n", "\n"+string(finst))
+		log.ErrFatal(writeStatement(statementPath(c), party.Final))
 		return nil
 	}
 	if len(party.Final.Desc.Parties) <= 0 {
 		log.Fatal("there is no parties to merge")
 	}
 
-	fs, err := client.Merge(cfg.Address, party.Final.Desc, cfg.OrgPrivate)
-	if err != nil {
-		return err
+	merge := client.Merge
+	if c.Bool("partial") {
+		merge = client.MergePartial
+	}
+	fs, cerr := merge(cfg.Address, party.Final.Desc, cfg.OrgPrivate)
+	if cerr != nil && cerr.ErrorCode() != service.ErrorTimeout {
+		return cerr
+	}
+	if cerr != nil || !fs.Merged {
+		log.Info("Waiting for the merge to complete")
+		fs, cerr = client.WaitForMerge(cfg.Address, party.Final.Desc.Hash(),
+			service.TIMEOUT)
+		if cerr != nil {
+			return cerr
+		}
 	}
-	party.Final = fs
+	log.ErrFatal(party.SetFinal(fs))
 	cfg.write()
-	finst, err := fs.ToToml()
+	for _, p := range fs.DroppedParties() {
+		log.Warn("Party at", p.Location, "was dropped from the merge")
+	}
+	finst, err := encodeFinal(fs, "toml")
 	log.ErrFatal(err)
 	log.Info("Created merged final statement:\n", "\n"+string(finst))
+	log.ErrFatal(writeStatement(statementPath(c), fs))
 	return nil
 }
 
-// creates a new private/public pair
-func attCreate(c *cli.Context) error {
-	priv := network.Suite.NewKey(random.Stream)
-	pub := network.Suite.Point().Mul(nil, priv)
-	privStr, err := crypto.ScalarToString64(nil, priv)
+// statementPath returns the file given by --out or, if --output-dir is
+// given, the file named after the party hash in that directory. It returns
+// an empty string if the statement is not to be written.
+func statementPath(c *cli.Context) string {
+	if out := c.String("out"); out != "" {
+		return out
+	}
+	if dir := c.GlobalString("output-dir"); dir != "" {
+		return path.Join(dir, statementFileName(c.Args().First()))
+	}
+	return ""
+}
+
+// statementFileName returns "{hash}.toml" for the base64 party hash, with
+// the characters that are not allowed in file names replaced.
+func statementFileName(hash string) string {
+	return strings.NewReplacer("/", "_", "+", "-").Replace(hash) + ".toml"
+}
+
+// writeStatement writes the final statement in toml to the file, nothing
+// is done if the name is empty.
+func writeStatement(name string, fs *service.FinalStatement) error {
+	if name == "" {
+		return nil
+	}
+	buf, err := encodeFinal(fs, "toml")
 	if err != nil {
 		return err
 	}
-	pubStr, err := crypto.PubToString64(nil, pub)
-	if err != nil {
+	if err = writeFileAtomic(name, buf, 0660); err != nil {
 		return err
 	}
-	log.Infof("Private: %s\nPublic: %s", privStr, pubStr)
+	log.Infof("Wrote final statement to %s", name)
 	return nil
 }
 
-// joins a poparty
-func attJoin(c *cli.Context) error {
-	log.Info("att: join")
-	if c.NArg() < 2 {
-		log.Fatal("Please give private key and final.toml")
+// writeFileAtomic writes buf to a temporary file in the directory of name
+// and renames it, so that name is never left half written.
+func writeFileAtomic(name string, buf []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(path.Dir(name), path.Base(name)+".tmp")
+	if err != nil {
+		return err
 	}
-	privStr := c.Args().First()
-	privBuf, err := base64.StdEncoding.DecodeString(privStr)
-	log.ErrFatal(err)
-	priv := network.Suite.Scalar()
-	log.ErrFatal(priv.UnmarshalBinary(privBuf))
-	cfg, client := getConfigClient(c)
-
-	finalName := c.Args().Get(1)
-	buf, err := ioutil.ReadFile(finalName)
-	log.ErrFatal(err)
-	final, err := service.NewFinalStatementFromToml(buf)
-	log.ErrFatal(err)
-	log.Info("final.verify()", final.Verify())
-	if len(final.Signature) <= 0 || final.Verify() != nil {
-		log.Lvl2("The local config is not finished yet")
-		log.Lvl2("Fetching final statement")
-		// Need to get the updated version of party config
-		// Cause attendee doesn't know,
-		// whether it has finished successfully or not
-		fs, err := client.FetchFinal(cfg.Address, final.Desc.Hash())
-		log.ErrFatal(err)
-		if len(fs.Signature) <= 0 || fs.Verify() != nil {
-			log.Fatal("Fetched final statement is invalid")
-		}
-		final = fs
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	if len(final.Desc.Parties) > 0 && !final.Merged {
-		log.Lvl2("The local party is not merged yet")
-		log.Lvl2("Fetching final statement")
-		fs, err := client.FetchFinal(cfg.Address, final.Desc.Hash())
-		log.ErrFatal(err)
-		if !fs.Merged {
-			log.Fatal("Global party is not merged")
-		}
-		if len(fs.Signature) <= 0 || fs.Verify() != nil {
-			log.Fatal("Fetched final statement is invalid")
-		}
-		final = fs
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
 	}
-	party := &PartyConfig{}
-	party.Final = final
-	party.Private = priv
-	party.Public = network.Suite.Point().Mul(nil, priv)
-	index := -1
-	for i, p := range party.Final.Attendees {
-		if p.Equal(party.Public) {
-			log.Info("Found public key at index", i)
-			index = i
-		}
+	if err = tmp.Close(); err != nil {
+		return err
 	}
-	if index == -1 {
-		log.Fatal("Didn't find our public key in the final statement!")
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
 	}
-	party.Index = index
-	hash := base64.StdEncoding.EncodeToString(final.Desc.Hash())
-	log.Infof("Final statement hash: %s", hash)
-	if !c.Bool("yes") {
-		fmt.Printf("Is it correct hash(y/n)")
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		c := strings.ToLower(string([]byte(input)[0]))
-		if c == "n" {
-			return nil
-		}
+	return os.Rename(tmp.Name(), name)
+}
+
+// hands a party over to another organizer
+func orgTransfer(c *cli.Context) error {
+	log.Info("Org: Transfer")
+	if c.NArg() < 2 {
+		log.Fatal("Please give party-hash and public key of the new organizer")
 	}
-	cfg.Parties[hash] = party
-	cfg.write()
-	log.Infof("Stored final statement")
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	pub, err := crypto.String64ToPub(network.Suite, c.Args().Get(1))
+	log.ErrFatal(err)
+	log.ErrFatal(client.TransferParty(cfg.Address, hash, pub, cfg.OrgPrivate))
+	log.Info("Transferred party", c.Args().First())
 	return nil
 }
 
-// signs a message + context
-func attSign(c *cli.Context) error {
-	log.Info("att: sign")
-	cfg, _ := getConfigClient(c)
-	if c.NArg() < 3 {
-		log.Fatal("Please give msg, context and party hash")
+// removes an attendee from a finalized party and signs the statement again
+func orgRevoke(c *cli.Context) error {
+	log.Info("Org: Revoke")
+	if c.NArg() < 2 {
+		log.Fatal("Please give party-hash and public key of the attendee")
 	}
-	log.Info("hash:", c.Args().Get(2))
-	party, err := cfg.getPartybyHash(c.Args().Get(2))
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
 	log.ErrFatal(err)
-
-	if party.Index == -1 || party.Private == nil || party.Public == nil ||
-		!network.Suite.Point().Mul(nil, party.Private).Equal(party.Public) {
-		log.Fatal("No public key stored. Please join a party")
+	pub, err := crypto.String64ToPub(network.Suite, c.Args().Get(1))
+	log.ErrFatal(err)
+	fs, cerr := client.RevokeAttendee(cfg.Address, hash, pub, cfg.OrgPrivate)
+	if cerr != nil && cerr.ErrorCode() == service.ErrorOtherFinals {
+		log.Info("The attendee is revoked once the organizers of all " +
+			"conodes of the party revoked it")
 	}
-
-	if len(party.Final.Signature) < 0 || party.Final.Verify() != nil {
-		log.Fatal("Party is not finilized or signature is not valid")
+	log.ErrFatal(cerr)
+	if party, err := cfg.getPartybyHash(c.Args().First()); err == nil {
+		log.ErrFatal(party.SetFinal(fs))
+		cfg.write()
 	}
-
-	msg := []byte(c.Args().First())
-	ctx := []byte(c.Args().Get(1))
-	Set := anon.Set(party.Final.Attendees)
-	sigtag := anon.Sign(network.Suite, random.Stream, msg,
-		Set, ctx, party.Index, party.Private)
-	sig := sigtag[:len(sigtag)-32]
-	tag := sigtag[len(sigtag)-32:]
-	log.Infof("\nSignature: %s\nTag: %s", base64.StdEncoding.EncodeToString(sig),
-		base64.StdEncoding.EncodeToString(tag))
+	finst, err := encodeFinal(fs, "toml")
+	log.ErrFatal(err)
+	log.Info("Created final statement:\n", "\n"+string(finst))
+	log.Infof("Revoked the attendee in epoch %d - its tokens don't "+
+		"verify against this statement", fs.Epoch)
 	return nil
 }
 
-// verifies a signature and tag
-func attVerify(c *cli.Context) error {
-	log.Info("att: verify")
-	cfg, _ := getConfigClient(c)
-	if c.NArg() < 5 {
-		log.Fatal("Please give a msg, context, signature, a tag and party hash")
+// replaces the key of an attendee before the finalization
+func orgRotate(c *cli.Context) error {
+	log.Info("Org: Rotate")
+	if c.NArg() < 4 {
+		log.Fatal("Please give party-hash, old and new public key of the " +
+			"attendee and the proof")
 	}
-	party, err := cfg.getPartybyHash(c.Args().Get(4))
-	log.ErrFatal(err)
-
-	if len(party.Final.Signature) < 0 || party.Final.Verify() != nil {
-		log.Fatal("Party is not finilized or signature is not valid")
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
 	}
-
-	msg := []byte(c.Args().First())
-	ctx := []byte(c.Args().Get(1))
-	sig, err := base64.StdEncoding.DecodeString(c.Args().Get(2))
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
 	log.ErrFatal(err)
-	tag, err := base64.StdEncoding.DecodeString(c.Args().Get(3))
+	oldPub, err := crypto.String64ToPub(network.Suite, c.Args().Get(1))
 	log.ErrFatal(err)
-	sigtag := append(sig, tag...)
-	ctag, err := anon.Verify(network.Suite, msg,
-		anon.Set(party.Final.Attendees), ctx, sigtag)
+	newPub, err := crypto.String64ToPub(network.Suite, c.Args().Get(2))
 	log.ErrFatal(err)
-	if !bytes.Equal(tag, ctag) {
-		log.Fatalf("Tag and calculated tag are not equal:\n%x - %x", tag, ctag)
+	proof, err := base64.StdEncoding.DecodeString(c.Args().Get(3))
+	log.ErrFatal(err)
+	log.ErrFatal(client.RotateAttendee(cfg.Address, hash, oldPub, newPub,
+		proof, cfg.OrgPrivate))
+	// Finalize with the new key from now on
+	if party, err := cfg.getPartybyHash(c.Args().First()); err == nil {
+		for i, a := range party.Final.Attendees {
+			if a.Equal(oldPub) {
+				party.Final.Attendees[i] = newPub
+			}
+		}
+		cfg.write()
 	}
-	log.Info("Successfully verified signature and tag")
+	log.Info("Rotated the key of the attendee")
 	return nil
 }
 
-func authStore(c *cli.Context) error {
-	log.Info("auth: store")
-	cfg, client := getConfigClient(c)
+// sends the final statement again to all conodes of the party
+func orgRepropagate(c *cli.Context) error {
+	log.Info("Org: Repropagate")
 	if c.NArg() < 1 {
-		log.Fatal("Please give a final.toml")
+		log.Fatal("Please give party-hash")
 	}
-
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	replies, cerr := client.Repropagate(cfg.Address, hash, cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	log.Infof("Final statement stored on %d conodes", replies)
+	return nil
+}
+
+// unites the attendees registered on all conodes of the party
+func orgReconcile(c *cli.Context) error {
+	log.Info("Org: Reconcile")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party-hash")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	num, cerr := client.ReconcileAttendees(cfg.Address, hash, cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	log.Infof("%d attendees registered on all conodes", num)
+	return nil
+}
+
+// shows the state of the party on all its conodes
+func orgStatus(c *cli.Context) error {
+	log.Info("Org: Status")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party-hash")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	conodes, cerr := client.FinalizeStatus(cfg.Address, hash)
+	log.ErrFatal(cerr)
+	return writeStatus(os.Stdout, conodes)
+}
+
+// writeStatus writes one line per conode with the state of the party.
+func writeStatus(w io.Writer, conodes []service.ConodeStatus) error {
+	for _, cs := range conodes {
+		var state string
+		switch {
+		case !cs.Reachable:
+			state = "unreachable"
+		case !cs.Exists:
+			state = "no config stored"
+		case cs.Finalized:
+			state = "finalized"
+		case cs.NumAttendees == 0:
+			state = "not finalized - its organizer didn't send the attendees"
+		default:
+			state = fmt.Sprintf("not finalized - has %d attendees",
+				cs.NumAttendees)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", cs.Conode.Address, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prints the changes of the state of the party until it is merged
+func orgWatch(c *cli.Context) error {
+	log.Info("Org: Watch")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party-hash")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	stop := make(chan struct{})
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+	poll := func() (*partyState, error) {
+		return fetchPartyState(client, cfg.Address, hash)
+	}
+	return watchParty(os.Stdout, poll, c.Duration("interval"), stop)
+}
+
+// partyState is the state of a party on one conode, as shown by org watch.
+type partyState struct {
+	Attendees int
+	Finalized bool
+	Merged    bool
+	// Mergeable is true if the party has other parties to merge with.
+	Mergeable bool
+}
+
+// done returns true if the state of the party can't change anymore,
+// except by reopening it.
+func (ps *partyState) done() bool {
+	return ps.Merged || (ps.Finalized && !ps.Mergeable)
+}
+
+// fetchPartyState returns the state of the party on the conode at dst. The
+// attendees of a party that isn't finalized are the ones its organizer sent
+// to that conode.
+func fetchPartyState(client *service.Client, dst network.Address,
+	hash []byte) (*partyState, error) {
+	fs, cerr := client.FetchFinal(dst, hash)
+	if cerr == nil {
+		return &partyState{
+			Attendees: len(fs.Attendees),
+			Finalized: fs.Verify() == nil,
+			Merged:    fs.Merged,
+			Mergeable: len(fs.Desc.Parties) > 1,
+		}, nil
+	}
+	if cerr.ErrorCode() != service.ErrorOtherFinals {
+		return nil, cerr
+	}
+	conodes, cerr := client.FinalizeStatus(dst, hash)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, cs := range conodes {
+		if cs.Conode.Address == dst {
+			return &partyState{Attendees: cs.NumAttendees}, nil
+		}
+	}
+	return nil, errors.New("conode is not in the roster of the party")
+}
+
+// watchParty polls the state of the party every interval and writes a line
+// to w for every change, until the party is done or stop is closed.
+func watchParty(w io.Writer, poll func() (*partyState, error),
+	interval time.Duration, stop <-chan struct{}) error {
+	var last *partyState
+	for {
+		ps, err := poll()
+		if err != nil {
+			return err
+		}
+		for _, line := range stateChanges(last, ps) {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		if ps.done() {
+			return nil
+		}
+		last = ps
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// stateChanges describes the transitions from old to ps, or the whole
+// state if old is nil.
+func stateChanges(old, ps *partyState) []string {
+	if old == nil {
+		lines := []string{fmt.Sprintf("%d attendees", ps.Attendees)}
+		if ps.Finalized {
+			lines = append(lines, "finalized")
+		}
+		if ps.Merged {
+			lines = append(lines, "merged")
+		}
+		return lines
+	}
+	var lines []string
+	if ps.Attendees != old.Attendees {
+		lines = append(lines, fmt.Sprintf("attendees: %d -> %d",
+			old.Attendees, ps.Attendees))
+	}
+	if ps.Finalized != old.Finalized {
+		if ps.Finalized {
+			lines = append(lines, "finalized")
+		} else {
+			lines = append(lines, "reopened")
+		}
+	}
+	if ps.Merged && !old.Merged {
+		lines = append(lines, "merged")
+	}
+	return lines
+}
+
+// looks up the hashes of parties by name and date
+func orgFind(c *cli.Context) error {
+	log.Info("Org: Find")
+	if c.NArg() < 1 {
+		log.Fatal("Please give the name of the party")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	ids, cerr := client.FindParty(cfg.Address, c.Args().First(), c.Args().Get(1))
+	log.ErrFatal(cerr)
+	for _, id := range ids {
+		log.Infof("Party hash: %s", base64.StdEncoding.EncodeToString(id))
+	}
+	return nil
+}
+
+// asks the conode to verify its final statements
+func orgAudit(c *cli.Context) error {
+	log.Info("Org: Audit")
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	failed, cerr := client.Audit(cfg.Address)
+	log.ErrFatal(cerr)
+	if len(failed) == 0 {
+		log.Info("All final statements verify")
+		return nil
+	}
+	for _, hash := range failed {
+		log.Errorf("Final statement doesn't verify: %s",
+			base64.StdEncoding.EncodeToString(hash))
+	}
+	return fmt.Errorf("%d final statements don't verify", len(failed))
+}
+
+// removes the parties that have been merged from the linked conode
+func orgPrune(c *cli.Context) error {
+	log.Info("Org: Prune")
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	removed, cerr := client.PruneMerged(cfg.Address, c.Bool("force"), cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	for _, hash := range removed {
+		log.Infof("Removed merged party: %s",
+			base64.StdEncoding.EncodeToString(hash))
+	}
+	log.Infof("Removed %d parties", len(removed))
+	return nil
+}
+
+// lists the parties with operations on the linked conode waiting for
+// other conodes
+func orgPending(c *cli.Context) error {
+	log.Info("Org: Pending")
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	pending, cerr := client.ListPending(cfg.Address, cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	for _, hash := range pending {
+		log.Infof("Pending party: %s", base64.StdEncoding.EncodeToString(hash))
+	}
+	log.Infof("%d parties with pending operations", len(pending))
+	return nil
+}
+
+// aborts the operations of a party on the linked conode that wait for
+// other conodes
+func orgCancel(c *cli.Context) error {
+	log.Info("Org: Cancel")
+	if c.NArg() < 1 {
+		log.Fatal("Please give the party hash")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	log.ErrFatal(client.CancelPending(cfg.Address, hash, cfg.OrgPrivate))
+	log.Info("Cancelled the pending operations")
+	return nil
+}
+
+// writes a dump of the parties of the organizer on the linked conode to a
+// file
+func orgBackup(c *cli.Context) error {
+	log.Info("Org: Backup")
+	if c.NArg() < 1 {
+		log.Fatal("Please give the file to write the backup to")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	dump, cerr := client.Backup(cfg.Address, cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	log.ErrFatal(ioutil.WriteFile(c.Args().First(), dump, 0600))
+	log.Infof("Wrote backup to %s", c.Args().First())
+	return nil
+}
+
+// adds the parties of a backup to the linked conode
+func orgRestore(c *cli.Context) error {
+	log.Info("Org: Restore")
+	if c.NArg() < 1 {
+		log.Fatal("Please give the backup file")
+	}
+	cfg, client := getConfigClient(c)
+	if cfg.Address == "" {
+		log.Fatal("Not linked")
+	}
+	dump, err := ioutil.ReadFile(c.Args().First())
+	log.ErrFatal(err)
+	restored, cerr := client.Restore(cfg.Address, dump, cfg.OrgPrivate)
+	log.ErrFatal(cerr)
+	for _, hash := range restored {
+		log.Infof("Restored party: %s", base64.StdEncoding.EncodeToString(hash))
+	}
+	log.Infof("Restored %d parties", len(restored))
+	return nil
+}
+
+// writes the final statement in the requested format
+func orgExport(c *cli.Context) error {
+	log.Info("Org: Export")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party-hash")
+	}
+	cfg, _ := getConfigClient(c)
+	party, err := cfg.getPartybyHash(c.Args().First())
+	log.ErrFatal(err)
+	buf, err := encodeFinal(party.Final, c.String("format"))
+	log.ErrFatal(err)
+	out := c.String("out")
+	if out == "" {
+		_, err = os.Stdout.Write(buf)
+		return err
+	}
+	log.ErrFatal(ioutil.WriteFile(out, buf, 0660))
+	log.Infof("Wrote final statement to %s", out)
+	return nil
+}
+
+// prints the public keys of the attendees of the final statement
+func orgAttendees(c *cli.Context) error {
+	log.Info("Org: Attendees")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party-hash")
+	}
+	cfg, _ := getConfigClient(c)
+	party, err := cfg.getPartybyHash(c.Args().First())
+	log.ErrFatal(err)
+	if c.Bool("count") {
+		fmt.Println(len(party.Final.Attendees))
+		return nil
+	}
+	out := c.String("out")
+	if out == "" {
+		return writeAttendees(os.Stdout, party.Final.Attendees)
+	}
+	f, err := os.Create(out)
+	log.ErrFatal(err)
+	defer f.Close()
+	log.ErrFatal(writeAttendees(f, party.Final.Attendees))
+	log.Infof("Wrote %d attendees to %s", len(party.Final.Attendees), out)
+	return nil
+}
+
+// writeAttendees writes one attendee per line in the base64 format that
+// 'org public' reads.
+func writeAttendees(w io.Writer, atts []abstract.Point) error {
+	for _, a := range atts {
+		str, err := crypto.PubToString64(nil, a)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintln(w, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads a final statement in any of the export formats and stores it if
+// it is signed by its conodes
+func orgImport(c *cli.Context) error {
+	log.Info("Org: Import")
+	if c.NArg() < 1 {
+		log.Fatal("Please give the file to import")
+	}
+	cfg, _ := getConfigClient(c)
+	buf, err := ioutil.ReadFile(c.Args().First())
+	log.ErrFatal(err)
+	final, err := decodeFinal(buf)
+	log.ErrFatal(err)
+	if err := final.Verify(); err != nil {
+		return fmt.Errorf("The final statement is not valid: %s", err)
+	}
+	hash := base64.StdEncoding.EncodeToString(final.Desc.Hash())
+	party, ok := cfg.Parties[hash]
+	if !ok {
+		party = &PartyConfig{Index: -1}
+		cfg.Parties[hash] = party
+	}
+	log.ErrFatal(party.SetFinal(final))
+	cfg.write()
+	log.Infof("Stored final statement, hash: %s", hash)
+	return nil
+}
+
+// encodeFinal returns the final statement in the given format, which is
+// one of "toml", "json" or "bin".
+func encodeFinal(fs *service.FinalStatement, format string) ([]byte, error) {
+	switch format {
+	case "toml", "":
+		return fs.ToToml()
+	case "json":
+		return fs.ToJSON()
+	case "bin":
+		return network.Marshal(fs)
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+// decodeFinal detects the format of buf and returns the final statement
+// stored in it.
+func decodeFinal(buf []byte) (*service.FinalStatement, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) {
+		return service.NewFinalStatementFromJSON(buf)
+	}
+	if _, msg, err := network.Unmarshal(buf); err == nil {
+		fs, ok := msg.(*service.FinalStatement)
+		if !ok {
+			return nil, errors.New("binary data is not a final statement")
+		}
+		return fs, nil
+	}
+	return service.NewFinalStatementFromToml(buf)
+}
+
+// creates a new private/public pair
+func attCreate(c *cli.Context) error {
+	priv := network.Suite.NewKey(random.Stream)
+	pub := network.Suite.Point().Mul(nil, priv)
+	privStr, err := crypto.ScalarToString64(nil, priv)
+	if err != nil {
+		return err
+	}
+	pubStr, err := crypto.PubToString64(nil, pub)
+	if err != nil {
+		return err
+	}
+	log.Infof("Private: %s\nPublic: %s", privStr, pubStr)
+	return nil
+}
+
+// joins a poparty
+func attJoin(c *cli.Context) error {
+	log.Info("att: join")
+	if c.NArg() < 2 {
+		log.Fatal("Please give private key and final.toml")
+	}
+	privStr := c.Args().First()
+	privBuf, err := base64.StdEncoding.DecodeString(privStr)
+	log.ErrFatal(err)
+	priv := network.Suite.Scalar()
+	log.ErrFatal(priv.UnmarshalBinary(privBuf))
+	cfg, client := getConfigClient(c)
+
+	finalName := c.Args().Get(1)
+	buf, err := ioutil.ReadFile(finalName)
+	log.ErrFatal(err)
+	final, err := service.NewFinalStatementFromToml(buf)
+	log.ErrFatal(err)
+	log.Info("final.verify()", final.Verify())
+	if len(final.Signature) <= 0 || final.Verify() != nil {
+		log.Lvl2("The local config is not finished yet")
+		log.Lvl2("Fetching final statement")
+		// Need to get the updated version of party config
+		// Cause attendee doesn't know,
+		// whether it has finished successfully or not
+		var fs *service.FinalStatement
+		var cerr onet.ClientError
+		if wait := c.Duration("wait"); wait > 0 {
+			log.Info("Waiting for the organizers to finalize the party")
+			fs, cerr = client.WaitForFinal(cfg.Address, final.Desc.Hash(), wait)
+		} else {
+			fs, cerr = client.FetchFinal(cfg.Address, final.Desc.Hash())
+		}
+		log.ErrFatal(cerr)
+		if len(fs.Signature) <= 0 || fs.Verify() != nil {
+			log.Fatal("Fetched final statement is invalid")
+		}
+		final = fs
+	}
+
+	if len(final.Desc.Parties) > 0 && !final.Merged {
+		log.Lvl2("The local party is not merged yet")
+		log.Lvl2("Fetching final statement")
+		fs, err := client.FetchFinal(cfg.Address, final.Desc.Hash())
+		log.ErrFatal(err)
+		if !fs.Merged {
+			log.Fatal("Global party is not merged")
+		}
+		if len(fs.Signature) <= 0 || fs.Verify() != nil {
+			log.Fatal("Fetched final statement is invalid")
+		}
+		final = fs
+	}
+	if group := c.String("expect-roster"); group != "" {
+		log.ErrFatal(final.VerifyAgainst(readGroup(group)))
+	}
+	party := &PartyConfig{
+		Private: priv,
+		Public:  network.Suite.Point().Mul(nil, priv),
+	}
+	log.ErrFatal(party.CheckKeypair())
+	log.ErrFatal(party.Join(final))
+	log.Info("Found public key at index", party.Index)
+	hash := base64.StdEncoding.EncodeToString(final.Desc.Hash())
+	if old, ok := cfg.Parties[hash]; ok && old.Index != -1 &&
+		old.CheckKeypair() == errInconsistentKeypair {
+		log.Warn("Replacing the inconsistent keypair stored for this party")
+	}
+	log.Infof("Final statement hash: %s", hash)
+	if !c.Bool("yes") {
+		fmt.Printf("Is it correct hash(y/n)")
+		reader := bufio.NewReader(os.Stdin)
+		input, _ := reader.ReadString('\n')
+		c := strings.ToLower(string([]byte(input)[0]))
+		if c == "n" {
+			return nil
+		}
+	}
+	cfg.Parties[hash] = party
+	cfg.write()
+	log.Infof("Stored final statement")
+	return nil
+}
+
+// pre-joins a party that is not finalized yet
+func attPrejoin(c *cli.Context) error {
+	log.Info("att: prejoin")
+	if c.NArg() < 2 {
+		log.Fatal("Please give private key and party hash")
+	}
+	privBuf, err := base64.StdEncoding.DecodeString(c.Args().First())
+	log.ErrFatal(err)
+	priv := network.Suite.Scalar()
+	log.ErrFatal(priv.UnmarshalBinary(privBuf))
+	hash := c.Args().Get(1)
+	_, err = base64.StdEncoding.DecodeString(hash)
+	log.ErrFatal(err)
+	cfg, client := getConfigClient(c)
+	if _, ok := cfg.Parties[hash]; ok {
+		log.Fatal("Party already joined")
+	}
+	cfg.Pending[hash] = &PartyConfig{
+		Private: priv,
+		Public:  network.Suite.Point().Mul(nil, priv),
+		Index:   -1,
+	}
+	log.Infof("Pre-joined party %s", hash)
+	cfg.completePending(client)
+	cfg.write()
+	return nil
+}
+
+// completePending tries to finish the join of the pre-joined parties and
+// logs the ones that got joined.
+func (cfg *Config) completePending(client *service.Client) {
+	if len(cfg.Pending) == 0 {
+		return
+	}
+	joined := joinPending(cfg, func(id []byte) (*service.FinalStatement,
+		onet.ClientError) {
+		return client.FetchFinal(cfg.Address, id)
+	}, func(fs *service.FinalStatement, id []byte) bool {
+		return client.BelongsTo(cfg.Address, fs, id)
+	})
+	for _, hash := range joined {
+		log.Infof("Joined party %s at index %d", hash, cfg.Parties[hash].Index)
+	}
+}
+
+// joinPending moves all pre-joined parties whose final statement is
+// available through fetch to the joined parties and returns their hashes.
+// The statement of a party that has been merged is the merged one, which
+// belongs checks. A party stays pending as long as it is not finalized, or
+// not merged if it is part of a merge. It is dropped if its final
+// statement doesn't hold the public key of the attendee, as this won't
+// change anymore.
+func joinPending(cfg *Config, fetch func([]byte) (*service.FinalStatement,
+	onet.ClientError), belongs func(*service.FinalStatement, []byte) bool) []string {
+	var joined []string
+	for hash, party := range cfg.Pending {
+		id, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil {
+			log.Error("Invalid party hash", hash, err)
+			continue
+		}
+		fs, cerr := fetch(id)
+		if cerr != nil {
+			log.Lvl2("Party", hash, "is not finalized yet:", cerr)
+			continue
+		}
+		if len(fs.Signature) <= 0 || fs.Verify() != nil || !belongs(fs, id) {
+			log.Warn("Fetched final statement of party", hash, "is invalid")
+			continue
+		}
+		if len(fs.Desc.Parties) > 0 && !fs.Merged {
+			log.Lvl2("Party", hash, "is not merged yet")
+			continue
+		}
+		if err := party.Join(fs); err != nil {
+			log.Error("Dropping party", hash, "-", err)
+			delete(cfg.Pending, hash)
+			continue
+		}
+		cfg.Parties[hash] = party
+		delete(cfg.Pending, hash)
+		joined = append(joined, hash)
+	}
+	sort.Strings(joined)
+	return joined
+}
+
+// updates the index of the attendee after the final statement changed
+func attReindex(c *cli.Context) error {
+	log.Info("att: reindex")
+	if c.NArg() < 1 {
+		log.Fatal("Please give party hash")
+	}
+	cfg, _ := getConfigClient(c)
+	party, err := cfg.getPartybyHash(c.Args().First())
+	log.ErrFatal(err)
+	old := party.Index
+	log.ErrFatal(reindex(party))
+	cfg.write()
+	log.Infof("Index changed from %d to %d", old, party.Index)
+	return nil
+}
+
+// reindex searches the public key of the attendee in the final statement
+// of the party and updates the stored index. It fails if the key is not
+// part of the attendees anymore, e.g. because it has been pruned.
+func reindex(party *PartyConfig) error {
+	if party.Public == nil || party.Final == nil {
+		return errors.New("No public key stored. Please join a party")
+	}
+	if err := party.Join(party.Final); err != nil {
+		return fmt.Errorf("%s - it may have been dropped during pruning", err)
+	}
+	return nil
+}
+
+// attendeeIndex returns the index of pub in the attendees. It returns an
+// error if pub is missing or present more than once, as a duplicate entry
+// means the attendee list of the party is malformed.
+func attendeeIndex(atts []abstract.Point, pub abstract.Point) (int, error) {
+	index := -1
+	for i, p := range atts {
+		if p.Equal(pub) {
+			if index != -1 {
+				return -1, fmt.Errorf("our public key is present at index %d and %d - "+
+					"the final statement is malformed", index, i)
+			}
+			index = i
+		}
+	}
+	if index == -1 {
+		return -1, errors.New("Didn't find our public key in the final statement!")
+	}
+	return index, nil
+}
+
+// signs a message + context
+func attSign(c *cli.Context) error {
+	log.Info("att: sign")
+	cfg, client := getConfigClient(c)
+	if c.NArg() < 3 {
+		log.Fatal("Please give msg, context and party hash")
+	}
+	if len(cfg.Pending) > 0 {
+		cfg.completePending(client)
+		cfg.write()
+	}
+	log.Info("hash:", c.Args().Get(2))
+	party, err := cfg.getPartybyHash(c.Args().Get(2))
+	log.ErrFatal(err)
+
+	if party.Index == -1 {
+		log.Fatal("No public key stored. Please join a party")
+	}
+	log.ErrFatal(party.CheckKeypair())
+
+	if len(party.Final.Signature) < 0 || party.Final.Verify() != nil {
+		log.Fatal("Party is not finilized or signature is not valid")
+	}
+
+	msg := []byte(c.Args().First())
+	if !c.Bool("raw") {
+		msg = tokenMsg(client, c.String("purpose"), msg)
+	}
+	ctx := []byte(c.Args().Get(1))
+	prevSig, err := base64.StdEncoding.DecodeString(c.String("chain"))
+	log.ErrFatal(err)
+	sig, tag := signMsg(client, party, msg, ctx, prevSig)
+	log.Infof("\nSignature: %s\nTag: %s", base64.StdEncoding.EncodeToString(sig),
+		base64.StdEncoding.EncodeToString(tag))
+	return nil
+}
+
+// verifies a signature and tag
+func attVerify(c *cli.Context) error {
+	log.Info("att: verify")
+	if c.NArg() < 5 {
+		log.Fatal("Please give a msg, context, signature, a tag and party hash")
+	}
+	final := verifierFinal(c, c.Args().Get(4))
+	client := service.NewClient()
+
+	msg := []byte(c.Args().First())
+	ctx := []byte(c.Args().Get(1))
+	sig, err := base64.StdEncoding.DecodeString(c.Args().Get(2))
+	log.ErrFatal(err)
+	tag, err := base64.StdEncoding.DecodeString(c.Args().Get(3))
+	log.ErrFatal(err)
+	if !c.Bool("raw") {
+		log.ErrFatal(checkToken(client, tag))
+		msg = tokenMsg(client, c.String("purpose"), msg)
+	}
+	prevSig, err := base64.StdEncoding.DecodeString(c.String("chain"))
+	log.ErrFatal(err)
+	prevTag, err := base64.StdEncoding.DecodeString(c.String("chain-tag"))
+	log.ErrFatal(err)
+	if len(prevSig) > 0 && len(prevTag) == 0 {
+		log.Fatal("Please give the tag of the chained signature with --chain-tag")
+	}
+	set, err := verifyMsg(client, final, msg, ctx, sig, tag,
+		prevSig, prevTag)
+	log.ErrFatal(err)
+	size, err := anonymitySet(set, c.Int("min-set"))
+	log.ErrFatal(err)
+	log.Infof("Successfully verified signature and tag - the signer is "+
+		"one of %d attendees", size)
+	return nil
+}
+
+// anonymitySet returns the number of attendees in set, the attendees a
+// token verified against and the signer is hidden among. It fails if there
+// are less than min, as a small set leaks who signed.
+func anonymitySet(set []abstract.Point, min int) (int, error) {
+	size := len(set)
+	if size < min {
+		return size, fmt.Errorf("Anonymity set of %d attendees is smaller "+
+			"than the minimum of %d", size, min)
+	}
+	return size, nil
+}
+
+// verifierFinal returns the final statement of the party with the given
+// hash, fetched from the conode given by --address or else from the
+// configuration. It fails if the statement can't be used for verifying,
+// or if it is not signed by the roster given by --roster.
+func verifierFinal(c *cli.Context, hash string) *service.FinalStatement {
+	cfg, client := getConfigClient(c)
+	var final *service.FinalStatement
+	if c.String("address") != "" {
+		log.Lvl2("Fetching final statement")
+		addr, err := service.ResolveAddress(c.String("address"))
+		log.ErrFatal(err)
+		id, err := base64.StdEncoding.DecodeString(hash)
+		log.ErrFatal(err)
+		fs, cerr := client.FetchFinal(addr, id)
+		log.ErrFatal(cerr)
+		final = fs
+	} else {
+		party, err := cfg.getPartybyHash(hash)
+		log.ErrFatal(err)
+		if party.Index != -1 && party.CheckKeypair() == errInconsistentKeypair {
+			log.Warn("Can verify, but not sign with this party:",
+				errInconsistentKeypair)
+		}
+		final = party.Final
+	}
+
+	if len(final.Signature) <= 0 || final.Verify() != nil {
+		log.Fatal("Party is not finilized or signature is not valid")
+	}
+	if group := c.String("roster"); group != "" {
+		log.ErrFatal(final.VerifyAgainst(readGroup(group)))
+	}
+	if final.Desc.Expired() {
+		log.Fatal("Party expired")
+	}
+	return final
+}
+
+// verifies all tokens of a csv-file
+func attVerifyBatch(c *cli.Context) error {
+	log.Info("att: verify-batch")
+	if c.NArg() < 2 {
+		log.Fatal("Please give a csv-file with tokens and the party hash")
+	}
+	f, err := os.Open(c.Args().First())
+	log.ErrFatal(err)
+	defer f.Close()
+	records, err := readTokens(f)
+	log.ErrFatal(err)
+	final := verifierFinal(c, c.Args().Get(1))
+
+	results := verifyBatch(service.NewClient(), final, records,
+		c.String("purpose"), c.Bool("raw"))
+	failed, err := writeBatchReport(os.Stdout, results)
+	log.ErrFatal(err)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tokens don't verify", failed, len(results))
+	}
+	return nil
+}
+
+// readTokens reads rows of msg,ctx,sig,tag from r, with the signature and
+// the tag in base64. A first row holding these column names is skipped.
+func readTokens(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	// The rows with a wrong number of fields fail in verifyBatch
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 &&
+		strings.Join(records[0], ",") == "msg,ctx,sig,tag" {
+		records = records[1:]
+	}
+	return records, nil
+}
+
+// batchResult is the outcome of the verification of one token.
+type batchResult struct {
+	// Row of the token, starting at 1
+	Row int
+	// Tag in base64
+	Tag string
+	// Err is nil if the token verifies
+	Err error
+	// Duplicate is the row of the first valid token with the same tag,
+	// or 0 if there is none.
+	Duplicate int
+}
+
+// verifyBatch verifies the tokens of the records, as returned by
+// readTokens, with the client, and finds the valid tokens sharing a tag.
+// These have been signed by the same attendee in the same context.
+func verifyBatch(client *service.Client, final *service.FinalStatement,
+	records [][]string, purpose string, raw bool) []*batchResult {
+	results := make([]*batchResult, len(records))
+	tags := make(map[string]int)
+	for i, rec := range records {
+		res := &batchResult{Row: i + 1}
+		results[i] = res
+		if len(rec) != 4 {
+			res.Err = fmt.Errorf("expected 4 fields, got %d", len(rec))
+			continue
+		}
+		res.Tag = rec[3]
+		msg := []byte(rec[0])
+		sig, err := base64.StdEncoding.DecodeString(rec[2])
+		if err != nil {
+			res.Err = fmt.Errorf("invalid signature: %s", err)
+			continue
+		}
+		tag, err := base64.StdEncoding.DecodeString(rec[3])
+		if err != nil {
+			res.Err = fmt.Errorf("invalid tag: %s", err)
+			continue
+		}
+		if !raw {
+			if res.Err = checkToken(client, tag); res.Err != nil {
+				continue
+			}
+			msg = tokenMsg(client, purpose, msg)
+		}
+		_, res.Err = verifyMsg(client, final, msg, []byte(rec[1]), sig, tag, nil, nil)
+		if res.Err != nil {
+			continue
+		}
+		if row, ok := tags[res.Tag]; ok {
+			res.Duplicate = row
+		} else {
+			tags[res.Tag] = res.Row
+		}
+	}
+	return results
+}
+
+// writeBatchReport writes one line per token, followed by the duplicate
+// tags and a summary. It returns the number of tokens that don't verify.
+func writeBatchReport(w io.Writer, results []*batchResult) (int, error) {
+	failed := 0
+	dups := make(map[string][]int)
+	dupTags := []string{}
+	for _, res := range results {
+		var err error
+		if res.Err != nil {
+			failed++
+			_, err = fmt.Fprintf(w, "row %d: FAIL %s\n", res.Row, res.Err)
+		} else {
+			_, err = fmt.Fprintf(w, "row %d: OK\n", res.Row)
+		}
+		if err != nil {
+			return failed, err
+		}
+		if res.Duplicate > 0 {
+			if _, ok := dups[res.Tag]; !ok {
+				dups[res.Tag] = []int{res.Duplicate}
+				dupTags = append(dupTags, res.Tag)
+			}
+			dups[res.Tag] = append(dups[res.Tag], res.Row)
+		}
+	}
+	for _, tag := range dupTags {
+		rows := make([]string, len(dups[tag]))
+		for i, row := range dups[tag] {
+			rows[i] = fmt.Sprint(row)
+		}
+		if _, err := fmt.Fprintf(w, "duplicate tag %s: rows %s\n", tag,
+			strings.Join(rows, ", ")); err != nil {
+			return failed, err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d valid, %d invalid, %d duplicate tags\n",
+		len(results)-failed, failed, len(dupTags))
+	return failed, err
+}
+
+// signMsg signs msg in the context ctx with the client, whose suite
+// defines the length of the tag, and returns the signature and the tag. If
+// prevSig is given, the signature is chained to this previous signature,
+// see chainMsg.
+func signMsg(client *service.Client, party *PartyConfig, msg, ctx,
+	prevSig []byte) (sig, tag []byte) {
+	if len(prevSig) > 0 {
+		msg = chainMsg(msg, prevSig)
+	}
+	sig, tag, err := client.Sign(party.Final, party.Index,
+		party.Private, msg, ctx)
+	log.ErrFatal(err)
+	return sig, tag
+}
+
+// verifyMsg verifies the signature and the tag of msg in the context ctx
+// with the client. If prevSig is given, the signature must be chained to
+// this previous signature, whose tag prevTag has to be the same as tag.
+// It returns the attendees the signature verified against.
+func verifyMsg(client *service.Client, final *service.FinalStatement, msg,
+	ctx, sig, tag, prevSig, prevTag []byte) ([]abstract.Point, error) {
+	if len(prevSig) > 0 {
+		if !bytes.Equal(tag, prevTag) {
+			return nil, errors.New("Tag is not the same as the chained tag")
+		}
+		msg = chainMsg(msg, prevSig)
+	}
+	return client.Verify(final, msg, ctx, sig, tag)
+}
+
+// tokenPrefix starts every token envelope, so that it can't be confused
+// with a raw message.
+const tokenPrefix = "PoP token\x00"
+
+// tokenMsg returns the envelope an attendee signs instead of the raw msg.
+// It records the parameters of the signature scheme of the client, its
+// scheme name prefixed by its length and its tag length, so that a token
+// never verifies under other parameters. Then it holds the purpose of the
+// token and msg, both prefixed by their length, so that a token for one
+// purpose never verifies for another one, even if a service uses the same
+// context for both.
+func tokenMsg(client *service.Client, purpose string, msg []byte) []byte {
+	scheme := client.Scheme()
+	env := make([]byte, 0, len(tokenPrefix)+16+len(scheme)+len(purpose)+
+		len(msg))
+	env = append(env, tokenPrefix...)
+	env = appendLength(env, len(scheme))
+	env = append(env, scheme...)
+	env = appendLength(env, client.TagLength())
+	env = appendLength(env, len(purpose))
+	env = append(env, purpose...)
+	env = appendLength(env, len(msg))
+	return append(env, msg...)
+}
+
+// checkToken returns an error if tag doesn't have the tag length of the
+// signature scheme of the client, so the token was created under other
+// parameters than the ones recorded by tokenMsg.
+func checkToken(client *service.Client, tag []byte) error {
+	if len(tag) != client.TagLength() {
+		return fmt.Errorf("Token has a tag of %d bytes, but the %s scheme "+
+			"uses tags of %d bytes", len(tag), client.Scheme(),
+			client.TagLength())
+	}
+	return nil
+}
+
+// appendLength appends l as a 4-byte big-endian integer.
+func appendLength(buf []byte, l int) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(l))
+	return append(buf, b[:]...)
+}
+
+// chainMsg binds msg to a previous signature. As the tag only depends on
+// the attendee and the context, a chained signature with the same tag as
+// the previous one proves that the same attendee signed both messages, in
+// this order, in the same context. Signatures in other contexts have
+// unrelated tags and can't be chained to it, so no link across contexts is
+// revealed. However a chain makes the sequence of actions public, which an
+// attendee might not want even though all its signatures in one context
+// are linkable anyway.
+func chainMsg(msg, prevSig []byte) []byte {
+	h := network.Suite.Hash()
+	h.Write([]byte("chain"))
+	h.Write(prevSig)
+	prev := h.Sum(nil)
+	h.Reset()
+	h.Write(prev)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func authStore(c *cli.Context) error {
+	log.Info("auth: store")
+	cfg, client := getConfigClient(c)
+	if c.NArg() < 1 {
+		log.Fatal("Please give a final.toml")
+	}
+
 	finalName := c.Args().First()
 	buf, err := ioutil.ReadFile(finalName)
 	log.ErrFatal(err)
@@ -512,8 +1777,8 @@ func authStore(c *cli.Context) error {
 		}
 		final = fs
 	}
-	party := &PartyConfig{}
-	party.Final = final
+	party := &PartyConfig{Index: -1}
+	log.ErrFatal(party.SetFinal(final))
 	hash := base64.StdEncoding.EncodeToString(final.Desc.Hash())
 	cfg.Parties[hash] = party
 	cfg.write()
@@ -521,11 +1786,393 @@ func authStore(c *cli.Context) error {
 	return nil
 }
 
-// getConfigClient returns the configuration and a client-structure.
+// readDesc reads the party description from pdFile and the parties to merge
+// from mergeFile, if it is not empty.
+func readDesc(pdFile, mergeFile string) (*service.PopDesc, error) {
+	desc := &service.PopDesc{}
+	buf, err := ioutil.ReadFile(pdFile)
+	if err != nil {
+		return nil, fmt.Errorf("While reading %s: %s", pdFile, err)
+	}
+	if err = decodePopDesc(string(buf), desc); err != nil {
+		return nil, fmt.Errorf("While decoding %s: %s", pdFile, err)
+	}
+	if mergeFile != "" {
+		buf, err = ioutil.ReadFile(mergeFile)
+		if err != nil {
+			return nil, fmt.Errorf("While reading %s: %s", mergeFile, err)
+		}
+		desc.Parties, err = decodeGroups(string(buf))
+		if err != nil {
+			return nil, fmt.Errorf("While decoding %s: %s", mergeFile, err)
+		}
+		if err = desc.ValidateParties(); err != nil {
+			return nil, fmt.Errorf("While checking %s: %s", mergeFile, err)
+		}
+	}
+	return desc, nil
+}
+
+// popDescTemplate is the skeleton of pop_desc.toml written by the template
+// command. The values in <> have to be replaced.
+const popDescTemplate = `# Description of a pop party. Replace the values in <> and store it on
+# the linked conode with "pop org config pop_desc.toml".
+
+# Name of the party
+Name = "<name>"
+# Date and time of the party as "YYYY-MM-DD HH:MM", followed by an optional
+# zone like "UTC" or "+02:00"
+DateTime = "<YYYY-MM-DD HH:MM> UTC"
+# Location of the party
+Location = "<location>"
+# Optional: how long after DateTime the tokens are valid, e.g. "720h"
+# TTL = "720h"
+# Optional: 1 to keep the attendees in the order of their registration
+# OrderingPolicy = 1
+# Optional: refuse to finalize with less attendees than this
+# MinAttendees = 10
+
+# The conodes of the organizers, one [[servers]] per conode, as found in
+# the public.toml of the conode
+[[servers]]
+  Address = "<tcp://host:port>"
+  Public = "<public key>"
+  Description = "<description>"
+`
+
+// popDescMergeTemplate is appended to popDescTemplate for a party that is
+// merged with other parties.
+const popDescMergeTemplate = `
+# The parties to merge, including this one with the same conodes as above.
+# Give this file a second time as merge_party.toml:
+#   pop org config pop_desc.toml pop_desc.toml
+[[parties]]
+  Location = "<location>"
+  # The OrderingPolicy of the party, if it is set in its description
+  # OrderingPolicy = 1
+  [[parties.servers]]
+    Address = "<tcp://host:port>"
+    Public = "<public key>"
+    Description = "<description>"
+
+[[parties]]
+  Location = "<other location>"
+  [[parties.servers]]
+    Address = "<other tcp://host:port>"
+    Public = "<other public key>"
+    Description = "<other description>"
+`
+
+// writes the skeleton of pop_desc.toml to stdout
+func popTemplate(c *cli.Context) error {
+	tmpl := popDescTemplate
+	if c.Bool("merge") {
+		tmpl += popDescMergeTemplate
+	}
+	_, err := fmt.Print(tmpl)
+	return err
+}
+
+// prints the hash of a party description, with the parties to merge of
+// an optional merge_party.toml, or compares it to the hash of the party
+// description given by --compare, so that the organizers can check that
+// they agree before storing it
+func descHash(c *cli.Context) error {
+	if c.NArg() < 1 {
+		log.Fatal("Please give pop_desc.toml and optionally merge_party.toml")
+	}
+	pdFiles := []string{c.Args().First()}
+	if other := c.String("compare"); other != "" {
+		pdFiles = append(pdFiles, other)
+	}
+	var hashes []string
+	for _, pdFile := range pdFiles {
+		desc, err := readDesc(pdFile, c.Args().Get(1))
+		log.ErrFatal(err)
+		hash := base64.StdEncoding.EncodeToString(desc.Hash())
+		log.Infof("Hash of %s: %s", pdFile, hash)
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) < 2 {
+		return nil
+	}
+	if hashes[0] != hashes[1] {
+		return errors.New("The hashes don't match")
+	}
+	log.Info("The hashes match")
+	return nil
+}
+
+// checks that the servers of a group file are the conodes of a party and
+// signed its final statement, so that the group file can be given to the
+// verifiers
+func checkGroupRoster(c *cli.Context) error {
+	if c.NArg() < 2 {
+		log.Fatal("Please give group.toml and final.toml")
+	}
+	group := readGroup(c.Args().First())
+	buf, err := ioutil.ReadFile(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	final, err := decodeFinal(buf)
+	if err != nil {
+		return err
+	}
+	diffs, err := diffGroup(group, final)
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		log.Info("The group differs from the roster of the party:\n" +
+			strings.Join(diffs, "\n"))
+		return errors.New("The group doesn't match the party")
+	}
+	if err := final.VerifyAgainst(group); err != nil {
+		return err
+	}
+	log.Info("The group matches the roster of the party")
+	return nil
+}
+
+// diffGroup lists the servers that are only in the group or only in the
+// roster of the final statement, and the servers whose address differs.
+// The servers are the same if they have the same public key, as in
+// service.Equal, and an address change is reported as the conodes can't be
+// reached at the old address anymore.
+func diffGroup(group *onet.Roster, final *service.FinalStatement) ([]string,
+	error) {
+	if final.Desc == nil || final.Desc.Roster == nil {
+		return nil, errors.New("The final statement has no roster")
+	}
+	roster := final.Desc.Roster
+	find := func(r *onet.Roster, si *network.ServerIdentity) *network.ServerIdentity {
+		for _, other := range r.List {
+			if si.Equal(other) {
+				return other
+			}
+		}
+		return nil
+	}
+	var diffs []string
+	for _, si := range group.List {
+		other := find(roster, si)
+		if other == nil {
+			diffs = append(diffs, fmt.Sprintf("only in the group: %s %s",
+				si.Address, si.Public))
+		} else if other.Address != si.Address {
+			diffs = append(diffs, fmt.Sprintf("address changed: %s %s, "+
+				"was %s", si.Address, si.Public, other.Address))
+		}
+	}
+	if !service.Equal(group, roster) {
+		for _, si := range roster.List {
+			if find(group, si) == nil {
+				diffs = append(diffs, fmt.Sprintf("only in the party: %s %s",
+					si.Address, si.Public))
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// compares two final statements
+func diffStatements(c *cli.Context) error {
+	if c.NArg() < 2 {
+		log.Fatal("Please give two final statements")
+	}
+	finals := make([]*service.FinalStatement, 2)
+	for i := range finals {
+		buf, err := ioutil.ReadFile(c.Args().Get(i))
+		log.ErrFatal(err)
+		finals[i], err = decodeFinal(buf)
+		log.ErrFatal(err, "While decoding", c.Args().Get(i))
+	}
+	diffs := diffFinals(finals[0], finals[1])
+	if len(diffs) == 0 {
+		log.Info("The final statements are the same")
+		return nil
+	}
+	log.Info("The final statements differ:\n" + strings.Join(diffs, "\n"))
+	return nil
+}
+
+// diffFinals returns a line for every difference between the two final
+// statements, the first one being called "a" and the second one "b".
+func diffFinals(a, b *service.FinalStatement) []string {
+	var diffs []string
+	diffField := func(name, va, vb string) {
+		if va != vb {
+			diffs = append(diffs, fmt.Sprintf("%s: a has %q, b has %q",
+				name, va, vb))
+		}
+	}
+	diffField("name", a.Desc.Name, b.Desc.Name)
+	diffField("datetime", a.Desc.DateTime, b.Desc.DateTime)
+	diffField("location", a.Desc.Location, b.Desc.Location)
+	diffField("merged", fmt.Sprint(a.Merged), fmt.Sprint(b.Merged))
+	valid := func(fs *service.FinalStatement) string {
+		if len(fs.Signature) > 0 && fs.Verify() == nil {
+			return "valid"
+		}
+		return "invalid"
+	}
+	diffField("signature", valid(a), valid(b))
+
+	rosterKeys := func(fs *service.FinalStatement) []string {
+		var keys []string
+		for _, si := range fs.Desc.Roster.List {
+			keys = append(keys, fmt.Sprintf("%s %s", si.Address, si.Public))
+		}
+		return keys
+	}
+	attKeys := func(fs *service.FinalStatement) []string {
+		var keys []string
+		for _, p := range fs.Attendees {
+			str, err := crypto.PubToString64(nil, p)
+			log.ErrFatal(err)
+			keys = append(keys, str)
+		}
+		return keys
+	}
+	diffSet := func(name string, ka, kb []string) {
+		inB := make(map[string]bool)
+		for _, k := range kb {
+			inB[k] = true
+		}
+		inA := make(map[string]bool)
+		for _, k := range ka {
+			inA[k] = true
+			if !inB[k] {
+				diffs = append(diffs, fmt.Sprintf("%s only in a: %s", name, k))
+			}
+		}
+		for _, k := range kb {
+			if !inA[k] {
+				diffs = append(diffs, fmt.Sprintf("%s only in b: %s", name, k))
+			}
+		}
+	}
+	diffSet("conode", rosterKeys(a), rosterKeys(b))
+	diffSet("attendee", attKeys(a), attKeys(b))
+	return diffs
+}
+
+// runs a party on two local conodes
+func selftest(c *cli.Context) error {
+	log.Info("Selftest")
+	tmp, err := ioutil.TempDir("", "pop-selftest")
+	log.ErrFatal(err)
+	defer os.RemoveAll(tmp)
+	if err := runSelftest(os.Stdout, tmp); err != nil {
+		log.Fatal("Selftest failed:", err)
+	}
+	log.Info("Selftest passed")
+	return nil
+}
+
+// runSelftest runs a whole party on two local conodes storing their data
+// in dir: it stores the config, registers two attendees, finalizes, signs a
+// message and verifies the token. Every stage is reported as PASS or FAIL
+// to out, and the first failing stage is returned.
+func runSelftest(out io.Writer, dir string) error {
+	stage := func(name string, err error) error {
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: %s\n", name, err)
+			return err
+		}
+		fmt.Fprintf(out, "PASS %s\n", name)
+		return nil
+	}
+
+	org := config.NewKeyPair(network.Suite)
+	// The conodes listen on free ports and are removed with all their
+	// temporary state once the test is done.
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(2, true)
+	var srvcs []*service.Service
+	for i, server := range servers {
+		s := server.Service(service.Name).(*service.Service)
+		s.SetStorage(service.DirStorage(path.Join(dir, strconv.Itoa(i))))
+		cfg := service.DefaultConfig()
+		cfg.AdminKey = org.Public
+		s.Configure(cfg)
+		srvcs = append(srvcs, s)
+	}
+	stage("start conodes", nil)
+
+	desc := &service.PopDesc{
+		Name:     "selftest",
+		DateTime: time.Now().UTC().Format(service.DateTimeFormat),
+		Location: "localhost",
+		Roster:   roster,
+	}
+	hash := desc.Hash()
+	sig, err := crypto.SignSchnorr(network.Suite, org.Secret, hash)
+	if err != nil {
+		return stage("store config", err)
+	}
+	for _, s := range srvcs {
+		if _, cerr := s.StoreConfig(&service.StoreConfig{Desc: desc,
+			Signature: sig}); cerr != nil {
+			return stage("store config", cerr)
+		}
+	}
+	stage("store config", nil)
+
+	atts := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	fr := &service.FinalizeRequest{DescID: hash}
+	for _, kp := range atts {
+		fr.Attendees = append(fr.Attendees, kp.Public)
+	}
+	frHash, err := fr.Hash()
+	if err == nil {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, org.Secret, frHash)
+	}
+	if err != nil {
+		return stage("register attendees", err)
+	}
+	stage("register attendees", nil)
+
+	// Only the last conode to finalize can sign the statement.
+	var final *service.FinalStatement
+	for _, s := range srvcs {
+		res, cerr := s.FinalizeRequest(fr)
+		if cerr == nil {
+			final = res.(*service.FinalizeResponse).Final
+		}
+	}
+	if final == nil {
+		return stage("finalize", errors.New("no conode signed the final statement"))
+	}
+	if err = stage("finalize", final.Verify()); err != nil {
+		return err
+	}
+
+	party := &PartyConfig{Private: atts[1].Secret, Public: atts[1].Public}
+	if err = party.Join(final); err != nil {
+		return stage("sign", err)
+	}
+	client := service.NewClient()
+	msg := tokenMsg(client, "selftest", []byte("message"))
+	ctx := []byte("context")
+	sigMsg, tag := signMsg(client, party, msg, ctx, nil)
+	stage("sign", nil)
+
+	_, err = verifyMsg(client, final, msg, ctx, sigMsg, tag, nil, nil)
+	return stage("verify", err)
+}
+
+// getConfigClient returns the configuration and a client-structure, which
+// sends the AuthToken given with --token.
 func getConfigClient(c *cli.Context) (*Config, *service.Client) {
 	cfg, err := newConfig(path.Join(c.GlobalString("config"), "config.bin"))
 	log.ErrFatal(err)
-	return cfg, service.NewClient()
+	client := service.NewClient()
+	client.AuthToken = []byte(c.GlobalString("token"))
+	return cfg, client
 }
 
 // newConfig tries to read the config and returns an organizer-
@@ -538,6 +2185,7 @@ func newConfig(fileConfig string) (*Config, error) {
 			OrgPublic:  kp.Public,
 			OrgPrivate: kp.Secret,
 			Parties:    make(map[string]*PartyConfig),
+			Pending:    make(map[string]*PartyConfig),
 			name:       name,
 		}, nil
 	}
@@ -558,6 +2206,9 @@ func newConfig(fileConfig string) (*Config, error) {
 	if cfg.Parties == nil {
 		cfg.Parties = make(map[string]*PartyConfig)
 	}
+	if cfg.Pending == nil {
+		cfg.Pending = make(map[string]*PartyConfig)
+	}
 	cfg.name = name
 	return cfg, nil
 }
@@ -576,6 +2227,83 @@ func (cfg *Config) getPartybyHash(hash string) (*PartyConfig, error) {
 	return nil, onet.NewClientErrorCode(service.ErrorInternal, "No such party")
 }
 
+// AttendeeIndex returns the index of the public key of the attendee in the
+// final statement of the party.
+func (p *PartyConfig) AttendeeIndex() (int, error) {
+	if p.Public == nil || p.Final == nil {
+		return -1, errors.New("No public key stored. Please join a party")
+	}
+	return attendeeIndex(p.Final.Attendees, p.Public)
+}
+
+// errInconsistentKeypair is returned by CheckKeypair if the stored private
+// key doesn't belong to the stored public key.
+var errInconsistentKeypair = errors.New("stored private/public keypair is " +
+	"inconsistent - re-join the party")
+
+// CheckKeypair returns an error if the keys of the party are missing or if
+// the public key is not the one of the private key, e.g. because the
+// configuration has been edited by hand.
+func (p *PartyConfig) CheckKeypair() error {
+	if p.Private == nil || p.Public == nil {
+		return errors.New("No public key stored. Please join a party")
+	}
+	if !network.Suite.Point().Mul(nil, p.Private).Equal(p.Public) {
+		return errInconsistentKeypair
+	}
+	return nil
+}
+
+// AddAttendee adds pub to the attendees of the party held by an organizer.
+// It fails if the key is already present or if the party is already
+// finalized, as the signature wouldn't cover the new key.
+func (p *PartyConfig) AddAttendee(pub abstract.Point) error {
+	if p.Index != -1 || p.Final == nil {
+		return errors.New("Only the organizer of a party can add attendees")
+	}
+	if len(p.Final.Signature) > 0 {
+		return errors.New("The party is already finalized")
+	}
+	for _, a := range p.Final.Attendees {
+		if a.Equal(pub) {
+			return errors.New("This key already exists")
+		}
+	}
+	p.Final.Attendees = append(p.Final.Attendees, pub)
+	return nil
+}
+
+// SetFinal replaces the final statement of the party. If the party is held
+// by an attendee, the index is moved to the position of its key in fs, and
+// fs is refused if the key is missing, so that the index always points to
+// the stored key.
+func (p *PartyConfig) SetFinal(fs *service.FinalStatement) error {
+	if fs == nil {
+		return errors.New("No final statement given")
+	}
+	if p.Index == -1 {
+		p.Final = fs
+		return nil
+	}
+	return p.Join(fs)
+}
+
+// Join stores fs as the final statement of the party of the attendee and
+// sets the index to the position of its key. Nothing is changed if the key
+// is missing.
+func (p *PartyConfig) Join(fs *service.FinalStatement) error {
+	if p.Public == nil || fs == nil {
+		return errors.New("No public key stored. Please join a party")
+	}
+	index, err := attendeeIndex(fs.Attendees, p.Public)
+	if err != nil {
+		return err
+	}
+	p.Final = fs
+	p.Index = index
+	return nil
+}
+
 // readGroup fetches group definition file.
 func readGroup(name string) *onet.Roster {
 	f, err := os.Open(name)
@@ -594,7 +2322,21 @@ type PopDescGroupToml struct {
 	Name     string
 	DateTime string
 	Location string
-	Servers  []*app.ServerToml `toml:"servers"`
+	// Version selects the hash function, see service.PopVersionSuite
+	Version int
+	// OrderingPolicy selects the order of the attendees in the final
+	// statement, see service.OrderSorted
+	OrderingPolicy int
+	// MinAttendees is the number of attendees needed to finalize the
+	// party, see service.PopDesc
+	MinAttendees int
+	// TTL is how long after DateTime the tokens are valid, e.g. "720h".
+	// The party never expires if it is empty.
+	TTL     string
+	Servers []*app.ServerToml `toml:"servers"`
+	// Verifiers are optional conodes receiving the final statement for
+	// the verifications, without signing it.
+	Verifiers []*app.ServerToml `toml:"verifiers"`
 }
 
 func decodePopDesc(buf string, desc *service.PopDesc) error {
@@ -604,8 +2346,25 @@ func decodePopDesc(buf string, desc *service.PopDesc) error {
 		return err
 	}
 	desc.Name = descGroup.Name
-	desc.DateTime = descGroup.DateTime
+	desc.DateTime, err = service.NormalizeDateTime(descGroup.DateTime)
+	if err != nil {
+		return err
+	}
 	desc.Location = descGroup.Location
+	desc.Version = descGroup.Version
+	desc.OrderingPolicy = descGroup.OrderingPolicy
+	desc.MinAttendees = descGroup.MinAttendees
+	if descGroup.TTL != "" {
+		ttl, err := time.ParseDuration(descGroup.TTL)
+		if err != nil {
+			return err
+		}
+		date, err := service.ParseDateTime(desc.DateTime)
+		if err != nil {
+			return err
+		}
+		desc.ExpiresAt = date.Add(ttl).Unix()
+	}
 	entities := make([]*network.ServerIdentity, len(descGroup.Servers))
 	for i, s := range descGroup.Servers {
 		en, err := toServerIdentity(s, network.Suite)
@@ -615,26 +2374,41 @@ func decodePopDesc(buf string, desc *service.PopDesc) error {
 		entities[i] = en
 	}
 	desc.Roster = onet.NewRoster(entities)
+	if len(descGroup.Verifiers) > 0 {
+		verifiers := make([]*network.ServerIdentity, len(descGroup.Verifiers))
+		for i, s := range descGroup.Verifiers {
+			verifiers[i], err = toServerIdentity(s, network.Suite)
+			if err != nil {
+				return err
+			}
+		}
+		desc.VerifierRoster = onet.NewRoster(verifiers)
+	}
 	return nil
 }
 
 type shortDescGroupToml struct {
-	Location string
-	Servers  []*app.ServerToml `toml:"servers"`
+	Location       string
+	OrderingPolicy int
+	Servers        []*app.ServerToml `toml:"servers"`
 }
 
-// decode config of several groups into array of rosters
+// decode config of several groups into array of rosters. Other keys are
+// ignored, so that the parties can also be part of pop_desc.toml.
 func decodeGroups(buf string) ([]*service.ShortDesc, error) {
-	decodedGroups := make(map[string][]shortDescGroupToml)
-	_, err := toml.Decode(buf, &decodedGroups)
+	decodedGroups := &struct {
+		Parties []shortDescGroupToml `toml:"parties"`
+	}{}
+	_, err := toml.Decode(buf, decodedGroups)
 	if err != nil {
 		return []*service.ShortDesc{}, err
 	}
-	groups := decodedGroups["parties"]
+	groups := decodedGroups.Parties
 	descs := []*service.ShortDesc{}
 	for _, descGroup := range groups {
 		desc := &service.ShortDesc{}
 		desc.Location = descGroup.Location
+		desc.OrderingPolicy = descGroup.OrderingPolicy
 		entities := make([]*network.ServerIdentity, len(descGroup.Servers))
 		for j, s := range descGroup.Servers {
 			en, err := toServerIdentity(s, network.Suite)
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"os"
+	"path"
 
+	"github.com/dedis/student_17_pop/service"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/nist"
+	"gopkg.in/dedis/crypto.v0/random"
+	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
+	"gopkg.in/dedis/onet.v1/network"
 )
 
 func TestConfigNew(t *testing.T) {
@@ -29,6 +45,903 @@ func TestConfigNew(t *testing.T) {
 	require.Equal(t, "127.0.0.1:3123", string(cfg.Address))
 }
 
+func TestEncodeDecodeFinal(t *testing.T) {
+	kp := config.NewKeyPair(network.Suite)
+	si := network.NewServerIdentity(kp.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	fs := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Location: "here",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees:   []abstract.Point{kp.Public},
+		FinalizedAt: 1501459200,
+	}
+	fs.Signature = fs.Desc.Hash()
+	for _, format := range []string{"toml", "json", "bin"} {
+		buf, err := encodeFinal(fs, format)
+		log.ErrFatal(err)
+		fs2, err := decodeFinal(buf)
+		log.ErrFatal(err)
+		require.Equal(t, fs.Desc.Hash(), fs2.Desc.Hash(), format)
+		require.True(t, fs.Attendees[0].Equal(fs2.Attendees[0]), format)
+		require.Equal(t, fs.Signature, fs2.Signature, format)
+		require.Equal(t, fs.FinalizedAt, fs2.FinalizedAt, format)
+	}
+	_, err := encodeFinal(fs, "xml")
+	require.NotNil(t, err)
+}
+
+func TestAttendeeIndex(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	kp3 := config.NewKeyPair(network.Suite)
+	atts := []abstract.Point{kp1.Public, kp2.Public}
+	index, err := attendeeIndex(atts, kp2.Public)
+	log.ErrFatal(err)
+	require.Equal(t, 1, index)
+	_, err = attendeeIndex(atts, kp3.Public)
+	require.NotNil(t, err)
+	atts = append(atts, kp2.Public)
+	_, err = attendeeIndex(atts, kp2.Public)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "malformed")
+}
+
+func TestReindex(t *testing.T) {
+	client := service.NewClient()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite), config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{
+		Private: kps[1].Secret,
+		Public:  kps[1].Public,
+		Index:   1,
+		Final: &service.FinalStatement{
+			Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+		},
+	}
+	// Refreshed statement with a reordered list
+	party.Final = &service.FinalStatement{
+		Attendees: []abstract.Point{kps[2].Public, kps[0].Public, kps[1].Public},
+	}
+	log.ErrFatal(reindex(party))
+	require.Equal(t, 2, party.Index)
+	sig, tag := signMsg(client, party, []byte("msg"), []byte("ctx"), nil)
+	_, err := verifyMsg(client, party.Final, []byte("msg"), []byte("ctx"), sig, tag, nil, nil)
+	require.Nil(t, err)
+
+	// The key has been pruned
+	party.Final.Attendees = []abstract.Point{kps[0].Public}
+	err = reindex(party)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "pruning")
+	require.Equal(t, 2, party.Index)
+}
+
+func TestPartyConfig_AddAttendee(t *testing.T) {
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{
+		Index: -1,
+		Final: &service.FinalStatement{Attendees: []abstract.Point{}},
+	}
+	log.ErrFatal(party.AddAttendee(kps[0].Public))
+	err := party.AddAttendee(kps[0].Public)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "already exists")
+	log.ErrFatal(addAttendees(party, []abstract.Point{kps[1].Public}))
+	require.Equal(t, 2, len(party.Final.Attendees))
+
+	// No more attendees once the party is finalized
+	party.Final.Signature = []byte{1}
+	require.NotNil(t, party.AddAttendee(config.NewKeyPair(network.Suite).Public))
+	require.Equal(t, 2, len(party.Final.Attendees))
+
+	// An attendee can't add keys to its party
+	att := &PartyConfig{Public: kps[0].Public, Final: &service.FinalStatement{}}
+	require.NotNil(t, att.AddAttendee(kps[1].Public))
+}
+
+func TestPartyConfig_CheckKeypair(t *testing.T) {
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{Private: kps[0].Secret, Public: kps[0].Public}
+	require.Nil(t, party.CheckKeypair())
+
+	// A corrupted configuration holds the public key of another pair
+	party.Public = kps[1].Public
+	err := party.CheckKeypair()
+	require.NotNil(t, err)
+	require.Equal(t, "stored private/public keypair is inconsistent - "+
+		"re-join the party", err.Error())
+
+	party.Private = nil
+	err = party.CheckKeypair()
+	require.NotNil(t, err)
+	require.NotEqual(t, errInconsistentKeypair, err)
+}
+
+func TestPartyConfig_SetFinal(t *testing.T) {
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite), config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{Private: kps[1].Secret, Public: kps[1].Public}
+	log.ErrFatal(party.Join(&service.FinalStatement{
+		Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+	}))
+	require.Equal(t, 1, party.Index)
+	index, err := party.AttendeeIndex()
+	log.ErrFatal(err)
+	require.Equal(t, party.Index, index)
+
+	log.ErrFatal(party.SetFinal(&service.FinalStatement{
+		Attendees: []abstract.Point{kps[2].Public, kps[0].Public, kps[1].Public},
+	}))
+	require.Equal(t, 2, party.Index)
+
+	// A statement without our key is refused and nothing changes
+	old := party.Final
+	require.NotNil(t, party.SetFinal(&service.FinalStatement{
+		Attendees: []abstract.Point{kps[0].Public},
+	}))
+	require.Equal(t, old, party.Final)
+	require.Equal(t, 2, party.Index)
+
+	// The organizer stays an organizer
+	org := &PartyConfig{Index: -1}
+	log.ErrFatal(org.SetFinal(old))
+	require.Equal(t, -1, org.Index)
+	require.NotNil(t, org.SetFinal(nil))
+}
+
+func TestSignMsgChained(t *testing.T) {
+	client := service.NewClient()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{
+		Private: kps[1].Secret,
+		Public:  kps[1].Public,
+		Index:   1,
+		Final: &service.FinalStatement{
+			Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+		},
+	}
+	ctx1 := []byte("ctx1")
+	ctx2 := []byte("ctx2")
+	sig1, tag1 := signMsg(client, party, []byte("msg1"), ctx1, nil)
+	_, err := verifyMsg(client, party.Final, []byte("msg1"), ctx1, sig1, tag1, nil, nil)
+	require.Nil(t, err)
+
+	sig2, tag2 := signMsg(client, party, []byte("msg2"), ctx1, sig1)
+	require.Equal(t, tag1, tag2)
+	_, err = verifyMsg(client, party.Final, []byte("msg2"), ctx1, sig2, tag2, sig1, tag1)
+	require.Nil(t, err)
+	// The chain is part of the signed message
+	_, err = verifyMsg(client, party.Final, []byte("msg2"), ctx1, sig2, tag2, nil, nil)
+	require.NotNil(t, err)
+	_, err = verifyMsg(client, party.Final, []byte("msg1"), ctx1, sig2, tag2, sig1, tag1)
+	require.NotNil(t, err)
+	// It binds to the previous signature, not only to the tag
+	sig1b, _ := signMsg(client, party, []byte("msg1b"), ctx1, nil)
+	_, err = verifyMsg(client, party.Final, []byte("msg2"), ctx1, sig2, tag2, sig1b, tag1)
+	require.NotNil(t, err)
+
+	// Chaining to a signature of another context doesn't link
+	sig3, tag3 := signMsg(client, party, []byte("msg3"), ctx2, sig1)
+	require.NotEqual(t, tag1, tag3)
+	_, err = verifyMsg(client, party.Final, []byte("msg3"), ctx2, sig3, tag3, sig1, tag1)
+	require.NotNil(t, err)
+	_, err = verifyMsg(client, party.Final, []byte("msg3"), ctx2, sig3, tag3, nil, nil)
+	require.NotNil(t, err)
+}
+
+func TestTokenMsg(t *testing.T) {
+	client := service.NewClient()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	party := &PartyConfig{
+		Private: kps[0].Secret,
+		Public:  kps[0].Public,
+		Index:   0,
+		Final: &service.FinalStatement{
+			Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+		},
+	}
+	msg := []byte("candidate1")
+	ctx := []byte("election")
+	sig, tag := signMsg(client, party, tokenMsg(client, "vote", msg), ctx, nil)
+	_, err := verifyMsg(client, party.Final, tokenMsg(client, "vote", msg), ctx, sig, tag, nil, nil)
+	require.Nil(t, err)
+	_, err = verifyMsg(client, party.Final, tokenMsg(client, "login", msg), ctx, sig, tag, nil, nil)
+	require.NotNil(t, err)
+	_, err = verifyMsg(client, party.Final, msg, ctx, sig, tag, nil, nil)
+	require.NotNil(t, err)
+
+	// The lengths keep purpose and message apart
+	require.NotEqual(t, tokenMsg(client, "vote", []byte("x")),
+		tokenMsg(client, "votex", nil))
+
+	// The envelope records the scheme and the tag length
+	env := tokenMsg(client, "vote", msg)
+	require.Equal(t, append([]byte("PoP token\x00\x00\x00\x00\x07Ed25519"+
+		"\x00\x00\x00\x20\x00\x00\x00\x04vote\x00\x00\x00\x0a"), msg...), env)
+
+	// Tokens under another tag length
+	suite := nist.NewAES128SHA256P256()
+	defClient := client
+	client = service.NewClient()
+	client.Suite = suite
+	require.NotEqual(t, defClient.TagLength(), client.TagLength())
+	kp := config.NewKeyPair(suite)
+	party = &PartyConfig{
+		Private: kp.Secret,
+		Public:  kp.Public,
+		Index:   1,
+		Final: &service.FinalStatement{
+			Attendees: []abstract.Point{config.NewKeyPair(suite).Public, kp.Public},
+		},
+	}
+	env = tokenMsg(client, "vote", msg)
+	sig, tag = signMsg(client, party, env, ctx, nil)
+	require.Equal(t, suite.PointLen(), len(tag))
+	require.Nil(t, checkToken(client, tag))
+	_, err = verifyMsg(client, party.Final, env, ctx, sig, tag, nil, nil)
+	require.Nil(t, err)
+	_, err = verifyMsg(defClient, party.Final, env, ctx, sig, tag, nil, nil)
+	require.NotNil(t, err)
+
+	// Tokens whose parameters don't match are rejected
+	require.NotNil(t, checkToken(defClient, tag))
+	_, err = verifyMsg(client, party.Final, tokenMsg(defClient, "vote", msg),
+		ctx, sig, tag, nil, nil)
+	require.NotNil(t, err)
+	sig, tag = signMsg(client, party, tokenMsg(defClient, "vote", msg), ctx, nil)
+	_, err = verifyMsg(client, party.Final, env, ctx, sig, tag, nil, nil)
+	require.NotNil(t, err)
+}
+
+func TestAnonymitySet(t *testing.T) {
+	client := service.NewClient()
+	kps := make([]*config.KeyPair, 3)
+	atts := make([]abstract.Point, len(kps))
+	for i := range kps {
+		kps[i] = config.NewKeyPair(network.Suite)
+		atts[i] = kps[i].Public
+	}
+	party := &PartyConfig{
+		Private: kps[1].Secret,
+		Public:  kps[1].Public,
+		Index:   1,
+		Final:   &service.FinalStatement{Attendees: atts},
+	}
+	msg, ctx := tokenMsg(client, "vote", []byte("candidate1")), []byte("election")
+	sig, tag := signMsg(client, party, msg, ctx, nil)
+	set, err := verifyMsg(client, party.Final, msg, ctx, sig, tag, nil, nil)
+	require.Nil(t, err)
+	size, err := anonymitySet(set, 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, size)
+	_, err = anonymitySet(set, 3)
+	require.Nil(t, err)
+	size, err = anonymitySet(set, 4)
+	require.NotNil(t, err)
+	require.Equal(t, 3, size)
+
+	// A token signed before a merge only hides the signer among the
+	// attendees of its party
+	merged := &service.FinalStatement{
+		Attendees: []abstract.Point{config.NewKeyPair(network.Suite).Public,
+			atts[0], config.NewKeyPair(network.Suite).Public, atts[1], atts[2]},
+		Origins: []*service.AttendeeOrigin{{Party: []byte("party"),
+			Indexes: []int{1, 3, 4}}},
+	}
+	set, err = verifyMsg(client, merged, msg, ctx, sig, tag, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, atts, set)
+	size, err = anonymitySet(set, 4)
+	require.NotNil(t, err)
+	require.Equal(t, 3, size)
+}
+
+func TestVerifyBatch(t *testing.T) {
+	client := service.NewClient()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	final := &service.FinalStatement{
+		Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+	}
+	party := func(i int) *PartyConfig {
+		return &PartyConfig{Private: kps[i].Secret, Public: kps[i].Public,
+			Index: i, Final: final}
+	}
+	var csvBuf bytes.Buffer
+	csvBuf.WriteString("msg,ctx,sig,tag\n")
+	row := func(p *PartyConfig, msg, ctx string) {
+		sig, tag := signMsg(client, p, tokenMsg(client, "entry", []byte(msg)), []byte(ctx), nil)
+		csvBuf.WriteString(strings.Join([]string{msg, ctx,
+			base64.StdEncoding.EncodeToString(sig),
+			base64.StdEncoding.EncodeToString(tag)}, ",") + "\n")
+	}
+	row(party(0), "door1", "concert")
+	row(party(1), "door1", "concert")
+	// signed for another context
+	row(party(1), "door2", "other")
+	// the same attendee entering twice
+	row(party(0), "door2", "concert")
+	csvBuf.WriteString("door3,concert,bm90IGEgc2ln,bm90IGEgdGFn\n")
+	csvBuf.WriteString("door3,concert\n")
+
+	records, err := readTokens(&csvBuf)
+	log.ErrFatal(err)
+	require.Equal(t, 6, len(records))
+	// Change the context of the third row
+	records[2][1] = "concert"
+
+	results := verifyBatch(client, final, records, "entry", false)
+	for i, ok := range []bool{true, true, false, true, false, false} {
+		require.Equal(t, i+1, results[i].Row)
+		require.Equal(t, ok, results[i].Err == nil, "row %d", i+1)
+	}
+	require.Equal(t, 0, results[1].Duplicate)
+	require.Equal(t, 1, results[3].Duplicate)
+	require.Equal(t, results[0].Tag, results[3].Tag)
+
+	// A wrong purpose fails all tokens
+	for _, res := range verifyBatch(client, final, records, "vote", false) {
+		require.NotNil(t, res.Err)
+	}
+
+	var out bytes.Buffer
+	failed, err := writeBatchReport(&out, results)
+	log.ErrFatal(err)
+	require.Equal(t, 3, failed)
+	report := out.String()
+	require.Contains(t, report, "row 1: OK\n")
+	require.Contains(t, report, "row 6: FAIL expected 4 fields, got 2\n")
+	require.Contains(t, report, "duplicate tag "+results[0].Tag+": rows 1, 4\n")
+	require.Contains(t, report, "3 valid, 3 invalid, 1 duplicate tags\n")
+}
+
+func TestDiffFinals(t *testing.T) {
+	kp1 := config.NewKeyPair(network.Suite)
+	kp2 := config.NewKeyPair(network.Suite)
+	si := network.NewServerIdentity(kp1.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	newFinal := func(atts ...abstract.Point) *service.FinalStatement {
+		return &service.FinalStatement{
+			Desc: &service.PopDesc{
+				Name:     "test",
+				DateTime: "yesterday",
+				Location: "here",
+				Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+			},
+			Attendees: atts,
+		}
+	}
+	a := newFinal(kp1.Public)
+	require.Equal(t, 0, len(diffFinals(a, newFinal(kp1.Public))))
+
+	b := newFinal(kp1.Public, kp2.Public)
+	diffs := diffFinals(a, b)
+	require.Equal(t, 1, len(diffs))
+	key, err := crypto.PubToString64(nil, kp2.Public)
+	log.ErrFatal(err)
+	require.Equal(t, "attendee only in b: "+key, diffs[0])
+
+	b.Desc.Location = "there"
+	require.Equal(t, 2, len(diffFinals(a, b)))
+}
+
+func TestReadDesc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "desc")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	servers := make([]string, 3)
+	for i := range servers {
+		pub, err := crypto.PubToString64(nil, config.NewKeyPair(network.Suite).Public)
+		log.ErrFatal(err)
+		servers[i] = fmt.Sprintf("Address = \"tcp://127.0.0.1:%d\"\n"+
+			"Public = \"%s\"\nDescription = \"conode%d\"\n", 2000+2*i, pub, i)
+	}
+	write := func(name, content string) string {
+		file := path.Join(dir, name)
+		log.ErrFatal(ioutil.WriteFile(file, []byte(content), 0660))
+		return file
+	}
+	desc := "Name = \"party\"\nDateTime = \"2017-08-08 15:00 UTC\"\n" +
+		"Location = \"city1\"\n[[servers]]\n" + servers[0] +
+		"[[servers]]\n" + servers[1]
+	party := func(loc string, srvs ...string) string {
+		str := "[[parties]]\nLocation = \"" + loc + "\"\n"
+		for _, s := range srvs {
+			str += "[[parties.servers]]\n" + s
+		}
+		return str
+	}
+	pd1 := write("pop_desc1.toml", desc)
+	pd2 := write("pop_desc2.toml", "\n"+strings.Replace(desc, " = ", "=", -1))
+	merge1 := write("merge1.toml", party("city1", servers[0], servers[1])+
+		party("city2", servers[2]))
+	merge2 := write("merge2.toml", party("city2", servers[2])+"\n\n"+
+		party("city1", servers[1], servers[0]))
+
+	d1, err := readDesc(pd1, "")
+	log.ErrFatal(err)
+	d2, err := readDesc(pd2, "")
+	log.ErrFatal(err)
+	require.Equal(t, d1.Hash(), d2.Hash())
+
+	// Neither the order of the parties nor of their conodes matters
+	d1, err = readDesc(pd1, merge1)
+	log.ErrFatal(err)
+	d2, err = readDesc(pd2, merge2)
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(d2.Parties))
+	require.Equal(t, d1.Hash(), d2.Hash())
+
+	pd3 := write("pop_desc3.toml", strings.Replace(desc, "city1", "city3", 1))
+	d3, err := readDesc(pd3, merge1)
+	log.ErrFatal(err)
+	require.NotEqual(t, d1.Hash(), d3.Hash())
+
+	// pop hash pop_desc.toml [merge_party.toml]
+	hash := func(args ...string) error {
+		return newApp().Run(append([]string{"pop", "-c", dir, "hash"}, args...))
+	}
+	require.Nil(t, hash(pd1))
+	require.Nil(t, hash(pd1, merge1))
+	require.Nil(t, hash("--compare", pd2, pd1, merge1))
+	require.NotNil(t, hash("--compare", pd3, pd1, merge1))
+
+	// A sub-party listed twice or without conodes is refused
+	_, err = readDesc(pd1, write("dup.toml", party("city1", servers[0], servers[1])+
+		party("city2", servers[2])+party("city1", servers[1], servers[0])))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "is the same as party 1")
+	_, err = readDesc(pd1, write("empty.toml", party("city1", servers[0], servers[1])+
+		party("city2")))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "no conodes")
+
+	_, err = readDesc(path.Join(dir, "missing.toml"), "")
+	require.NotNil(t, err)
+	_, err = readDesc(pd1, write("broken.toml", "[[parties"))
+	require.NotNil(t, err)
+}
+
+func TestPopDescTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	pubs := make([]string, 2)
+	for i := range pubs {
+		pubs[i], err = crypto.PubToString64(nil, config.NewKeyPair(network.Suite).Public)
+		log.ErrFatal(err)
+	}
+	fill := strings.NewReplacer("<name>", "party",
+		"<YYYY-MM-DD HH:MM>", "2017-08-08 15:00",
+		"<location>", "city1", "<other location>", "city2",
+		"<tcp://host:port>", "tcp://127.0.0.1:2000",
+		"<other tcp://host:port>", "tcp://127.0.0.1:2002",
+		"<public key>", pubs[0], "<other public key>", pubs[1],
+		"<description>", "conode1", "<other description>", "conode2")
+
+	desc := &service.PopDesc{}
+	require.NotNil(t, decodePopDesc(popDescTemplate, desc))
+	log.ErrFatal(decodePopDesc(fill.Replace(popDescTemplate), desc))
+	require.Equal(t, "party", desc.Name)
+	require.Equal(t, "2017-08-08 15:00", desc.DateTime)
+	require.Equal(t, "city1", desc.Location)
+	require.Equal(t, 1, len(desc.Roster.List))
+	require.Nil(t, desc.Validate())
+
+	// The filled merge template is its own merge_party.toml
+	file := path.Join(dir, "pop_desc.toml")
+	log.ErrFatal(ioutil.WriteFile(file,
+		[]byte(fill.Replace(popDescTemplate+popDescMergeTemplate)), 0660))
+	desc, err = readDesc(file, file)
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(desc.Parties))
+	require.Equal(t, "city2", desc.Parties[1].Location)
+	require.Nil(t, desc.Validate())
+}
+
+func TestDiffGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	servers := make([]string, 3)
+	for i := range servers {
+		pub, err := crypto.PubToString64(nil, config.NewKeyPair(network.Suite).Public)
+		log.ErrFatal(err)
+		servers[i] = fmt.Sprintf("[[servers]]\nAddress = \"tcp://127.0.0.1:%d\"\n"+
+			"Public = \"%s\"\nDescription = \"conode%d\"\n", 2000+2*i, pub, i)
+	}
+	write := func(name, content string) string {
+		file := path.Join(dir, name)
+		log.ErrFatal(ioutil.WriteFile(file, []byte(content), 0660))
+		return file
+	}
+	desc, err := readDesc(write("pop_desc.toml", "Name = \"party\"\n"+
+		"DateTime = \"2017-08-08 15:00 UTC\"\nLocation = \"city\"\n"+
+		servers[0]+servers[1]), "")
+	log.ErrFatal(err)
+	final := &service.FinalStatement{Desc: desc}
+	buf, err := final.ToToml()
+	log.ErrFatal(err)
+	finalFile := write("final.toml", string(buf))
+
+	// The order of the servers doesn't matter
+	group1 := write("group1.toml", servers[1]+servers[0])
+	diffs, err := diffGroup(readGroup(group1), final)
+	log.ErrFatal(err)
+	require.Equal(t, 0, len(diffs))
+
+	diffs, err = diffGroup(readGroup(write("group2.toml", servers[0]+servers[2])), final)
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(diffs))
+	require.Contains(t, diffs[0], "only in the group: tcp://127.0.0.1:2004")
+	require.Contains(t, diffs[1], "only in the party: tcp://127.0.0.1:2002")
+
+	// A conode that moved is reported
+	moved := strings.Replace(servers[1], "127.0.0.1:2002", "127.0.0.1:2012", 1)
+	diffs, err = diffGroup(readGroup(write("group3.toml", servers[0]+moved)), final)
+	log.ErrFatal(err)
+	require.Equal(t, 1, len(diffs))
+	require.Contains(t, diffs[0], "address changed: tcp://127.0.0.1:2012")
+	require.Contains(t, diffs[0], "was tcp://127.0.0.1:2002")
+
+	// The statement has to be signed by the group
+	err = newApp().Run([]string{"pop", "-c", dir, "check-roster", group1, finalFile})
+	require.NotNil(t, err)
+	require.NotEqual(t, "The group doesn't match the party", err.Error())
+}
+
+func TestReadRegistrations(t *testing.T) {
+	partyHash := []byte("party")
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	rows := make([]string, len(kps))
+	for i, kp := range kps {
+		var err error
+		rows[i], err = registrationRow(kp.Secret, partyHash)
+		log.ErrFatal(err)
+	}
+	pubs, err := readRegistrations(strings.NewReader(strings.Join(rows, "\n")),
+		partyHash)
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(pubs))
+	for i, kp := range kps {
+		require.True(t, kp.Public.Equal(pubs[i]))
+	}
+
+	// Signature for another party
+	_, err = readRegistrations(strings.NewReader(rows[0]), []byte("other"))
+	require.NotNil(t, err)
+	// Signature of another key
+	sig := strings.Split(rows[1], ",")[1]
+	bad := strings.Split(rows[0], ",")[0] + "," + sig
+	_, err = readRegistrations(strings.NewReader(rows[1]+"\n"+bad), partyHash)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "row 2")
+	// Malformed rows
+	for _, row := range []string{"onlykey", rows[0] + ",extra", "notbase64,notbase64"} {
+		_, err = readRegistrations(strings.NewReader(row), partyHash)
+		require.NotNil(t, err, row)
+	}
+}
+
+func TestJoinPending(t *testing.T) {
+	conode := eddsa.NewEdDSA(random.Stream)
+	si := network.NewServerIdentity(conode.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	fs := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Location: "here",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+	}
+	id := fs.Desc.Hash()
+	hash := base64.StdEncoding.EncodeToString(id)
+	cfg := &Config{
+		Parties: make(map[string]*PartyConfig),
+		Pending: map[string]*PartyConfig{
+			hash: {Private: kps[1].Secret, Public: kps[1].Public, Index: -1},
+		},
+	}
+	var final *service.FinalStatement
+	fetch := func(req []byte) (*service.FinalStatement, onet.ClientError) {
+		require.Equal(t, id, req)
+		if final == nil {
+			return nil, onet.NewClientErrorCode(service.ErrorOtherFinals,
+				"Not all other conodes finalized yet")
+		}
+		return final, nil
+	}
+	c := service.NewClient()
+	belongs := func(fs *service.FinalStatement, id []byte) bool {
+		return c.BelongsTo(si.Address, fs, id)
+	}
+
+	require.Equal(t, 0, len(joinPending(cfg, fetch, belongs)))
+	require.Equal(t, 1, len(cfg.Pending))
+
+	// An invalid signature keeps the party pending
+	final = fs
+	fs.Signature = id
+	require.Equal(t, 0, len(joinPending(cfg, fetch, belongs)))
+	require.Equal(t, 1, len(cfg.Pending))
+
+	h, err := fs.Hash()
+	log.ErrFatal(err)
+	fs.Signature, err = conode.Sign(h)
+	log.ErrFatal(err)
+	require.Equal(t, []string{hash}, joinPending(cfg, fetch, belongs))
+	require.Equal(t, 0, len(cfg.Pending))
+	party, err := cfg.getPartybyHash(hash)
+	log.ErrFatal(err)
+	require.Equal(t, 1, party.Index)
+	require.Equal(t, fs, party.Final)
+
+	// A party without the key of the attendee is dropped
+	cfg.Pending[hash] = &PartyConfig{Public: config.NewKeyPair(network.Suite).Public}
+	require.Equal(t, 0, len(joinPending(cfg, fetch, belongs)))
+	require.Equal(t, 0, len(cfg.Pending))
+
+	// A sub-party is joined with the merged statement
+	sub := *fs.Desc
+	sub.Parties = []*service.ShortDesc{
+		{Location: "here", Roster: sub.Roster},
+		{Location: "there", Roster: sub.Roster},
+	}
+	id = sub.Hash()
+	hash = base64.StdEncoding.EncodeToString(id)
+	merged := &service.FinalStatement{Desc: &service.PopDesc{}, Merged: true,
+		Attendees: fs.Attendees}
+	*merged.Desc = sub
+	merged.Desc.Location = "here" + service.DELIMETER + "there"
+	h, err = merged.Hash()
+	log.ErrFatal(err)
+	merged.Signature, err = conode.Sign(h)
+	log.ErrFatal(err)
+	final = merged
+	cfg.Pending[hash] = &PartyConfig{Private: kps[0].Secret, Public: kps[0].Public,
+		Index: -1}
+	require.Equal(t, []string{hash}, joinPending(cfg, fetch, belongs))
+	party, err = cfg.getPartybyHash(hash)
+	log.ErrFatal(err)
+	require.Equal(t, merged, party.Final)
+}
+
+func TestWriteStatement(t *testing.T) {
+	conode := eddsa.NewEdDSA(random.Stream)
+	si := network.NewServerIdentity(conode.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	fs := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "2017-08-08 15:00",
+			Location: "here",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: []abstract.Point{config.NewKeyPair(network.Suite).Public},
+	}
+	h, err := fs.Hash()
+	log.ErrFatal(err)
+	fs.Signature, err = conode.Sign(h)
+	log.ErrFatal(err)
+
+	dir, err := ioutil.TempDir("", "statement")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, writeStatement("", fs))
+	hash := base64.StdEncoding.EncodeToString(fs.Desc.Hash())
+	name := path.Join(dir, statementFileName(hash))
+	require.False(t, strings.Contains(path.Base(name), "/"))
+	require.Nil(t, writeStatement(name, fs))
+	// Overwriting works, too
+	require.Nil(t, writeStatement(name, fs))
+	files, err := ioutil.ReadDir(dir)
+	log.ErrFatal(err)
+	require.Equal(t, 1, len(files))
+
+	buf, err := ioutil.ReadFile(name)
+	log.ErrFatal(err)
+	fs2, err := decodeFinal(buf)
+	log.ErrFatal(err)
+	require.Nil(t, fs2.Verify())
+	require.Equal(t, fs.Desc.Hash(), fs2.Desc.Hash())
+
+	require.NotNil(t, writeStatement(path.Join(dir, "missing", "dir"), fs))
+}
+
+func TestOrgImport(t *testing.T) {
+	conode := eddsa.NewEdDSA(random.Stream)
+	si := network.NewServerIdentity(conode.Public,
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	fs := &service.FinalStatement{
+		Desc: &service.PopDesc{
+			Name:     "test",
+			DateTime: "2017-08-08 15:00",
+			Location: "here",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: []abstract.Point{config.NewKeyPair(network.Suite).Public},
+	}
+	dir, err := ioutil.TempDir("", "import")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "final.toml")
+	write := func() {
+		buf, err := fs.ToToml()
+		log.ErrFatal(err)
+		log.ErrFatal(ioutil.WriteFile(file, buf, 0660))
+	}
+	orgImport := func() error {
+		return newApp().Run([]string{"pop", "-c", dir, "org", "import", file})
+	}
+	hash := base64.StdEncoding.EncodeToString(fs.Desc.Hash())
+	stored := func() bool {
+		cfg, err := newConfig(path.Join(dir, "config.bin"))
+		log.ErrFatal(err)
+		_, ok := cfg.Parties[hash]
+		return ok
+	}
+
+	// An unsigned statement is refused
+	write()
+	require.NotNil(t, orgImport())
+	require.False(t, stored())
+
+	h, err := fs.Hash()
+	log.ErrFatal(err)
+	fs.Signature, err = conode.Sign(h)
+	log.ErrFatal(err)
+	write()
+	require.Nil(t, orgImport())
+	require.True(t, stored())
+}
+
+func TestWriteAttendees(t *testing.T) {
+	atts := make([]abstract.Point, 3)
+	for i := range atts {
+		atts[i] = config.NewKeyPair(network.Suite).Public
+	}
+	var out bytes.Buffer
+	require.Nil(t, writeAttendees(&out, atts))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, len(atts), len(lines))
+	for i, l := range lines {
+		pub, err := crypto.String64ToPub(network.Suite, l)
+		require.Nil(t, err)
+		require.True(t, atts[i].Equal(pub))
+	}
+	out.Reset()
+	require.Nil(t, writeAttendees(&out, nil))
+	require.Equal(t, "", out.String())
+}
+
+func TestWriteStatus(t *testing.T) {
+	sis := make([]*network.ServerIdentity, 4)
+	for i := range sis {
+		sis[i] = network.NewServerIdentity(config.NewKeyPair(network.Suite).Public,
+			network.NewAddress(network.PlainTCP, fmt.Sprintf("0:%d", 2000+2*i)))
+	}
+	var buf bytes.Buffer
+	log.ErrFatal(writeStatus(&buf, []service.ConodeStatus{
+		{Conode: sis[0], Reachable: true, Exists: true, NumAttendees: 2},
+		{Conode: sis[1], Reachable: true, Exists: true},
+		{Conode: sis[2]},
+		{Conode: sis[3], Reachable: true, Exists: true, NumAttendees: 2,
+			Finalized: true},
+	}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, 4, len(lines))
+	require.Contains(t, lines[0], "has 2 attendees")
+	require.Equal(t, "tcp://0:2002: not finalized - its organizer didn't "+
+		"send the attendees", lines[1])
+	require.Contains(t, lines[2], "unreachable")
+	require.Contains(t, lines[3], "finalized")
+}
+
+func TestWatchParty(t *testing.T) {
+	states := []*partyState{
+		{Attendees: 2, Mergeable: true},
+		{Attendees: 2, Mergeable: true},
+		{Attendees: 3, Mergeable: true},
+		{Attendees: 3, Finalized: true, Mergeable: true},
+		{Attendees: 5, Finalized: true, Merged: true, Mergeable: true},
+		{Attendees: 5, Finalized: true, Merged: true, Mergeable: true},
+	}
+	polls := 0
+	poll := func() (*partyState, error) {
+		polls++
+		return states[polls-1], nil
+	}
+	var buf bytes.Buffer
+	log.ErrFatal(watchParty(&buf, poll, time.Millisecond, nil))
+	require.Equal(t, 5, polls)
+	require.Equal(t, "2 attendees\nattendees: 2 -> 3\nfinalized\n"+
+		"attendees: 3 -> 5\nmerged\n", buf.String())
+
+	// A party without other parties is done once it's finalized
+	buf.Reset()
+	single := func() (*partyState, error) {
+		return &partyState{Attendees: 2, Finalized: true}, nil
+	}
+	log.ErrFatal(watchParty(&buf, single, time.Millisecond, nil))
+	require.Equal(t, "2 attendees\nfinalized\n", buf.String())
+
+	// Closing stop ends the watch
+	stop := make(chan struct{})
+	close(stop)
+	pending := func() (*partyState, error) {
+		return &partyState{Attendees: 1, Mergeable: true}, nil
+	}
+	log.ErrFatal(watchParty(&buf, pending, time.Hour, stop))
+
+	failing := func() (*partyState, error) {
+		return nil, errors.New("unreachable")
+	}
+	require.NotNil(t, watchParty(&buf, failing, time.Millisecond, nil))
+}
+
+func TestSelftest(t *testing.T) {
+	wd, err := os.Getwd()
+	log.ErrFatal(err)
+	dir, err := ioutil.TempDir("", "pop-selftest")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	var out bytes.Buffer
+	require.Nil(t, runSelftest(&out, dir))
+	require.False(t, strings.Contains(out.String(), "FAIL"), out.String())
+	for _, stage := range []string{"store config", "finalize", "sign", "verify"} {
+		require.True(t, strings.Contains(out.String(), "PASS "+stage), out.String())
+	}
+	// The conodes stored their data in dir
+	for _, sub := range []string{"0", "1"} {
+		_, err = os.Stat(path.Join(dir, sub, "storage"))
+		require.Nil(t, err)
+	}
+	wd2, err := os.Getwd()
+	log.ErrFatal(err)
+	require.Equal(t, wd, wd2)
+}
+
+func TestAuthToken(t *testing.T) {
+	f, err := ioutil.TempFile("", "pop_config")
+	log.ErrFatal(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("[PoP]\nAuthToken = \"secret\"\n")
+	log.ErrFatal(err)
+	log.ErrFatal(f.Close())
+	log.ErrFatal(os.Setenv(service.ENVConfig, f.Name()))
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	os.Unsetenv(service.ENVConfig)
+	dir, err := ioutil.TempDir("", "pop-auth")
+	log.ErrFatal(err)
+	defer os.RemoveAll(dir)
+	addr := servers[0].ServerIdentity.Address.NetworkAddress()
+
+	// Without the token the conode refuses even to send a PIN
+	err = newApp().Run([]string{"pop", "-c", dir, "org", "link", addr})
+	require.NotNil(t, err)
+	require.Equal(t, service.ErrorUnauthorized, err.(onet.ClientError).ErrorCode())
+	log.ErrFatal(newApp().Run([]string{"pop", "-c", dir, "--token", "secret",
+		"org", "link", addr}))
+	log.ErrFatal(os.Setenv("POP_TOKEN", "secret"))
+	defer os.Unsetenv("POP_TOKEN")
+	log.ErrFatal(newApp().Run([]string{"pop", "-c", dir, "org", "link", addr}))
+}
+
 func TestMainFunc(t *testing.T) {
 	os.Args = []string{os.Args[0], "--help"}
 	main()
@@ -1,6 +1,10 @@
 package main
 
-import "gopkg.in/urfave/cli.v1"
+import (
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
 
 /*
 This holds the cli-commands so the main-file is less cluttered.
@@ -27,6 +31,12 @@ func init() {
 				Usage:     "stores the configuration",
 				ArgsUsage: "pop_desc.toml [merged_party.toml]",
 				Action:    orgConfig,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "compact,c",
+						Usage: "store only the hashes of the merged parties",
+					},
+				},
 			},
 			{
 				Name:      "public",
@@ -34,6 +44,12 @@ func init() {
 				Usage:     "stores a public key during the party",
 				ArgsUsage: "party_hash",
 				Action:    orgPublic,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "csv",
+						Usage: "read signed public keys from this file, created with 'attendee register'",
+					},
+				},
 			},
 			{
 				Name:      "final",
@@ -41,6 +57,28 @@ func init() {
 				Usage:     "finalizes the party",
 				ArgsUsage: "party_hash",
 				Action:    orgFinal,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "strict,s",
+						Usage: "fail if the other conodes have different attendees instead of dropping them",
+					},
+					cli.StringFlag{
+						Name:  "out,o",
+						Usage: "write the final statement to this file",
+					},
+					cli.BoolFlag{
+						Name:  "preview",
+						Usage: "only show which attendees would be dropped, without finalizing",
+					},
+					cli.IntFlag{
+						Name:  "min",
+						Usage: "refuse to finalize if less attendees than this are kept",
+					},
+					cli.StringFlag{
+						Name:  "attendees-file",
+						Usage: "finalize with the attendees of this file, one base64 public key per line, instead of the ones added with 'org public'",
+					},
+				},
 			},
 			{
 				Name:      "merge",
@@ -48,6 +86,153 @@ func init() {
 				Usage:     "starts merging process",
 				ArgsUsage: "party_hash",
 				Action:    orgMerge,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "partial,p",
+						Usage: "leave out parties whose conodes can't be reached",
+					},
+					cli.StringFlag{
+						Name:  "out,o",
+						Usage: "write the merged statement to this file",
+					},
+				},
+			},
+			{
+				Name:      "repropagate",
+				Usage:     "sends the final statement again to conodes that missed it",
+				ArgsUsage: "party_hash",
+				Action:    orgRepropagate,
+			},
+			{
+				Name:      "reconcile",
+				Usage:     "unites the attendees registered on the conodes before the finalization",
+				ArgsUsage: "party_hash",
+				Action:    orgReconcile,
+			},
+			{
+				Name:      "status",
+				Usage:     "shows which conodes of the party have the attendees and finalized",
+				ArgsUsage: "party_hash",
+				Action:    orgStatus,
+			},
+			{
+				Name:      "watch",
+				Usage:     "prints the changes of the party on the linked conode until it is merged",
+				ArgsUsage: "party_hash",
+				Action:    orgWatch,
+				Flags: []cli.Flag{
+					cli.DurationFlag{
+						Name:  "interval,i",
+						Value: 5 * time.Second,
+						Usage: "time between two requests to the conode",
+					},
+				},
+			},
+			{
+				Name:      "find",
+				Usage:     "prints the hashes of the parties with the given name and date",
+				ArgsUsage: "name [\"YYYY-MM-DD HH:mm\"]",
+				Action:    orgFind,
+			},
+			{
+				Name:      "transfer",
+				Aliases:   []string{"t"},
+				Usage:     "hands the party over to another organizer on the linked conode",
+				ArgsUsage: "party_hash public_key",
+				Action:    orgTransfer,
+			},
+			{
+				Name:      "revoke",
+				Usage:     "removes an attendee from the finalized party, which invalidates its tokens",
+				ArgsUsage: "party_hash public_key",
+				Action:    orgRevoke,
+			},
+			{
+				Name:      "rotate",
+				Usage:     "replaces the key of an attendee before the finalization, with the line printed by 'attendee rotate'",
+				ArgsUsage: "party_hash old_public_key new_public_key proof",
+				Action:    orgRotate,
+			},
+			{
+				Name:    "audit",
+				Aliases: []string{"a"},
+				Usage:   "verifies all final statements stored in the linked conode",
+				Action:  orgAudit,
+			},
+			{
+				Name:   "prune",
+				Usage:  "removes the parties that have been merged into another party from the linked conode",
+				Action: orgPrune,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "force,f",
+						Usage: "also remove the statements from before the merge, which verify the older tokens",
+					},
+				},
+			},
+			{
+				Name:   "pending",
+				Usage:  "lists the parties with operations on the linked conode waiting for other conodes",
+				Action: orgPending,
+			},
+			{
+				Name:      "cancel",
+				Usage:     "aborts the operations of the party on the linked conode waiting for other conodes",
+				ArgsUsage: "party_hash",
+				Action:    orgCancel,
+			},
+			{
+				Name:      "backup",
+				Usage:     "writes a dump of your parties on the linked conode to a file",
+				ArgsUsage: "backup_file",
+				Action:    orgBackup,
+			},
+			{
+				Name:      "restore",
+				Usage:     "adds the parties of a backup to the linked conode",
+				ArgsUsage: "backup_file",
+				Action:    orgRestore,
+			},
+			{
+				Name:      "export",
+				Aliases:   []string{"e"},
+				Usage:     "writes the final statement to a file",
+				ArgsUsage: "party_hash",
+				Action:    orgExport,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "format,f",
+						Value: "toml",
+						Usage: "output format: toml, json or bin",
+					},
+					cli.StringFlag{
+						Name:  "out,o",
+						Usage: "output file, standard output if empty",
+					},
+				},
+			},
+			{
+				Name:      "attendees",
+				Usage:     "prints the public keys of the attendees, one per line",
+				ArgsUsage: "party_hash",
+				Action:    orgAttendees,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "out,o",
+						Usage: "output file, standard output if empty",
+					},
+					cli.BoolFlag{
+						Name:  "count",
+						Usage: "only print the number of attendees",
+					},
+				},
+			},
+			{
+				Name:      "import",
+				Aliases:   []string{"i"},
+				Usage:     "stores a final statement in toml, json or bin format",
+				ArgsUsage: "final_statement_file",
+				Action:    orgImport,
 			},
 		},
 	}
@@ -62,6 +247,26 @@ func init() {
 				Usage:   "create a private/public key pair",
 				Action:  attCreate,
 			},
+			{
+				Name:      "register",
+				Aliases:   []string{"r"},
+				Usage:     "prints a signed public key for the csv-registration",
+				ArgsUsage: "private_key party_hash",
+				Action:    attRegister,
+			},
+			{
+				Name:      "rotate",
+				Usage:     "creates a new key pair and prints the proof the organizer needs to replace the old key with it",
+				ArgsUsage: "private_key party_hash",
+				Action:    attRotate,
+			},
+			{
+				Name:      "prejoin",
+				Aliases:   []string{"p"},
+				Usage:     "join a poparty that is not finalized yet, the join completes once it is",
+				ArgsUsage: "private_key party_hash",
+				Action:    attPrejoin,
+			},
 			{
 				Name:      "join",
 				Aliases:   []string{"j"},
@@ -73,14 +278,42 @@ func init() {
 						Name:  "yes,y",
 						Usage: "disable asking",
 					},
+					cli.DurationFlag{
+						Name:  "wait,w",
+						Usage: "wait up to this duration for the party to be finalized, e.g. 10m",
+					},
+					cli.StringFlag{
+						Name:  "expect-roster,e",
+						Usage: "group.toml with the roster that has to sign the party",
+					},
 				},
 			},
+			{
+				Name:      "reindex",
+				Usage:     "updates the index of the public key after the final statement changed",
+				ArgsUsage: "party_hash",
+				Action:    attReindex,
+			},
 			{
 				Name:      "sign",
 				Aliases:   []string{"s"},
 				Usage:     "sign a message and its context",
 				ArgsUsage: "message context party_hash",
 				Action:    attSign,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "chain,c",
+						Usage: "chain the signature to a previous signature in the same context",
+					},
+					cli.StringFlag{
+						Name:  "purpose,p",
+						Usage: "what the token is for, e.g. login or vote",
+					},
+					cli.BoolFlag{
+						Name:  "raw,r",
+						Usage: "sign the message without the token envelope holding the purpose",
+					},
+				},
 			},
 			{
 				Name:      "verify",
@@ -88,6 +321,61 @@ func init() {
 				Usage:     "verifies a tag and a signature",
 				ArgsUsage: "message context tag signature party_hash",
 				Action:    attVerify,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "address,a",
+						Usage: "fetch the final statement from the conode at IP-address:port",
+					},
+					cli.StringFlag{
+						Name:  "chain,c",
+						Usage: "verify that the signature is chained to this previous signature",
+					},
+					cli.StringFlag{
+						Name:  "chain-tag",
+						Usage: "the tag of the chained signature, which has to be the same",
+					},
+					cli.StringFlag{
+						Name:  "purpose,p",
+						Usage: "the purpose the token has been signed for",
+					},
+					cli.BoolFlag{
+						Name:  "raw,r",
+						Usage: "verify a signature of the message without the token envelope",
+					},
+					cli.IntFlag{
+						Name:  "min-set",
+						Usage: "fail if the party has less attendees to hide the signer among",
+					},
+					cli.StringFlag{
+						Name:  "roster",
+						Usage: "group.toml with the roster that has to sign the party",
+					},
+				},
+			},
+			{
+				Name:      "verify-batch",
+				Aliases:   []string{"vb"},
+				Usage:     "verifies the tokens of a csv-file with rows of msg,ctx,sig,tag",
+				ArgsUsage: "tokens.csv party_hash",
+				Action:    attVerifyBatch,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "address,a",
+						Usage: "fetch the final statement from the conode at IP-address:port",
+					},
+					cli.StringFlag{
+						Name:  "purpose,p",
+						Usage: "the purpose the tokens have been signed for",
+					},
+					cli.BoolFlag{
+						Name:  "raw,r",
+						Usage: "verify signatures of the messages without the token envelope",
+					},
+					cli.StringFlag{
+						Name:  "roster",
+						Usage: "group.toml with the roster that has to sign the party",
+					},
+				},
 			},
 		},
 	}
@@ -108,6 +396,20 @@ func init() {
 				Usage:     "verifies a tag and a signature",
 				ArgsUsage: "message context tag signature party_hash",
 				Action:    attVerify,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "address,a",
+						Usage: "fetch the final statement from the conode at IP-address:port",
+					},
+					cli.StringFlag{
+						Name:  "chain,c",
+						Usage: "verify that the signature is chained to this previous signature",
+					},
+					cli.StringFlag{
+						Name:  "chain-tag",
+						Usage: "the tag of the chained signature, which has to be the same",
+					},
+				},
 			},
 		},
 	}
@@ -1,13 +1,30 @@
 package service
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/dedis/protobuf"
 	"github.com/satori/go.uuid"
+	"gopkg.in/dedis/cothority.v1/skipchain"
 	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/base64"
 	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
@@ -29,8 +46,71 @@ const (
 	// ErrorTimeout indicates that waiting on network was too long
 	// Either node is down or network is partitioned
 	ErrorTimeout
+	// ErrorWrongRoster indicates that another conode stored the party
+	// with a different roster
+	ErrorWrongRoster
+	// ErrorNoAttendees indicates that the party has no attendees
+	ErrorNoAttendees
+	// ErrorNotMergeable indicates that merging is disabled on the conode
+	ErrorNotMergeable
+	// ErrorChallenge indicates that the nonce of a signed request is not
+	// a challenge of the conode, has already been used or expired
+	ErrorChallenge
+	// ErrorAttendeesMismatch indicates that a strict finalization failed
+	// because the conodes have different attendees
+	ErrorAttendeesMismatch
+	// ErrorExpired indicates that the party expired
+	ErrorExpired
+	// ErrorUnauthorized indicates that the conode requires a token the
+	// client didn't send, see Config.AuthToken
+	ErrorUnauthorized
+	// ErrorTooFewAttendees indicates that a finalization would keep less
+	// attendees than the requested minimum
+	ErrorTooFewAttendees
+	// ErrorCancelled indicates that the operation has been aborted by
+	// CancelPending
+	ErrorCancelled
+	// ErrorNoCommonAttendees indicates that the conodes of the party have
+	// no attendee in common
+	ErrorNoCommonAttendees
 )
 
+const (
+	// PopVersionSuite hashes the party with the hash function of
+	// network.Suite
+	PopVersionSuite = iota
+	// PopVersionSHA512 hashes the party with SHA-512
+	PopVersionSHA512
+)
+
+const (
+	// OrderSorted sorts the attendees of the final statement by the binary
+	// marshalling of their keys, also after a merge.
+	OrderSorted = iota
+	// OrderInsertion keeps the attendees in the order the organizer
+	// registered them. A merge appends the attendees of the parties in the
+	// order of the hashes of the parties.
+	OrderInsertion
+)
+
+// popHashes maps the Version of a PopDesc to its hash function.
+var popHashes = map[int]func() hash.Hash{
+	PopVersionSuite:  network.Suite.Hash,
+	PopVersionSHA512: sha512.New,
+}
+
+// DateTimeFormat is the layout of PopDesc.DateTime, always in UTC
+const DateTimeFormat = "2006-01-02 15:04"
+
+// the zones accepted after a DateTime, the offsets are relative to UTC
+var dateTimeZones = []string{" -0700", " -07:00", "-07:00"}
+
+// pollInterval is the time between two requests when waiting on a conode.
+const pollInterval = 100 * time.Millisecond
+
+// maxPollInterval bounds the backoff when waiting for a finalization.
+const maxPollInterval = 5 * time.Second
+
 func init() {
 	network.RegisterMessage(&FinalStatement{})
 	network.RegisterMessage(&PopDesc{})
@@ -40,6 +120,12 @@ func init() {
 // service.
 type Client struct {
 	*onet.Client
+	// Suite is used by Sign and Verify for the tokens. If it is nil,
+	// network.Suite is used.
+	Suite abstract.Suite
+	// AuthToken is sent with every request if it is set. It is needed for
+	// the conodes configured with an AuthToken, see Config.
+	AuthToken []byte
 }
 
 // NewClient instantiates a new Client
@@ -47,13 +133,83 @@ func NewClient() *Client {
 	return &Client{Client: onet.NewClient(Name)}
 }
 
+// SendProtobuf sends msg to dst and decodes the reply into ret, like
+// onet.Client.SendProtobuf, but wraps msg into an AuthEnvelope if the
+// client has an AuthToken.
+func (c *Client) SendProtobuf(dst *network.ServerIdentity, msg interface{},
+	ret interface{}) onet.ClientError {
+	if len(c.AuthToken) == 0 {
+		return c.Client.SendProtobuf(dst, msg, ret)
+	}
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	buf, err = protobuf.Encode(&AuthEnvelope{Token: c.AuthToken, Request: buf})
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+	reply, cerr := c.Send(dst, path, buf)
+	if cerr != nil {
+		return cerr
+	}
+	if ret != nil {
+		err = protobuf.DecodeWithConstructors(reply, ret,
+			network.DefaultConstructors(network.Suite))
+		if err != nil {
+			return onet.NewClientError(err)
+		}
+	}
+	return nil
+}
+
 // PinRequest takes a destination-address, a PIN and a public key as an argument.
 // If no PIN is given, the cothority will print out a "PIN: ...."-line on the stdout.
 // If the PIN is given and is correct, the public key will be stored in the
-// service.
-func (c *Client) PinRequest(dst network.Address, pin string, pub abstract.Point) onet.ClientError {
+// service, which confirms it with the reply.
+func (c *Client) PinRequest(dst network.Address, pin string, pub abstract.Point) (
+	*PinReply, onet.ClientError) {
 	si := &network.ServerIdentity{Address: dst}
-	return c.SendProtobuf(si, &PinRequest{pin, pub}, nil)
+	res := &PinReply{}
+	if cerr := c.SendProtobuf(si, &PinRequest{pin, pub}, res); cerr != nil {
+		return nil, cerr
+	}
+	return res, nil
+}
+
+// Link links the public key to the conode at hostport. If no PIN is given,
+// the conode prints out a PIN in its log and the reply is nil. On the
+// second call with the correct PIN, the public key is stored in the conode
+// and the reply confirms it.
+func (c *Client) Link(hostport, pin string, pub abstract.Point) (*PinReply,
+	onet.ClientError) {
+	addr, err := ResolveAddress(hostport)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	reply, cerr := c.PinRequest(addr, pin, pub)
+	if cerr != nil {
+		if cerr.ErrorCode() == ErrorWrongPIN && pin == "" {
+			return nil, nil
+		}
+		return nil, cerr
+	}
+	return reply, nil
+}
+
+// ResolveAddress looks up the host of a host:port string and returns the
+// corresponding tcp-address of the conode.
+func ResolveAddress(hostport string) (network.Address, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	return network.NewTCPAddress(fmt.Sprintf("%s:%s", addrs[0], port)), nil
 }
 
 // StoreConfig sends the configuration to the conode for later usage.
@@ -70,7 +226,253 @@ func (c *Client) StoreConfig(dst network.Address, p *PopDesc, priv abstract.Scal
 	return nil
 }
 
-// Send Request to update local final statement
+// StoreConfigs sends several configurations to the conode with one
+// signature and returns their hashes. Either all of them are stored or
+// none, which is the case if one of them is already finalized.
+func (c *Client) StoreConfigs(dst network.Address, descs []*PopDesc,
+	priv abstract.Scalar) ([][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &StoreConfigsRequest{Descs: descs, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &StoreConfigsReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.IDs, nil
+}
+
+// StoreConfigAndWaitPropagation stores the config on the conode and then
+// asks all conodes of the roster until they have the config, too. As every
+// conode gets the config from its own organizer, this returns once all
+// organizers stored the party. If this doesn't happen before the timeout,
+// ErrorTimeout is returned.
+func (c *Client) StoreConfigAndWaitPropagation(dst network.Address, p *PopDesc,
+	priv abstract.Scalar, timeout time.Duration) onet.ClientError {
+	if err := c.StoreConfig(dst, p, priv); err != nil {
+		return err
+	}
+	hash := p.Hash()
+	deadline := time.Now().Add(timeout)
+	interval := pollInterval
+	missing := p.Roster.List
+	for {
+		var left []*network.ServerIdentity
+		for _, si := range missing {
+			exists, _, err := c.HasConfig(si.Address, hash)
+			if err != nil {
+				log.Lvl2("Couldn't ask", si, err)
+			}
+			if !exists {
+				left = append(left, si)
+			}
+		}
+		missing = left
+		if len(missing) == 0 {
+			return nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return onet.NewClientErrorCode(ErrorTimeout,
+				fmt.Sprintf("%d conodes don't have the config: %v",
+					len(missing), missing))
+		}
+		if interval > wait {
+			interval = wait
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// HasConfig asks the conode whether it stored the party with the given hash
+// and whether the party is finalized. It needs no signature.
+func (c *Client) HasConfig(dst network.Address, hash []byte) (exists,
+	finalized bool, cerr onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &HasConfigReply{}
+	cerr = c.SendProtobuf(si, &HasConfigRequest{hash}, res)
+	if cerr != nil {
+		return false, false, cerr
+	}
+	return res.Exists, res.Finalized, nil
+}
+
+// FinalizeStatus returns the state of the party with the given hash on
+// every conode of its roster, as seen by the conode at dst.
+func (c *Client) FinalizeStatus(dst network.Address, hash []byte) ([]ConodeStatus,
+	onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &FinalizeStatusReply{}
+	cerr := c.SendProtobuf(si, &FinalizeStatusRequest{hash}, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Conodes, nil
+}
+
+// IsRegistered returns whether the public key is one of the attendees of
+// the party with the given hash. Before the finalization the attendees are
+// the ones the organizer sent with the last finalization attempt.
+func (c *Client) IsRegistered(dst network.Address, hash []byte,
+	pub abstract.Point) (bool, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &IsRegisteredReply{}
+	cerr := c.SendProtobuf(si, &IsRegisteredRequest{hash, pub}, res)
+	if cerr != nil {
+		return false, cerr
+	}
+	return res.Registered, nil
+}
+
+// GetAttendeeInfo returns whether the public key is registered for the
+// party on the conode, and when the conode first got it. The time is 0 if
+// the conode never got the key from the organizer.
+func (c *Client) GetAttendeeInfo(dst network.Address, hash []byte,
+	pub abstract.Point) (registered bool, registeredAt int64, cerr onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &GetAttendeeInfoReply{}
+	cerr = c.SendProtobuf(si, &GetAttendeeInfoRequest{hash, pub}, res)
+	if cerr != nil {
+		return false, 0, cerr
+	}
+	return res.Registered, res.RegisteredAt, nil
+}
+
+// suite returns the suite of the tokens.
+func (c *Client) suite() abstract.Suite {
+	if c.Suite == nil {
+		return network.Suite
+	}
+	return c.Suite
+}
+
+// TagLength returns the length of the linkage tags of the tokens. The tag
+// is a point of the suite at the end of the signature created by
+// anon.Sign.
+func (c *Client) TagLength() int {
+	return c.suite().PointLen()
+}
+
+// Scheme returns the name of the suite of the tokens, which identifies
+// their signature scheme together with the tag length.
+func (c *Client) Scheme() string {
+	return c.suite().String()
+}
+
+// Sign signs msg in the context ctx as the attendee with the given index in
+// the final statement, which has the private key priv. It returns the
+// signature and the linkage tag, which is the same for all signatures of
+// the attendee in this context.
+func (c *Client) Sign(final *FinalStatement, index int, priv abstract.Scalar,
+	msg, ctx []byte) (sig, tag []byte, err error) {
+	if index < 0 || index >= len(final.Attendees) {
+		return nil, nil, fmt.Errorf("index %d is not in the %d attendees",
+			index, len(final.Attendees))
+	}
+	suite := c.suite()
+	if !suite.Point().Mul(nil, priv).Equal(final.Attendees[index]) {
+		return nil, nil, errors.New("private key doesn't match the attendee")
+	}
+	sigtag := anon.Sign(suite, random.Stream, msg,
+		anon.Set(final.Attendees), ctx, index, priv)
+	split := len(sigtag) - c.TagLength()
+	if split < 0 {
+		return nil, nil, errors.New("signature is shorter than a tag")
+	}
+	return sigtag[:split], sigtag[split:], nil
+}
+
+// Verify checks that sig is a signature of msg in the context ctx by one
+// of the attendees of the final statement, and tag its linkage tag. It
+// returns the attendees the signature verified against, which is the
+// anonymity set of the signer: all the attendees, or the attendees of one
+// of the origins if the token was signed before the merge.
+func (c *Client) Verify(final *FinalStatement, msg, ctx, sig,
+	tag []byte) ([]abstract.Point, error) {
+	if len(tag) != c.TagLength() {
+		return nil, fmt.Errorf("tag has %d bytes instead of %d", len(tag),
+			c.TagLength())
+	}
+	err := c.verifySet(final.Attendees, msg, ctx, sig, tag)
+	if err == nil {
+		return final.Attendees, nil
+	}
+	// The token may have been signed before the merge
+	for _, o := range final.Origins {
+		atts, oerr := final.OriginAttendees(o.Party)
+		if oerr == nil && c.verifySet(atts, msg, ctx, sig, tag) == nil {
+			return atts, nil
+		}
+	}
+	return nil, err
+}
+
+// verifySet returns nil if sig is a signature of msg in the context ctx by
+// one of atts, and tag its linkage tag.
+func (c *Client) verifySet(atts []abstract.Point, msg, ctx, sig, tag []byte) error {
+	sigtag := append(append([]byte{}, sig...), tag...)
+	ctag, err := anon.Verify(c.suite(), msg, anon.Set(atts), ctx, sigtag)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tag, ctag) {
+		return fmt.Errorf("Tag and calculated tag are not equal:\n%x - %x", tag, ctag)
+	}
+	return nil
+}
+
+// SignWeighted works like Sign, but the signature only hides the attendee
+// among the attendees with the same weight, so that VerifyWeighted can
+// return the weight. The tag is the same as the one of Sign.
+func (c *Client) SignWeighted(final *FinalStatement, index int,
+	priv abstract.Scalar, msg, ctx []byte) (sig, tag []byte, err error) {
+	if index < 0 || index >= len(final.Attendees) {
+		return nil, nil, fmt.Errorf("index %d is not in the %d attendees",
+			index, len(final.Attendees))
+	}
+	pub := final.Attendees[index]
+	class := final.weightClass(final.weight(index))
+	for i, a := range class {
+		if a.Equal(pub) {
+			return c.Sign(&FinalStatement{Attendees: class}, i, priv, msg, ctx)
+		}
+	}
+	return nil, nil, errors.New("attendee is not in its weight class")
+}
+
+// VerifyWeighted returns the weight of the signer if sig is a signature of
+// msg in the context ctx created with SignWeighted by one of the attendees
+// of the final statement, and tag its linkage tag. Only the attendees with
+// this weight can create such a signature, and the signer stays anonymous
+// among them.
+func (c *Client) VerifyWeighted(final *FinalStatement, msg, ctx, sig,
+	tag []byte) (int, error) {
+	for _, w := range final.weightClasses() {
+		class := &FinalStatement{Attendees: final.weightClass(w)}
+		if _, err := c.Verify(class, msg, ctx, sig, tag); err == nil {
+			return w, nil
+		}
+	}
+	return 0, errors.New("signature doesn't verify in any weight class")
+}
+
+// Send Request to update local final statement. The returned statement is
+// checked to belong to the party with the given hash.
 func (c *Client) FetchFinal(dst network.Address, hash []byte) (
 	*FinalStatement, onet.ClientError) {
 	si := &network.ServerIdentity{Address: dst}
@@ -79,9 +481,125 @@ func (c *Client) FetchFinal(dst network.Address, hash []byte) (
 	if err != nil {
 		return nil, err
 	}
+	if res.Final == nil || res.Final.Desc == nil || res.Final.Desc.Roster == nil ||
+		!c.BelongsTo(dst, res.Final, hash) {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Received statement doesn't match the hash")
+	}
 	return res.Final, nil
 }
 
+// FetchFinalHeader returns the final statement of the party with the given
+// hash without the attendees, so that a verifier with cached attendees
+// can check a new signature without fetching them again. Like for
+// FetchFinal, the header is checked to belong to the party.
+func (c *Client) FetchFinalHeader(dst network.Address, hash []byte) (
+	*FinalHeader, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &FinalHeader{}
+	cerr := c.SendProtobuf(si, &FetchHeaderRequest{hash}, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if res.Desc == nil || res.Desc.Roster == nil ||
+		!c.BelongsTo(dst, res.Statement(nil), hash) {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Received header doesn't match the hash")
+	}
+	return res, nil
+}
+
+// Statement returns the final statement of the header with the given
+// attendees, which must be in the order of the statement.
+func (h *FinalHeader) Statement(atts []abstract.Point) *FinalStatement {
+	return &FinalStatement{
+		Desc:        h.Desc,
+		Attendees:   atts,
+		Signature:   h.Signature,
+		Merged:      h.Merged,
+		FinalizedAt: h.FinalizedAt,
+		Weights:     h.Weights,
+		Origins:     h.Origins,
+		Epoch:       h.Epoch,
+	}
+}
+
+// VerifySkipBlock fetches the skipblock with the given ID from the roster of
+// the party and returns an error if it doesn't hold the hash of the final
+// statement, see Config.StoreOnSkipchain.
+func (c *Client) VerifySkipBlock(final *FinalStatement, id []byte) onet.ClientError {
+	return verifySkipBlock(final, id)
+}
+
+// verifySkipBlock is VerifySkipBlock, also used by the service to check the
+// skipblocks sent by other conodes.
+func verifySkipBlock(final *FinalStatement, id []byte) onet.ClientError {
+	if len(id) == 0 {
+		return onet.NewClientErrorCode(ErrorInternal, "No skipblock")
+	}
+	if final.Desc == nil || final.Desc.Roster == nil {
+		return onet.NewClientErrorCode(ErrorInternal, "No roster in statement")
+	}
+	hash, err := final.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	sb, cerr := skipchain.NewClient().GetSingleBlock(final.Desc.Roster, id)
+	if cerr != nil {
+		return cerr
+	}
+	_, msg, err := network.Unmarshal(sb.Data)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	entry, ok := msg.(*SkipchainEntry)
+	if !ok || !bytes.Equal(entry.PopHash, final.Desc.Hash()) ||
+		!bytes.Equal(entry.Hash, hash) {
+		return onet.NewClientErrorCode(ErrorInternal,
+			"Skipblock doesn't hold the final statement")
+	}
+	return nil
+}
+
+// BelongsTo returns true if fs is the statement of the party with the given
+// hash, or the merged statement of one of its sub-parties. The compact
+// sub-parties are resolved with GetParty, which checks their hash, first
+// on dst, then on the conodes of the merged party.
+func (c *Client) BelongsTo(dst network.Address, fs *FinalStatement,
+	hash []byte) bool {
+	if bytes.Equal(fs.Desc.Hash(), hash) {
+		return true
+	}
+	if !fs.Merged {
+		return false
+	}
+	addrs := []network.Address{dst}
+	for _, si := range fs.Desc.Roster.List {
+		if si.Address != dst {
+			addrs = append(addrs, si.Address)
+		}
+	}
+	for _, party := range fs.Desc.Parties {
+		if party.IsCompact() {
+			id := party.ID
+			party = nil
+			for _, addr := range addrs {
+				if sd, cerr := c.GetParty(addr, id); cerr == nil {
+					party = sd
+					break
+				}
+			}
+			if party == nil {
+				continue
+			}
+		}
+		if bytes.Equal(fs.Desc.subPartyDesc(party).Hash(), hash) {
+			return true
+		}
+	}
+	return false
+}
+
 // Finalize takes the address of the conode-server, a pop-description and a
 // list of attendees public keys. It contacts the other conodes and checks
 // if they are available and already have a description. If so, all attendees
@@ -90,10 +608,110 @@ func (c *Client) FetchFinal(dst network.Address, hash []byte) (
 // will be returned.
 func (c *Client) Finalize(dst network.Address, p *PopDesc, attendees []abstract.Point,
 	priv abstract.Scalar) (*FinalStatement, onet.ClientError) {
-	si := &network.ServerIdentity{Address: dst}
+	res, cerr := c.FinalizeWithCounts(dst, p, attendees, priv, false)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Final, nil
+}
+
+// FinalizeFromFile works like Finalize, with the attendees read from the
+// file at attendeesPath, see ReadAttendeesFile.
+func (c *Client) FinalizeFromFile(dst network.Address, p *PopDesc,
+	attendeesPath string, priv abstract.Scalar) (*FinalStatement, onet.ClientError) {
+	atts, err := ReadAttendeesFile(attendeesPath)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	return c.Finalize(dst, p, atts, priv)
+}
+
+// ReadAttendeesFile reads the public keys of the attendees from a file with
+// one base64 key per line, as written by 'pop org attendees'. Empty lines
+// are skipped.
+func ReadAttendeesFile(path string) ([]abstract.Point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var atts []abstract.Point
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		str := strings.TrimSpace(scanner.Text())
+		if str == "" {
+			continue
+		}
+		pub, err := crypto.String64ToPub(network.Suite, str)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid public key: %s", path, line, err)
+		}
+		atts = append(atts, pub)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return atts, nil
+}
+
+// FinalizeStrict works like Finalize, but fails with ErrorAttendeesMismatch
+// if the other conodes don't have exactly the same attendees, instead of
+// keeping only the common ones.
+func (c *Client) FinalizeStrict(dst network.Address, p *PopDesc,
+	attendees []abstract.Point, priv abstract.Scalar) (*FinalStatement,
+	onet.ClientError) {
+	res, cerr := c.FinalizeWithCounts(dst, p, attendees, priv, true)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Final, nil
+}
+
+// FinalizeWithCounts works like Finalize, or FinalizeStrict if strict is
+// true, but returns the whole response, which holds the number of
+// attendees and conodes of the final statement.
+func (c *Client) FinalizeWithCounts(dst network.Address, p *PopDesc,
+	attendees []abstract.Point, priv abstract.Scalar, strict bool) (
+	*FinalizeResponse, onet.ClientError) {
+	return c.FinalizeWithMin(dst, p, attendees, priv, strict, 0)
+}
+
+// FinalizeWithMin works like FinalizeWithCounts, but fails with
+// ErrorTooFewAttendees if less than min attendees are common to all
+// conodes, so that a party with a meaningless anonymity set doesn't get
+// signed.
+func (c *Client) FinalizeWithMin(dst network.Address, p *PopDesc,
+	attendees []abstract.Point, priv abstract.Scalar, strict bool, min int) (
+	*FinalizeResponse, onet.ClientError) {
+	req := &FinalizeRequest{}
+	req.DescID = p.Hash()
+	req.Attendees = attendees
+	req.Strict = strict
+	req.MinAttendees = min
+	return c.finalize(dst, req, priv)
+}
+
+// FinalizeWeighted works like Finalize, but gives every attendee the weight
+// with the same index in weights, e.g. for delegates. The conodes keep the
+// weights of the attendees they have in common.
+func (c *Client) FinalizeWeighted(dst network.Address, p *PopDesc,
+	attendees []abstract.Point, weights []int, priv abstract.Scalar) (
+	*FinalStatement, onet.ClientError) {
 	req := &FinalizeRequest{}
 	req.DescID = p.Hash()
 	req.Attendees = attendees
+	req.Weights = weights
+	res, cerr := c.finalize(dst, req, priv)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Final, nil
+}
+
+// finalize signs the request with priv and sends it to dst.
+func (c *Client) finalize(dst network.Address, req *FinalizeRequest,
+	priv abstract.Scalar) (*FinalizeResponse, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
 	hash, err := req.Hash()
 	if err != nil {
 		return nil, onet.NewClientError(err)
@@ -108,45 +726,597 @@ func (c *Client) Finalize(dst network.Address, p *PopDesc, attendees []abstract.
 	if e != nil {
 		return nil, e
 	}
-	return res.Final, nil
+	return res, nil
+}
+
+// PreviewFinalize returns the attendees a finalization with the same
+// arguments would keep and the ones it would drop, without finalizing.
+func (c *Client) PreviewFinalize(dst network.Address, p *PopDesc,
+	attendees []abstract.Point, priv abstract.Scalar, strict bool) (
+	kept, dropped []abstract.Point, cerr onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	req := &PreviewFinalizeRequest{}
+	req.DescID = p.Hash()
+	req.Attendees = attendees
+	req.Strict = strict
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, hash)
+	if err != nil {
+		return nil, nil, onet.NewClientError(err)
+	}
+	res := &PreviewFinalizeReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	return res.Attendees, res.Dropped, nil
 }
 
 func (c *Client) Merge(dst network.Address, p *PopDesc, priv abstract.Scalar) (
 	*FinalStatement, onet.ClientError) {
+	return c.merge(dst, p, priv, false)
+}
+
+// MergePartial works like Merge, but parties whose conodes can't be reached
+// are left out of the merge. DroppedParties of the returned statement
+// lists them.
+func (c *Client) MergePartial(dst network.Address, p *PopDesc,
+	priv abstract.Scalar) (*FinalStatement, onet.ClientError) {
+	return c.merge(dst, p, priv, true)
+}
+
+func (c *Client) merge(dst network.Address, p *PopDesc, priv abstract.Scalar,
+	partial bool) (*FinalStatement, onet.ClientError) {
 	si := &network.ServerIdentity{Address: dst}
 	res := &FinalizeResponse{}
-	hash := p.Hash()
-	sg, err := crypto.SignSchnorr(network.Suite, priv, hash)
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &MergeRequest{ID: p.Hash(), Partial: partial, Nonce: nonce}
+	sg, err := crypto.SignSchnorr(network.Suite, priv, req.Hash())
 	if err != nil {
 		return nil, onet.NewClientError(err)
 	}
+	req.Signature = sg
 
-	e := c.SendProtobuf(si, &MergeRequest{hash, sg}, res)
+	e := c.SendProtobuf(si, req, res)
 	if e != nil {
 		return nil, e
 	}
 	return res.Final, nil
 }
 
+// ReopenRegistration asks the conode to drop the signature of the finalized
+// party with the given hash, so that it can be finalized again with a new
+// list of attendees. It has to be called on every conode of the party.
+func (c *Client) ReopenRegistration(dst network.Address, hash []byte,
+	priv abstract.Scalar) onet.ClientError {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return cerr
+	}
+	req := &ReopenRequest{ID: hash, Nonce: nonce}
+	sg, err := crypto.SignSchnorr(network.Suite, priv, req.Hash())
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	req.Signature = sg
+	return c.SendProtobuf(si, req, nil)
+}
+
+// Repropagate asks the conode to send the signed final statement with the
+// given hash again to all conodes of the party. It returns the number of
+// conodes that stored it.
+func (c *Client) Repropagate(dst network.Address, hash []byte,
+	priv abstract.Scalar) (int, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return 0, cerr
+	}
+	req := &RepropagateRequest{ID: hash, Nonce: nonce}
+	sg, err := crypto.SignSchnorr(network.Suite, priv, req.Hash())
+	if err != nil {
+		return 0, onet.NewClientError(err)
+	}
+	req.Signature = sg
+	res := &RepropagateReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return 0, cerr
+	}
+	return res.Replies, nil
+}
+
+// ReconcileAttendees asks the conode to unite the attendees registered on
+// all conodes of the party, before it is finalized. It returns the number
+// of attendees of the party afterwards. The union of the attendees is
+// signed with priv, so the other conodes only take it if the party is
+// linked to the same organizer there.
+func (c *Client) ReconcileAttendees(dst network.Address, hash []byte,
+	priv abstract.Scalar) (int, onet.ClientError) {
+	res, cerr := c.reconcile(dst, hash, nil, priv)
+	if cerr != nil {
+		return 0, cerr
+	}
+	if len(res.Attendees) == 0 {
+		return 0, nil
+	}
+	res, cerr = c.reconcile(dst, hash, res.Attendees, priv)
+	if cerr != nil {
+		return 0, cerr
+	}
+	return res.NumAttendees, nil
+}
+
+// reconcile sends a signed ReconcileRequest with the attendees to the
+// conode.
+func (c *Client) reconcile(dst network.Address, hash []byte,
+	atts []abstract.Point, priv abstract.Scalar) (*ReconcileReply,
+	onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &ReconcileRequest{ID: hash, Nonce: nonce, Attendees: atts}
+	sg, err := crypto.SignSchnorr(network.Suite, priv, req.Hash())
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature = sg
+	res := &ReconcileReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res, nil
+}
+
+// RegisterAttendees adds the attendees to the party with the given hash on
+// the conode. If propagate is true, the conode sends the attendees to all
+// conodes of the party, which take them if the party is linked to the same
+// organizer there. It returns the number of attendees on the conode and
+// the number of conodes that received the attendees.
+func (c *Client) RegisterAttendees(dst network.Address, hash []byte,
+	atts []abstract.Point, priv abstract.Scalar, propagate bool) (int, int,
+	onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return 0, 0, cerr
+	}
+	req := &RegisterAttendeesRequest{ID: hash, Attendees: atts,
+		Propagate: propagate, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return 0, 0, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return 0, 0, onet.NewClientError(err)
+	}
+	res := &RegisterAttendeesReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return 0, 0, cerr
+	}
+	return res.NumAttendees, res.NumConodes, nil
+}
+
+// GetChallenge returns a new nonce of the conode, which has to be signed
+// together with the next merge, reopen or transfer request. The clients
+// fetch it themselves.
+func (c *Client) GetChallenge(dst network.Address) ([]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &GetChallengeReply{}
+	err := c.SendProtobuf(si, &GetChallenge{}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Nonce, nil
+}
+
+// TransferParty hands the party with the given hash to the organizer with
+// the public key pub. It has to be signed by priv, the key of the current
+// owner. Only the owner on dst changes.
+func (c *Client) TransferParty(dst network.Address, hash []byte,
+	pub abstract.Point, priv abstract.Scalar) onet.ClientError {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return cerr
+	}
+	req := &TransferRequest{ID: hash, Public: pub, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	return c.SendProtobuf(si, req, nil)
+}
+
+// RotateAttendee asks the conode to replace the key oldKey of an attendee
+// by newKey, before the party is finalized. proof is the signature of
+// RotationMsg by oldKey, priv the key of the organizer.
+func (c *Client) RotateAttendee(dst network.Address, hash []byte,
+	oldKey, newKey abstract.Point, proof crypto.SchnorrSig,
+	priv abstract.Scalar) onet.ClientError {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return cerr
+	}
+	req := &RotateAttendeeRequest{ID: hash, Old: oldKey, New: newKey,
+		Proof: proof, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	return c.SendProtobuf(si, req, nil)
+}
+
+// RevokeAttendee asks to remove the attendee pub from the finalized party
+// with the given hash. Once the organizers of all conodes revoked the same
+// attendees, the statement is signed again with a higher Epoch and
+// returned. This invalidates all tokens of the revoked attendees, as they
+// don't verify against the new statement. Before that, ErrorOtherFinals
+// is returned.
+func (c *Client) RevokeAttendee(dst network.Address, hash []byte,
+	pub abstract.Point, priv abstract.Scalar) (*FinalStatement, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &RevokeAttendeeRequest{ID: hash, Attendee: pub, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &FinalizeResponse{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Final, nil
+}
+
+// GetAggregate returns the aggregate public key of the roster of the
+// finalized party with the given hash.
+func (c *Client) GetAggregate(dst network.Address, hash []byte) (
+	abstract.Point, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &GetAggregateReply{}
+	err := c.SendProtobuf(si, &GetAggregateRequest{hash}, res)
+	if err != nil {
+		return nil, err
+	}
+	agg := network.Suite.Point()
+	if err := agg.UnmarshalBinary(res.Aggregate); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	return agg, nil
+}
+
+// GetSubParties returns the hashes and locations of the parties that were
+// merged into the party with the given hash. It fails if the party is not
+// merged.
+func (c *Client) GetSubParties(dst network.Address, hash []byte) (
+	[]*SubParty, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &GetSubPartiesReply{}
+	err := c.SendProtobuf(si, &GetSubPartiesRequest{hash}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Parties, nil
+}
+
+// FindParty returns the hashes of the parties stored on the conode with the
+// given name and date. If datetime is empty, parties of all dates are
+// returned. As more than one party can match, all candidates are returned.
+func (c *Client) FindParty(dst network.Address, name, datetime string) (
+	[][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &FindPartyReply{}
+	err := c.SendProtobuf(si, &FindPartyRequest{name, datetime}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res.IDs, nil
+}
+
+// Audit asks the conode to verify all its finalized statements and returns
+// the hashes of the parties whose signature doesn't verify.
+func (c *Client) Audit(dst network.Address) ([][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &AuditReply{}
+	err := c.SendProtobuf(si, &AuditRequest{}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res.Failed, nil
+}
+
+// PruneMerged removes the parties that have been merged into another party
+// from the conode and returns their hashes. The entries of the sub-parties
+// holding the merged statement are kept, so that the merged party can be
+// fetched with their hashes. If force is set, the statements of the
+// sub-parties from before the merge are removed, so that tokens created
+// before the merge can't be verified with this conode anymore.
+func (c *Client) PruneMerged(dst network.Address, force bool,
+	priv abstract.Scalar) ([][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &PruneMergedRequest{Force: force, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &PruneMergedReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Removed, nil
+}
+
+// ListPending returns the hashes of the parties that have finalize or
+// merge operations on the conode waiting for replies of other conodes. The
+// request is signed with the private key priv of the linked organizer.
+func (c *Client) ListPending(dst network.Address,
+	priv abstract.Scalar) ([][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &ListPendingRequest{Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &ListPendingReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Pending, nil
+}
+
+// CancelPending aborts the operations of the party with the given hash on
+// the conode that wait for replies of other conodes, e.g. because one of
+// them is down. The aborted operations return with ErrorCancelled. The
+// request is signed with the private key priv of the linked organizer.
+func (c *Client) CancelPending(dst network.Address, hash []byte,
+	priv abstract.Scalar) onet.ClientError {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return cerr
+	}
+	req := &CancelPendingRequest{ID: hash, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	return c.SendProtobuf(si, req, &CancelPendingReply{})
+}
+
+// Backup returns a versioned dump of the parties of the organizer priv
+// stored on the conode, including the statements gathered for the merges.
+// It can be given to Restore on the same or on another conode.
+func (c *Client) Backup(dst network.Address, priv abstract.Scalar) ([]byte,
+	onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &BackupRequest{Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &BackupReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Dump, nil
+}
+
+// Restore adds the parties of a dump returned by Backup to the ones stored
+// on the conode and returns the hashes of the restored ones. A stored
+// party is only replaced by a newer signed statement.
+func (c *Client) Restore(dst network.Address, dump []byte,
+	priv abstract.Scalar) ([][]byte, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	nonce, cerr := c.GetChallenge(dst)
+	if cerr != nil {
+		return nil, cerr
+	}
+	req := &RestoreRequest{Dump: dump, Nonce: nonce}
+	msg, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	req.Signature, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	res := &RestoreReply{}
+	cerr = c.SendProtobuf(si, req, res)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return res.Restored, nil
+}
+
+// GetParty returns the location and the roster of the party with the given
+// hash, as used in the compact Parties of a PopDesc.
+func (c *Client) GetParty(dst network.Address, hash []byte) (
+	*ShortDesc, onet.ClientError) {
+	si := &network.ServerIdentity{Address: dst}
+	res := &GetPartyReply{}
+	err := c.SendProtobuf(si, &GetPartyRequest{hash}, res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Party == nil || res.Party.IsCompact() ||
+		!bytes.Equal(res.Party.Hash(), hash) {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Received party doesn't match the hash")
+	}
+	return res.Party, nil
+}
+
+// WaitForFinal fetches the final statement of the party with the given hash
+// from the conode until it has a valid signature. The time between two
+// requests doubles up to maxPollInterval. If the party isn't finalized
+// before the timeout, an error is returned.
+func (c *Client) WaitForFinal(dst network.Address, hash []byte,
+	timeout time.Duration) (*FinalStatement, onet.ClientError) {
+	deadline := time.Now().Add(timeout)
+	interval := pollInterval
+	for {
+		fs, err := c.FetchFinal(dst, hash)
+		if err == nil && fs.Verify() == nil {
+			return fs, nil
+		}
+		if err != nil && err.ErrorCode() != ErrorOtherFinals {
+			return nil, err
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, onet.NewClientErrorCode(ErrorTimeout,
+				"timeout while waiting for finalization")
+		}
+		if interval > left {
+			interval = left
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// WaitForMerge fetches the final statement of the party with the given hash
+// from the conode until it is merged and has a valid signature. If this
+// doesn't happen before the timeout, an error is returned.
+func (c *Client) WaitForMerge(dst network.Address, hash []byte,
+	timeout time.Duration) (*FinalStatement, onet.ClientError) {
+	deadline := time.Now().Add(timeout)
+	for {
+		fs, err := c.FetchFinal(dst, hash)
+		if err == nil && fs.Merged && fs.Verify() == nil {
+			return fs, nil
+		}
+		if err != nil && err.ErrorCode() != ErrorOtherFinals {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, onet.NewClientErrorCode(ErrorTimeout,
+				"timeout while waiting for merge")
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
 // FinalStatement is the final configuration holding all data necessary
 // for a verifier.
 type FinalStatement struct {
 	// Desc is the description of the pop-party.
 	Desc *PopDesc
-	// Attendees holds a slice of all public keys of the attendees.
+	// Attendees holds a slice of all public keys of the attendees, in the
+	// order given by the OrderingPolicy of Desc.
 	Attendees []abstract.Point
 	// Signature is created by all conodes responsible for that pop-party
 	Signature []byte
 	// Flag indicates, that party was merged
 	Merged bool
+	// FinalizedAt is the unix time of the last signature of the party. It is
+	// not part of the hash, so it is not covered by the signature.
+	FinalizedAt int64
+	// Weights optionally holds the weight of every attendee, in the order
+	// of Attendees, e.g. for delegates. If it is empty, every attendee has
+	// the weight 1.
+	Weights []int
+	// Epoch is increased every time attendees are revoked after the
+	// finalization, so that a verifier can tell which of two statements
+	// of the party is the newer one.
+	Epoch int
+	// Origins holds for a merged statement the attendees of every merged
+	// party in the order of its own final statement, sorted by the hashes
+	// of the parties. Like this the tokens signed before the merge still
+	// verify, even if the parties ordered their attendees differently.
+	Origins []*AttendeeOrigin
+}
+
+// AttendeeOrigin lists the attendees of a merged statement that come from
+// one of the merged parties.
+type AttendeeOrigin struct {
+	// Party is the hash of the ShortDesc of the party
+	Party []byte
+	// Indexes are the positions of the attendees in the merged statement,
+	// in the order of the final statement of the party before the merge.
+	Indexes []int
 }
 
 // The toml-structure for (un)marshaling with toml
 type finalStatementToml struct {
-	Desc      *popDescToml
-	Attendees []string
-	Signature string
-	Merged    bool
+	Desc        *popDescToml
+	Attendees   []string
+	Signature   string
+	Merged      bool
+	FinalizedAt int64
+	Weights     []int                `toml:",omitempty" json:",omitempty"`
+	Epoch       int                  `toml:",omitempty" json:",omitempty"`
+	Origins     []attendeeOriginToml `toml:",omitempty" json:",omitempty"`
+}
+
+// The toml-structure of an AttendeeOrigin
+type attendeeOriginToml struct {
+	Party   string
+	Indexes []int
 }
 
 // NewFinalStatementFromToml creates a final statement from a toml slice-of-bytes.
@@ -156,55 +1326,64 @@ func NewFinalStatementFromToml(b []byte) (*FinalStatement, error) {
 	if err != nil {
 		return nil, err
 	}
-	sis := []*network.ServerIdentity{}
-	for _, s := range fsToml.Desc.Roster {
-		uid, err := uuid.FromString(s[2])
-		if err != nil {
-			return nil, err
-		}
-		pub, err := crypto.String64ToPub(network.Suite, s[3])
+	return fsToml.toFinalStatement()
+}
+
+// NewFinalStatementFromJSON creates a final statement from a json
+// slice-of-bytes as returned by ToJSON.
+func NewFinalStatementFromJSON(b []byte) (*FinalStatement, error) {
+	fsToml := &finalStatementToml{}
+	if err := json.Unmarshal(b, fsToml); err != nil {
+		return nil, err
+	}
+	return fsToml.toFinalStatement()
+}
+
+func (fsToml *finalStatementToml) toFinalStatement() (*FinalStatement, error) {
+	if fsToml.Desc == nil {
+		return nil, errors.New("no description in final statement")
+	}
+	rostr, err := fromToml(fsToml.Desc.Roster)
+	if err != nil {
+		return nil, err
+	}
+	var verifiers *onet.Roster
+	if len(fsToml.Desc.VerifierRoster) > 0 {
+		verifiers, err = fromToml(fsToml.Desc.VerifierRoster)
 		if err != nil {
 			return nil, err
 		}
-		sis = append(sis, &network.ServerIdentity{
-			Address:     network.Address(s[0]),
-			Description: s[1],
-			ID:          network.ServerIdentityID(uid),
-			Public:      pub,
-		})
 	}
-	rostr := onet.NewRoster(sis)
 	mparties := make([]*ShortDesc, len(fsToml.Desc.Parties))
 	for i, desc := range fsToml.Desc.Parties {
 		mparties[i] = &ShortDesc{}
-		mparties[i].Location = desc.Location
-
-		sis := []*network.ServerIdentity{}
-		for _, s := range desc.Roster {
-			uid, err := uuid.FromString(s[2])
-			if err != nil {
-				return nil, err
-			}
-			pub, err := crypto.String64ToPub(network.Suite, s[3])
+		if len(desc.Roster) == 0 && desc.ID != "" {
+			id, err := base64.StdEncoding.DecodeString(desc.ID)
 			if err != nil {
 				return nil, err
 			}
-			sis = append(sis, &network.ServerIdentity{
-				Address:     network.Address(s[0]),
-				Description: s[1],
-				ID:          network.ServerIdentityID(uid),
-				Public:      pub,
-			})
+			mparties[i].ID = id
+			continue
+		}
+		mparties[i].Location = desc.Location
+		mparties[i].OrderingPolicy = desc.OrderingPolicy
+		mparties[i].Roster, err = fromToml(desc.Roster)
+		if err != nil {
+			return nil, err
 		}
-		mparties[i].Roster = onet.NewRoster(sis)
 	}
 
 	desc := &PopDesc{
-		Name:     fsToml.Desc.Name,
-		DateTime: fsToml.Desc.DateTime,
-		Location: fsToml.Desc.Location,
-		Roster:   rostr,
-		Parties:  mparties,
+		Name:           fsToml.Desc.Name,
+		DateTime:       fsToml.Desc.DateTime,
+		Location:       fsToml.Desc.Location,
+		Roster:         rostr,
+		Parties:        mparties,
+		Version:        fsToml.Desc.Version,
+		ExpiresAt:      fsToml.Desc.ExpiresAt,
+		VerifierRoster: verifiers,
+		OrderingPolicy: fsToml.Desc.OrderingPolicy,
+		MinAttendees:   fsToml.Desc.MinAttendees,
 	}
 	atts := []abstract.Point{}
 	for _, p := range fsToml.Attendees {
@@ -214,36 +1393,80 @@ func NewFinalStatementFromToml(b []byte) (*FinalStatement, error) {
 		}
 		atts = append(atts, pub)
 	}
-	sig := make([]byte, 64)
-	sig, err = base64.StdEncoding.DecodeString(fsToml.Signature)
-	// TODO: sign and verify signature
+	sig, err := base64.StdEncoding.DecodeString(fsToml.Signature)
+	// TODO: sign and verify signature
+	if err != nil {
+		return nil, err
+	}
+	var origins []*AttendeeOrigin
+	for _, o := range fsToml.Origins {
+		party, err := base64.StdEncoding.DecodeString(o.Party)
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, &AttendeeOrigin{Party: party, Indexes: o.Indexes})
+	}
+	return &FinalStatement{
+		Desc:        desc,
+		Attendees:   atts,
+		Signature:   sig,
+		Merged:      fsToml.Merged,
+		FinalizedAt: fsToml.FinalizedAt,
+		Weights:     fsToml.Weights,
+		Epoch:       fsToml.Epoch,
+		Origins:     origins,
+	}, nil
+}
+
+func (desc *PopDesc) toToml() (*popDescToml, error) {
+	rostr, err := toToml(desc.Roster)
+	if err != nil {
+		return nil, err
+	}
+	descToml := &popDescToml{
+		Name:           desc.Name,
+		DateTime:       desc.DateTime,
+		Location:       desc.Location,
+		Roster:         rostr,
+		Version:        desc.Version,
+		ExpiresAt:      desc.ExpiresAt,
+		OrderingPolicy: desc.OrderingPolicy,
+		MinAttendees:   desc.MinAttendees,
+	}
+	if desc.VerifierRoster != nil {
+		descToml.VerifierRoster, err = toToml(desc.VerifierRoster)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return descToml, nil
+}
+
+// ToToml returns a toml-slice of byte and an eventual error.
+func (fs *FinalStatement) ToToml() ([]byte, error) {
+	fsToml, err := fs.toToml()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(fsToml)
 	if err != nil {
 		return nil, err
 	}
-	return &FinalStatement{
-		Desc:      desc,
-		Attendees: atts,
-		Signature: sig,
-		Merged:    fsToml.Merged,
-	}, nil
+	return buf.Bytes(), nil
 }
 
-func (desc *PopDesc) toToml() (*popDescToml, error) {
-	rostr, err := toToml(desc.Roster)
+// ToJSON returns a json-slice of byte and an eventual error. It uses the
+// same fields as ToToml.
+func (fs *FinalStatement) ToJSON() ([]byte, error) {
+	fsToml, err := fs.toToml()
 	if err != nil {
 		return nil, err
 	}
-	descToml := &popDescToml{
-		Name:     desc.Name,
-		DateTime: desc.DateTime,
-		Location: desc.Location,
-		Roster:   rostr,
-	}
-	return descToml, nil
+	return json.MarshalIndent(fsToml, "", "  ")
 }
 
-// ToToml returns a toml-slice of byte and an eventual error.
-func (fs *FinalStatement) ToToml() ([]byte, error) {
+func (fs *FinalStatement) toToml() (*finalStatementToml, error) {
 	descToml, err := fs.Desc.toToml()
 	if err != nil {
 		return nil, err
@@ -251,13 +1474,20 @@ func (fs *FinalStatement) ToToml() ([]byte, error) {
 	if len(fs.Desc.Parties) > 1 {
 		descToml.Parties = make([]ShortDescToml, len(fs.Desc.Parties))
 		for i, p := range fs.Desc.Parties {
+			if p.IsCompact() {
+				descToml.Parties[i] = ShortDescToml{
+					ID: base64.StdEncoding.EncodeToString(p.ID),
+				}
+				continue
+			}
 			rostr, err := toToml(p.Roster)
 			if err != nil {
 				return nil, err
 			}
 			sh := ShortDescToml{
-				Location: p.Location,
-				Roster:   rostr,
+				Location:       p.Location,
+				Roster:         rostr,
+				OrderingPolicy: p.OrderingPolicy,
 			}
 			descToml.Parties[i] = sh
 		}
@@ -271,24 +1501,31 @@ func (fs *FinalStatement) ToToml() ([]byte, error) {
 		atts[i] = str
 	}
 	fsToml := &finalStatementToml{
-		Desc:      descToml,
-		Attendees: atts,
-		Signature: base64.StdEncoding.EncodeToString(fs.Signature),
-		Merged:    fs.Merged,
+		Desc:        descToml,
+		Attendees:   atts,
+		Signature:   base64.StdEncoding.EncodeToString(fs.Signature),
+		Merged:      fs.Merged,
+		FinalizedAt: fs.FinalizedAt,
+		Weights:     fs.Weights,
+		Epoch:       fs.Epoch,
 	}
-	var buf bytes.Buffer
-	err = toml.NewEncoder(&buf).Encode(fsToml)
-	if err != nil {
-		return nil, err
+	for _, o := range fs.Origins {
+		fsToml.Origins = append(fsToml.Origins, attendeeOriginToml{
+			Party:   base64.StdEncoding.EncodeToString(o.Party),
+			Indexes: o.Indexes,
+		})
 	}
-	return buf.Bytes(), nil
+	return fsToml, nil
 }
 
 // Hash returns the hash of the popdesc and the attendees. In case of an error
 // in the hashing it will return a nil-slice and the error.
 func (fs *FinalStatement) Hash() ([]byte, error) {
-	h := network.Suite.Hash()
-	_, err := h.Write(fs.Desc.Hash())
+	h, err := fs.Desc.newHash()
+	if err != nil {
+		return nil, err
+	}
+	_, err = h.Write(fs.Desc.Hash())
 	if err != nil {
 		return nil, err
 	}
@@ -302,12 +1539,250 @@ func (fs *FinalStatement) Hash() ([]byte, error) {
 			return nil, err
 		}
 	}
+	if len(fs.Weights) > 0 {
+		_, err = h.Write([]byte("weights"))
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range fs.Weights {
+			err = binary.Write(h, binary.BigEndian, int64(w))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if fs.Epoch > 0 {
+		_, err = h.Write([]byte("epoch"))
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Write(h, binary.BigEndian, int64(fs.Epoch))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(fs.Origins) > 0 {
+		_, err = h.Write([]byte("origins"))
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range fs.Origins {
+			_, err = h.Write(o.Party)
+			if err != nil {
+				return nil, err
+			}
+			err = binary.Write(h, binary.BigEndian, int64(len(o.Indexes)))
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range o.Indexes {
+				err = binary.Write(h, binary.BigEndian, int64(i))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
 	return h.Sum(nil), nil
 }
 
+// OriginAttendees returns the attendees of the merged party with the given
+// hash in the order of its final statement before the merge, see Origins.
+func (fs *FinalStatement) OriginAttendees(party []byte) ([]abstract.Point, error) {
+	for _, o := range fs.Origins {
+		if !bytes.Equal(o.Party, party) {
+			continue
+		}
+		atts := make([]abstract.Point, len(o.Indexes))
+		for i, index := range o.Indexes {
+			if index < 0 || index >= len(fs.Attendees) {
+				return nil, fmt.Errorf("origin index %d is not in the %d attendees",
+					index, len(fs.Attendees))
+			}
+			atts[i] = fs.Attendees[index]
+		}
+		return atts, nil
+	}
+	return nil, errors.New("party is not an origin of the statement")
+}
+
+// Weight returns the weight of the attendee with the public key pub, or 0
+// if pub is not one of the attendees.
+func (fs *FinalStatement) Weight(pub abstract.Point) int {
+	for i, a := range fs.Attendees {
+		if a.Equal(pub) {
+			return fs.weight(i)
+		}
+	}
+	return 0
+}
+
+// TotalWeight returns the sum of the weights of all attendees.
+func (fs *FinalStatement) TotalWeight() int {
+	total := 0
+	for i := range fs.Attendees {
+		total += fs.weight(i)
+	}
+	return total
+}
+
+// weight returns the weight of the attendee with the given index.
+func (fs *FinalStatement) weight(index int) int {
+	if len(fs.Weights) == 0 {
+		return 1
+	}
+	if index >= len(fs.Weights) {
+		return 0
+	}
+	return fs.Weights[index]
+}
+
+// weightClasses returns the distinct weights of the attendees in
+// increasing order.
+func (fs *FinalStatement) weightClasses() []int {
+	seen := make(map[int]bool)
+	classes := []int{}
+	for i := range fs.Attendees {
+		w := fs.weight(i)
+		if !seen[w] {
+			seen[w] = true
+			classes = append(classes, w)
+		}
+	}
+	sort.Ints(classes)
+	return classes
+}
+
+// weightClass returns the attendees with the given weight, in the order
+// of the statement.
+func (fs *FinalStatement) weightClass(weight int) []abstract.Point {
+	atts := []abstract.Point{}
+	for i, a := range fs.Attendees {
+		if fs.weight(i) == weight {
+			atts = append(atts, a)
+		}
+	}
+	return atts
+}
+
+// setAttendees replaces the attendees, ordered by the ordering policy of
+// the party, and keeps the weights of the ones that were already present.
+// New attendees get the weight 1. If an attendee has no valid key, the
+// statement is not changed.
+func (fs *FinalStatement) setAttendees(atts []abstract.Point) error {
+	var err error
+	if fs.Desc != nil {
+		if atts, err = fs.Desc.orderAttendees(atts); err != nil {
+			return err
+		}
+	}
+	ws := fs.Weights
+	if len(fs.Weights) > 0 {
+		weights := make(map[string]int)
+		for i, a := range fs.Attendees {
+			key, err := attendeeKey(a)
+			if err != nil {
+				return err
+			}
+			weights[key] = fs.weight(i)
+		}
+		if ws, err = alignWeights(atts, weights); err != nil {
+			return err
+		}
+	}
+	origins, err := realignOrigins(fs.Origins, fs.Attendees, atts)
+	if err != nil {
+		return err
+	}
+	fs.Weights = ws
+	fs.Origins = origins
+	fs.Attendees = atts
+	return nil
+}
+
+// addAttendees appends the attendees that are not present yet, like
+// setAttendees.
+func (fs *FinalStatement) addAttendees(atts []abstract.Point) error {
+	all, err := appendAttendees(fs.Attendees, atts)
+	if err != nil {
+		return err
+	}
+	return fs.setAttendees(all)
+}
+
+// newAttendees returns the attendees of atts that are not present yet,
+// without duplicates.
+func (fs *FinalStatement) newAttendees(atts []abstract.Point) ([]abstract.Point, error) {
+	unique, err := appendAttendees(nil, atts)
+	if err != nil {
+		return nil, err
+	}
+	return subtractAttendees(unique, fs.Attendees)
+}
+
+// realignOrigins returns the origins with the indexes of the attendees
+// moved from old to atts. An origin with an attendee missing in atts is
+// dropped, as the tokens signed before the merge can't be verified without
+// the complete list of its attendees.
+func realignOrigins(origins []*AttendeeOrigin, old,
+	atts []abstract.Point) ([]*AttendeeOrigin, error) {
+	if len(origins) == 0 {
+		return origins, nil
+	}
+	indexes, err := attendeeIndexes(atts)
+	if err != nil {
+		return nil, err
+	}
+	var realigned []*AttendeeOrigin
+	for _, o := range origins {
+		moved := &AttendeeOrigin{Party: o.Party, Indexes: make([]int, len(o.Indexes))}
+		for i, index := range o.Indexes {
+			if index < 0 || index >= len(old) {
+				moved = nil
+				break
+			}
+			key, err := attendeeKey(old[index])
+			if err != nil {
+				return nil, err
+			}
+			ni, ok := indexes[key]
+			if !ok {
+				moved = nil
+				break
+			}
+			moved.Indexes[i] = ni
+		}
+		if moved != nil {
+			realigned = append(realigned, moved)
+		}
+	}
+	return realigned, nil
+}
+
+// alignWeights returns the weights of atts as found in weights, using 1
+// for the missing ones.
+func alignWeights(atts []abstract.Point, weights map[string]int) ([]int, error) {
+	ws := make([]int, len(atts))
+	for i, a := range atts {
+		key, err := attendeeKey(a)
+		if err != nil {
+			return nil, err
+		}
+		ws[i] = 1
+		if w, ok := weights[key]; ok {
+			ws[i] = w
+		}
+	}
+	return ws, nil
+}
+
 // Verify checks if the collective signature is correct and has been created
 // by the roster. On success, this returns nil.
 func (fs *FinalStatement) Verify() error {
+	if len(fs.Weights) > 0 && len(fs.Weights) != len(fs.Attendees) {
+		return fmt.Errorf("%d weights for %d attendees", len(fs.Weights),
+			len(fs.Attendees))
+	}
 	h, err := fs.Hash()
 	if err != nil {
 		return err
@@ -315,12 +1790,93 @@ func (fs *FinalStatement) Verify() error {
 	return eddsa.Verify(fs.Desc.Roster.Aggregate, h, fs.Signature)
 }
 
+// VerifyAgainst checks the collective signature like Verify and that the
+// statement has been signed by the expected roster, independently of the
+// order of its conodes. As Verify only checks the signature against the
+// roster of the statement, an organizer could otherwise present a
+// statement signed by its own conodes.
+func (fs *FinalStatement) VerifyAgainst(roster *onet.Roster) error {
+	r := fs.Desc.Roster
+	if r == nil || roster == nil || !Equal(r, roster) || !Equal(roster, r) ||
+		!r.Aggregate.Equal(roster.Aggregate) {
+		return errors.New("The roster of the final statement is not the expected one")
+	}
+	return fs.Verify()
+}
+
+// DroppedParties returns the parties of a merged statement whose conodes
+// are not part of the merged roster, because they couldn't be reached
+// during a partial merge. Compact parties are never returned.
+func (fs *FinalStatement) DroppedParties() []*ShortDesc {
+	if !fs.Merged {
+		return nil
+	}
+	var dropped []*ShortDesc
+	for _, p := range fs.Desc.Parties {
+		if p.IsCompact() {
+			// the roster of the party is not known
+			continue
+		}
+		for _, si := range p.Roster.List {
+			if i, _ := fs.Desc.Roster.Search(si.ID); i < 0 {
+				dropped = append(dropped, p)
+				break
+			}
+		}
+	}
+	return dropped
+}
+
+// ParseDateTime parses a DateTime in the DateTimeFormat, followed by an
+// optional zone, which is either "UTC", "Z" or an offset like "+0200" or
+// "+02:00". A DateTime without zone is in UTC.
+func ParseDateTime(dt string) (time.Time, error) {
+	for _, utc := range []string{" UTC", " Z", "Z"} {
+		if len(dt) > len(utc) && dt[len(dt)-len(utc):] == utc {
+			dt = dt[:len(dt)-len(utc)]
+			break
+		}
+	}
+	t, err := time.Parse(DateTimeFormat, dt)
+	if err == nil {
+		return t, nil
+	}
+	for _, zone := range dateTimeZones {
+		if t, errZone := time.Parse(DateTimeFormat+zone, dt); errZone == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("wrong DateTime %q, must be %q followed "+
+		"by an optional zone", dt, DateTimeFormat)
+}
+
+// NormalizeDateTime returns the DateTime in UTC, so that the same moment
+// always gives the same hash of the PopDesc.
+func NormalizeDateTime(dt string) (string, error) {
+	t, err := ParseDateTime(dt)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(DateTimeFormat), nil
+}
+
+// utcDateTime returns the DateTime normalized to UTC. A DateTime that
+// can't be parsed is returned as it is, so that it still has a hash.
+func (p *PopDesc) utcDateTime() string {
+	if dt, err := NormalizeDateTime(p.DateTime); err == nil {
+		return dt
+	}
+	return p.DateTime
+}
+
 // PopDesc holds the name, date and a roster of all involved conodes.
 type PopDesc struct {
 	// Name and purpose of the party.
 	Name string
 	// DateTime of the party. It is in the following format, following UTC:
 	//   YYYY-MM-DD HH:mm
+	// A time given in another zone is normalized to UTC in the hash and
+	// when the party is stored, see NormalizeDateTime.
 	DateTime string
 	// Location of the party
 	Location string
@@ -328,53 +1884,311 @@ type PopDesc struct {
 	Roster *onet.Roster
 	// List of parties to be merged
 	Parties []*ShortDesc
+	// Version selects the hash function of the party and its final
+	// statement, one of the PopVersion-constants.
+	Version int
+	// ExpiresAt is the unix time in seconds after which the tokens of the
+	// party are not valid anymore. If it is 0, the party never expires.
+	ExpiresAt int64
+	// VerifierRoster holds optional conodes that don't sign, but receive
+	// the final statement to serve the verifications.
+	VerifierRoster *onet.Roster
+	// OrderingPolicy is the order of the attendees in the final statement,
+	// one of the Order-constants. All parties of a merge need the same
+	// policy.
+	OrderingPolicy int
+	// MinAttendees is the number of attendees the party needs at least to
+	// be finalized, so that its tokens hide among enough keys. Every
+	// conode refuses to sign it with less, whatever the organizer asks.
+	MinAttendees int
+}
+
+// NewPopDesc returns a description of a party held by the conodes of
+// roster, which merges with the given parties if there are any. The
+// datetime is normalized to UTC and the description is validated.
+func NewPopDesc(name, datetime, location string, roster *onet.Roster,
+	parties []*ShortDesc) (*PopDesc, error) {
+	dt, err := NormalizeDateTime(datetime)
+	if err != nil {
+		return nil, err
+	}
+	desc := &PopDesc{
+		Name:     name,
+		DateTime: dt,
+		Location: location,
+		Roster:   roster,
+		Parties:  parties,
+	}
+	if err := desc.Validate(); err != nil {
+		return nil, err
+	}
+	return desc, nil
+}
+
+// Validate returns an error if the description can't be stored as a
+// party: the name is missing, the DateTime is not in UTC, there are no
+// conodes, or the party is not part of its own merge.
+func (p *PopDesc) Validate() error {
+	if p.Name == "" {
+		return errors.New("the party has no name")
+	}
+	dt, err := NormalizeDateTime(p.DateTime)
+	if err != nil {
+		return err
+	}
+	if dt != p.DateTime {
+		return fmt.Errorf("DateTime %q is not in UTC, use %q", p.DateTime, dt)
+	}
+	if p.Roster == nil || len(p.Roster.List) == 0 || p.Roster.Aggregate == nil {
+		return errors.New("the party has no conodes")
+	}
+	if _, ok := popHashes[p.Version]; !ok {
+		return fmt.Errorf("unknown version %d", p.Version)
+	}
+	if p.OrderingPolicy != OrderSorted && p.OrderingPolicy != OrderInsertion {
+		return fmt.Errorf("unknown ordering policy %d", p.OrderingPolicy)
+	}
+	if p.MinAttendees < 0 {
+		return fmt.Errorf("negative minimum of %d attendees", p.MinAttendees)
+	}
+	if len(p.Parties) == 0 {
+		return nil
+	}
+	if err := p.ValidateParties(); err != nil {
+		return err
+	}
+	if !p.hasParty(p.shortDesc()) {
+		return errors.New("the party is not included in its merge")
+	}
+	return nil
+}
+
+// ValidateParties returns an error if one of the parties to be merged has
+// no conodes or if two of them are the same party, which usually comes
+// from a copy-paste mistake in the merge file.
+func (p *PopDesc) ValidateParties() error {
+	seen := make(map[string]int)
+	for i, party := range p.Parties {
+		if party == nil {
+			return fmt.Errorf("party %d is empty", i+1)
+		}
+		if party.IsCompact() {
+			if len(party.ID) == 0 {
+				return fmt.Errorf("party %d has no ID", i+1)
+			}
+		} else if len(party.Roster.List) == 0 || party.Roster.Aggregate == nil {
+			return fmt.Errorf("party at %s has no conodes", party.Location)
+		}
+		h := string(party.Hash())
+		if j, ok := seen[h]; ok {
+			return fmt.Errorf("party %d at %s is the same as party %d",
+				i+1, party.Location, j+1)
+		}
+		seen[h] = i
+	}
+	return nil
 }
 
 // represents a PopDesc in string-version for toml.
 type popDescToml struct {
-	Name     string
-	DateTime string
-	Location string
-	Roster   [][]string
-	Parties  []ShortDescToml
+	Name           string
+	DateTime       string
+	Location       string
+	Roster         [][]string
+	Parties        []ShortDescToml
+	Version        int        `toml:",omitempty"`
+	ExpiresAt      int64      `toml:",omitempty"`
+	VerifierRoster [][]string `toml:",omitempty"`
+	OrderingPolicy int        `toml:",omitempty"`
+	MinAttendees   int        `toml:",omitempty"`
 }
 
 type ShortDesc struct {
 	Location string
 	Roster   *onet.Roster
+	// ID holds the hash of a compact party, which has no Location and
+	// Roster. It has to be resolved with GetParty to contact the party.
+	ID []byte
+	// OrderingPolicy is the one of the PopDesc of the party, so that the
+	// other parties of a merge know its hash.
+	OrderingPolicy int
 }
 
 type ShortDescToml struct {
-	Location string
-	Roster   [][]string
+	Location       string
+	Roster         [][]string
+	ID             string
+	OrderingPolicy int `toml:",omitempty"`
 }
 
 // Hash of this structure - calculated by hand instead of using network.Marshal.
 func (p *PopDesc) Hash() []byte {
-	hash := network.Suite.Hash()
+	hash, err := p.newHash()
+	if err != nil {
+		log.Error(err)
+		return []byte{}
+	}
 	hash.Write([]byte(p.Name))
-	hash.Write([]byte(p.DateTime))
+	hash.Write([]byte(p.utcDateTime()))
 	hash.Write([]byte(p.Location))
+	if p.Roster == nil || p.Roster.Aggregate == nil {
+		log.Error("party has no conodes")
+		return []byte{}
+	}
 	buf, err := p.Roster.Aggregate.MarshalBinary()
 	if err != nil {
 		log.Error(err)
 		return []byte{}
 	}
 	hash.Write(buf)
+	// Every optional field is prefixed by its name, so that two fields
+	// can't give the same bytes, and only hashed if it is set, so that
+	// parties without it keep their hash.
 	if len(p.Parties) > 0 {
-		for _, party := range p.Parties {
-			hash.Write([]byte(party.Location))
-			buf, err = party.Roster.Aggregate.MarshalBinary()
-			if err != nil {
-				log.Error(err)
+		hash.Write([]byte("parties"))
+		// The order of the parties must not change the hash
+		hashes := make([][]byte, len(p.Parties))
+		for i, party := range p.Parties {
+			hashes[i] = party.Hash()
+			if len(hashes[i]) == 0 {
 				return []byte{}
 			}
-			hash.Write(buf)
 		}
+		sort.Slice(hashes, func(i, j int) bool {
+			return bytes.Compare(hashes[i], hashes[j]) < 0
+		})
+		for _, h := range hashes {
+			hash.Write(h)
+		}
+	}
+	if p.ExpiresAt != 0 {
+		hash.Write([]byte("expires at"))
+		binary.Write(hash, binary.BigEndian, p.ExpiresAt)
+	}
+	if p.VerifierRoster != nil {
+		hash.Write([]byte("verifiers"))
+		if p.VerifierRoster.Aggregate == nil {
+			log.Error("verifiers have no aggregate key")
+			return []byte{}
+		}
+		buf, err := p.VerifierRoster.Aggregate.MarshalBinary()
+		if err != nil {
+			log.Error(err)
+			return []byte{}
+		}
+		hash.Write(buf)
+	}
+	if p.OrderingPolicy != OrderSorted {
+		hash.Write([]byte("ordering"))
+		binary.Write(hash, binary.BigEndian, int64(p.OrderingPolicy))
+	}
+	if p.MinAttendees != 0 {
+		hash.Write([]byte("min attendees"))
+		binary.Write(hash, binary.BigEndian, int64(p.MinAttendees))
+	}
+	return hash.Sum(nil)
+}
+
+// orderAttendees returns the attendees in the order of the ordering policy
+// of the party. The slice is not changed.
+func (p *PopDesc) orderAttendees(atts []abstract.Point) ([]abstract.Point, error) {
+	if p.OrderingPolicy == OrderInsertion {
+		return atts, nil
+	}
+	return sortAttendees(atts)
+}
+
+// Expired returns true if the party has an expiry date in the past.
+func (p *PopDesc) Expired() bool {
+	return p.ExpiresAt != 0 && time.Now().Unix() >= p.ExpiresAt
+}
+
+// newHash returns the hash function selected by the version of the party.
+func (p *PopDesc) newHash() (hash.Hash, error) {
+	h, ok := popHashes[p.Version]
+	if !ok {
+		return nil, fmt.Errorf("unknown version %d", p.Version)
+	}
+	return h(), nil
+}
+
+// Hash of the location and the aggregate key of the roster. It doesn't
+// depend on the order of the servers in the roster. For a compact party
+// it is the stored ID.
+func (sd *ShortDesc) Hash() []byte {
+	if sd.IsCompact() {
+		return sd.ID
+	}
+	if sd.Roster.Aggregate == nil {
+		log.Error("party has no conodes")
+		return []byte{}
+	}
+	hash := network.Suite.Hash()
+	hash.Write([]byte(sd.Location))
+	buf, err := sd.Roster.Aggregate.MarshalBinary()
+	if err != nil {
+		log.Error(err)
+		return []byte{}
+	}
+	hash.Write(buf)
+	if sd.OrderingPolicy != OrderSorted {
+		// Parties with the default ordering keep their hash
+		hash.Write([]byte("ordering"))
+		binary.Write(hash, binary.BigEndian, int64(sd.OrderingPolicy))
 	}
 	return hash.Sum(nil)
 }
 
+// IsCompact returns true if the party only holds its hash.
+func (sd *ShortDesc) IsCompact() bool {
+	return sd.Roster == nil
+}
+
+// Compact returns a copy of the description where the parties only hold
+// their hashes. The hash of the description doesn't change, but the
+// rosters of the other parties have to be fetched from the conodes when
+// merging.
+func (p *PopDesc) Compact() *PopDesc {
+	desc := *p
+	desc.Parties = make([]*ShortDesc, len(p.Parties))
+	for i, party := range p.Parties {
+		desc.Parties[i] = &ShortDesc{ID: party.Hash()}
+	}
+	return &desc
+}
+
+// subPartyDesc returns the description of the party sd as it was stored
+// before the merge of p.
+func (p *PopDesc) subPartyDesc(sd *ShortDesc) *PopDesc {
+	return &PopDesc{
+		Name:           p.Name,
+		DateTime:       p.DateTime,
+		Location:       sd.Location,
+		Roster:         sd.Roster,
+		Parties:        p.Parties,
+		Version:        p.Version,
+		OrderingPolicy: sd.OrderingPolicy,
+	}
+}
+
+// shortDesc returns the description of this party as it appears in the
+// Parties of a merge.
+func (p *PopDesc) shortDesc() *ShortDesc {
+	return &ShortDesc{Location: p.Location, Roster: p.Roster,
+		OrderingPolicy: p.OrderingPolicy}
+}
+
+// hasParty returns true if sd is one of the parties to be merged.
+func (p *PopDesc) hasParty(sd *ShortDesc) bool {
+	hash := sd.Hash()
+	for _, party := range p.Parties {
+		if bytes.Equal(party.Hash(), hash) {
+			return true
+		}
+	}
+	return false
+}
+
 // Checks if the first list contains the second
 func Equal(r1, r2 *onet.Roster) bool {
 	if len(r1.List) != len(r2.List) {
@@ -407,3 +2221,25 @@ func toToml(r *onet.Roster) ([][]string, error) {
 	}
 	return rostr, nil
 }
+
+// fromToml is the inverse of toToml.
+func fromToml(rostr [][]string) (*onet.Roster, error) {
+	sis := []*network.ServerIdentity{}
+	for _, s := range rostr {
+		uid, err := uuid.FromString(s[2])
+		if err != nil {
+			return nil, err
+		}
+		pub, err := crypto.String64ToPub(network.Suite, s[3])
+		if err != nil {
+			return nil, err
+		}
+		sis = append(sis, &network.ServerIdentity{
+			Address:     network.Address(s[0]),
+			Description: s[1],
+			ID:          network.ServerIdentityID(uid),
+			Public:      pub,
+		})
+	}
+	return onet.NewRoster(sis), nil
+}
@@ -1,14 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/cothority.v1/skipchain"
 	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
 	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/nist"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
+	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 )
@@ -23,7 +34,8 @@ func TestFinalStatement_ToToml(t *testing.T) {
 			DateTime: "yesterday",
 			Roster:   roster,
 		},
-		Attendees: []abstract.Point{pk.Public},
+		Attendees:   []abstract.Point{pk.Public},
+		FinalizedAt: 1501459200,
 	}
 	fs.Signature = fs.Desc.Hash()
 	fsStr, err := fs.ToToml()
@@ -34,6 +46,16 @@ func TestFinalStatement_ToToml(t *testing.T) {
 	require.Equal(t, fs.Desc.DateTime, fs2.Desc.DateTime)
 	require.True(t, fs.Desc.Roster.Aggregate.Equal(fs2.Desc.Roster.Aggregate))
 	require.True(t, fs.Attendees[0].Equal(fs2.Attendees[0]))
+	require.Equal(t, fs.FinalizedAt, fs2.FinalizedAt)
+
+	fsJSON, err := fs.ToJSON()
+	log.ErrFatal(err)
+	fs3, err := NewFinalStatementFromJSON(fsJSON)
+	log.ErrFatal(err)
+	require.Equal(t, fs.Desc.Hash(), fs3.Desc.Hash())
+	require.True(t, fs.Attendees[0].Equal(fs3.Attendees[0]))
+	require.Equal(t, fs.Signature, fs3.Signature)
+	require.Equal(t, fs.FinalizedAt, fs3.FinalizedAt)
 }
 
 func TestFinalStatement_Verify(t *testing.T) {
@@ -57,3 +79,1716 @@ func TestFinalStatement_Verify(t *testing.T) {
 	fs.Attendees = append(fs.Attendees, eddsa.Public)
 	require.NotNil(t, fs.Verify())
 }
+
+func TestFinalStatement_VerifyAgainst(t *testing.T) {
+	keys := []*eddsa.EdDSA{eddsa.NewEdDSA(random.Stream),
+		eddsa.NewEdDSA(random.Stream), eddsa.NewEdDSA(random.Stream)}
+	sis := make([]*network.ServerIdentity, len(keys))
+	for i, k := range keys {
+		sis[i] = network.NewServerIdentity(k.Public,
+			network.NewAddress(network.PlainTCP, "0:2000"))
+	}
+	fs := &FinalStatement{
+		Desc: &PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Roster:   onet.NewRoster(sis[:2]),
+		},
+		Attendees: []abstract.Point{keys[0].Public},
+	}
+	// The collective key of the first two conodes signs the statement
+	agg := &eddsa.EdDSA{
+		Secret: network.Suite.Scalar().Add(keys[0].Secret, keys[1].Secret),
+		Public: fs.Desc.Roster.Aggregate,
+	}
+	h, err := fs.Hash()
+	log.ErrFatal(err)
+	fs.Signature, err = agg.Sign(h)
+	log.ErrFatal(err)
+	require.Nil(t, fs.VerifyAgainst(onet.NewRoster(
+		[]*network.ServerIdentity{sis[1], sis[0]})))
+	require.NotNil(t, fs.VerifyAgainst(onet.NewRoster(sis[1:])))
+	require.NotNil(t, fs.VerifyAgainst(onet.NewRoster(sis[:1])))
+	require.NotNil(t, fs.VerifyAgainst(onet.NewRoster(sis)))
+	require.NotNil(t, fs.VerifyAgainst(nil))
+
+	// A statement signed by another roster verifies, but not against
+	// the pinned one
+	forged := &FinalStatement{
+		Desc: &PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Roster:   onet.NewRoster(sis[2:]),
+		},
+		Attendees: fs.Attendees,
+	}
+	h, err = forged.Hash()
+	log.ErrFatal(err)
+	forged.Signature, err = keys[2].Sign(h)
+	log.ErrFatal(err)
+	require.Nil(t, forged.Verify())
+	require.NotNil(t, forged.VerifyAgainst(fs.Desc.Roster))
+
+	fs.Attendees = append(fs.Attendees, keys[1].Public)
+	require.NotNil(t, fs.VerifyAgainst(fs.Desc.Roster))
+}
+
+func TestPopDesc_Version(t *testing.T) {
+	eddsa := eddsa.NewEdDSA(random.Stream)
+	si := network.NewServerIdentity(eddsa.Public, network.NewAddress(network.PlainTCP, "0:2000"))
+	fs := &FinalStatement{
+		Desc: &PopDesc{
+			Name:     "test",
+			DateTime: "yesterday",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: []abstract.Point{eddsa.Public},
+	}
+	hashSuite := fs.Desc.Hash()
+	fs.Desc.Version = PopVersionSHA512
+	hash := fs.Desc.Hash()
+	require.Equal(t, 64, len(hash))
+	require.NotEqual(t, hashSuite, hash)
+	h, err := fs.Hash()
+	log.ErrFatal(err)
+	require.Equal(t, 64, len(h))
+	fs.Signature, err = eddsa.Sign(h)
+	log.ErrFatal(err)
+	require.Nil(t, fs.Verify())
+
+	fsToml, err := fs.ToToml()
+	log.ErrFatal(err)
+	fs2, err := NewFinalStatementFromToml(fsToml)
+	log.ErrFatal(err)
+	require.Equal(t, PopVersionSHA512, fs2.Desc.Version)
+	require.Equal(t, hash, fs2.Desc.Hash())
+	require.Nil(t, fs2.Verify())
+	fsJSON, err := fs.ToJSON()
+	log.ErrFatal(err)
+	fs3, err := NewFinalStatementFromJSON(fsJSON)
+	log.ErrFatal(err)
+	require.Equal(t, hash, fs3.Desc.Hash())
+	require.Nil(t, fs3.Verify())
+
+	fs.Desc.Version = -1
+	require.Equal(t, 0, len(fs.Desc.Hash()))
+	_, err = fs.Hash()
+	require.NotNil(t, err)
+	require.NotNil(t, fs.Verify())
+}
+
+func TestClient_Link(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	service := local.GetServices(servers, serviceID)[0].(*Service)
+	hostport := servers[0].ServerIdentity.Address.NetworkAddress()
+	kp := config.NewKeyPair(network.Suite)
+	c := NewClient()
+
+	reply, cerr := c.Link(hostport, "", kp.Public)
+	require.Nil(t, cerr)
+	require.Nil(t, reply)
+	require.NotEqual(t, "", service.data.Pin)
+	require.Nil(t, service.data.Public)
+
+	reply, cerr = c.Link(hostport, "wrong", kp.Public)
+	require.NotNil(t, cerr)
+	require.Nil(t, reply)
+
+	reply, cerr = c.Link(hostport, service.data.Pin, kp.Public)
+	require.Nil(t, cerr)
+	require.NotNil(t, reply)
+	require.True(t, kp.Public.Equal(service.data.Public))
+	require.True(t, reply.Conode.ID.Equal(servers[0].ServerIdentity.ID))
+	require.False(t, reply.Relink)
+
+	// Linking again with another key replaces the first one
+	kp2 := config.NewKeyPair(network.Suite)
+	reply, cerr = c.Link(hostport, service.data.Pin, kp2.Public)
+	require.Nil(t, cerr)
+	require.True(t, reply.Relink)
+	require.True(t, kp2.Public.Equal(service.data.Public))
+}
+
+func TestClient_WaitForFinal(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.WaitForFinal(dst, []byte("unknown"), TIMEOUT)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorInternal, cerr.ErrorCode())
+	_, cerr = c.WaitForFinal(dst, hash, 2*pollInterval)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorTimeout, cerr.ErrorCode())
+
+	go func() {
+		time.Sleep(5 * pollInterval)
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for i := range srvcs {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+			log.ErrFatal(err)
+			srvcs[i].FinalizeRequest(fr)
+		}
+	}()
+	fs, cerr := c.WaitForFinal(dst, hash, TIMEOUT)
+	require.Nil(t, cerr)
+	require.Nil(t, fs.Verify())
+	require.Equal(t, len(atts), len(fs.Attendees))
+}
+
+func TestClient_WaitForMerge(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 4
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrNodes)
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.WaitForMerge(dst, hash, 2*pollInterval)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorTimeout, cerr.ErrorCode())
+
+	go func() {
+		time.Sleep(5 * pollInterval)
+		mr := &MergeRequest{ID: hash, Nonce: challenge(srvcs[0])}
+		sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+		log.ErrFatal(err)
+		mr.Signature = sg
+		srvcs[0].MergeRequest(mr)
+	}()
+	fs, cerr := c.WaitForMerge(dst, hash, TIMEOUT)
+	require.Nil(t, cerr)
+	require.True(t, fs.Merged)
+	require.Nil(t, fs.Verify())
+	require.Equal(t, len(atts), len(fs.Attendees))
+}
+
+func TestClient_GetSubParties(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 4
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrNodes)
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.GetSubParties(dst, hash)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorMerge, cerr.ErrorCode())
+
+	_, cerr = c.Merge(dst, descs[0], priv[0])
+	require.Nil(t, cerr)
+	parties, cerr := c.GetSubParties(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(parties))
+	for _, desc := range descs {
+		found := false
+		for _, p := range parties {
+			if bytes.Equal(desc.shortDesc().Hash(), p.ID) {
+				require.Equal(t, desc.Location, p.Location)
+				found = true
+			}
+		}
+		require.True(t, found)
+	}
+}
+
+func TestClient_GetAggregate(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.GetAggregate(dst, []byte("unknown"))
+	require.NotNil(t, cerr)
+	_, cerr = c.GetAggregate(dst, hash)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	agg, cerr := c.GetAggregate(dst, hash)
+	require.Nil(t, cerr)
+	require.True(t, descs[0].Roster.Aggregate.Equal(agg))
+}
+
+func TestNormalizeDateTime(t *testing.T) {
+	utc, err := NormalizeDateTime("2017-08-08 15:00")
+	require.Nil(t, err)
+	require.Equal(t, "2017-08-08 15:00", utc)
+	for _, dt := range []string{"2017-08-08 15:00 UTC", "2017-08-08 15:00Z",
+		"2017-08-08 17:00 +0200", "2017-08-08 17:00 +02:00",
+		"2017-08-08 10:30-04:30"} {
+		norm, err := NormalizeDateTime(dt)
+		require.Nil(t, err, dt)
+		require.Equal(t, utc, norm, dt)
+	}
+	// Crossing the date
+	norm, err := NormalizeDateTime("2017-08-09 01:00 +1000")
+	require.Nil(t, err)
+	require.Equal(t, utc, norm)
+
+	for _, dt := range []string{"yesterday", "2017-08-08", "2017-08-08 15:00 CEST",
+		"2017-08-08 15:00 +2"} {
+		_, err = NormalizeDateTime(dt)
+		require.NotNil(t, err, dt)
+	}
+
+	// The hash doesn't depend on the zone, even without normalizing first
+	el := &onet.Roster{Aggregate: network.Suite.Point().Null()}
+	local := &PopDesc{Name: "test", DateTime: "2017-08-08 17:00 +0200", Roster: el}
+	desc := &PopDesc{Name: "test", DateTime: utc, Roster: el}
+	require.Equal(t, desc.Hash(), local.Hash())
+	local.DateTime, err = NormalizeDateTime(local.DateTime)
+	require.Nil(t, err)
+	require.Equal(t, desc.Hash(), local.Hash())
+}
+
+func TestClient_FetchFinalHash(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	for _, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for i := range srvcs {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+			log.ErrFatal(err)
+			srvcs[i].FinalizeRequest(fr)
+		}
+	}
+	c := NewClient()
+	dst := srvcs[0].ServerIdentity().Address
+	hash0, hash1 := descs[0].Hash(), descs[1].Hash()
+	fs, cerr := c.FetchFinal(dst, hash0)
+	require.Nil(t, cerr)
+	require.Equal(t, hash0, fs.Desc.Hash())
+
+	// The conode returns the valid statement of another party
+	srvcs[0].data.Finals[string(hash0)] = srvcs[0].data.Finals[string(hash1)]
+	_, cerr = c.FetchFinal(dst, hash0)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorInternal, cerr.ErrorCode())
+	// Also if it claims to be merged
+	srvcs[0].data.Finals[string(hash1)].Merged = true
+	_, cerr = c.FetchFinal(dst, hash0)
+	require.NotNil(t, cerr)
+}
+
+func TestClient_VerifierRoster(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	signers := onet.NewRoster(r.List[:2])
+	_, atts, srvcs, priv := storeDesc(local.GetServices(nodes[:2], serviceID), signers, 2, 1)
+	desc := &PopDesc{
+		Name:           "name",
+		DateTime:       "2017-07-31 00:00",
+		Location:       "verified",
+		Roster:         signers,
+		VerifierRoster: onet.NewRoster(r.List[2:]),
+	}
+	hash := desc.Hash()
+	plain := *desc
+	plain.VerifierRoster = nil
+	require.NotEqual(t, plain.Hash(), hash)
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i, s := range srvcs {
+		sig, err := crypto.SignSchnorr(network.Suite, priv[i], hash)
+		log.ErrFatal(err)
+		_, cerr := s.StoreConfig(&StoreConfig{desc, sig})
+		require.Nil(t, cerr)
+	}
+	for i, s := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		s.FinalizeRequest(fr)
+	}
+
+	c := NewClient()
+	for _, si := range r.List[2:] {
+		fs, cerr := c.FetchFinal(si.Address, hash)
+		require.Nil(t, cerr)
+		require.Nil(t, fs.Verify())
+		require.Equal(t, hash, fs.Desc.Hash())
+		require.True(t, Equal(desc.VerifierRoster, fs.Desc.VerifierRoster))
+	}
+
+	// The verifiers survive the toml-encoding
+	fs, cerr := c.FetchFinal(r.List[0].Address, hash)
+	require.Nil(t, cerr)
+	buf, err := fs.ToToml()
+	log.ErrFatal(err)
+	fs2, err := NewFinalStatementFromToml(buf)
+	log.ErrFatal(err)
+	require.Equal(t, hash, fs2.Desc.Hash())
+	require.Nil(t, fs2.Verify())
+}
+
+func TestClient_FinalizeWithCounts(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 5, 1)
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := 1; i < len(srvcs); i++ {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	c := NewClient()
+	res, cerr := c.FinalizeWithCounts(r.List[0].Address, descs[0], atts, priv[0], false)
+	require.Nil(t, cerr)
+	require.Nil(t, res.Final.Verify())
+	require.Equal(t, 5, res.NumAttendees)
+	require.Equal(t, len(res.Final.Attendees), res.NumAttendees)
+	require.Equal(t, 3, res.NumConodes)
+	require.Equal(t, len(res.Final.Desc.Roster.List), res.NumConodes)
+}
+
+func TestClient_FinalizeWithMin(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 5, 1)
+	// The other conodes don't know about the last attendee
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts[:4]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := 1; i < len(srvcs); i++ {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	c := NewClient()
+	dst := r.List[0].Address
+	for _, min := range []int{6, 5} {
+		_, cerr := c.FinalizeWithMin(dst, descs[0], atts, priv[0], false, min)
+		require.NotNil(t, cerr)
+		require.Equal(t, ErrorTooFewAttendees, cerr.ErrorCode())
+		require.Empty(t, srvcs[0].data.Finals[string(descs[0].Hash())].Signature)
+	}
+	res, cerr := c.FinalizeWithMin(dst, descs[0], atts, priv[0], false, 4)
+	require.Nil(t, cerr)
+	require.Nil(t, res.Final.Verify())
+	require.Equal(t, 4, res.NumAttendees)
+}
+
+func TestClient_FinalizeFromFile(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 5, 1)
+	// The other conodes don't know about the last attendee, so it gets pruned
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts[:4]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := 1; i < len(srvcs); i++ {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+
+	tmp, err := ioutil.TempDir("", "pop")
+	log.ErrFatal(err)
+	defer os.RemoveAll(tmp)
+	file := filepath.Join(tmp, "attendees.txt")
+	var lines bytes.Buffer
+	for _, a := range atts {
+		str, err := crypto.PubToString64(network.Suite, a)
+		log.ErrFatal(err)
+		lines.WriteString(str + "\n\n")
+	}
+	log.ErrFatal(ioutil.WriteFile(file, lines.Bytes(), 0600))
+	read, err := ReadAttendeesFile(file)
+	require.Nil(t, err)
+	require.Equal(t, attendeeKeys(atts), attendeeKeys(read))
+
+	c := NewClient()
+	dst := r.List[0].Address
+	_, cerr := c.FinalizeFromFile(dst, descs[0], filepath.Join(tmp, "missing.txt"), priv[0])
+	require.NotNil(t, cerr)
+	bad := filepath.Join(tmp, "bad.txt")
+	log.ErrFatal(ioutil.WriteFile(bad, append(lines.Bytes(), []byte("not a key\n")...), 0600))
+	_, cerr = c.FinalizeFromFile(dst, descs[0], bad, priv[0])
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "bad.txt:11")
+
+	final, cerr := c.FinalizeFromFile(dst, descs[0], file, priv[0])
+	require.Nil(t, cerr)
+	require.Nil(t, final.Verify())
+	// The attendees are sorted by the statement, so compare them as sets
+	want, got := attendeeKeys(atts[:4]), attendeeKeys(final.Attendees)
+	sort.Strings(want)
+	sort.Strings(got)
+	require.Equal(t, want, got)
+}
+
+func TestClient_FetchFinalHeader(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	hash := descs[0].Hash()
+	c := NewClient()
+	dst := r.List[0].Address
+	_, cerr := c.FetchFinalHeader(dst, hash)
+	require.NotNil(t, cerr)
+
+	for i := len(srvcs) - 1; i >= 0; i-- {
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	final, cerr := c.FetchFinal(dst, hash)
+	require.Nil(t, cerr)
+
+	// The header together with the cached attendees verifies
+	header, cerr := c.FetchFinalHeader(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, final.Signature, header.Signature)
+	require.Nil(t, header.Statement(final.Attendees).Verify())
+	require.NotNil(t, header.Statement(final.Attendees[1:]).Verify())
+	_, cerr = c.FetchFinalHeader(dst, []byte("unknown"))
+	require.NotNil(t, cerr)
+}
+
+func TestClient_FinalizeWeighted(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	kps := make([]*config.KeyPair, 4)
+	atts := make([]abstract.Point, len(kps))
+	for i := range kps {
+		kps[i] = config.NewKeyPair(network.Suite)
+		atts[i] = kps[i].Public
+	}
+	// The second conode doesn't have the last attendee, so its weight
+	// is dropped with it.
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts[:3],
+		Weights: []int{1, 3, 1}}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[1], frHash)
+	log.ErrFatal(err)
+	srvcs[1].FinalizeRequest(fr)
+
+	c := NewClient()
+	dst := r.List[0].Address
+	_, cerr := c.FinalizeWeighted(dst, descs[0], atts, []int{1, 3}, priv[0])
+	require.NotNil(t, cerr)
+	// The conodes have to agree on the weights of the common attendees
+	_, cerr = c.FinalizeWeighted(dst, descs[0], atts, []int{1, 2, 1, 5}, priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorAttendeesMismatch, cerr.ErrorCode())
+	final, cerr := c.FinalizeWeighted(dst, descs[0], atts, []int{1, 3, 1, 5}, priv[0])
+	require.Nil(t, cerr)
+	require.Nil(t, final.Verify())
+	require.Equal(t, 3, len(final.Attendees))
+	require.Equal(t, 5, final.TotalWeight())
+	require.Equal(t, 3, final.Weight(atts[1]))
+	require.Equal(t, 0, final.Weight(atts[3]))
+
+	// The weights are covered by the signature
+	weights := final.Weights
+	final.Weights = []int{2, 2, 1}
+	require.NotNil(t, final.Verify())
+	final.Weights = weights
+	buf, err := final.ToToml()
+	log.ErrFatal(err)
+	fs, err := NewFinalStatementFromToml(buf)
+	log.ErrFatal(err)
+	require.Nil(t, fs.Verify())
+	require.Equal(t, 5, fs.TotalWeight())
+
+	// The signature only reveals the weight of the signer
+	msg, ctx := []byte("msg"), []byte("ctx")
+	for i, w := range []int{1, 3} {
+		index := indexOf(final.Attendees, kps[i].Public)
+		sig, tag, err := c.SignWeighted(final, index, kps[i].Secret, msg, ctx)
+		log.ErrFatal(err)
+		weight, err := c.VerifyWeighted(final, msg, ctx, sig, tag)
+		log.ErrFatal(err)
+		require.Equal(t, w, weight)
+		_, tag2, err := c.Sign(final, index, kps[i].Secret, msg, ctx)
+		log.ErrFatal(err)
+		require.Equal(t, tag, tag2)
+	}
+	sig, tag, err := c.SignWeighted(final, indexOf(final.Attendees, kps[0].Public),
+		kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	_, err = c.VerifyWeighted(final, []byte("other"), ctx, sig, tag)
+	require.NotNil(t, err)
+
+	// An attendee with weight 1 can't claim the weight 3, neither with a
+	// ring of all attendees nor with one holding the heavy attendee
+	light := indexOf(final.Attendees, kps[0].Public)
+	sig, tag, err = c.Sign(final, light, kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	_, err = c.VerifyWeighted(final, msg, ctx, sig, tag)
+	require.NotNil(t, err)
+	ring := &FinalStatement{Attendees: []abstract.Point{kps[1].Public,
+		kps[0].Public}}
+	sig, tag, err = c.Sign(ring, 1, kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	_, err = c.VerifyWeighted(final, msg, ctx, sig, tag)
+	require.NotNil(t, err)
+}
+
+func TestClient_StoreOnSkipchain(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	for _, s := range srvcs {
+		s.config.StoreOnSkipchain = true
+	}
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[1], frHash)
+	log.ErrFatal(err)
+	srvcs[1].FinalizeRequest(fr)
+
+	c := NewClient()
+	res, cerr := c.FinalizeWithCounts(r.List[0].Address, descs[0], atts, priv[0], false)
+	require.Nil(t, cerr)
+	require.NotNil(t, res.SkipBlockID)
+	require.Nil(t, c.VerifySkipBlock(res.Final, res.SkipBlockID))
+
+	// The other conode got the block too
+	hash := string(descs[0].Hash())
+	Eventually(t, func() bool {
+		return bytes.Equal(srvcs[1].data.SkipBlocks[hash], res.SkipBlockID)
+	}, "skipblock not propagated")
+	// and doesn't take a block which doesn't hold its statement
+	srvcs[1].StoreSkipBlockRef(&SkipBlockRef{PopHash: descs[0].Hash(), ID: []byte("other")})
+	require.Equal(t, res.SkipBlockID, []byte(srvcs[1].data.SkipBlocks[hash]))
+
+	// The block holds the hash of the statement
+	sb, cerr := skipchain.NewClient().GetSingleBlock(r, res.SkipBlockID)
+	require.Nil(t, cerr)
+	_, msg, err := network.Unmarshal(sb.Data)
+	log.ErrFatal(err)
+	finalHash, err := res.Final.Hash()
+	log.ErrFatal(err)
+	require.Equal(t, finalHash, msg.(*SkipchainEntry).Hash)
+
+	// Another statement doesn't verify against the block
+	final := *res.Final
+	final.Attendees = final.Attendees[:1]
+	require.NotNil(t, c.VerifySkipBlock(&final, res.SkipBlockID))
+
+	// A repeated request returns the same block
+	res2, cerr := c.FinalizeWithCounts(r.List[0].Address, descs[0], atts, priv[0], false)
+	require.Nil(t, cerr)
+	require.Equal(t, res.SkipBlockID, res2.SkipBlockID)
+}
+
+func TestClient_IsRegistered(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	hash := descs[0].Hash()
+	c := NewClient()
+	dst := srvcs[0].ServerIdentity().Address
+	other := config.NewKeyPair(network.Suite).Public
+
+	_, cerr := c.IsRegistered(dst, []byte("unknown"), atts[0])
+	require.NotNil(t, cerr)
+	reg, cerr := c.IsRegistered(dst, hash, atts[0])
+	require.Nil(t, cerr)
+	require.False(t, reg)
+
+	// The other conode didn't finalize yet, so this is still a draft
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts[:1]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], frHash)
+	log.ErrFatal(err)
+	_, cerr = srvcs[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	reg, cerr = c.IsRegistered(dst, hash, atts[0])
+	require.Nil(t, cerr)
+	require.True(t, reg)
+	reg, cerr = c.IsRegistered(dst, hash, atts[1])
+	require.Nil(t, cerr)
+	require.False(t, reg)
+
+	fr = &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err = fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	for _, a := range atts {
+		reg, cerr = c.IsRegistered(dst, hash, a)
+		require.Nil(t, cerr)
+		require.True(t, reg)
+	}
+	reg, cerr = c.IsRegistered(dst, hash, other)
+	require.Nil(t, cerr)
+	require.False(t, reg)
+}
+
+func TestNewPopDesc(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	srvcs := local.GetServices(nodes, serviceID)
+	roster := onet.NewRoster(r.List)
+
+	desc, err := NewPopDesc("name", "2017-07-31 02:00 +02:00", "city", roster, nil)
+	log.ErrFatal(err)
+	require.Equal(t, "2017-07-31 00:00", desc.DateTime)
+	require.Nil(t, desc.Validate())
+
+	kp := config.NewKeyPair(network.Suite)
+	srvcs[0].(*Service).data.Public = kp.Public
+	c := NewClient()
+	dst := r.List[0].Address
+	require.Nil(t, c.StoreConfig(dst, desc, kp.Secret))
+	exists, _, cerr := c.HasConfig(dst, desc.Hash())
+	require.Nil(t, cerr)
+	require.True(t, exists)
+
+	_, err = NewPopDesc("", "2017-07-31 00:00", "city", roster, nil)
+	require.NotNil(t, err)
+	_, err = NewPopDesc("name", "yesterday", "city", roster, nil)
+	require.NotNil(t, err)
+	_, err = NewPopDesc("name", "2017-07-31 00:00", "city",
+		onet.NewRoster([]*network.ServerIdentity{}), nil)
+	require.NotNil(t, err)
+
+	// The party has to be part of its merge
+	other := &ShortDesc{Location: "other", Roster: onet.NewRoster(r.List[1:])}
+	_, err = NewPopDesc("name", "2017-07-31 00:00", "city", roster,
+		[]*ShortDesc{other})
+	require.NotNil(t, err)
+	desc, err = NewPopDesc("name", "2017-07-31 00:00", "city", roster,
+		[]*ShortDesc{other, {Location: "city", Roster: roster}})
+	log.ErrFatal(err)
+	require.Equal(t, 2, len(desc.Parties))
+
+	// The conodes refuse a sub-party listed twice or without conodes
+	dup := *desc
+	dup.Parties = append(desc.Parties, &ShortDesc{Location: "other",
+		Roster: onet.NewRoster([]*network.ServerIdentity{r.List[1]})})
+	require.NotNil(t, dup.Validate())
+	cerr = c.StoreConfig(dst, &dup, kp.Secret)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "is the same as party 1")
+	empty := *desc
+	empty.Parties = []*ShortDesc{desc.Parties[1],
+		{Location: "other", Roster: onet.NewRoster([]*network.ServerIdentity{})}}
+	require.NotNil(t, empty.Validate())
+	cerr = c.StoreConfig(dst, &empty, kp.Secret)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "has no conodes")
+
+	desc.DateTime = "2017-07-31 02:00 +02:00"
+	require.NotNil(t, desc.Validate())
+}
+
+func TestClient_AuthToken(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	srvcs := local.GetServices(nodes, serviceID)
+	for _, s := range srvcs {
+		s.(*Service).config.AuthToken = []byte("secret")
+	}
+	kp := config.NewKeyPair(network.Suite)
+	srvcs[0].(*Service).data.Public = kp.Public
+	desc, err := NewPopDesc("name", "2017-07-31 00:00", "city", r, nil)
+	log.ErrFatal(err)
+	dst := r.List[0].Address
+
+	// Reading and storing are both refused without the right token
+	for _, token := range [][]byte{nil, []byte("wrong")} {
+		c := NewClient()
+		c.AuthToken = token
+		_, cerr := c.GetChallenge(dst)
+		require.NotNil(t, cerr)
+		require.Equal(t, ErrorUnauthorized, cerr.ErrorCode())
+		cerr = c.StoreConfig(dst, desc, kp.Secret)
+		require.NotNil(t, cerr)
+		require.Equal(t, ErrorUnauthorized, cerr.ErrorCode())
+	}
+	require.Empty(t, srvcs[0].(*Service).data.Finals)
+
+	c := NewClient()
+	c.AuthToken = []byte("secret")
+	_, cerr := c.GetChallenge(dst)
+	require.Nil(t, cerr)
+	require.Nil(t, c.StoreConfig(dst, desc, kp.Secret))
+	exists, _, cerr := c.HasConfig(dst, desc.Hash())
+	require.Nil(t, cerr)
+	require.True(t, exists)
+
+	// The other conodes fetch the parties without token
+	_, cerr = NewClient().GetParty(dst, desc.shortDesc().Hash())
+	require.Nil(t, cerr)
+}
+
+func TestClient_StoreConfigs(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	srvcs := local.GetServices(nodes, serviceID)
+	s := srvcs[0].(*Service)
+	kp := config.NewKeyPair(network.Suite)
+	s.data.Public = kp.Public
+	c := NewClient()
+	dst := r.List[0].Address
+
+	var descs []*PopDesc
+	for _, hour := range []string{"09:00", "13:00", "17:00"} {
+		desc, err := NewPopDesc("name", "2017-07-31 "+hour, "city",
+			onet.NewRoster(r.List), nil)
+		log.ErrFatal(err)
+		descs = append(descs, desc)
+	}
+
+	// Nothing is stored if one of the configs is invalid
+	broken := *descs[2]
+	broken.Roster = nil
+	_, cerr := c.StoreConfigs(dst, []*PopDesc{descs[0], descs[1], &broken}, kp.Secret)
+	require.NotNil(t, cerr)
+	require.Empty(t, s.data.Finals)
+	_, cerr = c.StoreConfigs(dst, descs, config.NewKeyPair(network.Suite).Secret)
+	require.NotNil(t, cerr)
+	require.Empty(t, s.data.Finals)
+
+	ids, cerr := c.StoreConfigs(dst, descs, kp.Secret)
+	require.Nil(t, cerr)
+	require.Equal(t, 3, len(ids))
+	require.Equal(t, 3, len(s.data.Finals))
+	for i, desc := range descs {
+		require.Equal(t, desc.Hash(), ids[i])
+		require.NotNil(t, s.data.Finals[string(ids[i])])
+		require.NotNil(t, s.data.syncMetas[string(ids[i])])
+	}
+
+	// A request can't be replayed
+	req := &StoreConfigsRequest{Descs: descs, Nonce: challenge(s)}
+	hash, err := req.Hash()
+	log.ErrFatal(err)
+	req.Signature, err = crypto.SignSchnorr(network.Suite, kp.Secret, hash)
+	log.ErrFatal(err)
+	_, cerr = s.StoreConfigs(req)
+	require.Nil(t, cerr)
+	_, cerr = s.StoreConfigs(req)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+
+	// A finalized party is not replaced
+	final := s.data.Finals[string(ids[1])]
+	final.Attendees = []abstract.Point{kp.Public}
+	final.Signature = []byte("signed")
+	_, cerr = c.StoreConfigs(dst, descs, kp.Secret)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+	require.Equal(t, final, s.data.Finals[string(ids[1])])
+}
+
+func TestClient_HasConfig(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	srvcs := local.GetServices(nodes, serviceID)
+	desc := &PopDesc{
+		Name:     "name",
+		DateTime: "2017-07-31 00:00",
+		Location: "city",
+		Roster:   onet.NewRoster(r.List),
+	}
+	hash := desc.Hash()
+	c := NewClient()
+	dst := r.List[0].Address
+
+	exists, finalized, cerr := c.HasConfig(dst, hash)
+	require.Nil(t, cerr)
+	require.False(t, exists)
+	require.False(t, finalized)
+	s := srvcs[0].(*Service)
+	require.Equal(t, 0, len(s.data.Finals))
+
+	descs, atts, sret, priv := storeDesc(srvcs, r, 2, 1)
+	hash = descs[0].Hash()
+	for i := 0; i < 2; i++ {
+		exists, finalized, cerr = c.HasConfig(dst, hash)
+		require.Nil(t, cerr)
+		require.True(t, exists)
+		require.False(t, finalized)
+	}
+	// Asking doesn't change the attendees
+	s.data.Finals[string(hash)].Attendees = atts
+	_, _, cerr = c.HasConfig(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, atts, s.data.Finals[string(hash)].Attendees)
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range sret {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		sret[i].FinalizeRequest(fr)
+	}
+	exists, finalized, cerr = c.HasConfig(dst, hash)
+	require.Nil(t, cerr)
+	require.True(t, exists)
+	require.True(t, finalized)
+}
+
+func TestClient_StoreConfigAndWaitPropagation(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	srvcs := local.GetServices(nodes, serviceID)
+	privs := make([]abstract.Scalar, len(srvcs))
+	for i, s := range srvcs {
+		kp := config.NewKeyPair(network.Suite)
+		s.(*Service).data.Public, privs[i] = kp.Public, kp.Secret
+	}
+	desc := &PopDesc{
+		Name:     "name",
+		DateTime: "2017-07-31 00:00",
+		Location: "city",
+		Roster:   onet.NewRoster(r.List),
+	}
+	store := func(i int) {
+		sig, err := crypto.SignSchnorr(network.Suite, privs[i], desc.Hash())
+		log.ErrFatal(err)
+		_, cerr := srvcs[i].(*Service).StoreConfig(&StoreConfig{desc, sig})
+		log.ErrFatal(cerr)
+	}
+	c := NewClient()
+	dst := r.List[0].Address
+
+	cerr := c.StoreConfigAndWaitPropagation(dst, desc, privs[0], 300*time.Millisecond)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorTimeout, cerr.ErrorCode())
+
+	done := make(chan onet.ClientError)
+	go func() {
+		done <- c.StoreConfigAndWaitPropagation(dst, desc, privs[0], 10*time.Second)
+	}()
+	store(1)
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("Returned before all conodes have the config")
+	default:
+	}
+	store(2)
+	require.Nil(t, <-done)
+}
+
+func TestClient_Repropagate(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.Repropagate(dst, hash, priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	// The last conode missed the propagation of the signature
+	lagging := srvcs[2]
+	lagging.data.Finals[string(hash)] = &FinalStatement{Desc: descs[0],
+		Signature: []byte{}}
+	_, cerr = c.FetchFinal(lagging.ServerIdentity().Address, hash)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+
+	_, cerr = c.Repropagate(dst, hash, priv[1])
+	require.NotNil(t, cerr)
+	replies, cerr := c.Repropagate(dst, hash, priv[0])
+	require.Nil(t, cerr)
+	require.Equal(t, 3, replies)
+	fs, cerr := c.FetchFinal(lagging.ServerIdentity().Address, hash)
+	require.Nil(t, cerr)
+	require.Nil(t, fs.Verify())
+	require.Equal(t, len(atts), len(fs.Attendees))
+}
+
+func TestClient_PreviewFinalize(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 4, 1)
+	desc := descs[0]
+	hash := string(desc.Hash())
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	// The second conode misses the last attendee
+	_, cerr := c.FinalizeWithCounts(srvcs[1].ServerIdentity().Address, desc,
+		atts[:3], priv[1], false)
+	require.NotNil(t, cerr)
+	_, cerr = c.FinalizeWithCounts(srvcs[2].ServerIdentity().Address, desc,
+		atts, priv[2], false)
+	require.NotNil(t, cerr)
+
+	_, _, cerr = c.PreviewFinalize(dst, desc, atts, priv[1], false)
+	require.NotNil(t, cerr)
+	kept, dropped, cerr := c.PreviewFinalize(dst, desc, atts, priv[0], false)
+	require.Nil(t, cerr)
+	requireSameAttendees(t, atts[:3], kept)
+	requireSameAttendees(t, atts[3:], dropped)
+
+	// Nothing changed on the conodes
+	require.Equal(t, 0, len(srvcs[0].data.Finals[hash].Attendees))
+	requireSameAttendees(t, atts[:3], srvcs[1].data.Finals[hash].Attendees)
+	requireSameAttendees(t, atts, srvcs[2].data.Finals[hash].Attendees)
+	for _, s := range srvcs {
+		require.Equal(t, 0, len(s.data.Finals[hash].Signature))
+	}
+
+	res, cerr := c.FinalizeWithCounts(dst, desc, atts, priv[0], false)
+	require.Nil(t, cerr)
+	requireSameAttendees(t, kept, res.Final.Attendees)
+
+	_, _, cerr = c.PreviewFinalize(dst, desc, atts, priv[0], false)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+}
+
+func TestClient_SignVerify(t *testing.T) {
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	final := &FinalStatement{
+		Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+	}
+	c := NewClient()
+	msg, ctx := []byte("msg"), []byte("ctx")
+	sig, tag, err := c.Sign(final, 1, kps[1].Secret, msg, ctx)
+	log.ErrFatal(err)
+	require.Equal(t, 32, len(tag))
+	_, err = c.Verify(final, msg, ctx, sig, tag)
+	require.Nil(t, err)
+	_, err = c.Verify(final, []byte("other"), ctx, sig, tag)
+	require.NotNil(t, err)
+	_, err = c.Verify(final, msg, []byte("other"), sig, tag)
+	require.NotNil(t, err)
+
+	// The tag links the signatures in one context
+	sig2, tag2, err := c.Sign(final, 1, kps[1].Secret, []byte("msg2"), ctx)
+	log.ErrFatal(err)
+	require.Equal(t, tag, tag2)
+	_, err = c.Verify(final, []byte("msg2"), ctx, sig2, tag2)
+	require.Nil(t, err)
+	_, tag3, err := c.Sign(final, 0, kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	require.NotEqual(t, tag, tag3)
+
+	// Moving the boundary between signature and tag fails
+	sigtag := append(append([]byte{}, sig...), tag...)
+	for _, split := range []int{len(sig) - 1, len(sig) + 1} {
+		_, err = c.Verify(final, msg, ctx, sigtag[:split], sigtag[split:])
+		require.NotNil(t, err)
+	}
+	_, err = c.Verify(final, msg, ctx, sigtag[:len(sig)], sigtag[len(sig):])
+	require.Nil(t, err)
+
+	_, _, err = c.Sign(final, 0, kps[1].Secret, msg, ctx)
+	require.NotNil(t, err)
+	_, _, err = c.Sign(final, 2, kps[1].Secret, msg, ctx)
+	require.NotNil(t, err)
+}
+
+func TestClient_SignVerifySuite(t *testing.T) {
+	suite := nist.NewAES128SHA256P256()
+	kps := []*config.KeyPair{config.NewKeyPair(suite), config.NewKeyPair(suite)}
+	final := &FinalStatement{
+		Attendees: []abstract.Point{kps[0].Public, kps[1].Public},
+	}
+	c := NewClient()
+	c.Suite = suite
+	require.Equal(t, suite.PointLen(), c.TagLength())
+	require.NotEqual(t, NewClient().TagLength(), c.TagLength())
+
+	msg, ctx := []byte("msg"), []byte("ctx")
+	sig, tag, err := c.Sign(final, 0, kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	require.Equal(t, c.TagLength(), len(tag))
+	_, err = c.Verify(final, msg, ctx, sig, tag)
+	require.Nil(t, err)
+	sigtag := append(append([]byte{}, sig...), tag...)
+	split := len(sigtag) - NewClient().TagLength()
+	_, err = c.Verify(final, msg, ctx, sigtag[:split], sigtag[split:])
+	require.NotNil(t, err)
+}
+
+func TestClient_RotateAttendee(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+	kpOld := config.NewKeyPair(network.Suite)
+	kpNew := config.NewKeyPair(network.Suite)
+	other := config.NewKeyPair(network.Suite).Public
+
+	finalize := func(i int, atts []abstract.Point) onet.ClientError {
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		_, cerr := srvcs[i].FinalizeRequest(fr)
+		return cerr
+	}
+	require.NotNil(t, finalize(0, []abstract.Point{kpOld.Public, other}))
+
+	msg, err := RotationMsg(hash, kpNew.Public)
+	log.ErrFatal(err)
+	proof, err := crypto.SignSchnorr(network.Suite, kpOld.Secret, msg)
+	log.ErrFatal(err)
+	badProof, err := crypto.SignSchnorr(network.Suite, kpNew.Secret, msg)
+	log.ErrFatal(err)
+	require.NotNil(t, c.RotateAttendee(dst, hash, kpOld.Public, kpNew.Public,
+		badProof, priv[0]))
+	require.NotNil(t, c.RotateAttendee(dst, hash, kpOld.Public, kpNew.Public,
+		proof, priv[1]))
+	require.Nil(t, c.RotateAttendee(dst, hash, kpOld.Public, kpNew.Public,
+		proof, priv[0]))
+	registered, cerr := c.IsRegistered(dst, hash, kpOld.Public)
+	require.Nil(t, cerr)
+	require.False(t, registered)
+
+	// Sending the old key again doesn't undo the rotation
+	require.NotNil(t, finalize(0, []abstract.Point{kpOld.Public, other}))
+	registered, cerr = c.IsRegistered(dst, hash, kpOld.Public)
+	require.Nil(t, cerr)
+	require.False(t, registered)
+
+	// The other organizer uses the new key as well
+	require.Nil(t, finalize(1, []abstract.Point{kpNew.Public, other}))
+	fs, cerr := c.FetchFinal(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(fs.Attendees))
+	index := -1
+	for i, a := range fs.Attendees {
+		if a.Equal(kpNew.Public) {
+			index = i
+		}
+	}
+	require.NotEqual(t, -1, index)
+	set := anon.Set(fs.Attendees)
+	sigtag := anon.Sign(network.Suite, random.Stream, []byte("msg"), set,
+		[]byte("ctx"), index, kpNew.Secret)
+	_, err = anon.Verify(network.Suite, []byte("msg"), set, []byte("ctx"), sigtag)
+	require.Nil(t, err)
+
+	// No rotation after the finalization
+	msg, err = RotationMsg(hash, kpOld.Public)
+	log.ErrFatal(err)
+	proof, err = crypto.SignSchnorr(network.Suite, kpNew.Secret, msg)
+	log.ErrFatal(err)
+	cerr = c.RotateAttendee(dst, hash, kpNew.Public, kpOld.Public, proof, priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+}
+
+func TestClient_RevokeAttendee(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	hash := descs[0].Hash()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	atts := []abstract.Point{kps[0].Public, kps[1].Public}
+	c := NewClient()
+	_, cerr := c.RevokeAttendee(r.List[0].Address, hash, atts[1], priv[0])
+	require.NotNil(t, cerr)
+
+	for i := len(srvcs) - 1; i >= 0; i-- {
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	final, cerr := c.FetchFinal(r.List[0].Address, hash)
+	require.Nil(t, cerr)
+	msg, ctx := []byte("msg"), []byte("ctx")
+	sig, tag, err := c.Sign(final, indexOf(final.Attendees, kps[1].Public),
+		kps[1].Secret, msg, ctx)
+	log.ErrFatal(err)
+	_, err = c.Verify(final, msg, ctx, sig, tag)
+	require.Nil(t, err)
+
+	// The statement is only signed again once all organizers revoked
+	_, cerr = c.RevokeAttendee(r.List[0].Address, hash,
+		config.NewKeyPair(network.Suite).Public, priv[0])
+	require.NotNil(t, cerr)
+	_, cerr = c.RevokeAttendee(r.List[0].Address, hash, atts[1], priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+	require.Nil(t, srvcs[0].data.Finals[string(hash)].Verify())
+	revoked, cerr := c.RevokeAttendee(r.List[1].Address, hash, atts[1], priv[1])
+	require.Nil(t, cerr)
+	require.Nil(t, revoked.Verify())
+	require.Equal(t, 1, revoked.Epoch)
+	require.Equal(t, 1, len(revoked.Attendees))
+
+	// The token of the revoked attendee doesn't verify anymore
+	Eventually(t, func() bool {
+		fs, cerr := c.FetchFinal(r.List[0].Address, hash)
+		return cerr == nil && fs.Epoch == 1
+	}, "revoked statement not propagated")
+	_, err = c.Verify(revoked, msg, ctx, sig, tag)
+	require.NotNil(t, err)
+
+	// A verifier with the remaining attendees can use the header
+	header, cerr := c.FetchFinalHeader(r.List[0].Address, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, 1, header.Epoch)
+	require.Nil(t, header.Statement(revoked.Attendees).Verify())
+	sig, tag, err = c.Sign(revoked, 0, kps[0].Secret, msg, ctx)
+	log.ErrFatal(err)
+	_, err = c.Verify(revoked, msg, ctx, sig, tag)
+	require.Nil(t, err)
+
+	// An older statement doesn't replace the revoked one
+	srvcs[0].PropagateFinal(final)
+	require.Equal(t, 1, srvcs[0].data.Finals[string(hash)].Epoch)
+}
+
+func TestClient_GetAttendeeInfo(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	finalize := func(i int, atts []abstract.Point) {
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	// The first attendee registers before the others, the extra one only
+	// on the first conode
+	extra := config.NewKeyPair(network.Suite).Public
+	finalize(0, atts[:1])
+	time.Sleep(1100 * time.Millisecond)
+	finalize(0, append([]abstract.Point{extra}, atts...))
+	require.NotEqual(t, int64(0),
+		srvcs[0].data.Registrations[string(hash)].registeredAt(extra))
+	finalize(1, atts)
+	fs, cerr := c.FetchFinal(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, 3, len(fs.Attendees))
+
+	// The registration of the pruned attendee is removed
+	Eventually(t, func() bool {
+		return srvcs[0].data.Registrations[string(hash)].registeredAt(extra) == 0
+	}, "pruned attendee still registered")
+
+	times := make([]int64, len(atts))
+	for i, a := range atts {
+		registered, at, cerr := c.GetAttendeeInfo(dst, hash, a)
+		require.Nil(t, cerr)
+		require.True(t, registered)
+		require.NotEqual(t, int64(0), at)
+		times[i] = at
+	}
+	require.True(t, times[0] < times[1])
+	require.Equal(t, times[1], times[2])
+
+	registered, at, cerr := c.GetAttendeeInfo(dst, hash,
+		config.NewKeyPair(network.Suite).Public)
+	require.Nil(t, cerr)
+	require.False(t, registered)
+	require.Equal(t, int64(0), at)
+	_, _, cerr = c.GetAttendeeInfo(dst, []byte("unknown"), atts[0])
+	require.NotNil(t, cerr)
+}
+
+func TestClient_ReconcileAttendees(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	// The last attendee only registered on the last conode
+	for i, s := range srvcs {
+		n := 2
+		if i == 2 {
+			n = 3
+		}
+		s.data.Finals[string(hash)].Attendees = append([]abstract.Point{}, atts[:n]...)
+	}
+	_, cerr := c.ReconcileAttendees(dst, hash, priv[1])
+	require.NotNil(t, cerr)
+	// The other conodes don't take attendees that their organizer didn't
+	// sign.
+	_, cerr = c.ReconcileAttendees(dst, hash, priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, 2, len(srvcs[1].data.Finals[string(hash)].Attendees))
+	for _, s := range srvcs[1:] {
+		s.data.Owners[string(hash)] = &partyOwner{srvcs[0].data.Public}
+	}
+	num, cerr := c.ReconcileAttendees(dst, hash, priv[0])
+	require.Nil(t, cerr)
+	require.Equal(t, 3, num)
+	for _, s := range srvcs {
+		requireSameAttendees(t, atts, s.data.Finals[string(hash)].Attendees)
+	}
+
+	// Only the conodes of the party are accepted as senders
+	other := config.NewKeyPair(network.Suite).Public
+	ra := &ReconcileAttendees{PopHash: hash, Attendees: []abstract.Point{other}}
+	sig, err := crypto.SignSchnorr(network.Suite, priv[0], ra.requestHash())
+	log.ErrFatal(err)
+	ra.Signature = sig
+	srvcs[1].ReconcileAttendees(&network.Envelope{
+		ServerIdentity: network.NewServerIdentity(other, srvcs[0].ServerIdentity().Address),
+		Msg:            ra})
+	require.Equal(t, 3, len(srvcs[1].data.Finals[string(hash)].Attendees))
+
+	// Now a strict finalization passes
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts, Strict: true}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	var final *FinalStatement
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		msg, _ := srvcs[i].FinalizeRequest(fr)
+		if msg != nil {
+			final = msg.(*FinalizeResponse).Final
+		}
+	}
+	require.NotNil(t, final)
+	require.Equal(t, 3, len(final.Attendees))
+
+	_, cerr = c.ReconcileAttendees(dst, hash, priv[0])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+}
+
+func TestClient_RegisterAttendees(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, _, cerr := c.RegisterAttendees(dst, hash, atts[:1], priv[1], true)
+	require.NotNil(t, cerr)
+
+	// Without propagation only the first conode knows the attendee
+	num, conodes, cerr := c.RegisterAttendees(dst, hash, atts[:1], priv[0], false)
+	require.Nil(t, cerr)
+	require.Equal(t, 1, num)
+	require.Equal(t, 0, conodes)
+	registered, cerr := c.IsRegistered(srvcs[1].ServerIdentity().Address, hash, atts[0])
+	require.Nil(t, cerr)
+	require.False(t, registered)
+
+	// The new attendees reach all conodes before the finalization, even
+	// though every conode has its own organizer. A conode that can't
+	// store them isn't counted.
+	srvcs[2].data.Finals[string(hash)].Signature = []byte("signed")
+	num, conodes, cerr = c.RegisterAttendees(dst, hash, atts[1:2], priv[0], true)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, num)
+	require.Equal(t, 2, conodes)
+	srvcs[2].data.Finals[string(hash)].Signature = []byte{}
+	num, conodes, cerr = c.RegisterAttendees(dst, hash, atts, priv[0], true)
+	require.Nil(t, cerr)
+	require.Equal(t, 3, num)
+	require.Equal(t, 3, conodes)
+	for _, s := range srvcs[1:] {
+		for _, a := range atts[1:] {
+			registered, cerr = c.IsRegistered(s.ServerIdentity().Address, hash, a)
+			require.Nil(t, cerr)
+			require.True(t, registered)
+		}
+	}
+
+	// The signed request holds the first attendee too, so none is pruned
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	var final *FinalStatement
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		msg, _ := srvcs[i].FinalizeRequest(fr)
+		if msg != nil {
+			final = msg.(*FinalizeResponse).Final
+		}
+	}
+	require.NotNil(t, final)
+	requireSameAttendees(t, atts, final.Attendees)
+
+	_, _, cerr = c.RegisterAttendees(dst, hash, atts, priv[0], true)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+}
+
+func TestClient_FinalizeStatus(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	_, cerr := c.FinalizeStatus(dst, []byte("unknown"))
+	require.NotNil(t, cerr)
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	finalize := func(i int) onet.ClientError {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		_, cerr := srvcs[i].FinalizeRequest(fr)
+		return cerr
+	}
+	require.NotNil(t, finalize(0))
+	require.NotNil(t, finalize(1))
+
+	// The last conode is the holdout
+	conodes, cerr := c.FinalizeStatus(dst, hash)
+	require.Nil(t, cerr)
+	require.Equal(t, 3, len(conodes))
+	for i, cs := range conodes {
+		require.True(t, cs.Conode.Equal(r.List[i]))
+		require.True(t, cs.Reachable)
+		require.True(t, cs.Exists)
+		require.False(t, cs.Finalized)
+	}
+	require.Equal(t, 2, conodes[0].NumAttendees)
+	require.Equal(t, 2, conodes[1].NumAttendees)
+	require.Equal(t, 0, conodes[2].NumAttendees)
+
+	require.Nil(t, finalize(2))
+	conodes, cerr = c.FinalizeStatus(dst, hash)
+	require.Nil(t, cerr)
+	for _, cs := range conodes {
+		require.True(t, cs.Finalized)
+	}
+}
+
+func TestClient_FetchExpired(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	desc := descs[0]
+	noExpiry := desc.Hash()
+	desc.ExpiresAt = time.Now().Unix() + 2
+	hash := desc.Hash()
+	require.NotEqual(t, noExpiry, hash)
+	require.False(t, desc.Expired())
+	for i, s := range srvcs {
+		sg, err := crypto.SignSchnorr(network.Suite, priv[i], hash)
+		log.ErrFatal(err)
+		_, cerr := s.StoreConfig(&StoreConfig{desc, sg})
+		log.ErrFatal(cerr)
+	}
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+	fs, cerr := c.FetchFinal(dst, hash)
+	require.Nil(t, cerr)
+	require.Nil(t, fs.Verify())
+	require.Equal(t, desc.ExpiresAt, fs.Desc.ExpiresAt)
+
+	time.Sleep(time.Unix(desc.ExpiresAt, 0).Sub(time.Now()) + pollInterval)
+	require.True(t, desc.Expired())
+	_, cerr = c.FetchFinal(dst, hash)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorExpired, cerr.ErrorCode())
+	_, cerr = srvcs[0].GetAggregate(&GetAggregateRequest{hash})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorExpired, cerr.ErrorCode())
+
+	// A statement that didn't come through StoreConfig, like a merged or
+	// propagated one, expires as well.
+	propagated := *srvcs[1].data.Finals[string(hash)]
+	srvcs[1].data.Finals["propagated"] = &propagated
+	_, cerr = srvcs[1].FetchFinal(&FetchRequest{[]byte("propagated")})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorExpired, cerr.ErrorCode())
+}
+
+func TestClient_FindParty(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	desc := &PopDesc{
+		Name:     "meetup",
+		DateTime: "2017-07-31 18:00",
+		Location: "city0",
+		Roster:   descs[0].Roster,
+	}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], desc.Hash())
+	log.ErrFatal(err)
+	_, cerr := srvcs[0].StoreConfig(&StoreConfig{desc, sg})
+	log.ErrFatal(cerr)
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	ids, cerr := c.FindParty(dst, "meetup", "2017-07-31 18:00")
+	require.Nil(t, cerr)
+	require.Equal(t, [][]byte{desc.Hash()}, ids)
+	ids, cerr = c.FindParty(dst, "meetup", "")
+	require.Nil(t, cerr)
+	require.Equal(t, 1, len(ids))
+	_, cerr = c.FindParty(dst, "meetup", "2017-08-01 18:00")
+	require.NotNil(t, cerr)
+
+	// Both parties of storeDesc match
+	ids, cerr = c.FindParty(dst, descs[0].Name, descs[0].DateTime)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(ids))
+	for _, d := range descs {
+		require.Contains(t, ids, d.Hash())
+	}
+}
+
+func TestClient_Audit(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	for _, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for i := range srvcs {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+			log.ErrFatal(err)
+			srvcs[i].FinalizeRequest(fr)
+		}
+	}
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+	failed, cerr := c.Audit(dst)
+	require.Nil(t, cerr)
+	require.Equal(t, 0, len(failed))
+
+	hash := descs[1].Hash()
+	final := srvcs[0].data.Finals[string(hash)]
+	require.Nil(t, final.Verify())
+	final.Signature[0] ^= 0xff
+	failed, cerr = c.Audit(dst)
+	require.Nil(t, cerr)
+	require.Equal(t, [][]byte{hash}, failed)
+}
+
+func TestClient_Pending(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 1, 1)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	c := NewClient()
+
+	pending, cerr := c.ListPending(dst, priv[0])
+	require.Nil(t, cerr)
+	require.Equal(t, 0, len(pending))
+
+	syncData := srvcs[0].data.syncMetas[string(hash)]
+	ccDone := make(chan *CheckConfigReply)
+	go func() {
+		ccDone <- syncData.waitCheckConfig()
+	}()
+	Eventually(t, func() bool {
+		return syncData.pending()
+	}, "CheckConfig wait didn't start")
+	pending, cerr = c.ListPending(dst, priv[0])
+	require.Nil(t, cerr)
+	require.Equal(t, [][]byte{hash}, pending)
+
+	// Only the linked organizer can list and cancel
+	_, cerr = c.ListPending(dst, priv[1])
+	require.NotNil(t, cerr)
+	require.NotNil(t, c.CancelPending(dst, hash, priv[1]))
+	require.NotNil(t, c.CancelPending(dst, []byte("unknown"), priv[0]))
+	require.True(t, syncData.pending())
+
+	require.Nil(t, c.CancelPending(dst, hash, priv[0]))
+	select {
+	case rep := <-ccDone:
+		require.Nil(t, rep)
+	case <-time.After(time.Second):
+		t.Fatal("CheckConfig wait didn't return")
+	}
+	Eventually(t, func() bool {
+		pending, cerr = c.ListPending(dst, priv[0])
+		return cerr == nil && len(pending) == 0
+	}, "Still pending operations")
+}
+
+func TestPopDesc_Hash(t *testing.T) {
+	sis := make([]*network.ServerIdentity, 3)
+	for i := range sis {
+		kp := config.NewKeyPair(network.Suite)
+		sis[i] = network.NewServerIdentity(kp.Public,
+			network.NewAddress(network.PlainTCP, fmt.Sprintf("0:%d", 2000+i)))
+	}
+	desc1 := &PopDesc{
+		Name:     "test",
+		DateTime: "2017-07-31 00:00",
+		Location: "city0",
+		Roster:   onet.NewRoster(sis[:2]),
+		Parties: []*ShortDesc{
+			{Location: "city0", Roster: onet.NewRoster(sis[:2])},
+			{Location: "city1", Roster: onet.NewRoster(sis[2:])},
+		},
+	}
+	desc2 := &PopDesc{
+		Name:     "test",
+		DateTime: "2017-07-31 00:00",
+		Location: "city0",
+		Roster:   onet.NewRoster([]*network.ServerIdentity{sis[1], sis[0]}),
+		Parties: []*ShortDesc{
+			{Location: "city1", Roster: onet.NewRoster(sis[2:])},
+			{Location: "city0",
+				Roster: onet.NewRoster([]*network.ServerIdentity{sis[1], sis[0]})},
+		},
+	}
+	require.Equal(t, desc1.Parties[0].Hash(), desc2.Parties[1].Hash())
+	require.Equal(t, desc1.Hash(), desc2.Hash())
+
+	compact := desc1.Compact()
+	require.True(t, compact.Parties[0].IsCompact())
+	require.False(t, desc1.Parties[0].IsCompact())
+	require.Equal(t, desc1.Hash(), compact.Hash())
+	fsToml, err := (&FinalStatement{Desc: compact}).ToToml()
+	log.ErrFatal(err)
+	fs, err := NewFinalStatementFromToml(fsToml)
+	log.ErrFatal(err)
+	require.True(t, fs.Desc.Parties[1].IsCompact())
+	require.Equal(t, desc1.Hash(), fs.Desc.Hash())
+
+	desc2.Parties[0].Location = "city2"
+	require.NotEqual(t, desc1.Hash(), desc2.Hash())
+
+	// The optional fields are tagged, so they give different hashes even
+	// with the same value
+	hashes := map[string]bool{string(desc1.Hash()): true}
+	for _, set := range []func(d *PopDesc){
+		func(d *PopDesc) { d.ExpiresAt = 1 },
+		func(d *PopDesc) { d.MinAttendees = 1 },
+		func(d *PopDesc) { d.OrderingPolicy = OrderInsertion },
+		func(d *PopDesc) { d.VerifierRoster = onet.NewRoster(sis[2:]) },
+		func(d *PopDesc) { d.Parties = nil },
+	} {
+		desc := *desc1
+		set(&desc)
+		hash := desc.Hash()
+		require.NotEqual(t, 0, len(hash))
+		require.False(t, hashes[string(hash)])
+		hashes[string(hash)] = true
+	}
+}
+
+// indexOf returns the index of pub in atts, or -1 if it is missing.
+func indexOf(atts []abstract.Point, pub abstract.Point) int {
+	for i, a := range atts {
+		if a.Equal(pub) {
+			return i
+		}
+	}
+	return -1
+}
@@ -27,16 +27,27 @@ attendee.
 
 import (
 	"bytes"
+	"compress/flate"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/dedis/protobuf"
 	"gopkg.in/dedis/cothority.v1/bftcosi"
 	"gopkg.in/dedis/cothority.v1/messaging"
+	"gopkg.in/dedis/cothority.v1/skipchain"
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
@@ -55,22 +66,186 @@ const bftSignMerge = "PopBFTSignMerge"
 const TIMEOUT = 60 * time.Second
 const DELIMETER = "; "
 
+// maxChallenges is the number of nonces of GetChallenge a conode keeps at
+// the same time. If more are requested, the oldest one is dropped.
+const maxChallenges = 64
+
+// ENVConfig is the environment variable holding the path of the TOML file
+// with the configuration of the service, usually the private.toml of the
+// conode. The options are read from its [PoP] table, see Config. If it is
+// not set, the service uses DefaultConfig.
+const ENVConfig = "CONODE_POP_CONFIG"
+
+// Config holds the options of a service. A service reads them from the
+// conode configuration when it is created, see ENVConfig, and Configure
+// replaces them.
+type Config struct {
+	// DisableMerge refuses merge requests and ignores all merge messages,
+	// which is useful for parties that are never merged.
+	DisableMerge bool
+	// CompressStorage stores the attendees of the final statements
+	// compressed, which saves space for big parties. Compressed storage is
+	// always read.
+	CompressStorage bool
+	// AdminKey links a service that is not linked yet to this public key,
+	// so that automated deployments don't need to read the PIN from the
+	// log. A PinRequest can still link the service to another key.
+	AdminKey abstract.Point
+	// SignMerge signs the MergeConfig and MergeConfigReply messages with
+	// Private, and accepts these messages only if they are signed by a
+	// conode of the party whose final statement is sent. Like this a
+	// conode can't inject a valid final statement of a party it doesn't
+	// belong to. All conodes of the merged parties need the same setting.
+	SignMerge bool
+	// Private is the private key of the conode, read from the Private
+	// entry of private.toml. It is only needed for SignMerge and has to
+	// belong to the public key of the conode.
+	Private abstract.Scalar
+	// StoreOnSkipchain stores the hash of every signed final statement on
+	// a skipchain of the roster of the party, one chain per party.
+	StoreOnSkipchain bool
+	// AuthToken, if not empty, has to be sent by the clients, see
+	// Client.AuthToken. The token is sent in clear, so the websockets have
+	// to be protected, e.g. by a TLS proxy.
+	AuthToken []byte
+	// BroadcastWindow is the maximum number of MergeCheck messages of a
+	// merge waiting for a reply. Further messages are sent once replies
+	// arrived, so that the replies of a big merge don't all arrive at the
+	// same time. 0 sends all messages at once.
+	BroadcastWindow int
+	// BroadcastJitter is the maximum random pause before sending a
+	// MergeCheck when the BroadcastWindow is full.
+	BroadcastJitter time.Duration
+	// ChallengeTimeout is how long a nonce returned by GetChallenge can be
+	// used.
+	ChallengeTimeout time.Duration
+	// MaxMessageEntries is the maximum number of attendees, conodes,
+	// parties and statements in a message from another conode. It is
+	// checked once the message is decoded: bigger messages are dropped,
+	// so that a peer can't make the conode process and store arbitrary
+	// big lists.
+	MaxMessageEntries int
+	// MaxMessageSize is the maximum size in bytes of a message received by
+	// the conode. onet checks it before decoding the message, so that a
+	// peer can't make the conode allocate arbitrary big messages. As onet
+	// has a single limit, it applies to all services of the conode.
+	MaxMessageSize int
+}
+
+// DefaultConfig returns the options of a service without configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		BroadcastWindow:   32,
+		BroadcastJitter:   10 * time.Millisecond,
+		ChallengeTimeout:  5 * time.Minute,
+		MaxMessageEntries: 1000000,
+		MaxMessageSize:    10 * 1024 * 1024,
+	}
+}
+
+// configToml holds the private key of the conode and the [PoP] table of
+// the configuration file. The keys are hex encoded like in private.toml,
+// and the durations are strings like "10ms".
+type configToml struct {
+	Private string
+	PoP     struct {
+		DisableMerge      bool
+		CompressStorage   bool
+		AdminKey          string
+		SignMerge         bool
+		StoreOnSkipchain  bool
+		AuthToken         string
+		BroadcastWindow   *int
+		BroadcastJitter   string
+		ChallengeTimeout  string
+		MaxMessageEntries *int
+		MaxMessageSize    *int
+	}
+}
+
+// ReadConfig reads the [PoP] table of the TOML file. The options that are
+// not set keep the value of DefaultConfig.
+func ReadConfig(file string) (*Config, error) {
+	ct := &configToml{}
+	if _, err := toml.DecodeFile(file, ct); err != nil {
+		return nil, err
+	}
+	pop := ct.PoP
+	cfg := DefaultConfig()
+	cfg.DisableMerge = pop.DisableMerge
+	cfg.CompressStorage = pop.CompressStorage
+	cfg.SignMerge = pop.SignMerge
+	cfg.StoreOnSkipchain = pop.StoreOnSkipchain
+	cfg.AuthToken = []byte(pop.AuthToken)
+	if ct.Private != "" {
+		var err error
+		cfg.Private, err = crypto.StringHexToScalar(network.Suite, ct.Private)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Private: %s", err)
+		}
+	}
+	if pop.AdminKey != "" {
+		var err error
+		cfg.AdminKey, err = crypto.StringHexToPoint(network.Suite, pop.AdminKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AdminKey: %s", err)
+		}
+	}
+	if pop.BroadcastWindow != nil {
+		cfg.BroadcastWindow = *pop.BroadcastWindow
+	}
+	if pop.MaxMessageEntries != nil {
+		cfg.MaxMessageEntries = *pop.MaxMessageEntries
+	}
+	if pop.MaxMessageSize != nil {
+		cfg.MaxMessageSize = *pop.MaxMessageSize
+	}
+	for _, d := range []struct {
+		str string
+		dur *time.Duration
+	}{{pop.BroadcastJitter, &cfg.BroadcastJitter},
+		{pop.ChallengeTimeout, &cfg.ChallengeTimeout}} {
+		if d.str == "" {
+			continue
+		}
+		var err error
+		if *d.dur, err = time.ParseDuration(d.str); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
 var checkConfigID network.MessageTypeID
 var checkConfigReplyID network.MessageTypeID
 var mergeConfigID network.MessageTypeID
 var mergeConfigReplyID network.MessageTypeID
 var mergeCheckID network.MessageTypeID
 var mergeCheckReplyID network.MessageTypeID
+var reconcileAttendeesID network.MessageTypeID
+var reconcileAttendeesReplyID network.MessageTypeID
+var partyStatusID network.MessageTypeID
+var partyStatusReplyID network.MessageTypeID
+var propagateAttendeesID network.MessageTypeID
+var propagateAttendeesReplyID network.MessageTypeID
 
 func init() {
 	onet.RegisterNewService(Name, newService)
 	network.RegisterMessage(&saveData{})
+	network.RegisterMessage(&stateDump{})
+	network.RegisterMessage(&signedDump{})
 	checkConfigID = network.RegisterMessage(CheckConfig{})
 	checkConfigReplyID = network.RegisterMessage(CheckConfigReply{})
 	mergeConfigID = network.RegisterMessage(MergeConfig{})
 	mergeConfigReplyID = network.RegisterMessage(MergeConfigReply{})
 	mergeCheckID = network.RegisterMessage(MergeCheck{})
 	mergeCheckReplyID = network.RegisterMessage(MergeCheckReply{})
+	reconcileAttendeesID = network.RegisterMessage(ReconcileAttendees{})
+	reconcileAttendeesReplyID = network.RegisterMessage(ReconcileAttendeesReply{})
+	partyStatusID = network.RegisterMessage(PartyStatus{})
+	partyStatusReplyID = network.RegisterMessage(PartyStatusReply{})
+	propagateAttendeesID = network.RegisterMessage(PropagateAttendees{})
+	propagateAttendeesReplyID = network.RegisterMessage(PropagateAttendeesReply{})
 }
 
 // Service represents data needed for one pop-party.
@@ -82,6 +257,28 @@ type Service struct {
 	data *saveData
 	// propagate final message
 	Propagate messaging.PropagationFunc
+	// propagate the skipblocks of the final statements
+	PropagateSkipBlock messaging.PropagationFunc
+	// storage holds the saved data, the onet.Context unless set with
+	// SetStorage
+	storage Storage
+	// AllowEmpty permits to finalize a party without attendees. Only
+	// used for testing.
+	AllowEmpty bool
+	// tamperSignature, if set, replaces the BFT signatures of the final
+	// statements before they are checked. Only used for testing.
+	tamperSignature func(sig []byte) []byte
+	// options of the service, see Configure
+	config *Config
+	// the unused nonces returned by GetChallenge and their expiry
+	challenges    map[string]time.Time
+	challengeLock sync.Mutex
+	// serialises Backup and Restore
+	dumpLock sync.Mutex
+	// running finalizations and merges, see begin and Close
+	inflight  sync.WaitGroup
+	closing   bool
+	closeLock sync.Mutex
 }
 
 type saveData struct {
@@ -91,12 +288,220 @@ type saveData struct {
 	Public abstract.Point
 	// The final statements
 	Finals map[string]*FinalStatement
+	// Owners of the parties that have been transferred to another
+	// organizer, indexed like Finals. All other parties are owned by
+	// Public.
+	Owners map[string]*partyOwner
+	// Registration times of the attendees, indexed like Finals. They are
+	// not part of the final statements, so they are not signed. Only the
+	// attendees received from the organizer are recorded, and they are
+	// removed with the attendees of the party.
+	Registrations map[string]*registrations
+	// Signed results of the merges, indexed by mergeKey
+	MergeCache map[string]*mergeCache
+	// Attendees the organizer revoked after the finalization, indexed like
+	// Finals. They are removed once all conodes signed the statement
+	// without them.
+	Revocations map[string]*revocations
+	// Keys the attendees rotated before the finalization, indexed like
+	// Finals. They are applied to the attendees of every FinalizeRequest,
+	// so that an organizer sending the old keys doesn't undo them.
+	Rotations map[string]*rotations
+	// Latest skipblocks holding the final statements, indexed like
+	// Finals. Only used if Config.StoreOnSkipchain is set.
+	SkipBlocks map[string]skipchain.SkipBlockID
+	// Compressed attendees of the final statements, indexed like Finals.
+	// Only used in storage, the statements in memory are always complete.
+	Attendees map[string][]byte
 	// The meta info used in merge process
 	mergeMetas map[string]*mergeMeta
 	// Sync tools
 	syncMetas map[string]*syncMeta
 }
 
+// partyOwner holds the key of the organizer of a transferred party.
+type partyOwner struct {
+	Public abstract.Point
+}
+
+// registrations holds the unix times at which the attendees have been
+// received from the organizer, the same index in both slices.
+type registrations struct {
+	Attendees []abstract.Point
+	Times     []int64
+}
+
+// registeredAt returns the registration time of pub, or 0 if it is
+// unknown.
+func (r *registrations) registeredAt(pub abstract.Point) int64 {
+	for i, a := range r.Attendees {
+		if a.Equal(pub) {
+			return r.Times[i]
+		}
+	}
+	return 0
+}
+
+// keep removes the attendees that are not in atts.
+func (r *registrations) keep(atts []abstract.Point) error {
+	kept, err := attendeeSet(atts)
+	if err != nil {
+		return err
+	}
+	var regs registrations
+	for i, a := range r.Attendees {
+		key, err := attendeeKey(a)
+		if err != nil {
+			return err
+		}
+		if kept[key] {
+			regs.Attendees = append(regs.Attendees, a)
+			regs.Times = append(regs.Times, r.Times[i])
+		}
+	}
+	*r = regs
+	return nil
+}
+
+// add stores the current time for the attendees that are not known yet.
+func (r *registrations) add(atts []abstract.Point) error {
+	known, err := attendeeSet(r.Attendees)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, a := range atts {
+		key, err := attendeeKey(a)
+		if err != nil {
+			return err
+		}
+		if !known[key] {
+			known[key] = true
+			r.Attendees = append(r.Attendees, a)
+			r.Times = append(r.Times, now)
+		}
+	}
+	return nil
+}
+
+// revocations holds the attendees to remove from a finalized party.
+type revocations struct {
+	Attendees []abstract.Point
+}
+
+// rotations holds the keys the attendees rotated, Old[i] has been replaced
+// by New[i], in the order of the rotations.
+type rotations struct {
+	Old []abstract.Point
+	New []abstract.Point
+}
+
+// apply returns copies of atts and their weights where the rotated keys
+// are replaced by their new keys. An old key is dropped together with its
+// weight if its new key is in atts already.
+func (r *rotations) apply(atts []abstract.Point, weights []int) ([]abstract.Point, []int) {
+	res := make([]abstract.Point, len(atts))
+	copy(res, atts)
+	var ws []int
+	if len(weights) > 0 {
+		ws = make([]int, len(weights))
+		copy(ws, weights)
+	}
+	find := func(pub abstract.Point) int {
+		for i, a := range res {
+			if a.Equal(pub) {
+				return i
+			}
+		}
+		return -1
+	}
+	for i, old := range r.Old {
+		j := find(old)
+		if j < 0 {
+			continue
+		}
+		if find(r.New[i]) < 0 {
+			res[j] = r.New[i]
+			continue
+		}
+		res = append(res[:j], res[j+1:]...)
+		if j < len(ws) {
+			ws = append(ws[:j], ws[j+1:]...)
+		}
+	}
+	return res, ws
+}
+
+// mergeCache holds the signed result of a merge, which is returned again
+// for a merge of the same statements.
+type mergeCache struct {
+	// Hashes of the short descriptions of the merged parties
+	Parties [][]byte
+	// Hash of the local final statement before the merge
+	Local []byte
+	// The merged final statement
+	Final *FinalStatement
+}
+
+// mergeKey returns the key of the merge of the statements stmts of the
+// parties of desc in the MergeCache, and the hashes of the parties. The
+// key changes as soon as the statement of one of the parties changes, and
+// it doesn't depend on the order of the parties.
+func mergeKey(desc *PopDesc, stmts []*FinalStatement) (string, [][]byte, error) {
+	hashes := make([][]byte, len(desc.Parties))
+	for i, party := range desc.Parties {
+		hashes[i] = party.Hash()
+	}
+	finals := make([][]byte, len(stmts))
+	for i, f := range stmts {
+		var err error
+		if finals[i], err = f.Hash(); err != nil {
+			return "", nil, err
+		}
+	}
+	for _, l := range [][][]byte{hashes, finals} {
+		sort.Slice(l, func(i, j int) bool {
+			return bytes.Compare(l[i], l[j]) < 0
+		})
+	}
+	h := network.Suite.Hash()
+	h.Write([]byte(desc.Name))
+	h.Write([]byte(desc.DateTime))
+	for _, hash := range append(hashes, finals...) {
+		h.Write(hash)
+	}
+	return string(h.Sum(nil)), hashes, nil
+}
+
+// cachedMerge returns the merged statement of an earlier merge with the
+// same key, or nil if there is none or the local party changed since.
+func (s *Service) cachedMerge(key string, local []byte) *FinalStatement {
+	mc, ok := s.data.MergeCache[key]
+	if !ok {
+		return nil
+	}
+	if !bytes.Equal(local, mc.Local) || mc.Final == nil ||
+		mc.Final.Verify() != nil {
+		delete(s.data.MergeCache, key)
+		return nil
+	}
+	return mc.Final
+}
+
+// invalidateMerges removes the cached merges that include the party of
+// desc, because its final statement changed.
+func (s *Service) invalidateMerges(desc *PopDesc) {
+	hash := desc.shortDesc().Hash()
+	for key, mc := range s.data.MergeCache {
+		for _, p := range mc.Parties {
+			if bytes.Equal(p, hash) {
+				delete(s.data.MergeCache, key)
+				break
+			}
+		}
+	}
+}
+
 type mergeMeta struct {
 	// Map of final statements of parties that are going to be merged together
 	statementsMap map[string]*FinalStatement
@@ -116,8 +521,312 @@ type syncMeta struct {
 	ccChannel chan *CheckConfigReply
 	// channel to return the mergereply
 	mcChannel chan *MergeConfigReply
+	// channel to return the reconcile reply
+	raChannel chan *ReconcileAttendeesReply
+	// channel to return the replies to the propagated attendees
+	paChannel chan *PropagateAttendeesReply
 	// group waits responses after broadcast
 	mcGroup *sync.WaitGroup
+	// protects the counters below
+	sync.Mutex
+	// number of goroutines waiting on ccChannel and mcChannel
+	ccWaiting int
+	mcWaiting int
+	// number of replies mcGroup still waits for
+	mcPending int
+	// set if mcGroup has been released by a cancel
+	mcCancelled bool
+	// channels of the pending PartyStatus requests, indexed by
+	// psKey of the request ID and the asked conode
+	psPending map[string]chan *PartyStatusReply
+}
+
+func newSyncMeta() *syncMeta {
+	return &syncMeta{
+		ccChannel: make(chan *CheckConfigReply, 1),
+		mcChannel: make(chan *MergeConfigReply, 1),
+		raChannel: make(chan *ReconcileAttendeesReply, 1),
+		paChannel: make(chan *PropagateAttendeesReply, 1),
+		mcGroup:   &sync.WaitGroup{},
+		psPending: make(map[string]chan *PartyStatusReply),
+	}
+}
+
+// waitCheckConfig blocks until a CheckConfigReply or a cancel arrives.
+func (sm *syncMeta) waitCheckConfig() *CheckConfigReply {
+	sm.Lock()
+	sm.ccWaiting++
+	sm.Unlock()
+	rep := <-sm.ccChannel
+	sm.Lock()
+	sm.ccWaiting--
+	sm.Unlock()
+	return rep
+}
+
+// waitMergeConfig blocks until a MergeConfigReply, a cancel or the
+// timeout arrives. It returns false on timeout.
+func (sm *syncMeta) waitMergeConfig(timeout time.Duration) (*MergeConfigReply, bool) {
+	sm.Lock()
+	sm.mcWaiting++
+	sm.Unlock()
+	defer func() {
+		sm.Lock()
+		sm.mcWaiting--
+		sm.Unlock()
+	}()
+	select {
+	case mcr := <-sm.mcChannel:
+		return mcr, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// waitReconcile blocks until a ReconcileAttendeesReply or the timeout
+// arrives. It returns false on timeout.
+func (sm *syncMeta) waitReconcile(timeout time.Duration) (*ReconcileAttendeesReply, bool) {
+	select {
+	case rar := <-sm.raChannel:
+		return rar, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// waitAttendeeDelta blocks until a PropagateAttendeesReply or the timeout
+// arrives. It returns false on timeout.
+func (sm *syncMeta) waitAttendeeDelta(timeout time.Duration) (*PropagateAttendeesReply, bool) {
+	select {
+	case par := <-sm.paChannel:
+		return par, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// psKey returns the index of the PartyStatus request reqID sent to si.
+func psKey(reqID []byte, si *network.ServerIdentity) string {
+	return string(reqID) + si.ID.String()
+}
+
+// expectPartyStatus registers a channel for the reply of si to the
+// PartyStatus request reqID.
+func (sm *syncMeta) expectPartyStatus(reqID []byte, si *network.ServerIdentity) chan *PartyStatusReply {
+	ch := make(chan *PartyStatusReply, 1)
+	sm.Lock()
+	sm.psPending[psKey(reqID, si)] = ch
+	sm.Unlock()
+	return ch
+}
+
+// passPartyStatus hands the reply received from si to the request it
+// answers. It returns false if no request to si with that ID is pending.
+func (sm *syncMeta) passPartyStatus(psr *PartyStatusReply, si *network.ServerIdentity) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	key := psKey(psr.ReqID, si)
+	ch, ok := sm.psPending[key]
+	if !ok {
+		return false
+	}
+	delete(sm.psPending, key)
+	ch <- psr
+	return true
+}
+
+// waitPartyStatus blocks until the reply of si to the request reqID or the
+// timeout arrives. It returns false on timeout.
+func (sm *syncMeta) waitPartyStatus(reqID []byte, si *network.ServerIdentity,
+	ch chan *PartyStatusReply, timeout time.Duration) (*PartyStatusReply, bool) {
+	defer func() {
+		sm.Lock()
+		delete(sm.psPending, psKey(reqID, si))
+		sm.Unlock()
+	}()
+	select {
+	case psr := <-ch:
+		return psr, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// addMergeChecks announces n replies to wait for on mcGroup.
+func (sm *syncMeta) addMergeChecks(n int) {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.mcPending += n
+	sm.mcGroup.Add(n)
+}
+
+// doneMergeCheck marks one reply as received. Replies arriving after a
+// cancel are ignored.
+func (sm *syncMeta) doneMergeCheck() {
+	sm.Lock()
+	defer sm.Unlock()
+	if sm.mcPending > 0 {
+		sm.mcPending--
+		sm.mcGroup.Done()
+	}
+}
+
+// waitMergeChecks blocks until all announced replies arrived or a cancel.
+// It returns false if the wait has been cancelled.
+func (sm *syncMeta) waitMergeChecks() bool {
+	sm.mcGroup.Wait()
+	sm.Lock()
+	defer sm.Unlock()
+	cancelled := sm.mcCancelled
+	sm.mcCancelled = false
+	return !cancelled
+}
+
+// pendingChecks returns the number of replies mcGroup still waits for.
+func (sm *syncMeta) pendingChecks() int {
+	sm.Lock()
+	defer sm.Unlock()
+	return sm.mcPending
+}
+
+// paceMergeChecks calls send for the n MergeCheck messages, announcing
+// every reply on sm before. With a window > 0, at most window replies are
+// waited for at any time, and a random pause of up to jitter is done
+// while the window is full.
+func (sm *syncMeta) paceMergeChecks(n, window int, jitter time.Duration,
+	send func(i int) error) error {
+	for i := 0; i < n; i++ {
+		for window > 0 && sm.pendingChecks() >= window {
+			pause := time.Millisecond
+			if jitter > 0 {
+				pause += time.Duration(random.Int(big.NewInt(int64(jitter)),
+					random.Stream).Int64())
+			}
+			time.Sleep(pause)
+		}
+		sm.addMergeChecks(1)
+		if err := send(i); err != nil {
+			sm.doneMergeCheck()
+			return err
+		}
+	}
+	return nil
+}
+
+func (sm *syncMeta) pending() bool {
+	sm.Lock()
+	defer sm.Unlock()
+	return sm.ccWaiting > 0 || sm.mcWaiting > 0 || sm.mcPending > 0
+}
+
+// cancel unblocks all goroutines waiting on this syncMeta.
+func (sm *syncMeta) cancel() {
+	sm.Lock()
+	defer sm.Unlock()
+	for i := 0; i < sm.ccWaiting; i++ {
+		select {
+		case sm.ccChannel <- nil:
+		default:
+		}
+	}
+	for i := 0; i < sm.mcWaiting; i++ {
+		select {
+		case sm.mcChannel <- nil:
+		default:
+		}
+	}
+	if sm.mcPending > 0 {
+		sm.mcCancelled = true
+	}
+	for ; sm.mcPending > 0; sm.mcPending-- {
+		sm.mcGroup.Done()
+	}
+}
+
+// ListPending returns the hashes of the parties that have finalize or
+// merge operations waiting for replies of other conodes. The request has
+// to be signed by the organizer linked to the conode.
+func (s *Service) ListPending(req *ListPendingRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("ListPending: %s", s.Context.ServerIdentity())
+	if cerr := s.verifyOrganizer(req, req.Signature, req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	return &ListPendingReply{Pending: s.listPending()}, nil
+}
+
+// CancelPending unblocks all operations of the party in the request that
+// wait for replies of other conodes. The request has to be signed by the
+// organizer linked to the conode.
+func (s *Service) CancelPending(req *CancelPendingRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("CancelPending: %s %x", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.verifyOrganizer(req, req.Signature, req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	if err := s.cancelPending(req.ID); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	return &CancelPendingReply{}, nil
+}
+
+// verifyOrganizer returns an error if sig is not a signature of the hash
+// of req by the organizer linked to the conode, or if nonce is not a
+// challenge of the conode.
+func (s *Service) verifyOrganizer(req interface {
+	Hash() ([]byte, error)
+}, sig crypto.SchnorrSig, nonce []byte) onet.ClientError {
+	if s.data.Public == nil {
+		return onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, sig); err != nil {
+		return onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	return s.useChallenge(nonce)
+}
+
+// listPending returns the hashes of the parties that have finalize or
+// merge operations waiting for replies of other conodes.
+func (s *Service) listPending() [][]byte {
+	var hashes [][]byte
+	for hash, sm := range s.data.syncMetas {
+		if sm.pending() {
+			hashes = append(hashes, []byte(hash))
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+	return hashes
+}
+
+// cancelPending unblocks all operations of the party with the given hash
+// that wait for replies of other conodes. The waiting operations return
+// with an error.
+func (s *Service) cancelPending(hash []byte) error {
+	sm, ok := s.data.syncMetas[string(hash)]
+	if !ok {
+		return errors.New("No party found with this hash")
+	}
+	log.Lvlf2("%s cancels pending operations of %x", s.ServerIdentity(), hash)
+	sm.cancel()
+	return nil
+}
+
+// sortedStatements returns the statements to be merged, ordered by the
+// hashes of their descriptions, so that merging doesn't depend on the
+// order of the map.
+func (mm *mergeMeta) sortedStatements() []*FinalStatement {
+	stmts := make([]*FinalStatement, 0, len(mm.statementsMap))
+	for _, f := range mm.statementsMap {
+		stmts = append(stmts, f)
+	}
+	sortStatements(stmts)
+	return stmts
 }
 
 // PinRequest prints out a pin if none is given, else it verifies it has the
@@ -132,46 +841,51 @@ func (s *Service) PinRequest(req *PinRequest) (network.Message, onet.ClientError
 	if req.Pin != s.data.Pin {
 		return nil, onet.NewClientErrorCode(ErrorWrongPIN, "Wrong PIN")
 	}
+	reply := &PinReply{Conode: s.ServerIdentity(), Relink: s.data.Public != nil}
 	s.data.Public = req.Public
 	s.save()
 	log.Lvl1("Successfully registered PIN/Public", s.data.Pin, req.Public)
-	return nil, nil
+	return reply, nil
 }
 
 // StoreConfig saves the pop-config locally
 func (s *Service) StoreConfig(req *StoreConfig) (network.Message, onet.ClientError) {
-	log.Lvlf2("StoreConfig: %s %v %x", s.Context.ServerIdentity(), req.Desc, req.Desc.Hash())
-	if req.Desc.Roster == nil {
-		return nil, onet.NewClientErrorCode(ErrorInternal, "no roster set")
+	if cerr := checkStoreConfig(req.Desc); cerr != nil {
+		return nil, cerr
 	}
+	log.Lvlf2("StoreConfig: %s %v %x", s.Context.ServerIdentity(), req.Desc, req.Desc.Hash())
 	if s.data.Public == nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
 	}
 	hash := req.Desc.Hash()
-	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
+	// A party transferred to another organizer can only be stored again
+	// by the new one.
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(hash), hash, req.Signature); err != nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature"+err.Error())
 	}
-	s.data.Finals[string(hash)] = &FinalStatement{Desc: req.Desc, Signature: []byte{}}
-	s.data.syncMetas[string(hash)] = &syncMeta{
-		ccChannel: make(chan *CheckConfigReply, 1),
-		mcChannel: make(chan *MergeConfigReply, 1),
-		mcGroup:   &sync.WaitGroup{},
-	}
-	if len(req.Desc.Parties) > 0 {
-		meta := newmergeMeta()
-		s.data.mergeMetas[string(hash)] = meta
-		// party is merged with itself already
-		meta.statementsMap[string(hash)] = s.data.Finals[string(hash)]
-	}
+	s.storeConfig(req.Desc)
 	s.save()
 	return &StoreConfigReply{hash}, nil
 }
 
-// FinalizeRequest returns the FinalStatement if all conodes already received
-// a PopDesc and signed off. The FinalStatement holds the updated PopDesc, the
-// pruned attendees-public-key-list and the collective signature.
-func (s *Service) FinalizeRequest(req *FinalizeRequest) (network.Message, onet.ClientError) {
-	log.Lvlf2("Finalize: %s %+v", s.Context.ServerIdentity(), req)
+// StoreConfigs saves several pop-configs with one signature. If one of them
+// can't be stored, none is.
+func (s *Service) StoreConfigs(req *StoreConfigsRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("StoreConfigs: %s %d configs", s.Context.ServerIdentity(), len(req.Descs))
+	if len(req.Descs) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "no config given")
+	}
+	for i, desc := range req.Descs {
+		if desc == nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("config %d is empty", i+1))
+		}
+		if cerr := checkStoreConfig(desc); cerr != nil {
+			return nil, onet.NewClientErrorCode(cerr.ErrorCode(),
+				fmt.Sprintf("config %d: %s", i+1, cerr.ErrorMsg()))
+		}
+	}
 	if s.data.Public == nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
 	}
@@ -179,166 +893,1884 @@ func (s *Service) FinalizeRequest(req *FinalizeRequest) (network.Message, onet.C
 	if err != nil {
 		return nil, onet.NewClientError(err)
 	}
-	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
-		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature:"+err.Error())
-	}
-
-	var final *FinalStatement
-	var ok bool
-	if final, ok = s.data.Finals[string(req.DescID)]; !ok || final == nil || final.Desc == nil {
-		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	for i, desc := range req.Descs {
+		if err := crypto.VerifySchnorr(network.Suite, s.owner(desc.Hash()), hash, req.Signature); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("config %d: Invalid signature: %s", i+1, err))
+		}
 	}
-	if final.Verify() == nil {
-		log.Lvl2("Sending known final statement")
-		return &FinalizeResponse{final}, nil
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
 	}
-
-	// Contact all other nodes and ask them if they already have a config.
-	final.Attendees = make([]abstract.Point, len(req.Attendees))
-	copy(final.Attendees, req.Attendees)
-	cc := &CheckConfig{final.Desc.Hash(), req.Attendees}
-	for _, c := range final.Desc.Roster.List {
-		if !c.ID.Equal(s.ServerIdentity().ID) {
-			log.Lvl2("Contacting", c, cc.Attendees)
-			err := s.SendRaw(c, cc)
-			if err != nil {
-				return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
-			}
-			if syncData, ok := s.data.syncMetas[string(req.DescID)]; ok {
-				rep := <-syncData.ccChannel
-				if rep == nil {
-					return nil, onet.NewClientErrorCode(ErrorOtherFinals,
-						"Not all other conodes finalized yet")
-				}
-			}
+	for i, desc := range req.Descs {
+		if final, ok := s.data.Finals[string(desc.Hash())]; ok && len(final.Signature) > 0 {
+			return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+				fmt.Sprintf("config %d: party is already finalized", i+1))
 		}
 	}
-
-	// Create signature and propagate it
-	cerr := s.signAndPropagateFinal(final)
-	if cerr != nil {
-		return nil, cerr
+	reply := &StoreConfigsReply{}
+	for _, desc := range req.Descs {
+		s.storeConfig(desc)
+		reply.IDs = append(reply.IDs, desc.Hash())
 	}
-	return &FinalizeResponse{final}, nil
+	s.save()
+	return reply, nil
 }
 
-func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) bool {
-	final, err := NewFinalStatementFromToml(Data)
-	if err != nil {
-		log.Error(err.Error())
-		return false
+// checkStoreConfig returns an error if desc can't be stored.
+func checkStoreConfig(desc *PopDesc) onet.ClientError {
+	if desc == nil {
+		return onet.NewClientErrorCode(ErrorInternal, "no config given")
 	}
-	hash, err := final.Hash()
-	if err != nil {
-		log.Error(err.Error())
-		return false
+	if desc.Roster == nil {
+		return onet.NewClientErrorCode(ErrorInternal, "no roster set")
 	}
-	if !bytes.Equal(hash, Msg) {
-		log.Error("hash of received Final stmt and msg are not equal")
-		return false
+	if desc.VerifierRoster != nil && !validAggregate(desc.VerifierRoster) {
+		return onet.NewClientErrorCode(ErrorInternal,
+			"invalid verifier roster")
 	}
-	var fs *FinalStatement
-	var ok bool
-
-	if fs, ok = s.data.Finals[string(final.Desc.Hash())]; !ok {
-		log.Error("final Statement not found")
-		return false
+	if _, err := desc.newHash(); err != nil {
+		return onet.NewClientErrorCode(ErrorInternal, err.Error())
 	}
-
-	hash, err = fs.Hash()
-
-	if !bytes.Equal(hash, Msg) {
-		log.Error("hash of lccocal Final stmt and msg are not equal")
-		return false
+	if err := desc.ValidateParties(); err != nil {
+		return onet.NewClientErrorCode(ErrorInternal, err.Error())
 	}
-	return true
+	return nil
+}
+
+// storeConfig stores desc as a new party, replacing the party stored
+// with the same hash. The DateTime is stored in UTC like it is hashed.
+// The caller has to save the data.
+func (s *Service) storeConfig(desc *PopDesc) {
+	desc.DateTime = desc.utcDateTime()
+	hash := string(desc.Hash())
+	s.data.Finals[hash] = &FinalStatement{Desc: desc, Signature: []byte{}}
+	s.data.syncMetas[hash] = newSyncMeta()
+	if len(desc.Parties) > 0 && !s.config.DisableMerge {
+		meta := newmergeMeta()
+		s.data.mergeMetas[hash] = meta
+		// party is merged with itself already
+		meta.statementsMap[hash] = s.data.Finals[hash]
+	}
+}
+
+// TransferParty hands the party to another organizer. Afterwards only the
+// new key can finalize, merge or reopen the party on this conode, the
+// other parties are not affected.
+func (s *Service) TransferParty(req *TransferRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("TransferParty: %s %x", s.Context.ServerIdentity(), req.ID)
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if req.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No public key given")
+	}
+	if _, ok := s.data.Finals[string(req.ID)]; !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	s.data.Owners[string(req.ID)] = &partyOwner{req.Public}
+	s.save()
+	return nil, nil
+}
+
+// RotateAttendee replaces the key of an attendee by a new one before the
+// party is finalized. The attendee proves that it controls the old key,
+// the organizer asks for the rotation.
+func (s *Service) RotateAttendee(req *RotateAttendeeRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("RotateAttendee: %s %x", s.Context.ServerIdentity(), req.ID)
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if req.Old == nil || req.New == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No public key given")
+	}
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	msg, err := RotationMsg(req.ID, req.New)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, req.Old, msg, req.Proof); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid proof of the old key: "+err.Error())
+	}
+	if len(final.Signature) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is already finalized")
+	}
+	index := -1
+	for i, a := range final.Attendees {
+		if a.Equal(req.New) {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				"New key is already registered")
+		}
+		if a.Equal(req.Old) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Old key is not registered")
+	}
+	final.Attendees[index] = req.New
+	rot, ok := s.data.Rotations[string(req.ID)]
+	if !ok {
+		rot = &rotations{}
+		s.data.Rotations[string(req.ID)] = rot
+	}
+	rot.Old = append(rot.Old, req.Old)
+	rot.New = append(rot.New, req.New)
+	s.register(string(req.ID), []abstract.Point{req.New})
+	s.pruneRegistrations(string(req.ID), final.Attendees)
+	s.save()
+	return nil, nil
+}
+
+// RevokeAttendee removes an attendee from a finalized party, e.g. when a
+// fraud is discovered. The revocation stays pending until the organizers
+// of all conodes revoked the same attendees, then the statement without
+// them is signed again with the next epoch. The tokens of the revoked
+// attendees don't verify against the new statement.
+func (s *Service) RevokeAttendee(req *RevokeAttendeeRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("RevokeAttendee: %s %x", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if req.Attendee == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No public key given")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Party is not finalized yet")
+	}
+	rev, ok := s.data.Revocations[string(req.ID)]
+	if !ok {
+		rev = &revocations{}
+	}
+	attendee := []abstract.Point{req.Attendee}
+	added, err := subtractAttendees(attendee, rev.Attendees)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	if len(added) > 0 {
+		registered, err := intersectAttendees(final.Attendees, attendee)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		if len(registered) == 0 {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				"Attendee is not registered")
+		}
+		rev.Attendees = append(rev.Attendees, req.Attendee)
+		s.data.Revocations[string(req.ID)] = rev
+		s.save()
+	}
+
+	// The conodes only refuse to sign if most of them didn't revoke the
+	// attendees, so all of them are asked first.
+	syncData, ok := s.data.syncMetas[string(req.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	for _, c := range final.Desc.Roster.List {
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		psr, err := s.askPartyStatus(syncData, req.ID, c)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		if psr == nil {
+			return nil, onet.NewClientErrorCode(ErrorTimeout,
+				fmt.Sprintf("Conode %s didn't reply", c.Address))
+		}
+		same, err := sameAttendees(psr.Revoked, rev.Attendees)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		if !same {
+			return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+				fmt.Sprintf("Conode %s didn't revoke the same attendees yet",
+					c.Address))
+		}
+	}
+	next, err := s.revokedStatement(string(req.ID), final)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	if cerr := s.signAndPropagateFinal(next); cerr != nil {
+		return nil, cerr
+	}
+	return newFinalizeResponse(next), nil
+}
+
+// revokedStatement returns a copy of the finalized statement without the
+// revoked attendees and with the next epoch, or nil if no attendee is
+// revoked.
+func (s *Service) revokedStatement(hash string, final *FinalStatement) (
+	*FinalStatement, error) {
+	rev, ok := s.data.Revocations[hash]
+	if !ok || len(rev.Attendees) == 0 || len(final.Signature) == 0 {
+		return nil, nil
+	}
+	atts, err := subtractAttendees(final.Attendees, rev.Attendees)
+	if err != nil {
+		return nil, err
+	}
+	next := *final
+	if err := next.setAttendees(atts); err != nil {
+		return nil, err
+	}
+	next.Epoch++
+	next.Signature = []byte{}
+	return &next, nil
+}
+
+// GetChallenge returns a new nonce that has to be signed together with the
+// next request of the organizer. Every nonce can be used once, until it
+// expires. At most maxChallenges nonces are kept, the oldest one is
+// dropped for a new one.
+func (s *Service) GetChallenge(req *GetChallenge) (network.Message,
+	onet.ClientError) {
+	s.challengeLock.Lock()
+	defer s.challengeLock.Unlock()
+	if s.challenges == nil {
+		s.challenges = make(map[string]time.Time)
+	}
+	now := time.Now()
+	var oldest string
+	for nonce, end := range s.challenges {
+		if now.After(end) {
+			delete(s.challenges, nonce)
+		} else if oldest == "" || end.Before(s.challenges[oldest]) {
+			oldest = nonce
+		}
+	}
+	if len(s.challenges) >= maxChallenges {
+		delete(s.challenges, oldest)
+	}
+	nonce := random.Bytes(32, random.Stream)
+	s.challenges[string(nonce)] = now.Add(s.config.ChallengeTimeout)
+	return &GetChallengeReply{nonce}, nil
+}
+
+// useChallenge returns an error if the nonce has not been returned by
+// GetChallenge, has already been used or expired. On success the nonce is
+// dropped, so that it can be used only once. It has to be called after
+// verifying the signature over the nonce, else anybody could drop the
+// nonce.
+func (s *Service) useChallenge(nonce []byte) onet.ClientError {
+	s.challengeLock.Lock()
+	defer s.challengeLock.Unlock()
+	end, ok := s.challenges[string(nonce)]
+	if len(nonce) == 0 || !ok {
+		return onet.NewClientErrorCode(ErrorChallenge, "Invalid challenge")
+	}
+	delete(s.challenges, string(nonce))
+	if time.Now().After(end) {
+		return onet.NewClientErrorCode(ErrorChallenge, "Challenge expired")
+	}
+	return nil
+}
+
+// owner returns the public key of the organizer of the party with the
+// given hash.
+func (s *Service) owner(id []byte) abstract.Point {
+	if o, ok := s.data.Owners[string(id)]; ok {
+		return o.Public
+	}
+	return s.data.Public
+}
+
+// moveOwner gives the party stored under newHash, after a merge, the
+// organizer of the party stored under oldHash.
+func (s *Service) moveOwner(oldHash, newHash string) {
+	if o, ok := s.data.Owners[oldHash]; ok {
+		s.data.Owners[newHash] = o
+	}
+}
+
+// FinalizeRequest returns the FinalStatement if all conodes already received
+// a PopDesc and signed off. The FinalStatement holds the updated PopDesc, the
+// pruned attendees-public-key-list and the collective signature.
+func (s *Service) FinalizeRequest(req *FinalizeRequest) (network.Message, onet.ClientError) {
+	log.Lvlf2("Finalize: %s %+v", s.Context.ServerIdentity(), req)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.DescID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature:"+err.Error())
+	}
+
+	var final *FinalStatement
+	var ok bool
+	if final, ok = s.data.Finals[string(req.DescID)]; !ok || final == nil || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	// Finalizing again would check the configs over the merged roster and
+	// replace the merged attendees.
+	if final.Merged {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Party is already merged - use merge or repropagate instead of finalize")
+	}
+	if final.Verify() == nil {
+		log.Lvl2("Sending known final statement")
+		if s.config.StoreOnSkipchain &&
+			verifySkipBlock(final, s.data.SkipBlocks[string(req.DescID)]) != nil {
+			if cerr := s.storeAndPropagateSkipBlock(final); cerr != nil {
+				return nil, cerr
+			}
+		}
+		return s.finalizeResponse(final), nil
+	}
+	if rot, ok := s.data.Rotations[string(req.DescID)]; ok {
+		req.Attendees, req.Weights = rot.apply(req.Attendees, req.Weights)
+	}
+	if len(req.Attendees) == 0 && !s.AllowEmpty {
+		return nil, onet.NewClientErrorCode(ErrorNoAttendees,
+			"Can't finalize a party without attendees")
+	}
+	// The minimum of the party can only be raised by the request
+	min := final.Desc.MinAttendees
+	if req.MinAttendees > min {
+		min = req.MinAttendees
+	}
+	if len(req.Attendees) < min {
+		return nil, onet.NewClientErrorCode(ErrorTooFewAttendees,
+			fmt.Sprintf("Got %d attendees, at least %d required",
+				len(req.Attendees), min))
+	}
+	if len(req.Weights) > 0 && len(req.Weights) != len(req.Attendees) {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			fmt.Sprintf("Got %d weights for %d attendees",
+				len(req.Weights), len(req.Attendees)))
+	}
+	for _, w := range req.Weights {
+		if w <= 0 {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				"Weights must be positive")
+		}
+	}
+
+	// Contact all other nodes and ask them if they already have a config.
+	// A first round only asks for their status, so that the attendees of
+	// the other nodes are not pruned if one of them can't finalize.
+	final.Attendees = make([]abstract.Point, len(req.Attendees))
+	copy(final.Attendees, req.Attendees)
+	final.Weights = nil
+	if len(req.Weights) > 0 {
+		final.Weights = make([]int, len(req.Weights))
+		copy(final.Weights, req.Weights)
+	}
+	if err := final.setAttendees(final.Attendees); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	s.register(string(req.DescID), req.Attendees)
+	cc := &CheckConfig{final.Desc.Hash(), req.Attendees, final.Desc, req.Strict,
+		true, req.Weights}
+	kept, cerr := s.checkConfigs(final, cc)
+	if cerr != nil {
+		return nil, cerr
+	}
+	// Check the minimum before the other conodes prune their attendees.
+	if len(kept) < min {
+		return nil, onet.NewClientErrorCode(ErrorTooFewAttendees,
+			fmt.Sprintf("Only %d attendees are common to all conodes, at least %d required",
+				len(kept), min))
+	}
+	cc.DryRun = false
+	if _, cerr := s.checkConfigs(final, cc); cerr != nil {
+		return nil, cerr
+	}
+
+	// Create signature and propagate it
+	cerr = s.signAndPropagateFinal(final)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return s.finalizeResponse(final), nil
+}
+
+// PreviewFinalize asks the other conodes for their attendees like
+// FinalizeRequest, but only returns the attendees that would be kept and
+// the ones that would be dropped. Nothing is stored, neither here nor on
+// the other conodes.
+func (s *Service) PreviewFinalize(req *PreviewFinalizeRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("PreviewFinalize: %s %x", s.Context.ServerIdentity(), req.DescID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.DescID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature:"+err.Error())
+	}
+	final, ok := s.data.Finals[string(req.DescID)]
+	if !ok || final == nil || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is already finalized")
+	}
+	syncData, ok := s.data.syncMetas[string(req.DescID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+
+	atts := append([]abstract.Point{}, req.Attendees...)
+	cc := &CheckConfig{final.Desc.Hash(), req.Attendees, final.Desc, req.Strict,
+		true, nil}
+	for _, c := range final.Desc.Roster.List {
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		if err := s.SendRaw(c, cc); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		rep := syncData.waitCheckConfig()
+		if cerr := checkConfigError(c, cc, rep); cerr != nil {
+			return nil, cerr
+		}
+		if atts, err = intersectAttendees(atts, rep.Attendees); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+	}
+	dropped, err := subtractAttendees(req.Attendees, atts)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	return &PreviewFinalizeReply{atts, dropped}, nil
+}
+
+// checkConfigs sends cc to all other nodes of the party, one after the
+// other, and stops at the first node that is not ready to finalize. It
+// returns the attendees of cc that all nodes have in common.
+func (s *Service) checkConfigs(final *FinalStatement, cc *CheckConfig) (
+	[]abstract.Point, onet.ClientError) {
+	atts := cc.Attendees
+	for _, c := range final.Desc.Roster.List {
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		log.Lvl2("Contacting", c, cc.Attendees)
+		err := s.SendRaw(c, cc)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		if syncData, ok := s.data.syncMetas[string(cc.PopHash)]; ok {
+			rep := syncData.waitCheckConfig()
+			if cerr := checkConfigError(c, cc, rep); cerr != nil {
+				return nil, cerr
+			}
+			if atts, err = intersectAttendees(atts, rep.Attendees); err != nil {
+				return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+			}
+		}
+	}
+	// Every conode can share attendees with us, but none with all
+	if len(atts) == 0 && len(cc.Attendees) > 0 && !s.AllowEmpty {
+		return nil, onet.NewClientErrorCode(ErrorNoCommonAttendees,
+			"The conodes have no attendee in common")
+	}
+	return atts, nil
+}
+
+// checkConfigError returns the error corresponding to the reply of the
+// conode c to cc, or nil if the conode is ready to finalize.
+func checkConfigError(c *network.ServerIdentity, cc *CheckConfig,
+	rep *CheckConfigReply) onet.ClientError {
+	if rep == nil {
+		return onet.NewClientErrorCode(ErrorOtherFinals,
+			fmt.Sprintf("Not all other conodes finalized yet: no reply from %s",
+				c.Address))
+	}
+	switch rep.PopStatus {
+	case PopStatusOK:
+		return nil
+	case PopStatusWrongRoster:
+		return onet.NewClientErrorCode(ErrorWrongRoster,
+			fmt.Sprintf("Conode %s stored the party with a different roster",
+				c.Address))
+	case PopStatusWrongHash:
+		return onet.NewClientErrorCode(ErrorOtherFinals,
+			fmt.Sprintf("Conode %s has no party with hash %x", c.Address,
+				cc.PopHash))
+	case PopStatusNoConfig:
+		return onet.NewClientErrorCode(ErrorOtherFinals,
+			fmt.Sprintf("Conode %s has no party stored", c.Address))
+	case PopStatusAttendeesMismatch:
+		here, err := subtractAttendees(cc.Attendees, rep.Attendees)
+		if err != nil {
+			return onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		there, err := subtractAttendees(rep.Attendees, cc.Attendees)
+		if err != nil {
+			return onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		return onet.NewClientErrorCode(ErrorAttendeesMismatch,
+			fmt.Sprintf("Conode %s has different attendees - only here: %v, only there: %v",
+				c.Address, here, there))
+	case PopStatusWeightsMismatch:
+		return onet.NewClientErrorCode(ErrorAttendeesMismatch,
+			fmt.Sprintf("Conode %s has different weights for the attendees",
+				c.Address))
+	case PopStatusNoCommonAttendees:
+		return onet.NewClientErrorCode(ErrorNoCommonAttendees,
+			fmt.Sprintf("Conode %s has none of the attendees", c.Address))
+	}
+	return onet.NewClientErrorCode(ErrorOtherFinals,
+		fmt.Sprintf("Not all other conodes finalized yet: %s replied with status %d",
+			c.Address, rep.PopStatus))
+}
+
+func (s *Service) bftVerifyFinal(Msg []byte, Data []byte) bool {
+	return s.verifyLocalStatement(Msg, Data)
+}
+
+// verifyLocalStatement returns true if Data holds a final statement with
+// the hash Msg, and the party of this final statement is stored here with
+// the same hash. It never panics on a malformed or unknown statement, as
+// anybody in the roster can start the signing.
+func (s *Service) verifyLocalStatement(Msg []byte, Data []byte) bool {
+	fs, err := NewFinalStatementFromToml(Data)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if fs.Desc.Roster == nil {
+		log.Error("Received final statement has no roster")
+		return false
+	}
+	hashReceived, err := fs.Hash()
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if !bytes.Equal(Msg, hashReceived) {
+		log.Error("Msg to sign differs from data hash")
+		return false
+	}
+	if len(fs.Attendees) < fs.Desc.MinAttendees {
+		log.Errorf("Asked to sign party %s at %s with %d attendees, at least %d required",
+			fs.Desc.Name, fs.Desc.Location, len(fs.Attendees), fs.Desc.MinAttendees)
+		return false
+	}
+
+	// searching for local party
+	localFinal, ok := s.data.Finals[string(fs.Desc.Hash())]
+	if !ok || localFinal == nil || localFinal.Desc == nil ||
+		localFinal.Desc.Roster == nil {
+		log.Errorf("Asked to sign party %s at %s, which is not stored here",
+			fs.Desc.Name, fs.Desc.Location)
+		return false
+	}
+	hashLocal, err := localFinal.Hash()
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if bytes.Equal(hashLocal, hashReceived) {
+		return true
+	}
+	// The statement without the attendees revoked here is signed as well
+	next, err := s.revokedStatement(string(fs.Desc.Hash()), localFinal)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+	if next != nil {
+		hashNext, err := next.Hash()
+		if err == nil && bytes.Equal(hashNext, hashReceived) {
+			return true
+		}
+	}
+	log.Error("hashes of local and sent finalStatements are not equal")
+	return false
+}
+
+//signs FinalStatement with BFTCosi and Propagates signature to other nodes
+func (s *Service) signAndPropagateFinal(final *FinalStatement) onet.ClientError {
+	tree := final.Desc.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
+	if tree == nil {
+		return onet.NewClientErrorCode(ErrorInternal,
+			"Root does not exist")
+	}
+	node, err := s.CreateProtocol(bftSignMerge, tree)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+
+	// Register the function generating the protocol instance
+	root, ok := node.(*bftcosi.ProtocolBFTCoSi)
+	if !ok {
+		return onet.NewClientErrorCode(ErrorInternal,
+			"protocol instance is invalid")
+	}
+
+	final.FinalizedAt = time.Now().Unix()
+	root.Msg, err = final.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+
+	root.Data, err = final.ToToml()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+
+	final.Signature = []byte{}
+	signature := make(chan []byte)
+	root.RegisterOnSignatureDone(func(sig *bftcosi.BFTSignature) {
+		if s.tamperSignature != nil {
+			signature <- s.tamperSignature(sig.Sig)
+		} else {
+			signature <- sig.Sig
+		}
+	})
+
+	go node.Start()
+
+	var sig []byte
+	select {
+	case sig = <-signature:
+		break
+	case <-time.After(TIMEOUT):
+		log.Error("signing failed on timeout")
+		return onet.NewClientErrorCode(ErrorTimeout,
+			"signing timeout")
+	}
+	if len(sig) < 64 {
+		log.Errorf("BFT signature of %d bytes", len(sig))
+		return onet.NewClientErrorCode(ErrorInternal,
+			"BFT produced invalid signature")
+	}
+	final.Signature = sig[:64]
+	if err := final.Verify(); err != nil {
+		return onet.NewClientErrorCode(ErrorOtherFinals,
+			"Not all conodes signed the statement")
+	}
+	return s.propagateFinal(final)
+}
+
+// propagateFinal sends the signed final statement to all conodes of the
+// party and stores it on the skipchain if asked to.
+func (s *Service) propagateFinal(final *FinalStatement) onet.ClientError {
+	roster := propagationRoster(final.Desc)
+	replies, err := s.Propagate(roster, final, 10000)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	if replies != len(roster.List) {
+		log.Warn("Did only get", replies)
+	}
+	s.save()
+	// The skipchain is only written once all conodes have the signed
+	// statement. If it fails, a repeated FinalizeRequest retries it.
+	if s.config.StoreOnSkipchain {
+		return s.storeAndPropagateSkipBlock(final)
+	}
+	return nil
+}
+
+// storeAndPropagateSkipBlock stores the signed final statement on the
+// skipchain and sends the reference of the block to all conodes of the
+// party, so that each of them returns it with the statement.
+func (s *Service) storeAndPropagateSkipBlock(final *FinalStatement) onet.ClientError {
+	if cerr := s.storeSkipBlock(final); cerr != nil {
+		return cerr
+	}
+	s.save()
+	popHash := final.Desc.Hash()
+	ref := &SkipBlockRef{PopHash: popHash, ID: s.data.SkipBlocks[string(popHash)]}
+	roster := propagationRoster(final.Desc)
+	replies, err := s.PropagateSkipBlock(roster, ref, 10000)
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	if replies != len(roster.List) {
+		log.Warn("Did only get", replies)
+	}
+	return nil
+}
+
+// StoreSkipBlockRef stores the skipblock of a final statement, sent by the
+// conode that wrote it. The block is only stored if it holds the final
+// statement stored here.
+func (s *Service) StoreSkipBlockRef(msg network.Message) {
+	ref, ok := msg.(*SkipBlockRef)
+	if !ok {
+		log.Error("Couldn't convert to a SkipBlockRef")
+		return
+	}
+	final, ok := s.data.Finals[string(ref.PopHash)]
+	if !ok || final.Desc == nil || len(final.Signature) == 0 {
+		log.Errorf("%s: no signed final statement for %x", s.ServerIdentity(), ref.PopHash)
+		return
+	}
+	if bytes.Equal(s.data.SkipBlocks[string(ref.PopHash)], ref.ID) {
+		return
+	}
+	if cerr := verifySkipBlock(final, ref.ID); cerr != nil {
+		log.Error(cerr)
+		return
+	}
+	s.data.SkipBlocks[string(ref.PopHash)] = ref.ID
+	s.save()
+}
+
+// storeSkipBlock appends the hash of the signed final statement to the
+// skipchain of the party, or creates the skipchain with the roster of the
+// party if it's the first statement of the party.
+func (s *Service) storeSkipBlock(final *FinalStatement) onet.ClientError {
+	hash, err := final.Hash()
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	popHash := final.Desc.Hash()
+	entry := &SkipchainEntry{PopHash: popHash, Hash: hash}
+	client := skipchain.NewClient()
+	latest, ok := s.data.SkipBlocks[string(popHash)]
+	if !ok {
+		genesis, cerr := client.CreateGenesis(final.Desc.Roster, 1, 1,
+			skipchain.VerificationNone, entry, nil)
+		if cerr != nil {
+			return cerr
+		}
+		s.data.SkipBlocks[string(popHash)] = genesis.Hash
+		return nil
+	}
+	sb, cerr := client.GetSingleBlock(final.Desc.Roster, latest)
+	if cerr != nil {
+		return cerr
+	}
+	reply, cerr := client.StoreSkipBlock(sb, nil, entry)
+	if cerr != nil {
+		return cerr
+	}
+	s.data.SkipBlocks[string(popHash)] = reply.Latest.Hash
+	return nil
+}
+
+// finalizeResponse returns the response for the final statement with the
+// skipblock holding it, if any.
+func (s *Service) finalizeResponse(final *FinalStatement) *FinalizeResponse {
+	res := newFinalizeResponse(final)
+	if final.Desc != nil {
+		res.SkipBlockID = s.data.SkipBlocks[string(final.Desc.Hash())]
+	}
+	return res
+}
+
+// Repropagate sends the signed final statement again to all conodes of the
+// party, for the ones that missed the propagation after the signing.
+func (s *Service) Repropagate(req *RepropagateRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("Repropagate: %s %x", s.Context.ServerIdentity(), req.ID)
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), req.Hash(), req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) <= 0 || final.Verify() != nil {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is not finalized")
+	}
+	roster := propagationRoster(final.Desc)
+	replies, err := s.Propagate(roster, final, 10000)
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if replies != len(roster.List) {
+		log.Warn("Did only get", replies)
+	}
+	return &RepropagateReply{replies}, nil
+}
+
+// propagationRoster returns the conodes receiving the final statement of
+// the party: its roster and the verifiers, if any.
+func propagationRoster(desc *PopDesc) *onet.Roster {
+	if desc.VerifierRoster == nil {
+		return desc.Roster
+	}
+	return unionRoster(desc.Roster, desc.VerifierRoster)
+}
+
+// Reconcile unites the attendees registered on all conodes of a party
+// before it is finalized, so that an attendee registered only on some
+// conodes doesn't get pruned by the finalization. Contrary to a merge,
+// which unites the final statements of different parties, it only
+// touches the draft attendees of one party.
+func (s *Service) Reconcile(req *ReconcileRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("Reconcile: %s %x", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), req.Hash(), req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is already finalized")
+	}
+	syncData, ok := s.data.syncMetas[string(req.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+
+	// Without attendees the request only collects the attendees of all
+	// conodes. The organizer then signs their union, which is sent to
+	// all conodes.
+	collect := len(req.Attendees) == 0
+	ra := &ReconcileAttendees{PopHash: req.ID}
+	atts := final.Attendees
+	if !collect {
+		ra.Attendees = req.Attendees
+		ra.Nonce = req.Nonce
+		ra.Signature = req.Signature
+	}
+	for _, c := range final.Desc.Roster.List {
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		err := s.SendRaw(c, ra)
+		if err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+		rep, ok := syncData.waitReconcile(TIMEOUT)
+		if !ok || rep == nil {
+			return nil, onet.NewClientErrorCode(ErrorTimeout,
+				fmt.Sprintf("Conode %s didn't reply", c.Address))
+		}
+		if !statusOK(rep.PopStatus) {
+			return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+				fmt.Sprintf("Conode %s can't reconcile: status %d",
+					c.Address, rep.PopStatus))
+		}
+		if atts, err = appendAttendees(atts, rep.Attendees); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+		}
+	}
+	if collect {
+		return &ReconcileReply{len(atts), atts}, nil
+	}
+	if err := final.addAttendees(req.Attendees); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	s.register(string(req.ID), req.Attendees)
+	s.save()
+	return &ReconcileReply{NumAttendees: len(final.Attendees)}, nil
+}
+
+// ReconcileAttendees adds the received attendees to the draft of the party
+// and sends back all attendees of the draft. The attendees are only added
+// if the sender is a conode of the party and the organizer of the party
+// signed them.
+func (s *Service) ReconcileAttendees(req *network.Envelope) {
+	ra, ok := req.Msg.(*ReconcileAttendees)
+	if !ok {
+		log.Errorf("Didn't get a ReconcileAttendees: %#v", req.Msg)
+		return
+	}
+	if s.oversized(req) {
+		return
+	}
+	rar := &ReconcileAttendeesReply{PopStatusWrongHash, ra.PopHash, nil}
+	final, ok := s.data.Finals[string(ra.PopHash)]
+	if !ok || final.Desc == nil {
+		log.Error("No party with given hash")
+	} else if i, _ := final.Desc.Roster.Search(req.ServerIdentity.ID); i < 0 {
+		log.Errorf("%s is not a conode of the party", req.ServerIdentity)
+		rar.PopStatus = PopStatusWrongRoster
+	} else if len(final.Signature) > 0 {
+		rar.PopStatus = PopStatusFinalized
+	} else if len(ra.Attendees) == 0 {
+		rar.PopStatus = PopStatusOK
+		rar.Attendees = final.Attendees
+	} else if err := crypto.VerifySchnorr(network.Suite, s.owner(ra.PopHash),
+		ra.requestHash(), ra.Signature); err != nil {
+		log.Error("Attendees not signed by the organizer:", err)
+		rar.PopStatus = PopStatusBadSignature
+	} else if err := final.addAttendees(ra.Attendees); err != nil {
+		log.Error("Couldn't add the attendees:", err)
+		rar.PopStatus = PopStatusAttendeesMismatch
+	} else {
+		s.register(string(ra.PopHash), ra.Attendees)
+		s.save()
+		rar.PopStatus = PopStatusOK
+		rar.Attendees = final.Attendees
+	}
+	if err := s.SendRaw(req.ServerIdentity, rar); err != nil {
+		log.Error("Couldn't send reply:", err)
+	}
+}
+
+// ReconcileAttendeesReply passes the reply to the waiting Reconcile.
+func (s *Service) ReconcileAttendeesReply(req *network.Envelope) {
+	if s.oversized(req) {
+		return
+	}
+	rar, ok := req.Msg.(*ReconcileAttendeesReply)
+	if !ok {
+		log.Errorf("Didn't get a ReconcileAttendeesReply: %v", req.Msg)
+		return
+	}
+	syncData, ok := s.data.syncMetas[string(rar.PopHash)]
+	if !ok {
+		log.Error("No hash for syncMeta found")
+		return
+	}
+	if len(syncData.raChannel) == 0 {
+		syncData.raChannel <- rar
+	}
+}
+
+// RegisterAttendees adds the attendees to the draft of a party that is not
+// finalized yet. With Propagate, the new attendees are sent right away
+// to all conodes of the party, so that they hold them all when the party
+// is finalized and none of them gets pruned.
+func (s *Service) RegisterAttendees(req *RegisterAttendeesRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("RegisterAttendees: %s %x", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) > 0 {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Party is already finalized")
+	}
+	added, err := final.newAttendees(req.Attendees)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	if err := final.addAttendees(added); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	s.register(string(req.ID), added)
+	s.save()
+	reply := &RegisterAttendeesReply{NumAttendees: len(final.Attendees)}
+	if !req.Propagate || len(added) == 0 {
+		return reply, nil
+	}
+	reply.NumConodes = s.propagateAttendees(final,
+		&PropagateAttendees{req.ID, req.Attendees})
+	if reply.NumConodes != len(final.Desc.Roster.List) {
+		log.Warn("Did only get", reply.NumConodes)
+	}
+	return reply, nil
+}
+
+// propagateAttendees sends the attendees to the other conodes of the party,
+// one after the other. It returns the number of conodes holding them,
+// including this one.
+func (s *Service) propagateAttendees(final *FinalStatement,
+	ad *PropagateAttendees) int {
+	syncData, ok := s.data.syncMetas[string(ad.PopHash)]
+	if !ok {
+		log.Error("No hash for syncMeta found")
+		return 1
+	}
+	stored := 1
+	for _, c := range final.Desc.Roster.List {
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			continue
+		}
+		if err := s.SendRaw(c, ad); err != nil {
+			log.Error("Couldn't send the attendees:", err)
+			continue
+		}
+		rep, ok := syncData.waitAttendeeDelta(TIMEOUT)
+		if !ok || rep == nil {
+			log.Errorf("Conode %s didn't reply", c.Address)
+			continue
+		}
+		if !statusOK(rep.PopStatus) {
+			log.Errorf("Conode %s didn't store the attendees: status %d",
+				c.Address, rep.PopStatus)
+			continue
+		}
+		stored++
+	}
+	return stored
+}
+
+// StoreAttendeeDelta adds the propagated attendees to the draft of the
+// party, if the sender is a conode of the party. Unlike PropagateFinal it
+// never replaces the stored statement, so duplicated or reordered deltas
+// are harmless: known attendees are skipped, and the delta is refused if
+// the party is unknown or already finalized, which keeps its signature
+// valid.
+func (s *Service) StoreAttendeeDelta(req *network.Envelope) {
+	ad, ok := req.Msg.(*PropagateAttendees)
+	if !ok {
+		log.Errorf("Didn't get a PropagateAttendees: %#v", req.Msg)
+		return
+	}
+	if s.oversized(req) {
+		return
+	}
+	par := &PropagateAttendeesReply{PopStatusWrongHash, ad.PopHash}
+	final, ok := s.data.Finals[string(ad.PopHash)]
+	if !ok || final.Desc == nil {
+		log.Error("No party with given hash")
+	} else if i, _ := final.Desc.Roster.Search(req.ServerIdentity.ID); i < 0 {
+		log.Errorf("%s is not a conode of the party", req.ServerIdentity)
+		par.PopStatus = PopStatusWrongRoster
+	} else if len(final.Signature) > 0 {
+		log.Lvl2("Party is already finalized")
+		par.PopStatus = PopStatusFinalized
+	} else if added, err := final.newAttendees(ad.Attendees); err != nil {
+		log.Error("Couldn't add the attendees:", err)
+		par.PopStatus = PopStatusAttendeesMismatch
+	} else if err := final.addAttendees(added); err != nil {
+		log.Error("Couldn't add the attendees:", err)
+		par.PopStatus = PopStatusAttendeesMismatch
+	} else {
+		if len(added) > 0 {
+			s.register(string(ad.PopHash), added)
+			s.save()
+			log.Lvlf2("%s Stored %d new attendees", s.ServerIdentity(),
+				len(added))
+		}
+		par.PopStatus = PopStatusOK
+	}
+	if err := s.SendRaw(req.ServerIdentity, par); err != nil {
+		log.Error("Couldn't send reply:", err)
+	}
+}
+
+// StoreAttendeeDeltaReply passes the reply to the waiting
+// RegisterAttendees.
+func (s *Service) StoreAttendeeDeltaReply(req *network.Envelope) {
+	par, ok := req.Msg.(*PropagateAttendeesReply)
+	if !ok {
+		log.Errorf("Didn't get a PropagateAttendeesReply: %v", req.Msg)
+		return
+	}
+	syncData, ok := s.data.syncMetas[string(par.PopHash)]
+	if !ok {
+		log.Error("No hash for syncMeta found")
+		return
+	}
+	if len(syncData.paChannel) == 0 {
+		syncData.paChannel <- par
+	}
+}
+
+// PropagateFinal saves the new final statement
+func (s *Service) PropagateFinal(msg network.Message) {
+	fs, ok := msg.(*FinalStatement)
+	if !ok {
+		log.Error("Couldn't convert to a FinalStatement")
+		return
+	}
+	if err := fs.Verify(); err != nil {
+		log.Error(err)
+		return
+	}
+	final, ok := s.data.Finals[string(fs.Desc.Hash())]
+	if !ok {
+		// Only the verifiers get the statement without the config,
+		// the other parties are stored by their organizers.
+		if !hasConode(fs.Desc.VerifierRoster, s.ServerIdentity().Public) {
+			log.Lvl2(s.ServerIdentity(), "Ignoring final statement of unknown party")
+			return
+		}
+		final = &FinalStatement{}
+		s.data.Finals[string(fs.Desc.Hash())] = final
+	}
+	if fs.Epoch < final.Epoch {
+		log.Errorf("Refusing statement of epoch %d, stored one has epoch %d",
+			fs.Epoch, final.Epoch)
+		return
+	}
+	if fs.Epoch > final.Epoch {
+		delete(s.data.Revocations, string(fs.Desc.Hash()))
+	}
+	// Keep the parties as they are stored here, compact or with rosters.
+	// They don't change the hash.
+	desc := *fs.Desc
+	if final.Desc != nil {
+		desc.Parties = final.Desc.Parties
+	}
+	*final = *fs
+	final.Desc = &desc
+	s.pruneRegistrations(string(fs.Desc.Hash()), final.Attendees)
+	if !final.Merged {
+		s.invalidateMerges(final.Desc)
+	}
+	s.save()
+	log.Lvlf2("%s Stored final statement %v", s.ServerIdentity(), fs)
+}
+
+// FetchFinal returns FinalStatement by hash
+// used after Finalization
+func (s *Service) FetchFinal(req *FetchRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("FetchFinal: %s %v", s.Context.ServerIdentity(), req.ID)
+	var fs *FinalStatement
+	var ok bool
+	if fs, ok = s.data.Finals[string(req.ID)]; !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"No config found")
+	}
+	if len(fs.Signature) <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
+			"Not all other conodes finalized yet")
+	}
+	if fs.Desc != nil && fs.Desc.Expired() {
+		return nil, onet.NewClientErrorCode(ErrorExpired, "Party expired")
+	}
+	return newFinalizeResponse(fs), nil
 }
 
-//signs FinalStatement with BFTCosi and Propagates signature to other nodes
-func (s *Service) signAndPropagateFinal(final *FinalStatement) onet.ClientError {
-	tree := final.Desc.Roster.GenerateNaryTreeWithRoot(2, s.ServerIdentity())
-	if tree == nil {
-		return onet.NewClientErrorCode(ErrorInternal,
-			"Root does not exist")
+// FetchFinalHeader returns the final statement of a finalized party like
+// FetchFinal, but without the attendees.
+func (s *Service) FetchFinalHeader(req *FetchHeaderRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("FetchFinalHeader: %s %x", s.Context.ServerIdentity(), req.ID)
+	msg, cerr := s.FetchFinal(&FetchRequest{req.ID})
+	if cerr != nil {
+		return nil, cerr
 	}
-	node, err := s.CreateProtocol(bftSignMerge, tree)
-	if err != nil {
-		return onet.NewClientError(err)
+	fs := msg.(*FinalizeResponse).Final
+	return &FinalHeader{
+		Desc:        fs.Desc,
+		Signature:   fs.Signature,
+		Merged:      fs.Merged,
+		FinalizedAt: fs.FinalizedAt,
+		Weights:     fs.Weights,
+		Origins:     fs.Origins,
+		Epoch:       fs.Epoch,
+	}, nil
+}
+
+// HasConfig returns whether the party is stored on this conode and whether
+// it is finalized. It must not change the stored party, so that anybody
+// can call it.
+func (s *Service) HasConfig(req *HasConfigRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("HasConfig: %s %x", s.Context.ServerIdentity(), req.ID)
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok {
+		return &HasConfigReply{}, nil
+	}
+	return &HasConfigReply{Exists: true,
+		Finalized: len(final.Signature) > 0}, nil
+}
+
+// FinalizeStatus returns the state of the party on every conode of its
+// roster. If a finalization fails because not all conodes finalized yet,
+// it shows the conodes whose organizer didn't send the attendees.
+func (s *Service) FinalizeStatus(req *FinalizeStatusRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("FinalizeStatus: %s %x", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	syncData, ok := s.data.syncMetas[string(req.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	reply := &FinalizeStatusReply{}
+	for _, c := range final.Desc.Roster.List {
+		cs := ConodeStatus{Conode: c}
+		if c.ID.Equal(s.ServerIdentity().ID) {
+			cs = ConodeStatus{c, true, true, len(final.Attendees),
+				len(final.Signature) > 0}
+		} else if rep, err := s.askPartyStatus(syncData, req.ID, c); err != nil {
+			log.Lvl2("Couldn't reach", c, err)
+		} else if rep != nil {
+			cs = ConodeStatus{c, true, rep.Exists, rep.NumAttendees,
+				rep.Finalized}
+		}
+		reply.Conodes = append(reply.Conodes, cs)
 	}
+	return reply, nil
+}
 
-	// Register the function generating the protocol instance
-	root, ok := node.(*bftcosi.ProtocolBFTCoSi)
+// askPartyStatus sends a PartyStatus with a fresh request ID to c and
+// waits for the reply of c to it. Every call waits on its own channel, so
+// concurrent FinalizeStatus and RevokeAttendee requests don't take each
+// other's replies. The reply is nil if c didn't answer in time.
+func (s *Service) askPartyStatus(sm *syncMeta, hash []byte,
+	c *network.ServerIdentity) (*PartyStatusReply, error) {
+	reqID := random.Bytes(16, random.Stream)
+	ch := sm.expectPartyStatus(reqID, c)
+	if err := s.SendRaw(c, &PartyStatus{hash, reqID}); err != nil {
+		sm.Lock()
+		delete(sm.psPending, psKey(reqID, c))
+		sm.Unlock()
+		return nil, err
+	}
+	psr, _ := sm.waitPartyStatus(reqID, c, ch, TIMEOUT)
+	return psr, nil
+}
+
+// PartyStatus sends back the state of the party on this conode.
+func (s *Service) PartyStatus(req *network.Envelope) {
+	ps, ok := req.Msg.(*PartyStatus)
 	if !ok {
-		return onet.NewClientErrorCode(ErrorInternal,
-			"protocol instance is invalid")
+		log.Errorf("Didn't get a PartyStatus: %#v", req.Msg)
+		return
+	}
+	psr := &PartyStatusReply{PopHash: ps.PopHash, ReqID: ps.ReqID}
+	if final, ok := s.data.Finals[string(ps.PopHash)]; ok && final.Desc != nil {
+		psr.Exists = true
+		psr.NumAttendees = len(final.Attendees)
+		psr.Finalized = len(final.Signature) > 0
+		if rev, ok := s.data.Revocations[string(ps.PopHash)]; ok {
+			psr.Revoked = rev.Attendees
+		}
 	}
+	if err := s.SendRaw(req.ServerIdentity, psr); err != nil {
+		log.Error("Couldn't send reply:", err)
+	}
+}
 
-	root.Msg, err = final.Hash()
+// PartyStatusReply passes the reply to the FinalizeStatus or RevokeAttendee
+// that asked the sending conode for it.
+func (s *Service) PartyStatusReply(req *network.Envelope) {
+	psr, ok := req.Msg.(*PartyStatusReply)
+	if !ok {
+		log.Errorf("Didn't get a PartyStatusReply: %v", req.Msg)
+		return
+	}
+	syncData, ok := s.data.syncMetas[string(psr.PopHash)]
+	if !ok {
+		log.Error("No hash for syncMeta found")
+		return
+	}
+	if !syncData.passPartyStatus(psr, req.ServerIdentity) {
+		log.Lvl2("Dropping unexpected PartyStatusReply from",
+			req.ServerIdentity)
+	}
+}
+
+// IsRegistered returns whether the public key is one of the attendees of
+// the party. Before the finalization it checks the attendees given to the
+// last FinalizeRequest, which are pruned if the other conodes don't know
+// them.
+func (s *Service) IsRegistered(req *IsRegisteredRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("IsRegistered: %s %x", s.Context.ServerIdentity(), req.ID)
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if req.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No public key given")
+	}
+	reply := &IsRegisteredReply{Finalized: len(final.Signature) > 0}
+	for _, a := range final.Attendees {
+		if a.Equal(req.Public) {
+			reply.Registered = true
+			break
+		}
+	}
+	return reply, nil
+}
+
+// GetAttendeeInfo returns whether the key is registered for the party and
+// when it has been received.
+func (s *Service) GetAttendeeInfo(req *GetAttendeeInfoRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("GetAttendeeInfo: %s %x", s.Context.ServerIdentity(), req.ID)
+	final, ok := s.data.Finals[string(req.ID)]
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if req.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No public key given")
+	}
+	reply := &GetAttendeeInfoReply{}
+	for _, a := range final.Attendees {
+		if a.Equal(req.Public) {
+			reply.Registered = true
+			break
+		}
+	}
+	if reg, ok := s.data.Registrations[string(req.ID)]; ok {
+		reply.RegisteredAt = reg.registeredAt(req.Public)
+	}
+	return reply, nil
+}
+
+// register records the registration time of the attendees of the party
+// that are new to this conode.
+func (s *Service) register(hash string, atts []abstract.Point) {
+	reg, ok := s.data.Registrations[hash]
+	if !ok {
+		reg = &registrations{}
+		s.data.Registrations[hash] = reg
+	}
+	if err := reg.add(atts); err != nil {
+		log.Error("Couldn't store the registration times:", err)
+	}
+}
+
+// pruneRegistrations removes the registration times of the attendees of
+// the party that are not in atts anymore, because they have been pruned by
+// the finalization, revoked or rotated.
+func (s *Service) pruneRegistrations(hash string, atts []abstract.Point) {
+	reg, ok := s.data.Registrations[hash]
+	if !ok {
+		return
+	}
+	if err := reg.keep(atts); err != nil {
+		log.Error("Couldn't prune the registration times:", err)
+		return
+	}
+	if len(reg.Attendees) == 0 {
+		delete(s.data.Registrations, hash)
+	}
+}
+
+// FindParty returns the hashes of the stored parties with the requested
+// name and date.
+func (s *Service) FindParty(req *FindPartyRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("FindParty: %s %s %s", s.Context.ServerIdentity(), req.Name,
+		req.DateTime)
+	reply := &FindPartyReply{}
+	for hash, final := range s.data.Finals {
+		if final.Desc == nil || final.Desc.Name != req.Name {
+			continue
+		}
+		if req.DateTime != "" && final.Desc.DateTime != req.DateTime {
+			continue
+		}
+		reply.IDs = append(reply.IDs, []byte(hash))
+	}
+	if len(reply.IDs) == 0 {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No party found")
+	}
+	sort.Slice(reply.IDs, func(i, j int) bool {
+		return bytes.Compare(reply.IDs[i], reply.IDs[j]) < 0
+	})
+	return reply, nil
+}
+
+// Audit verifies the signatures of all finalized statements and returns the
+// hashes of the parties that fail.
+func (s *Service) Audit(req *AuditRequest) (network.Message, onet.ClientError) {
+	log.Lvlf2("Audit: %s", s.Context.ServerIdentity())
+	reply := &AuditReply{}
+	for hash, final := range s.data.Finals {
+		if len(final.Signature) == 0 {
+			continue
+		}
+		if final.Desc == nil || final.Verify() != nil {
+			log.Warnf("Final statement %x doesn't verify", hash)
+			reply.Failed = append(reply.Failed, []byte(hash))
+		}
+	}
+	sort.Slice(reply.Failed, func(i, j int) bool {
+		return bytes.Compare(reply.Failed[i], reply.Failed[j]) < 0
+	})
+	return reply, nil
+}
+
+// PruneMerged removes the parties that have been superseded by a merged
+// party stored on this conode, see PruneMergedRequest. Parties that have
+// been transferred to another organizer are kept.
+func (s *Service) PruneMerged(req *PruneMergedRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("PruneMerged: %s", s.Context.ServerIdentity())
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
 	if err != nil {
-		return onet.NewClientError(err)
+		return nil, onet.NewClientError(err)
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	reply := &PruneMergedReply{}
+	for id, final := range s.data.Finals {
+		if _, ok := s.data.Owners[id]; ok {
+			continue
+		}
+		if !s.superseded(id, final, req.Force) {
+			continue
+		}
+		log.Lvlf2("Removing merged party %x", id)
+		delete(s.data.Finals, id)
+		delete(s.data.Registrations, id)
+		delete(s.data.Revocations, id)
+		delete(s.data.Rotations, id)
+		delete(s.data.SkipBlocks, id)
+		delete(s.data.mergeMetas, id)
+		delete(s.data.syncMetas, id)
+		reply.Removed = append(reply.Removed, []byte(id))
+	}
+	if len(reply.Removed) > 0 {
+		s.save()
 	}
+	sort.Slice(reply.Removed, func(i, j int) bool {
+		return bytes.Compare(reply.Removed[i], reply.Removed[j]) < 0
+	})
+	return reply, nil
+}
 
-	root.Data, err = final.ToToml()
+// superseded returns true if the statement stored under id has been merged
+// into a signed statement stored under another hash. Only the entries
+// stored under their own hash count, as the entries of the sub-parties
+// holding the merged statement are used by FetchFinal to find the merged
+// party, and the statements of the sub-parties from before the merge only
+// count if force is set.
+func (s *Service) superseded(id string, final *FinalStatement, force bool) bool {
+	if final.Desc == nil || !force || final.Merged ||
+		string(final.Desc.Hash()) != id {
+		return false
+	}
+	short := final.Desc.shortDesc()
+	for other, merged := range s.data.Finals {
+		if other == id || !merged.Merged || merged.Desc == nil ||
+			other != string(merged.Desc.Hash()) {
+			continue
+		}
+		if merged.Desc.hasParty(short) && merged.Verify() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupVersion is the version of the dumps returned by Backup. Restore
+// refuses dumps of another version.
+const BackupVersion = 2
+
+// stateDump holds the parties of a conode and the statements gathered for
+// their merges. The channels and flags used while merging are not part of
+// it, Restore creates them again. The owners of the parties are not part
+// of it either, they stay with the conode.
+type stateDump struct {
+	Version       int
+	Finals        map[string]*FinalStatement
+	Registrations map[string]*registrations
+	MergeCache    map[string]*mergeCache
+	Revocations   map[string]*revocations
+	Rotations     map[string]*rotations
+	SkipBlocks    map[string]skipchain.SkipBlockID
+	// Statements received for the merges, indexed like Finals
+	Merges map[string]*mergeDump
+}
+
+// mergeDump holds the statements of a mergeMeta.
+type mergeDump struct {
+	Statements map[string]*FinalStatement
+}
+
+// signedDump is a marshalled stateDump signed by the conode that made it.
+type signedDump struct {
+	Dump      []byte
+	Conode    abstract.Point
+	Signature crypto.SchnorrSig
+}
+
+// dumpHash returns the message the conode signs.
+func (sd *signedDump) dumpHash() []byte {
+	h := network.Suite.Hash()
+	h.Write([]byte("dump"))
+	h.Write(sd.Dump)
+	return h.Sum(nil)
+}
+
+// Backup returns a dump of the parties stored on the conode that belong to
+// the organizer signing the request, so that they can be restored on a new
+// conode or after the storage got lost. The dump is signed by the conode,
+// which needs its private key for it, see Config.Private.
+func (s *Service) Backup(req *BackupRequest) (network.Message, onet.ClientError) {
+	log.Lvlf2("Backup: %s", s.Context.ServerIdentity())
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	s.dumpLock.Lock()
+	defer s.dumpLock.Unlock()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
 	if err != nil {
-		return onet.NewClientError(err)
+		return nil, onet.NewClientError(err)
+	}
+	dumped := make(map[string]bool)
+	for id := range s.data.Finals {
+		if crypto.VerifySchnorr(network.Suite, s.owner([]byte(id)), hash, req.Signature) == nil {
+			dumped[id] = true
+		}
+	}
+	if len(dumped) == 0 &&
+		crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature) != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature")
+	}
+	priv, err := s.conodePrivate()
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Can't sign the dump: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
 	}
+	dump := &stateDump{
+		Version:       BackupVersion,
+		Finals:        make(map[string]*FinalStatement),
+		Registrations: make(map[string]*registrations),
+		MergeCache:    make(map[string]*mergeCache),
+		Revocations:   make(map[string]*revocations),
+		SkipBlocks:    make(map[string]skipchain.SkipBlockID),
+		Rotations:     make(map[string]*rotations),
+		Merges:        make(map[string]*mergeDump),
+	}
+	for id := range dumped {
+		dump.Finals[id] = s.data.Finals[id]
+		if r, ok := s.data.Registrations[id]; ok {
+			dump.Registrations[id] = r
+		}
+		if r, ok := s.data.Revocations[id]; ok {
+			dump.Revocations[id] = r
+		}
+		if r, ok := s.data.Rotations[id]; ok {
+			dump.Rotations[id] = r
+		}
+		if sb, ok := s.data.SkipBlocks[id]; ok {
+			dump.SkipBlocks[id] = sb
+		}
+		if meta, ok := s.data.mergeMetas[id]; ok {
+			dump.Merges[id] = &mergeDump{Statements: meta.statementsMap}
+		}
+	}
+	for key, mc := range s.data.MergeCache {
+		if mc.Final != nil && mc.Final.Desc != nil &&
+			dumped[string(mc.Final.Desc.Hash())] {
+			dump.MergeCache[key] = mc
+		}
+	}
+	sd := &signedDump{Conode: s.ServerIdentity().Public}
+	sd.Dump, err = network.Marshal(dump)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	sd.Signature, err = crypto.SignSchnorr(network.Suite, priv, sd.dumpHash())
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	buf, err := network.Marshal(sd)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	return &BackupReply{Dump: buf}, nil
+}
 
-	final.Signature = []byte{}
-	signature := make(chan []byte)
-	root.RegisterOnSignatureDone(func(sig *bftcosi.BFTSignature) {
-		if len(sig.Sig) >= 64 {
-			signature <- sig.Sig[:64]
-		} else {
-			signature <- []byte{}
+// Restore adds the parties of a dump returned by Backup to the ones stored
+// on the conode. The dump has to be signed by a conode of every party it
+// holds, and the request by the organizer of every party, which is the
+// linked one for the parties not stored yet. A stored party is only
+// replaced if the dump holds a newer signed statement of it. The owners of
+// the stored parties are kept.
+func (s *Service) Restore(req *RestoreRequest) (network.Message, onet.ClientError) {
+	log.Lvlf2("Restore: %s", s.Context.ServerIdentity())
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	s.dumpLock.Lock()
+	defer s.dumpLock.Unlock()
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	hash, err := req.Hash()
+	if err != nil {
+		return nil, onet.NewClientError(err)
+	}
+	dump, cerr := openDump(req.Dump)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if len(dump.Finals) == 0 {
+		if err := crypto.VerifySchnorr(network.Suite, s.data.Public, hash, req.Signature); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+		}
+	}
+	for id := range dump.Finals {
+		if err := crypto.VerifySchnorr(network.Suite, s.owner([]byte(id)), hash, req.Signature); err != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("Party %x belongs to another organizer", id))
+		}
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	reply := &RestoreReply{}
+	for id, final := range dump.Finals {
+		if stored, ok := s.data.Finals[id]; ok && !newerStatement(final, stored) {
+			continue
+		}
+		s.data.Finals[id] = final
+		s.data.syncMetas[id] = newSyncMeta()
+		reply.Restored = append(reply.Restored, []byte(id))
+		if r, ok := dump.Registrations[id]; ok {
+			s.data.Registrations[id] = r
+		}
+		if r, ok := dump.Revocations[id]; ok {
+			s.data.Revocations[id] = r
+		}
+		if r, ok := dump.Rotations[id]; ok {
+			s.data.Rotations[id] = r
+		}
+		if sb, ok := dump.SkipBlocks[id]; ok {
+			s.data.SkipBlocks[id] = sb
+		}
+		delete(s.data.mergeMetas, id)
+		md, ok := dump.Merges[id]
+		if !ok || s.config.DisableMerge {
+			continue
+		}
+		meta := newmergeMeta()
+		for h, f := range md.Statements {
+			meta.statementsMap[h] = f
+		}
+		if _, ok := meta.statementsMap[id]; ok {
+			// The own statement has to be the stored one, as in StoreConfig
+			meta.statementsMap[id] = final
 		}
+		s.data.mergeMetas[id] = meta
+	}
+	for key, mc := range dump.MergeCache {
+		if _, ok := s.data.MergeCache[key]; !ok {
+			s.data.MergeCache[key] = mc
+		}
+	}
+	s.linkAliases()
+	s.save()
+	sort.Slice(reply.Restored, func(i, j int) bool {
+		return bytes.Compare(reply.Restored[i], reply.Restored[j]) < 0
 	})
+	return reply, nil
+}
 
-	go node.Start()
+// openDump checks the signature of a dump returned by Backup and returns
+// the dump. Every party of the dump has to hold the conode that signed it,
+// and its statement has to be valid if it is signed.
+func openDump(buf []byte) (*stateDump, onet.ClientError) {
+	_, msg, err := network.Unmarshal(buf)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid dump: "+err.Error())
+	}
+	sd, ok := msg.(*signedDump)
+	if !ok || sd.Conode == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid dump: not signed")
+	}
+	if err := crypto.VerifySchnorr(network.Suite, sd.Conode, sd.dumpHash(), sd.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Invalid dump: wrong signature: "+err.Error())
+	}
+	_, msg, err = network.Unmarshal(sd.Dump)
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid dump: "+err.Error())
+	}
+	dump, ok := msg.(*stateDump)
+	if !ok {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid dump: wrong type")
+	}
+	if dump.Version != BackupVersion {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			fmt.Sprintf("Unknown dump version %d", dump.Version))
+	}
+	for id, final := range dump.Finals {
+		if final == nil || final.Desc == nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("Invalid dump: party %x has no description", id))
+		}
+		if !hasConode(propagationRoster(final.Desc), sd.Conode) {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("Invalid dump: party %x doesn't hold the conode of the dump", id))
+		}
+		if len(final.Signature) > 0 && final.Verify() != nil {
+			return nil, onet.NewClientErrorCode(ErrorInternal,
+				fmt.Sprintf("Invalid dump: party %x has an invalid signature", id))
+		}
+	}
+	return dump, nil
+}
 
-	select {
-	case final.Signature, ok = <-signature:
-		break
-	case <-time.After(TIMEOUT):
-		log.Error("signing failed on timeout")
-		return onet.NewClientErrorCode(ErrorTimeout,
-			"signing timeout")
+// hasConode returns true if the roster holds a conode with the public key.
+func hasConode(roster *onet.Roster, pub abstract.Point) bool {
+	if roster == nil {
+		return false
+	}
+	for _, si := range roster.List {
+		if si.Public.Equal(pub) {
+			return true
+		}
 	}
+	return false
+}
 
-	replies, err := s.Propagate(final.Desc.Roster, final, 10000)
-	if err != nil {
-		return onet.NewClientError(err)
+// validAggregate returns true if the aggregate key of the roster is the sum
+// of the keys of its conodes. The hash of a ShortDesc only covers the
+// aggregate, so a roster with other conodes would match it otherwise.
+func validAggregate(roster *onet.Roster) bool {
+	if roster == nil || roster.Aggregate == nil || len(roster.List) == 0 {
+		return false
 	}
-	if replies != len(final.Desc.Roster.List) {
-		log.Warn("Did only get", replies)
+	agg := network.Suite.Point().Null()
+	for _, si := range roster.List {
+		if si.Public == nil {
+			return false
+		}
+		agg.Add(agg, si.Public)
 	}
-	s.save()
-	return nil
+	return agg.Equal(roster.Aggregate)
 }
 
-// PropagateFinal saves the new final statement
-func (s *Service) PropagateFinal(msg network.Message) {
-	fs, ok := msg.(*FinalStatement)
-	if !ok {
-		log.Error("Couldn't convert to a FinalStatement")
-		return
+// newerStatement returns true if the final statement of a dump replaces the
+// stored one: it is signed and the stored one not, or it has a later
+// epoch.
+func newerStatement(final, stored *FinalStatement) bool {
+	if len(final.Signature) == 0 {
+		return false
 	}
-	if err := fs.Verify(); err != nil {
-		log.Error(err)
-		return
+	return len(stored.Signature) == 0 || final.Epoch > stored.Epoch
+}
+
+// linkAliases points the entries of the sub-parties of a merged party to
+// the statement stored under the hash of the merged party, as after the
+// merge, so that superseded recognizes them.
+func (s *Service) linkAliases() {
+	for id, final := range s.data.Finals {
+		if final.Desc == nil {
+			continue
+		}
+		own := string(final.Desc.Hash())
+		target, ok := s.data.Finals[own]
+		if own == id || !ok || target == final {
+			continue
+		}
+		fh, err1 := final.Hash()
+		th, err2 := target.Hash()
+		if err1 == nil && err2 == nil && bytes.Equal(fh, th) {
+			s.data.Finals[id] = target
+		}
 	}
-	*s.data.Finals[string(fs.Desc.Hash())] = *fs
-	s.save()
-	log.Lvlf2("%s Stored final statement %v", s.ServerIdentity(), fs)
 }
 
-// FetchFinal returns FinalStatement by hash
-// used after Finalization
-func (s *Service) FetchFinal(req *FetchRequest) (network.Message,
+// GetAggregate returns the aggregate public key of the roster of a finalized
+// party, so that its signature can be checked without the whole statement.
+func (s *Service) GetAggregate(req *GetAggregateRequest) (network.Message,
 	onet.ClientError) {
-	log.Lvlf2("FetchFinal: %s %v", s.Context.ServerIdentity(), req.ID)
-	var fs *FinalStatement
-	var ok bool
-	if fs, ok = s.data.Finals[string(req.ID)]; !ok {
+	log.Lvlf2("GetAggregate: %s %v", s.Context.ServerIdentity(), req.ID)
+	fs, ok := s.data.Finals[string(req.ID)]
+	if !ok || fs.Desc == nil || fs.Desc.Roster == nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal,
 			"No config found")
 	}
@@ -346,7 +2778,41 @@ func (s *Service) FetchFinal(req *FetchRequest) (network.Message,
 		return nil, onet.NewClientErrorCode(ErrorOtherFinals,
 			"Not all other conodes finalized yet")
 	}
-	return &FinalizeResponse{fs}, nil
+	if fs.Desc.Expired() {
+		return nil, onet.NewClientErrorCode(ErrorExpired, "Party expired")
+	}
+	buf, err := fs.Desc.Roster.Aggregate.MarshalBinary()
+	if err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
+	}
+	return &GetAggregateReply{buf}, nil
+}
+
+// GetSubParties returns the hashes and locations of the parties that were
+// merged into the party with the given hash.
+func (s *Service) GetSubParties(req *GetSubPartiesRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("GetSubParties: %s %x", s.Context.ServerIdentity(), req.ID)
+	fs, ok := s.data.Finals[string(req.ID)]
+	if !ok || fs.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"No config found")
+	}
+	if !fs.Merged {
+		return nil, onet.NewClientErrorCode(ErrorMerge,
+			"Party is not merged")
+	}
+	reply := &GetSubPartiesReply{}
+	for _, party := range fs.Desc.Parties {
+		sp := &SubParty{ID: party.Hash(), Location: party.Location}
+		if party.IsCompact() {
+			if sd := s.findParty(party.ID); sd != nil {
+				sp.Location = sd.Location
+			}
+		}
+		reply.Parties = append(reply.Parties, sp)
+	}
+	return reply, nil
 }
 
 // MergeRequest starts Merge process and returns FinalStatement after
@@ -354,13 +2820,24 @@ func (s *Service) FetchFinal(req *FetchRequest) (network.Message,
 func (s *Service) MergeRequest(req *MergeRequest) (network.Message,
 	onet.ClientError) {
 	log.Lvlf2("MergeRequest: %s %v", s.Context.ServerIdentity(), req.ID)
+	if cerr := s.begin(); cerr != nil {
+		return nil, cerr
+	}
+	defer s.inflight.Done()
+	if s.config.DisableMerge {
+		return nil, onet.NewClientErrorCode(ErrorNotMergeable,
+			"Merging is disabled on this conode")
+	}
 	if s.data.Public == nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
 	}
 
-	if err := crypto.VerifySchnorr(network.Suite, s.data.Public, req.ID, req.Signature); err != nil {
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), req.Hash(), req.Signature); err != nil {
 		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: err")
 	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
 
 	var final *FinalStatement
 	var meta *mergeMeta
@@ -383,30 +2860,84 @@ func (s *Service) MergeRequest(req *MergeRequest) (network.Message,
 			"Party is unmergeable")
 	}
 	if final.Merged {
-		return &FinalizeResponse{final}, nil
+		return newFinalizeResponse(final), nil
 	}
 	// Check if the party is the merge list
-	found := false
-	for _, party := range final.Desc.Parties {
-		if Equal(party.Roster, final.Desc.Roster) {
-			found = true
-			break
-		}
-	}
-	if !found {
+	if !final.Desc.hasParty(final.Desc.shortDesc()) {
 		return nil, onet.NewClientErrorCode(ErrorInternal,
 			"Party is not included in merge list")
 	}
-	err := s.Merge(final, meta)
+	local, herr := final.Hash()
+	if herr != nil {
+		return nil, onet.NewClientError(herr)
+	}
+	stmts, err := s.Merge(final, meta, req.Partial)
 	if err != nil {
 		return nil, err
 	}
-	err = s.signAndPropagateFinal(final)
+	key, parties, herr := mergeKey(final.Desc, stmts)
+	if herr != nil {
+		return nil, onet.NewClientError(herr)
+	}
+	// The same statements have been merged and signed before.
+	if cached := s.cachedMerge(key, local); cached != nil {
+		log.Lvl2(s.ServerIdentity(), "Using the cached merge")
+		*final = *cached
+		err = s.propagateFinal(final)
+	} else {
+		err = s.signAndPropagateFinal(final)
+	}
 	if err != nil {
 		return nil, err
 	}
+	s.data.MergeCache[key] = &mergeCache{parties, local, final}
+	s.save()
 	// trigger merging process
-	return &FinalizeResponse{final}, nil
+	return s.finalizeResponse(final), nil
+}
+
+// ReopenRegistration clears the signature and the merged flag of an already
+// finalized party, so that forgotten attendees can be added and the party
+// finalized again. It has to be sent to all conodes of the party.
+// All tokens issued for the previous final statement become invalid.
+func (s *Service) ReopenRegistration(req *ReopenRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("ReopenRegistration: %s %v", s.Context.ServerIdentity(), req.ID)
+	if s.data.Public == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Not linked yet")
+	}
+	if err := crypto.VerifySchnorr(network.Suite, s.owner(req.ID), req.Hash(), req.Signature); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "Invalid signature: "+err.Error())
+	}
+	if cerr := s.useChallenge(req.Nonce); cerr != nil {
+		return nil, cerr
+	}
+	var final *FinalStatement
+	var ok bool
+	if final, ok = s.data.Finals[string(req.ID)]; !ok || final == nil || final.Desc == nil {
+		return nil, onet.NewClientErrorCode(ErrorInternal, "No config found")
+	}
+	if len(final.Signature) <= 0 {
+		return nil, onet.NewClientErrorCode(ErrorInternal,
+			"Party is not finalized yet")
+	}
+	log.Warn("Reopening registration of party", final.Desc.Name,
+		"- all tokens issued for it become invalid")
+	// The organizer has to send the complete list of attendees again.
+	final.Attendees = []abstract.Point{}
+	final.Weights = nil
+	final.Signature = []byte{}
+	final.Merged = false
+	final.FinalizedAt = 0
+	delete(s.data.Revocations, string(req.ID))
+	s.invalidateMerges(final.Desc)
+	if meta, ok := s.data.mergeMetas[string(req.ID)]; ok {
+		meta.distrib = false
+		meta.statementsMap = make(map[string]*FinalStatement)
+		meta.statementsMap[string(req.ID)] = final
+	}
+	s.save()
+	return nil, nil
 }
 
 // MergeConfig receives a final statement of requesting party,
@@ -420,14 +2951,23 @@ func (s *Service) MergeConfig(req *network.Envelope) {
 		log.Errorf("Didn't get a MergeConfig: %#v", req.Msg)
 		return
 	}
+	if s.oversized(req) {
+		return
+	}
 	if mc.Final == nil || mc.Final.Desc == nil {
 		log.Error("MergeConfig is empty")
 		return
 	}
-	mcr := &MergeConfigReply{PopStatusOK, mc.Final.Desc.Hash(), nil}
+	mcr := &MergeConfigReply{PopStatusOK, mc.Final.Desc.Hash(), nil, nil, ""}
 
 	var final *FinalStatement
 	var meta *mergeMeta
+	if err := s.verifyMergeSender(req.ServerIdentity, mc.Final, mc.Hash,
+		mc.Signature); err != nil {
+		log.Errorf("SECURITY: MergeConfig from %s: %s", req.ServerIdentity, err)
+		mcr.PopStatus = PopStatusBadSignature
+		goto send
+	}
 	if final, ok = s.data.Finals[string(mc.ID)]; !ok {
 		log.Errorf("No config found")
 		mcr.PopStatus = PopStatusWrongHash
@@ -438,9 +2978,21 @@ func (s *Service) MergeConfig(req *network.Envelope) {
 		mcr.PopStatus = PopStatusWrongHash
 		goto send
 	}
+	if final.Verify() != nil {
+		// The requester has to retry once the local party is finalized.
+		// Storing the received statement now would refuse the retry.
+		log.Lvl2(s.ServerIdentity(), "Local party is not finalized yet")
+		mcr.PopStatus = PopStatusMergeNonFinalized
+		goto send
+	}
 
 	mcr.PopStatus = final.VerifyMergeStatement(mc.Final)
-	if mcr.PopStatus < PopStatusOK {
+	if !statusOK(mcr.PopStatus) {
+		goto send
+	}
+	if mcr.Conflict = s.mergeConflict(final, mc.Final); mcr.Conflict != "" {
+		log.Error(s.ServerIdentity(), "can't merge:", mcr.Conflict)
+		mcr.PopStatus = PopStatusMergeError
 		goto send
 	}
 	if _, ok = meta.statementsMap[string(mc.Final.Desc.Hash())]; ok {
@@ -455,16 +3007,84 @@ func (s *Service) MergeConfig(req *network.Envelope) {
 	mcr.Final = final
 
 send:
+	if s.config.SignMerge {
+		if err := s.signMergeMsg(mcr.Hash, &mcr.Signature); err != nil {
+			log.Error("Couldn't sign reply:", err)
+		}
+	}
 	err := s.SendRaw(req.ServerIdentity, mcr)
 	if err != nil {
 		log.Error("Couldn't send reply:", err)
 	}
 }
 
+// conodePrivate returns the private key of the conode from the
+// configuration, after checking that it belongs to the ServerIdentity of
+// the service.
+func (s *Service) conodePrivate() (abstract.Scalar, error) {
+	priv := s.config.Private
+	if priv == nil {
+		return nil, errors.New("the private key of the conode is not configured")
+	}
+	if !network.Suite.Point().Mul(nil, priv).Equal(s.ServerIdentity().Public) {
+		return nil, errors.New("the configured private key doesn't belong to the conode")
+	}
+	return priv, nil
+}
+
+// signMergeMsg signs the message returned by hash with the key of the
+// conode.
+func (s *Service) signMergeMsg(hash func() ([]byte, error),
+	sig *crypto.SchnorrSig) error {
+	msg, err := hash()
+	if err != nil {
+		return err
+	}
+	priv, err := s.conodePrivate()
+	if err != nil {
+		return err
+	}
+	*sig, err = crypto.SignSchnorr(network.Suite, priv, msg)
+	return err
+}
+
+// verifyMergeSender returns an error if Config.SignMerge is set and the sender is
+// not in the roster of the final statement or its signature over the
+// message returned by hash doesn't verify.
+func (s *Service) verifyMergeSender(si *network.ServerIdentity,
+	fs *FinalStatement, hash func() ([]byte, error),
+	sig crypto.SchnorrSig) error {
+	if !s.config.SignMerge {
+		return nil
+	}
+	if si == nil || fs == nil || fs.Desc == nil || fs.Desc.Roster == nil {
+		return errors.New("no sender or final statement")
+	}
+	member := false
+	for _, c := range fs.Desc.Roster.List {
+		if c.Public.Equal(si.Public) {
+			member = true
+			break
+		}
+	}
+	if !member {
+		return fmt.Errorf("%s is not in the roster of party %s at %s", si,
+			fs.Desc.Name, fs.Desc.Location)
+	}
+	msg, err := hash()
+	if err != nil {
+		return err
+	}
+	return crypto.VerifySchnorr(network.Suite, si.Public, msg, sig)
+}
+
 // MergeConfigReply processes the response after MergeConfig message
-func (s Service) MergeConfigReply(req *network.Envelope) {
+func (s *Service) MergeConfigReply(req *network.Envelope) {
 	log.Lvlf2("MergeConfigReply: %s from %s got %v",
 		s.ServerIdentity(), req.ServerIdentity.String(), req.Msg)
+	if s.oversized(req) {
+		return
+	}
 	mcrVal, ok := req.Msg.(*MergeConfigReply)
 	var mcr *MergeConfigReply
 	mcr = func() *MergeConfigReply {
@@ -477,7 +3097,7 @@ func (s Service) MergeConfigReply(req *network.Envelope) {
 			log.Error("No party with given hash")
 			return nil
 		}
-		if mcrVal.PopStatus < PopStatusOK {
+		if !statusOK(mcrVal.PopStatus) {
 			log.Error("Wrong pop-status:", mcrVal.PopStatus)
 			return mcrVal
 		}
@@ -485,6 +3105,13 @@ func (s Service) MergeConfigReply(req *network.Envelope) {
 			log.Error("Empty FinalStatement in reply")
 			return nil
 		}
+		if err := s.verifyMergeSender(req.ServerIdentity, mcrVal.Final,
+			mcrVal.Hash, mcrVal.Signature); err != nil {
+			log.Errorf("SECURITY: MergeConfigReply from %s: %s",
+				req.ServerIdentity, err)
+			mcrVal.PopStatus = PopStatusBadSignature
+			return mcrVal
+		}
 		mcrVal.PopStatus = final.VerifyMergeStatement(mcrVal.Final)
 		return mcrVal
 	}()
@@ -507,19 +3134,49 @@ func (s *Service) CheckConfig(req *network.Envelope) {
 		log.Errorf("Didn't get a CheckConfig: %#v", req.Msg)
 		return
 	}
+	if s.oversized(req) {
+		return
+	}
 
-	ccr := &CheckConfigReply{PopStatusOK, cc.PopHash, nil}
+	ccr := &CheckConfigReply{PopStatusNoConfig, cc.PopHash, nil, cc.DryRun}
 	if len(s.data.Finals) > 0 {
 		var final *FinalStatement
 		if final, ok = s.data.Finals[string(cc.PopHash)]; !ok {
 			ccr.PopStatus = PopStatusWrongHash
+			if cc.Desc != nil && s.hasRosterVariant(cc.Desc) {
+				log.Warn(s.ServerIdentity(), "stored", cc.Desc.Name,
+					"with a different roster")
+				ccr.PopStatus = PopStatusWrongRoster
+			}
+		} else if same, err := sameAttendees(final.Attendees,
+			cc.Attendees); err != nil {
+			log.Error("Invalid attendees:", err)
+			ccr.PopStatus = PopStatusAttendeesMismatch
+		} else if cc.Strict && len(final.Attendees) > 0 && !same {
+			// Send back all our attendees, so that the requester can
+			// report the difference.
+			ccr.PopStatus = PopStatusAttendeesMismatch
+			ccr.Attendees = final.Attendees
 		} else {
-			final.Attendees = intersectAttendees(final.Attendees, cc.Attendees)
-			if len(final.Attendees) == 0 {
+			atts, err := intersectAttendees(final.Attendees, cc.Attendees)
+			if err != nil {
+				log.Error("Invalid attendees:", err)
+				ccr.PopStatus = PopStatusAttendeesMismatch
+			} else if len(atts) == 0 && !s.AllowEmpty {
 				ccr.PopStatus = PopStatusNoAttendees
+				if len(final.Attendees) > 0 {
+					ccr.PopStatus = PopStatusNoCommonAttendees
+				}
+			} else if !sameWeights(final, atts, cc) {
+				ccr.PopStatus = PopStatusWeightsMismatch
 			} else {
 				ccr.PopStatus = PopStatusOK
-				ccr.Attendees = final.Attendees
+				ccr.Attendees = atts
+			}
+			if err == nil && !cc.DryRun {
+				if err := final.setAttendees(atts); err != nil {
+					log.Error("Couldn't store the attendees:", err)
+				}
 			}
 		}
 	}
@@ -530,9 +3187,110 @@ func (s *Service) CheckConfig(req *network.Envelope) {
 	}
 }
 
+// sameWeights returns true if the attendees atts have the same weights in
+// final and in cc. Without weights every attendee weighs 1.
+func sameWeights(final *FinalStatement, atts []abstract.Point, cc *CheckConfig) bool {
+	if len(final.Weights) == 0 && len(cc.Weights) == 0 {
+		return true
+	}
+	requested := &FinalStatement{Attendees: cc.Attendees, Weights: cc.Weights}
+	for _, a := range atts {
+		if final.Weight(a) != requested.Weight(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// oversized returns true if the message of req has more than
+// MaxMessageEntries entries. The message is then logged and has to be
+// dropped. As req is already decoded, this bounds what the conode
+// processes, while the size of the message is bounded by MaxMessageSize.
+func (s *Service) oversized(req *network.Envelope) bool {
+	n := messageEntries(req.Msg)
+	if n <= s.config.MaxMessageEntries {
+		return false
+	}
+	log.Errorf("%s drops %T from %s: %d entries, only %d allowed",
+		s.ServerIdentity(), req.Msg, req.ServerIdentity, n, s.config.MaxMessageEntries)
+	return true
+}
+
+// messageEntries returns the number of attendees, conodes, parties and
+// statements in a message between the conodes.
+func messageEntries(msg network.Message) int {
+	switch m := msg.(type) {
+	case *CheckConfig:
+		return len(m.Attendees) + descEntries(m.Desc)
+	case *CheckConfigReply:
+		return len(m.Attendees)
+	case *ReconcileAttendees:
+		return len(m.Attendees)
+	case *ReconcileAttendeesReply:
+		return len(m.Attendees)
+	case *PropagateAttendees:
+		return len(m.Attendees)
+	case *MergeConfig:
+		return statementEntries(m.Final)
+	case *MergeConfigReply:
+		return statementEntries(m.Final)
+	case *MergeCheck:
+		n := len(m.MergeInfo)
+		for i := range m.MergeInfo {
+			n += statementEntries(&m.MergeInfo[i])
+		}
+		return n
+	}
+	return 0
+}
+
+func statementEntries(fs *FinalStatement) int {
+	if fs == nil {
+		return 0
+	}
+	return len(fs.Attendees) + descEntries(fs.Desc)
+}
+
+func descEntries(desc *PopDesc) int {
+	if desc == nil {
+		return 0
+	}
+	n := len(desc.Parties)
+	for _, r := range []*onet.Roster{desc.Roster, desc.VerifierRoster} {
+		if r != nil {
+			n += len(r.List)
+		}
+	}
+	for _, sd := range desc.Parties {
+		if sd != nil && sd.Roster != nil {
+			n += len(sd.Roster.List)
+		}
+	}
+	return n
+}
+
+// hasRosterVariant returns true if a party with the same name, date and
+// location as desc is stored, but with a different roster.
+func (s *Service) hasRosterVariant(desc *PopDesc) bool {
+	for _, f := range s.data.Finals {
+		if f.Desc == nil || f.Desc.Roster == nil || desc.Roster == nil {
+			continue
+		}
+		if f.Desc.Name == desc.Name && f.Desc.DateTime == desc.DateTime &&
+			f.Desc.Location == desc.Location &&
+			!Equal(f.Desc.Roster, desc.Roster) {
+			return true
+		}
+	}
+	return false
+}
+
 // CheckConfigReply strips the attendees missing in the reply, if the
 // PopStatus == PopStatusOK.
 func (s *Service) CheckConfigReply(req *network.Envelope) {
+	if s.oversized(req) {
+		return
+	}
 	ccrVal, ok := req.Msg.(*CheckConfigReply)
 	var ccr *CheckConfigReply
 	ccr = func() *CheckConfigReply {
@@ -545,11 +3303,21 @@ func (s *Service) CheckConfigReply(req *network.Envelope) {
 			log.Error("No party with given hash")
 			return nil
 		}
-		if ccrVal.PopStatus < PopStatusOK {
+		if !statusOK(ccrVal.PopStatus) {
 			log.Error("Wrong pop-status:", ccrVal.PopStatus)
-			return nil
+			return ccrVal
+		}
+		if ccrVal.DryRun {
+			return ccrVal
+		}
+		atts, err := intersectAttendees(final.Attendees, ccrVal.Attendees)
+		if err == nil {
+			err = final.setAttendees(atts)
+		}
+		if err != nil {
+			log.Error("Invalid attendees:", err)
+			ccrVal.PopStatus = PopStatusAttendeesMismatch
 		}
-		final.Attendees = intersectAttendees(final.Attendees, ccrVal.Attendees)
 		return ccrVal
 	}()
 	if syncData, ok := s.data.syncMetas[string(ccrVal.PopHash)]; ok {
@@ -569,6 +3337,9 @@ func (s *Service) MergeCheck(req *network.Envelope) {
 		log.Errorf("Didn't get a MergeCheck: %v", req.Msg)
 		return
 	}
+	if s.oversized(req) {
+		return
+	}
 	mcr := &MergeCheckReply{msg.IDsndr, PopStatusOK}
 	found := false
 	var hash []byte
@@ -578,7 +3349,7 @@ func (s *Service) MergeCheck(req *network.Envelope) {
 	var syncData *syncMeta
 
 	var newHash string
-	locs := make([]string, 0)
+	var stmts []*FinalStatement
 	if final, ok = s.data.Finals[string(msg.IDrecv)]; !ok {
 		log.Error("No party with given hash")
 		mcr.PopStatus = PopStatusWrongHash
@@ -615,9 +3386,12 @@ func (s *Service) MergeCheck(req *network.Envelope) {
 			found = true
 		}
 		status := final.VerifyMergeStatement(&mergeStmt)
-		if status < PopStatusOK {
+		if !statusOK(status) {
 			log.Error("Received non valid FinalStatement")
 			mcr.PopStatus = PopStatusMergeError
+			if status == PopStatusBadSignature {
+				mcr.PopStatus = status
+			}
 			goto send
 		}
 	}
@@ -626,19 +3400,18 @@ func (s *Service) MergeCheck(req *network.Envelope) {
 		mcr.PopStatus = PopStatusMergeError
 		goto send
 	}
-	final.Desc.Location = ""
-	for _, f := range msg.MergeInfo {
-		final.Attendees = unionAttendies(final.Attendees, f.Attendees)
-		final.Desc.Roster = unionRoster(final.Desc.Roster, f.Desc.Roster)
-		locs = append(locs, f.Desc.Location)
+	for i := range msg.MergeInfo {
+		stmts = append(stmts, &msg.MergeInfo[i])
+	}
+	sortStatements(stmts)
+	if err := mergeStatements(final, stmts); err != nil {
+		log.Error("Couldn't merge the statements:", err)
+		mcr.PopStatus = PopStatusMergeError
+		goto send
 	}
-	sort.Slice(locs, func(i, j int) bool {
-		return strings.Compare(locs[i], locs[j]) < 0
-	})
-	final.Desc.Location = strings.Join(locs, DELIMETER)
-	final.Merged = true
 
 	newHash = string(final.Desc.Hash())
+	s.moveOwner(string(msg.IDrecv), newHash)
 	s.data.Finals[newHash] = final
 	s.data.mergeMetas[newHash] = meta
 	s.data.syncMetas[newHash] = syncData
@@ -656,194 +3429,311 @@ func (s *Service) MergeCheckReply(req *network.Envelope) {
 	if !ok {
 		log.Errorf("Didn't get a MergeCheckReply: %v", req.Msg)
 	}
-	if msg.PopStatus < PopStatusOK {
+	if !statusOK(msg.PopStatus) {
 		log.Error("Wrong pop status on MergeCheckReply", msg.PopStatus)
 	}
 	if syncData, ok := s.data.syncMetas[string(msg.ID)]; ok {
-		syncData.mcGroup.Done()
+		syncData.doneMergeCheck()
 	} else {
 		log.Error("No hash found on MergeCheckReply")
 	}
 }
 
-func (s *Service) broadcastFinal(final *FinalStatement, meta *mergeMeta) error {
+func (s *Service) broadcastFinal(final *FinalStatement, meta *mergeMeta,
+	parties []*ShortDesc) onet.ClientError {
+	// Every conode of the parties has to answer, so the replies can't be
+	// counted for a party without conodes.
+	if cerr := checkPartyRosters(parties); cerr != nil {
+		return cerr
+	}
 	msg := &MergeCheck{}
-	msg.MergeInfo = make([]FinalStatement, len(meta.statementsMap))
-	i := 0
-	for _, f := range meta.statementsMap {
+	stmts := meta.sortedStatements()
+	msg.MergeInfo = make([]FinalStatement, len(stmts))
+	for i, f := range stmts {
 		msg.MergeInfo[i] = *f
-		i++
 	}
 	msg.IDsndr = final.Desc.Hash()
 
 	syncData, ok := s.data.syncMetas[string(final.Desc.Hash())]
 	if !ok {
-		return errors.New("Sync Data not found by hash")
+		return onet.NewClientErrorCode(ErrorMerge, "Sync Data not found by hash")
 	}
 
-	// Count number of conodes except current
-	n := 0
-	for _, p := range final.Desc.Parties {
-		n += len(p.Roster.List)
+	// Only the parties taking part in the merge are contacted, the others
+	// were dropped in a partial merge.
+	var merged []*ShortDesc
+	var hashes [][]byte
+	for _, party := range parties {
+		hash := final.Desc.subPartyDesc(party).Hash()
+		if _, ok := meta.statementsMap[string(hash)]; ok {
+			merged = append(merged, party)
+			hashes = append(hashes, hash)
+		}
 	}
-	n--
-	syncData.mcGroup.Add(n)
-
-	var pop PopDesc
-	for _, party := range final.Desc.Parties {
-		pop.Name = final.Desc.Name
-		pop.DateTime = final.Desc.DateTime
-		pop.Parties = final.Desc.Parties
-		pop.Location = party.Location
-		pop.Roster = party.Roster
-		msg.IDrecv = pop.Hash()
 
+	// All conodes except current
+	var dsts []*network.ServerIdentity
+	var msgs []*MergeCheck
+	for i, party := range merged {
+		m := *msg
+		m.IDrecv = hashes[i]
 		for _, si := range party.Roster.List {
 			if !(s.ServerIdentity().Equal(si) &&
-				bytes.Equal(msg.IDrecv, final.Desc.Hash())) {
-				err := s.SendRaw(si, msg)
-				if err != nil {
-					return err
-				}
+				bytes.Equal(m.IDrecv, final.Desc.Hash())) {
+				dsts = append(dsts, si)
+				msgs = append(msgs, &m)
+			}
+		}
+	}
+	err := syncData.paceMergeChecks(len(dsts), s.config.BroadcastWindow,
+		s.config.BroadcastJitter, func(i int) error {
+			return s.SendRaw(dsts[i], msgs[i])
+		})
+	if err != nil {
+		return onet.NewClientError(err)
+	}
+	if !syncData.waitMergeChecks() {
+		return onet.NewClientErrorCode(ErrorCancelled,
+			"Merge has been cancelled")
+	}
+	return nil
+}
+
+// checkPartyRosters returns an error if one of the parties has no conodes.
+func checkPartyRosters(parties []*ShortDesc) onet.ClientError {
+	for _, party := range parties {
+		if party.Roster == nil || len(party.Roster.List) == 0 {
+			return onet.NewClientErrorCode(ErrorMerge,
+				fmt.Sprintf("Party at %s has no conodes", party.Location))
+		}
+	}
+	return nil
+}
+
+// GetParty returns the location and the roster of a party, so that compact
+// descriptions can be resolved.
+func (s *Service) GetParty(req *GetPartyRequest) (network.Message,
+	onet.ClientError) {
+	log.Lvlf2("GetParty: %s %x", s.Context.ServerIdentity(), req.ID)
+	if s.config.DisableMerge {
+		return nil, onet.NewClientErrorCode(ErrorNotMergeable,
+			"Merging is disabled on this conode")
+	}
+	if party := s.findParty(req.ID); party != nil {
+		return &GetPartyReply{party}, nil
+	}
+	return nil, onet.NewClientErrorCode(ErrorInternal, "No party found")
+}
+
+// findParty searches the stored descriptions for the party with the given
+// hash.
+func (s *Service) findParty(hash []byte) *ShortDesc {
+	for _, final := range s.data.Finals {
+		if final.Desc == nil || final.Desc.Roster == nil {
+			continue
+		}
+		if sd := final.Desc.shortDesc(); bytes.Equal(sd.Hash(), hash) {
+			return sd
+		}
+		for _, party := range final.Desc.Parties {
+			if !party.IsCompact() && bytes.Equal(party.Hash(), hash) {
+				return party
 			}
 		}
 	}
-	syncData.mcGroup.Wait()
 	return nil
 }
 
+// resolveParties returns the parties of desc with their rosters. The
+// compact parties are searched locally first, then they are fetched from the
+// other conodes. The conodes are visited like a graph: first the ones of our
+// roster, then the ones of every party resolved so far. Like this a party
+// is found if it is known to a party sharing a conode with a party we know,
+// even if no conode of our roster knows it.
+func (s *Service) resolveParties(desc *PopDesc) ([]*ShortDesc, onet.ClientError) {
+	parties := make([]*ShortDesc, len(desc.Parties))
+	var queue []*network.ServerIdentity
+	visited := map[network.ServerIdentityID]bool{s.ServerIdentity().ID: true}
+	enqueue := func(r *onet.Roster) {
+		for _, si := range r.List {
+			if !visited[si.ID] {
+				visited[si.ID] = true
+				queue = append(queue, si)
+			}
+		}
+	}
+	enqueue(desc.Roster)
+	missing := 0
+	for i, party := range desc.Parties {
+		if !party.IsCompact() {
+			parties[i] = party
+		} else {
+			parties[i] = s.findParty(party.ID)
+		}
+		if parties[i] != nil {
+			enqueue(parties[i].Roster)
+		} else {
+			missing++
+		}
+	}
+	for ; missing > 0 && len(queue) > 0; queue = queue[1:] {
+		si := queue[0]
+		for i, party := range desc.Parties {
+			if parties[i] != nil {
+				continue
+			}
+			sd, cerr := NewClient().GetParty(si.Address, party.ID)
+			if cerr != nil {
+				log.Lvl2("Couldn't get party from", si, cerr)
+				if cerr.ErrorCode() != ErrorInternal {
+					// Unreachable, no need to ask for the others
+					break
+				}
+				continue
+			}
+			// Don't visit conodes an untrusted reply made up
+			if !validAggregate(sd.Roster) {
+				log.Lvl2("Wrong aggregate in the party from", si)
+				continue
+			}
+			parties[i] = sd
+			missing--
+			enqueue(sd.Roster)
+		}
+	}
+	for i, party := range parties {
+		if party == nil {
+			return nil, onet.NewClientErrorCode(ErrorMerge,
+				fmt.Sprintf("Couldn't resolve party %x", desc.Parties[i].ID))
+		}
+	}
+	return parties, nil
+}
+
 // Merge sends MergeConfig to all parties,
 // Receives Replies, updates info about global merge party
 // When all merge party's info is saved, merge it and starts global sighning process
 // After all, sends StoreConfig request to other conodes of own party
-func (s *Service) Merge(final *FinalStatement, meta *mergeMeta) onet.ClientError {
+// If partial is set, parties where no conode answers are dropped from the
+// merge instead of failing it. It returns the statements it merged.
+func (s *Service) Merge(final *FinalStatement, meta *mergeMeta,
+	partial bool) ([]*FinalStatement, onet.ClientError) {
 	if meta.distrib {
 		// Used not to start merge process 2 times, when one is on run.
 		log.Lvl2(s.ServerIdentity(), "Not enter merge")
-		return nil
+		return nil, nil
 	}
 	log.Lvl2("Merge ", s.ServerIdentity())
 	meta.distrib = true
 	// Flag indicating that there were connection with other nodes
 	syncData, ok := s.data.syncMetas[string(final.Desc.Hash())]
 	if !ok {
-		return onet.NewClientErrorCode(ErrorMerge, "Wrong Hash")
+		return nil, onet.NewClientErrorCode(ErrorMerge, "Wrong Hash")
 	}
-	for _, party := range final.Desc.Parties {
-		popDesc := PopDesc{
-			Name:     final.Desc.Name,
-			DateTime: final.Desc.DateTime,
-			Location: party.Location,
-			Roster:   party.Roster,
-			Parties:  final.Desc.Parties,
-		}
-		hash := popDesc.Hash()
+	parties, cerr := s.resolveParties(final.Desc)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if cerr := checkPartyRosters(parties); cerr != nil {
+		return nil, cerr
+	}
+	for _, party := range parties {
+		hash := final.Desc.subPartyDesc(party).Hash()
 		if _, ok := meta.statementsMap[string(hash)]; ok {
 			// that's unlikely due to running in cycle
 			continue
 		}
 		mc := &MergeConfig{Final: final, ID: hash}
+		if s.config.SignMerge {
+			if err := s.signMergeMsg(mc.Hash, &mc.Signature); err != nil {
+				return nil, onet.NewClientError(err)
+			}
+		}
+		status := PopStatusOK
+		conflict := ""
 		for _, si := range party.Roster.List {
 			log.Lvlf2("Sending from %s to %s", s.ServerIdentity(), si)
 			err := s.SendRaw(si, mc)
 			if err != nil {
-				return onet.NewClientErrorCode(ErrorInternal, err.Error())
+				if partial {
+					log.Lvl2("Couldn't reach", si, err)
+					continue
+				}
+				return nil, onet.NewClientErrorCode(ErrorInternal, err.Error())
 			}
-			var mcr *MergeConfigReply
-			select {
-			case mcr = <-syncData.mcChannel:
-				break
-			case <-time.After(TIMEOUT):
-				return onet.NewClientErrorCode(ErrorTimeout,
+			mcr, ok := syncData.waitMergeConfig(TIMEOUT)
+			if !ok {
+				if partial {
+					log.Lvl2("No answer from", si)
+					continue
+				}
+				return nil, onet.NewClientErrorCode(ErrorTimeout,
 					"timeout on waiting response MergeConfig")
 			}
 			if mcr == nil {
-				return onet.NewClientErrorCode(ErrorMerge,
-					"Error during merging")
+				return nil, onet.NewClientErrorCode(ErrorCancelled,
+					"Merge has been cancelled")
 			}
 			if mcr.PopStatus == PopStatusOK {
 				meta.statementsMap[string(hash)] = mcr.Final
 				break
 			}
+			status = mcr.PopStatus
+			if mcr.Conflict != "" {
+				conflict = mcr.Conflict
+			}
 		}
 		if _, ok = meta.statementsMap[string(hash)]; !ok {
-			return onet.NewClientErrorCode(ErrorMerge,
+			if partial {
+				log.Warnf("Dropping party at %s from the merge", party.Location)
+				continue
+			}
+			if status == PopStatusMergeNonFinalized {
+				meta.distrib = false
+				return nil, onet.NewClientErrorCode(ErrorMerge,
+					fmt.Sprintf("Party at %s is not finalized yet - please retry later",
+						party.Location))
+			}
+			if conflict != "" {
+				return nil, onet.NewClientErrorCode(ErrorMerge,
+					fmt.Sprintf("Party at %s conflicts: %s", party.Location,
+						conflict))
+			}
+			return nil, onet.NewClientErrorCode(ErrorMerge,
 				"merge with party failed")
 		}
 	}
+	if len(meta.statementsMap) <= 1 {
+		return nil, onet.NewClientErrorCode(ErrorMerge,
+			"no other party could be merged")
+	}
 	// send merge info to fellows from the same party
-	err := s.broadcastFinal(final, meta)
-	if err != nil {
-		return onet.NewClientError(err)
+	cerr = s.broadcastFinal(final, meta, parties)
+	if cerr != nil {
+		return nil, cerr
 	}
-
-	// Unite the lists
-	locs := make([]string, 0)
-	Roster := &onet.Roster{}
-	for _, f := range meta.statementsMap {
-		// although there must not be any intersection
-		// in attendies list it's better to check it
-		// not simply extend the list
-		final.Attendees = unionAttendies(final.Attendees, f.Attendees)
-		Roster = unionRoster(Roster, f.Desc.Roster)
-		locs = append(locs, f.Desc.Location)
+
+	// Unite the lists
+	oldHash := string(final.Desc.Hash())
+	stmts := meta.sortedStatements()
+	if err := mergeStatements(final, stmts); err != nil {
+		return nil, onet.NewClientErrorCode(ErrorMerge, err.Error())
 	}
-	sort.Slice(locs, func(i, j int) bool {
-		return strings.Compare(locs[i], locs[j]) < 0
-	})
-	final.Desc.Location = strings.Join(locs, DELIMETER)
-	final.Desc.Roster = Roster
-	final.Merged = true
 
 	// refresh data
 	hash := string(final.Desc.Hash())
+	s.moveOwner(oldHash, hash)
 	s.data.Finals[hash] = final
 	s.data.mergeMetas[hash] = meta
 	s.data.syncMetas[hash] = syncData
 	meta.statementsMap = make(map[string]*FinalStatement)
 	meta.statementsMap[hash] = final
-	return nil
+	return stmts, nil
 }
 
 // function used in bft
 func (s *Service) bftVerifyMerge(Msg []byte, Data []byte) bool {
-	fs, err := NewFinalStatementFromToml(Data)
-	if err != nil {
-		log.Error(err.Error())
-		return false
-	}
-	hashReceived, err := fs.Hash()
-	if err != nil {
-		log.Error(err.Error())
-		return false
-	}
-	if !bytes.Equal(Msg, hashReceived) {
-		log.Error("Msg to sign differs from data hash")
-		return false
-	}
-
-	// searching for local party
-	hash := fs.Desc.Hash()
-	var localFinal *FinalStatement
-	var ok bool
-	if localFinal, ok = s.data.Finals[string(hash)]; !ok {
-		log.Error("No party is here")
-		log.Fatal("oh no")
-		return false
-	}
-
-	hashLocal, err := localFinal.Hash()
-
-	if err != nil {
-		log.Error(err.Error())
-		return false
-	}
-
-	if !bytes.Equal(hashLocal, hashReceived) {
-		log.Error("hashes of local and sent finalStatements are not equal")
-		return false
-	}
-	return true
+	return s.verifyLocalStatement(Msg, Data)
 }
 
 // VerifyMergeStatement checks that received mergeFinal is valid and can be merged with final
@@ -855,20 +3745,22 @@ func (final *FinalStatement) VerifyMergeStatement(mergeFinal *FinalStatement) in
 		log.Error("Received party is not finished")
 		return PopStatusMergeNonFinalized
 	}
-	if mergeFinal.Verify() != nil {
-		log.Error("Received config party signature is invalid")
-		return PopStatusMergeError
+	if err := mergeFinal.Verify(); err != nil {
+		// Not a party that is still running, somebody sent a forged or
+		// corrupted statement.
+		log.Errorf("SECURITY: received party %s at %s with an invalid signature: %s",
+			mergeFinal.Desc.Name, mergeFinal.Desc.Location, err)
+		return PopStatusBadSignature
 	}
 
 	if final.Desc.DateTime != mergeFinal.Desc.DateTime {
 		log.Error("Parties were held in different times")
 		return PopStatusMergeError
 	}
-
 	// Check if the party is the merge list
 	found := true
 	for _, party := range final.Desc.Parties {
-		if Equal(party.Roster, mergeFinal.Desc.Roster) {
+		if bytes.Equal(party.Hash(), mergeFinal.Desc.shortDesc().Hash()) {
 			found = true
 			break
 		}
@@ -881,44 +3773,287 @@ func (final *FinalStatement) VerifyMergeStatement(mergeFinal *FinalStatement) in
 	return PopStatusOK
 }
 
-// Get intersection of attendees
-func intersectAttendees(atts1, atts2 []abstract.Point) []abstract.Point {
-	myMap := make(map[string]bool)
+// mergeConflict returns why the received statement conflicts with the
+// local one, or "" if it doesn't. A conode that belongs to both parties
+// must hold the same attendees and, once it is signed, the same final
+// statement for the received party.
+func (s *Service) mergeConflict(final, mergeFinal *FinalStatement) string {
+	stored, ok := s.data.Finals[string(mergeFinal.Desc.Hash())]
+	if !ok || stored == final {
+		return ""
+	}
+	same, err := sameAttendees(stored.Attendees, mergeFinal.Attendees)
+	if err != nil {
+		return fmt.Sprintf("%s got invalid attendees for the party at %s: %s",
+			s.ServerIdentity().Address, mergeFinal.Desc.Location, err)
+	}
+	if len(stored.Attendees) > 0 && !same {
+		return fmt.Sprintf("%s holds different attendees for the party at %s",
+			s.ServerIdentity().Address, mergeFinal.Desc.Location)
+	}
+	if len(stored.Signature) > 0 {
+		h1, err1 := stored.Hash()
+		h2, err2 := mergeFinal.Hash()
+		if err1 != nil || err2 != nil || !bytes.Equal(h1, h2) {
+			return fmt.Sprintf("%s holds a different statement for the party at %s",
+				s.ServerIdentity().Address, mergeFinal.Desc.Location)
+		}
+	}
+	return ""
+}
 
-	for _, p := range atts1 {
-		myMap[p.String()] = true
+// sortStatements orders the statements by the hashes of their descriptions.
+func sortStatements(stmts []*FinalStatement) {
+	sort.Slice(stmts, func(i, j int) bool {
+		return bytes.Compare(stmts[i].Desc.Hash(), stmts[j].Desc.Hash()) < 0
+	})
+}
+
+// mergeStatements unites the attendees, the rosters, the verifiers and the
+// locations of the statements in final and marks it as merged. The merged
+// statement keeps the ordering policy of the parties if they all have the
+// same, else it is sorted. Origins records the order of the attendees of
+// every party. It fails if an attendee has no valid key, in which case
+// final may be partially updated.
+func mergeStatements(final *FinalStatement, stmts []*FinalStatement) error {
+	locs := make([]string, 0, len(stmts))
+	roster := &onet.Roster{}
+	var verifiers *onet.Roster
+	weighted := len(final.Weights) > 0
+	weights := make(map[string]int)
+	for i, a := range final.Attendees {
+		key, err := attendeeKey(a)
+		if err != nil {
+			return err
+		}
+		weights[key] = final.weight(i)
+	}
+	var atts []abstract.Point
+	var err error
+	policy := final.Desc.OrderingPolicy
+	origins := make(map[string][]abstract.Point)
+	for _, f := range stmts {
+		// although there must not be any intersection
+		// in attendies list it's better to check it
+		// not simply extend the list
+		atts, err = appendAttendees(atts, f.Attendees)
+		if err != nil {
+			return err
+		}
+		origins[string(f.Desc.shortDesc().Hash())] = f.Attendees
+		if f.Desc.OrderingPolicy != policy {
+			policy = OrderSorted
+		}
+		weighted = weighted || len(f.Weights) > 0
+		for i, a := range f.Attendees {
+			key, err := attendeeKey(a)
+			if err != nil {
+				return err
+			}
+			weights[key] = f.weight(i)
+		}
+		roster = unionRoster(roster, f.Desc.Roster)
+		if f.Desc.VerifierRoster != nil {
+			if verifiers == nil {
+				verifiers = &onet.Roster{}
+			}
+			verifiers = unionRoster(verifiers, f.Desc.VerifierRoster)
+		}
+		locs = append(locs, f.Desc.Location)
+	}
+	sort.Slice(locs, func(i, j int) bool {
+		return strings.Compare(locs[i], locs[j]) < 0
+	})
+	final.Desc.OrderingPolicy = policy
+	if atts, err = appendAttendees(atts, final.Attendees); err != nil {
+		return err
+	}
+	if final.Attendees, err = final.Desc.orderAttendees(atts); err != nil {
+		return err
+	}
+	if final.Origins, err = attendeeOrigins(final.Attendees, origins); err != nil {
+		return err
+	}
+	final.Desc.Location = strings.Join(locs, DELIMETER)
+	final.Desc.Roster = roster
+	final.Desc.VerifierRoster = verifiers
+	final.Merged = true
+	final.Weights = nil
+	if weighted {
+		final.Weights, err = alignWeights(final.Attendees, weights)
+	}
+	return err
+}
+
+// attendeeOrigins returns the origins of the merged attendees, given the
+// attendees of every party indexed by the hash of the party.
+func attendeeOrigins(atts []abstract.Point,
+	parties map[string][]abstract.Point) ([]*AttendeeOrigin, error) {
+	indexes, err := attendeeIndexes(atts)
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]*AttendeeOrigin, 0, len(parties))
+	for party, patts := range parties {
+		o := &AttendeeOrigin{Party: []byte(party), Indexes: make([]int, len(patts))}
+		for i, a := range patts {
+			key, err := attendeeKey(a)
+			if err != nil {
+				return nil, err
+			}
+			o.Indexes[i] = indexes[key]
+		}
+		origins = append(origins, o)
 	}
-	min := len(atts1)
-	if min < len(atts1) {
-		min = len(atts1)
+	sort.Slice(origins, func(i, j int) bool {
+		return bytes.Compare(origins[i].Party, origins[j].Party) < 0
+	})
+	return origins, nil
+}
+
+// Get intersection of attendees
+func intersectAttendees(atts1, atts2 []abstract.Point) ([]abstract.Point, error) {
+	myMap, err := attendeeSet(atts1)
+	if err != nil {
+		return nil, err
 	}
-	na := make([]abstract.Point, 0, min)
+	na := make([]abstract.Point, 0, len(atts1))
 	for _, p := range atts2 {
-		if _, ok := myMap[p.String()]; ok {
+		key, err := attendeeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		if myMap[key] {
 			na = append(na, p)
 		}
 	}
-	return na
+	return na, nil
+}
+
+func unionAttendies(atts1, atts2 []abstract.Point) ([]abstract.Point, error) {
+	na, err := appendAttendees(atts1, atts2)
+	if err != nil {
+		return nil, err
+	}
+	return sortAttendees(na)
 }
 
-func unionAttendies(atts1, atts2 []abstract.Point) []abstract.Point {
+// appendAttendees returns the attendees of atts1 followed by the ones of
+// atts2 missing in atts1, without duplicates.
+func appendAttendees(atts1, atts2 []abstract.Point) ([]abstract.Point, error) {
 	myMap := make(map[string]bool)
 	na := make([]abstract.Point, 0, len(atts1)+len(atts2))
+	for _, atts := range [][]abstract.Point{atts1, atts2} {
+		for _, p := range atts {
+			key, err := attendeeKey(p)
+			if err != nil {
+				return nil, err
+			}
+			if !myMap[key] {
+				myMap[key] = true
+				na = append(na, p)
+			}
+		}
+	}
+	return na, nil
+}
 
-	na = append(na, atts1...)
+// subtractAttendees returns the attendees of atts1 missing in atts2.
+func subtractAttendees(atts1, atts2 []abstract.Point) ([]abstract.Point, error) {
+	myMap, err := attendeeSet(atts2)
+	if err != nil {
+		return nil, err
+	}
+	var na []abstract.Point
 	for _, p := range atts1 {
-		myMap[p.String()] = true
+		key, err := attendeeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		if !myMap[key] {
+			na = append(na, p)
+		}
 	}
+	return na, nil
+}
 
-	for _, p := range atts2 {
-		if _, ok := myMap[p.String()]; !ok {
-			na = append(na, p)
+// sameAttendees returns true if both lists hold the same attendees,
+// independent of their order.
+func sameAttendees(atts1, atts2 []abstract.Point) (bool, error) {
+	only1, err := subtractAttendees(atts1, atts2)
+	if err != nil {
+		return false, err
+	}
+	only2, err := subtractAttendees(atts2, atts1)
+	if err != nil {
+		return false, err
+	}
+	return len(only1) == 0 && len(only2) == 0, nil
+}
+
+// attendeeKey returns the canonical encoding of the public key of an
+// attendee, which is the binary marshalling of the point. It is used as a
+// map-key and to sort the attendees of merged parties, so it is part of the
+// wire format: changing it changes the hash of merged final statements.
+func attendeeKey(p abstract.Point) (string, error) {
+	if p == nil {
+		return "", errors.New("attendee without public key")
+	}
+	buf, err := p.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// attendeeSet returns the attendeeKeys of atts.
+func attendeeSet(atts []abstract.Point) (map[string]bool, error) {
+	set := make(map[string]bool, len(atts))
+	for _, p := range atts {
+		key, err := attendeeKey(p)
+		if err != nil {
+			return nil, err
 		}
+		set[key] = true
 	}
-	sort.Slice(na, func(i, j int) bool {
-		return strings.Compare(na[i].String(), na[j].String()) < 0
+	return set, nil
+}
+
+// attendeeIndexes returns the index of every attendee of atts, indexed by
+// its attendeeKey.
+func attendeeIndexes(atts []abstract.Point) (map[string]int, error) {
+	indexes := make(map[string]int, len(atts))
+	for i, p := range atts {
+		key, err := attendeeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		indexes[key] = i
+	}
+	return indexes, nil
+}
+
+// sortAttendees returns the attendees sorted by their attendeeKey. The
+// slice is not changed.
+func sortAttendees(atts []abstract.Point) ([]abstract.Point, error) {
+	keys := make([]string, len(atts))
+	sorted := make([]int, len(atts))
+	for i, p := range atts {
+		key, err := attendeeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		sorted[i] = i
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return keys[sorted[i]] < keys[sorted[j]]
 	})
-	return na
+	res := make([]abstract.Point, len(atts))
+	for i, index := range sorted {
+		res[i] = atts[index]
+	}
+	return res, nil
 }
 
 func unionRoster(r1, r2 *onet.Roster) *onet.Roster {
@@ -943,19 +4078,256 @@ func unionRoster(r1, r2 *onet.Roster) *onet.Roster {
 // saves the actual identity
 func (s *Service) save() {
 	log.Lvl2("Saving service", s.ServerIdentity())
-	err := s.Save("storage", s.data)
+	data := s.data
+	if s.config.CompressStorage {
+		var err error
+		data, err = s.data.compressed()
+		if err != nil {
+			log.Error("Couldn't compress data:", err)
+			return
+		}
+	}
+	err := s.storage.Save("storage", data)
 	if err != nil {
 		log.Error("Couldn't save data:", err)
 	}
 }
 
+// Storage saves and loads the data of the service. The default is the
+// onet.Context of the service, which writes to disk.
+type Storage interface {
+	Save(id string, data interface{}) error
+	Load(id string) (interface{}, error)
+	DataAvailable(id string) bool
+}
+
+// MemoryStorage is a Storage that keeps the marshalled data in memory, so
+// that tests can check that the data survives a save and a load without
+// touching the disk.
+type MemoryStorage struct {
+	data map[string][]byte
+	sync.Mutex
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{data: make(map[string][]byte)}
+}
+
+// Save marshals data and stores it under id.
+func (ms *MemoryStorage) Save(id string, data interface{}) error {
+	buf, err := network.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	ms.data[id] = buf
+	return nil
+}
+
+// Load returns a copy of the data stored under id.
+func (ms *MemoryStorage) Load(id string) (interface{}, error) {
+	ms.Lock()
+	buf, ok := ms.data[id]
+	ms.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no data stored for %s", id)
+	}
+	_, msg, err := network.Unmarshal(buf)
+	return msg, err
+}
+
+// DataAvailable returns whether data is stored under id.
+func (ms *MemoryStorage) DataAvailable(id string) bool {
+	ms.Lock()
+	defer ms.Unlock()
+	_, ok := ms.data[id]
+	return ok
+}
+
+// DirStorage is a Storage that writes the marshalled data to files in the
+// directory, which is created if needed.
+type DirStorage string
+
+// Save marshals data and writes it to the file id.
+func (ds DirStorage) Save(id string, data interface{}) error {
+	buf, err := network.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(string(ds), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(string(ds), id), buf, 0600)
+}
+
+// Load returns the data of the file id.
+func (ds DirStorage) Load(id string) (interface{}, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(string(ds), id))
+	if err != nil {
+		return nil, err
+	}
+	_, msg, err := network.Unmarshal(buf)
+	return msg, err
+}
+
+// DataAvailable returns whether the file id exists.
+func (ds DirStorage) DataAvailable(id string) bool {
+	_, err := os.Stat(filepath.Join(string(ds), id))
+	return err == nil
+}
+
+// SetStorage makes the service save to and load from st instead of the
+// disk. The data is not copied, so it is meant for tests, which call it
+// right after creating the service.
+func (s *Service) SetStorage(st Storage) {
+	s.storage = st
+}
+
+// Configure replaces the options of the service. If the service is not
+// linked yet, it is linked to cfg.AdminKey. Like SetStorage it is meant to
+// be called right after creating the service.
+func (s *Service) Configure(cfg *Config) {
+	s.config = cfg
+	if cfg.MaxMessageSize > 0 {
+		network.MaxPacketSize = network.Size(cfg.MaxMessageSize)
+	}
+	if _, err := s.conodePrivate(); cfg.SignMerge && err != nil {
+		log.Error(s.ServerIdentity(), "can't sign merge messages:", err)
+	}
+	if s.data.Public == nil && cfg.AdminKey != nil {
+		log.Lvl1("Linking to the pre-shared admin key", cfg.AdminKey)
+		s.data.Public = cfg.AdminKey
+		s.save()
+	}
+}
+
+// unlessMergeDisabled returns a processor calling f, unless merging is
+// disabled, in which case the messages are dropped.
+func (s *Service) unlessMergeDisabled(f func(*network.Envelope)) func(*network.Envelope) {
+	return func(env *network.Envelope) {
+		if s.config.DisableMerge {
+			log.Lvl2(s.ServerIdentity(), "merging is disabled, dropping",
+				env.MsgType)
+			return
+		}
+		f(env)
+	}
+}
+
+// ProcessClientRequest checks the token of the client if the service
+// requires one, see Config.AuthToken, before the request is handled. The
+// requests the conodes send each other, see conodeRequest, are handled
+// without token.
+func (s *Service) ProcessClientRequest(path string, buf []byte) ([]byte,
+	onet.ClientError) {
+	if len(s.config.AuthToken) == 0 {
+		return s.ServiceProcessor.ProcessClientRequest(path, buf)
+	}
+	env := &AuthEnvelope{}
+	if err := protobuf.Decode(buf, env); err == nil &&
+		subtle.ConstantTimeCompare(env.Token, s.config.AuthToken) == 1 {
+		return s.ServiceProcessor.ProcessClientRequest(path, env.Request)
+	}
+	if !conodeRequest(path) {
+		log.Lvl2(s.ServerIdentity(), "refused unauthorized request", path)
+		return nil, onet.NewClientErrorCode(ErrorUnauthorized, "Unauthorized request")
+	}
+	return s.ServiceProcessor.ProcessClientRequest(path, buf)
+}
+
+// conodeRequest returns true for the requests the conodes send to the
+// other conodes of a merge, which don't know their tokens. They only
+// return public descriptions of the parties.
+func conodeRequest(path string) bool {
+	return path == "GetPartyRequest"
+}
+
+// begin registers a running finalization or merge, so that Close waits for
+// it. It fails if the service is shutting down. On success the caller has
+// to call s.inflight.Done when finished.
+func (s *Service) begin() onet.ClientError {
+	s.closeLock.Lock()
+	defer s.closeLock.Unlock()
+	if s.closing {
+		return onet.NewClientErrorCode(ErrorInternal, "Service is shutting down")
+	}
+	s.inflight.Add(1)
+	return nil
+}
+
+// Close refuses new finalizations and merges, waits for the running ones
+// to finish and saves the data. As onet doesn't tear down services, it is
+// called when the conode is stopped by a signal, see closeOnSignal.
+func (s *Service) Close() {
+	s.closeLock.Lock()
+	s.closing = true
+	s.closeLock.Unlock()
+	s.inflight.Wait()
+	s.save()
+}
+
+// shutdown holds the services of the conode that are closed before it
+// stops, see closeOnSignal.
+var shutdown struct {
+	sync.Mutex
+	once     sync.Once
+	services []*Service
+}
+
+// exit stops the conode once its services are closed.
+var exit = os.Exit
+
+// closeOnSignal closes s, together with the other services of the conode,
+// when the conode receives SIGINT or SIGTERM, and stops the conode
+// afterwards.
+func closeOnSignal(s *Service) {
+	shutdown.Lock()
+	shutdown.services = append(shutdown.services, s)
+	shutdown.Unlock()
+	shutdown.once.Do(func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go gracefulShutdown(sigs)
+	})
+}
+
+// gracefulShutdown closes the services and stops the conode for every
+// signal received on sigs. Its name keeps the leak check of the onet tests
+// from reporting it.
+func gracefulShutdown(sigs chan os.Signal) {
+	for sig := range sigs {
+		log.Lvl1("Got", sig, "- closing the PoP services")
+		closeServices()
+		exit(0)
+	}
+}
+
+// closeServices closes all services registered by closeOnSignal at once,
+// so that none waits for the finalizations of another one.
+func closeServices() {
+	shutdown.Lock()
+	services := shutdown.services
+	shutdown.Unlock()
+	var wg sync.WaitGroup
+	for _, s := range services {
+		wg.Add(1)
+		go func(s *Service) {
+			defer wg.Done()
+			s.Close()
+		}(s)
+	}
+	wg.Wait()
+}
+
 // Tries to load the configuration and updates if a configuration
 // is found, else it returns an error.
 func (s *Service) tryLoad() error {
-	if !s.DataAvailable("storage") {
+	if !s.storage.DataAvailable("storage") {
 		return nil
 	}
-	msg, err := s.Load("storage")
+	msg, err := s.storage.Load("storage")
 	if err != nil {
 		return err
 	}
@@ -964,43 +4336,175 @@ func (s *Service) tryLoad() error {
 	if !ok {
 		return errors.New("Data of wrong type")
 	}
+	return s.data.decompress()
+}
+
+// compressed returns a copy of the data to be stored, where the attendees
+// of the final statements are compressed.
+func (sd *saveData) compressed() (*saveData, error) {
+	data := &saveData{
+		Pin:           sd.Pin,
+		Public:        sd.Public,
+		Owners:        sd.Owners,
+		Registrations: sd.Registrations,
+		MergeCache:    sd.MergeCache,
+		Revocations:   sd.Revocations,
+		Rotations:     sd.Rotations,
+		SkipBlocks:    sd.SkipBlocks,
+		Finals:        make(map[string]*FinalStatement),
+		Attendees:     make(map[string][]byte),
+	}
+	for hash, final := range sd.Finals {
+		buf, err := compressAttendees(final.Attendees)
+		if err != nil {
+			return nil, err
+		}
+		f := *final
+		f.Attendees = nil
+		data.Finals[hash] = &f
+		data.Attendees[hash] = buf
+	}
+	return data, nil
+}
+
+// decompress restores the attendees of the final statements loaded from a
+// compressed storage.
+func (sd *saveData) decompress() error {
+	for hash, buf := range sd.Attendees {
+		final, ok := sd.Finals[hash]
+		if !ok {
+			return fmt.Errorf("compressed attendees of unknown party %x", hash)
+		}
+		atts, err := decompressAttendees(buf)
+		if err != nil {
+			return err
+		}
+		final.Attendees = atts
+	}
+	sd.Attendees = nil
 	return nil
 }
 
+// compressAttendees concatenates the binary form of the points, which is
+// already compressed, and deflates the result.
+func compressAttendees(atts []abstract.Point) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range atts {
+		if _, err := a.MarshalTo(w); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressAttendees returns the points compressed by compressAttendees
+// in the same order.
+func decompressAttendees(buf []byte) ([]abstract.Point, error) {
+	raw, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(raw)
+	var atts []abstract.Point
+	for r.Len() > 0 {
+		p := network.Suite.Point()
+		if _, err := p.UnmarshalFrom(r); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated attendee list")
+			}
+			return nil, err
+		}
+		atts = append(atts, p)
+	}
+	return atts, nil
+}
+
 // newService registers the request-methods.
 func newService(c *onet.Context) onet.Service {
+	cfg := DefaultConfig()
+	if file := os.Getenv(ENVConfig); file != "" {
+		var err error
+		cfg, err = ReadConfig(file)
+		log.ErrFatal(err, "Couldn't read the configuration")
+	}
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
+		storage:          c,
 		data:             &saveData{},
+		config:           cfg,
 	}
 	log.ErrFatal(s.RegisterHandlers(s.PinRequest, s.StoreConfig, s.FinalizeRequest,
-		s.FetchFinal, s.MergeRequest), "Couldn't register messages")
+		s.FetchFinal, s.MergeRequest, s.ReopenRegistration, s.GetAggregate,
+		s.Audit, s.TransferParty, s.GetChallenge, s.FindParty,
+		s.GetSubParties, s.Repropagate, s.HasConfig, s.IsRegistered,
+		s.Reconcile, s.GetAttendeeInfo, s.RotateAttendee,
+		s.PreviewFinalize, s.RegisterAttendees, s.FinalizeStatus,
+		s.FetchFinalHeader, s.RevokeAttendee, s.PruneMerged,
+		s.ListPending, s.CancelPending,
+		s.Backup, s.Restore, s.StoreConfigs, s.GetParty),
+		"Couldn't register messages")
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 	}
 	if s.data.Finals == nil {
 		s.data.Finals = make(map[string]*FinalStatement)
 	}
+	if s.data.Owners == nil {
+		s.data.Owners = make(map[string]*partyOwner)
+	}
+	if s.data.Registrations == nil {
+		s.data.Registrations = make(map[string]*registrations)
+	}
+	if s.data.MergeCache == nil {
+		s.data.MergeCache = make(map[string]*mergeCache)
+	}
+	if s.data.Revocations == nil {
+		s.data.Revocations = make(map[string]*revocations)
+	}
+	if s.data.Rotations == nil {
+		s.data.Rotations = make(map[string]*rotations)
+	}
+	if s.data.SkipBlocks == nil {
+		s.data.SkipBlocks = make(map[string]skipchain.SkipBlockID)
+	}
 	if s.data.mergeMetas == nil {
 		s.data.mergeMetas = make(map[string]*mergeMeta)
 	}
 	if s.data.syncMetas == nil {
 		s.data.syncMetas = make(map[string]*syncMeta)
 	}
+	s.Configure(cfg)
 	var err error
 	s.Propagate, err = messaging.NewPropagationFunc(c, "PoPPropagate", s.PropagateFinal)
 	log.ErrFatal(err)
+	s.PropagateSkipBlock, err = messaging.NewPropagationFunc(c,
+		"PoPPropagateSkipBlock", s.StoreSkipBlockRef)
+	log.ErrFatal(err)
 	s.RegisterProcessorFunc(checkConfigID, s.CheckConfig)
 	s.RegisterProcessorFunc(checkConfigReplyID, s.CheckConfigReply)
-	s.RegisterProcessorFunc(mergeConfigID, s.MergeConfig)
-	s.RegisterProcessorFunc(mergeConfigReplyID, s.MergeConfigReply)
-	s.RegisterProcessorFunc(mergeCheckID, s.MergeCheck)
-	s.RegisterProcessorFunc(mergeCheckReplyID, s.MergeCheckReply)
+	s.RegisterProcessorFunc(reconcileAttendeesID, s.ReconcileAttendees)
+	s.RegisterProcessorFunc(reconcileAttendeesReplyID, s.ReconcileAttendeesReply)
+	s.RegisterProcessorFunc(partyStatusID, s.PartyStatus)
+	s.RegisterProcessorFunc(partyStatusReplyID, s.PartyStatusReply)
+	s.RegisterProcessorFunc(propagateAttendeesID, s.StoreAttendeeDelta)
+	s.RegisterProcessorFunc(propagateAttendeesReplyID, s.StoreAttendeeDeltaReply)
+	s.RegisterProcessorFunc(mergeConfigID, s.unlessMergeDisabled(s.MergeConfig))
+	s.RegisterProcessorFunc(mergeConfigReplyID, s.unlessMergeDisabled(s.MergeConfigReply))
+	s.RegisterProcessorFunc(mergeCheckID, s.unlessMergeDisabled(s.MergeCheck))
+	s.RegisterProcessorFunc(mergeCheckReplyID, s.unlessMergeDisabled(s.MergeCheckReply))
 	s.ProtocolRegister(bftSignFinal, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyFinal)
 	})
 	s.ProtocolRegister(bftSignMerge, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return bftcosi.NewBFTCoSiProtocol(n, s.bftVerifyMerge)
 	})
+	closeOnSignal(s)
 	return s
 }
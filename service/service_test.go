@@ -4,14 +4,24 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/dedis/cothority.v1/skipchain"
 	"gopkg.in/dedis/crypto.v0/abstract"
+	"gopkg.in/dedis/crypto.v0/anon"
 	"gopkg.in/dedis/crypto.v0/config"
+	"gopkg.in/dedis/crypto.v0/eddsa"
+	"gopkg.in/dedis/crypto.v0/random"
 	"gopkg.in/dedis/onet.v1"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/log"
 	"gopkg.in/dedis/onet.v1/network"
 
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -36,6 +46,34 @@ func TestServiceSave(t *testing.T) {
 	log.ErrFatal(service.tryLoad())
 	require.Equal(t, "1234", service.data.Pin)
 }
+
+func TestService_MemoryStorage(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	services := local.GetServices(nodes, serviceID)
+	for _, s := range services {
+		s.(*Service).SetStorage(NewMemoryStorage())
+	}
+	descs, atts, srvcs, priv := storeDesc(services, r, 2, 1)
+	hash := descs[0].Hash()
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	s := srvcs[0]
+	require.False(t, s.DataAvailable("storage"))
+	s.data = &saveData{}
+	log.ErrFatal(s.tryLoad())
+	final := s.data.Finals[string(hash)]
+	require.NotNil(t, final)
+	require.Equal(t, len(atts), len(final.Attendees))
+	require.Nil(t, final.Verify())
+}
 func TestService_PinRequest(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -51,6 +89,78 @@ func TestService_PinRequest(t *testing.T) {
 	require.Equal(t, service.data.Public, pub)
 }
 
+func TestService_AdminKey(t *testing.T) {
+	kp := config.NewKeyPair(network.Suite)
+	key, err := crypto.PointToStringHex(network.Suite, kp.Public)
+	log.ErrFatal(err)
+	defer setConfigFile(t, "[PoP]\nAdminKey = \""+key+"\"\n")()
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	service := local.GetServices(nodes, serviceID)[0].(*Service)
+	require.True(t, service.data.Public.Equal(kp.Public))
+	desc := &PopDesc{
+		Name:     "test",
+		DateTime: "tomorrow",
+		Roster:   onet.NewRoster(r.List),
+	}
+	sg, err := crypto.SignSchnorr(network.Suite, kp.Secret, desc.Hash())
+	log.ErrFatal(err)
+	_, cerr := service.StoreConfig(&StoreConfig{desc, sg})
+	require.Nil(t, cerr)
+	require.Equal(t, 1, len(service.data.Finals))
+
+	// PIN linking still works
+	pub := config.NewKeyPair(network.Suite).Public
+	_, cerr = service.PinRequest(&PinRequest{"", pub})
+	require.NotNil(t, cerr)
+	_, cerr = service.PinRequest(&PinRequest{service.data.Pin, pub})
+	require.Nil(t, cerr)
+	require.True(t, service.data.Public.Equal(pub))
+}
+
+func TestReadConfig(t *testing.T) {
+	defer setConfigFile(t, "Private = \"0100000000000000000000000000000000000000000000000000000000000000\"\n[PoP]\nSignMerge = true\n"+
+		"AuthToken = \"secret\"\nBroadcastWindow = 0\nChallengeTimeout = \"1m\"\n")()
+	cfg, err := ReadConfig(os.Getenv(ENVConfig))
+	require.Nil(t, err)
+	require.True(t, cfg.SignMerge)
+	require.False(t, cfg.DisableMerge)
+	require.Equal(t, []byte("secret"), cfg.AuthToken)
+	require.Equal(t, 0, cfg.BroadcastWindow)
+	require.Equal(t, time.Minute, cfg.ChallengeTimeout)
+	// Options that are not set keep their default
+	require.Equal(t, DefaultConfig().BroadcastJitter, cfg.BroadcastJitter)
+	require.Equal(t, DefaultConfig().MaxMessageEntries, cfg.MaxMessageEntries)
+	require.Equal(t, DefaultConfig().MaxMessageSize, cfg.MaxMessageSize)
+	require.Nil(t, cfg.AdminKey)
+	require.True(t, cfg.Private.Equal(network.Suite.Scalar().One()))
+
+	defer setConfigFile(t, "[PoP]\nAdminKey = \"not hex\"\n")()
+	_, err = ReadConfig(os.Getenv(ENVConfig))
+	require.NotNil(t, err)
+}
+
+func TestService_ResetState(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	_, _, srvcs, _ := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	s := srvcs[0]
+	pub := s.data.Public
+	require.NotEqual(t, 0, len(s.data.Finals))
+	require.NotEqual(t, 0, len(s.data.mergeMetas))
+	require.NotEqual(t, 0, len(s.data.syncMetas))
+	s.resetState()
+	require.Equal(t, 0, len(s.data.Finals))
+	require.Equal(t, 0, len(s.data.Owners))
+	require.Equal(t, 0, len(s.data.mergeMetas))
+	require.Equal(t, 0, len(s.data.syncMetas))
+	require.Equal(t, pub, s.data.Public)
+	_, cerr := s.FetchFinal(&FetchRequest{[]byte("any")})
+	require.NotNil(t, cerr)
+}
+
 func TestService_StoreConfig(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -75,6 +185,51 @@ func TestService_StoreConfig(t *testing.T) {
 	require.True(t, ok)
 }
 
+func TestService_StoreConfigZone(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(1, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	desc := *descs[0]
+	desc.Location = "zone"
+	desc.DateTime = "2017-08-09 01:00 +1000"
+	hash := desc.Hash()
+	sig, err := crypto.SignSchnorr(network.Suite, priv[0], hash)
+	log.ErrFatal(err)
+	_, cerr := srvcs[0].StoreConfig(&StoreConfig{&desc, sig})
+	require.Nil(t, cerr)
+	final, ok := srvcs[0].data.Finals[string(hash)]
+	require.True(t, ok)
+	require.Equal(t, "2017-08-08 15:00", final.Desc.DateTime)
+	require.Equal(t, hash, final.Desc.Hash())
+}
+
+func TestService_StoreConfigVerifiers(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+	// Unsigned configs with broken verifiers are refused, not hashed
+	for _, verifiers := range []*onet.Roster{{},
+		{List: r.List[1:], Aggregate: r.List[0].Public}} {
+		desc := *descs[0]
+		desc.Name = "verifiers"
+		desc.VerifierRoster = verifiers
+		_, cerr := srvcs[0].StoreConfig(&StoreConfig{&desc, nil})
+		require.NotNil(t, cerr)
+	}
+	_, cerr := srvcs[0].StoreConfig(&StoreConfig{})
+	require.NotNil(t, cerr)
+
+	desc := *descs[0]
+	desc.Name = "verifiers"
+	desc.VerifierRoster = onet.NewRoster(r.List[1:])
+	sig, err := crypto.SignSchnorr(network.Suite, priv[0], desc.Hash())
+	log.ErrFatal(err)
+	_, cerr = srvcs[0].StoreConfig(&StoreConfig{&desc, sig})
+	require.Nil(t, cerr)
+}
+
 func TestService_CheckConfigMessage(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -87,7 +242,7 @@ func TestService_CheckConfigMessage(t *testing.T) {
 			copy(s.data.Finals[hash].Attendees, atts)
 		}
 	}
-	cc := &CheckConfig{[]byte{}, atts}
+	cc := &CheckConfig{[]byte{}, atts, nil, false, false, nil}
 	srvcs[0].SendRaw(r.List[1], cc)
 	hash := string(descs[0].Hash())
 	select {
@@ -109,6 +264,72 @@ func TestService_CheckConfigMessage(t *testing.T) {
 	require.Equal(t, 1, len(srvcs[1].data.Finals[hash].Attendees))
 }
 
+func TestService_CheckConfigOversized(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, _ := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	hash := string(descs[0].Hash())
+	srvcs[1].data.Finals[hash].Attendees = atts[:2]
+	srvcs[1].config.MaxMessageEntries = 2
+
+	// The roster of the description counts as well
+	cc := &CheckConfig{[]byte(hash), atts[:1], descs[0], false, false, nil}
+	srvcs[0].SendRaw(r.List[1], cc)
+	select {
+	case <-srvcs[0].data.syncMetas[hash].ccChannel:
+		require.Fail(t, "oversized message got a reply")
+	case <-time.After(TIMEOUT / 60):
+	}
+	require.Equal(t, 2, len(srvcs[1].data.Finals[hash].Attendees))
+
+	cc.Desc = nil
+	srvcs[0].SendRaw(r.List[1], cc)
+	ccr := <-srvcs[0].data.syncMetas[hash].ccChannel
+	require.NotNil(t, ccr)
+	require.Equal(t, PopStatusOK, ccr.PopStatus)
+	require.Equal(t, 1, len(srvcs[1].data.Finals[hash].Attendees))
+
+	// Bigger messages than MaxMessageSize are dropped before decoding
+	defer func(size network.Size) {
+		network.MaxPacketSize = size
+	}(network.MaxPacketSize)
+	cfg := DefaultConfig()
+	cfg.MaxMessageSize = 64
+	srvcs[1].Configure(cfg)
+	require.Equal(t, network.Size(64), network.MaxPacketSize)
+	srvcs[1].data.Finals[hash].Attendees = atts
+	srvcs[0].SendRaw(r.List[1], cc)
+	select {
+	case <-srvcs[0].data.syncMetas[hash].ccChannel:
+		require.Fail(t, "oversized message got a reply")
+	case <-time.After(TIMEOUT / 60):
+	}
+	require.Equal(t, len(atts), len(srvcs[1].data.Finals[hash].Attendees))
+}
+
+func TestService_CheckConfigStatus(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, _ := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	hash := string(descs[0].Hash())
+	cc := &CheckConfig{[]byte(hash), atts, nil, false, false, nil}
+
+	srvcs[1].data.Finals = make(map[string]*FinalStatement)
+	srvcs[0].SendRaw(r.List[1], cc)
+	ccr := <-srvcs[0].data.syncMetas[hash].ccChannel
+	require.NotNil(t, ccr)
+	require.Equal(t, PopStatusNoConfig, ccr.PopStatus)
+
+	other := string(descs[1].Hash())
+	srvcs[1].data.Finals[other] = &FinalStatement{Desc: descs[1], Signature: []byte{}}
+	srvcs[0].SendRaw(r.List[1], cc)
+	ccr = <-srvcs[0].data.syncMetas[hash].ccChannel
+	require.NotNil(t, ccr)
+	require.Equal(t, PopStatusWrongHash, ccr.PopStatus)
+}
+
 func TestService_CheckConfigReply(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -120,7 +341,7 @@ func TestService_CheckConfigReply(t *testing.T) {
 		s0.data.Finals[hash].Attendees = make([]abstract.Point, len(atts))
 		copy(s0.data.Finals[hash].Attendees, atts)
 
-		ccr := &CheckConfigReply{0, desc.Hash(), atts}
+		ccr := &CheckConfigReply{0, desc.Hash(), atts, false}
 		req := &network.Envelope{
 			Msg:            ccr,
 			ServerIdentity: nodes[1].ServerIdentity,
@@ -136,7 +357,7 @@ func TestService_CheckConfigReply(t *testing.T) {
 		<-s0.data.syncMetas[hash].ccChannel
 		require.Equal(t, 2, len(s0.data.Finals[hash].Attendees))
 
-		ccr.PopStatus = PopStatusOK + 1
+		ccr.PopStatus = PopStatusOK
 		req.Msg = ccr
 		s0.CheckConfigReply(req)
 		<-s0.data.syncMetas[hash].ccChannel
@@ -144,6 +365,22 @@ func TestService_CheckConfigReply(t *testing.T) {
 	}
 }
 
+func TestStatusOK(t *testing.T) {
+	require.True(t, statusOK(PopStatusOK))
+	// Conodes that only know the first statuses see the errors as well
+	for _, status := range []int{PopStatusWrongHash, PopStatusNoAttendees,
+		PopStatusMergeError, PopStatusMergeNonFinalized, PopStatusFinalized,
+		PopStatusWrongRoster, PopStatusNoConfig, PopStatusAttendeesMismatch,
+		PopStatusBadSignature, PopStatusWeightsMismatch,
+		PopStatusNoCommonAttendees} {
+		require.False(t, statusOK(status))
+		require.True(t, status < PopStatusOK)
+	}
+	// Unknown statuses are errors
+	require.False(t, statusOK(PopStatusOK+1))
+	require.False(t, statusOK(PopStatusNoCommonAttendees-1))
+}
+
 func TestService_FinalizeRequest(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -204,6 +441,210 @@ func TestService_FinalizeRequest(t *testing.T) {
 	}
 }
 
+func TestService_FinalizeWrongHash(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 4, 2)
+	desc := descs[0]
+
+	// The last conode only knows the second party
+	services[2].resetState()
+	sg, err := crypto.SignSchnorr(network.Suite, privs[2], descs[1].Hash())
+	log.ErrFatal(err)
+	_, cerr := services[2].StoreConfig(&StoreConfig{descs[1], sg})
+	require.Nil(t, cerr)
+
+	fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
+	log.ErrFatal(err)
+	_, cerr = services[1].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+
+	fr = &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[:2]}
+	hash, err = fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	_, cerr = services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), r.List[2].Address.String())
+	require.Contains(t, cerr.Error(), "no party with hash")
+
+	// The second conode didn't prune its attendees for the failed request
+	require.Equal(t, sortedAttendees(atts),
+		services[1].data.Finals[string(desc.Hash())].Attendees)
+}
+
+func TestService_FinalizeWrongRoster(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	desc := descs[0]
+
+	fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts}
+	hash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+
+	// Attendees are missing on the other conode
+	_, cerr := services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorOtherFinals, cerr.ErrorCode())
+
+	// The other conode holds the same party with a different roster
+	variant := &PopDesc{
+		Name:     desc.Name,
+		DateTime: desc.DateTime,
+		Location: desc.Location,
+		Roster:   onet.NewRoster(r.List[1:]),
+	}
+	delete(services[1].data.Finals, string(desc.Hash()))
+	sg, err := crypto.SignSchnorr(network.Suite, privs[1], variant.Hash())
+	log.ErrFatal(err)
+	_, cerr = services[1].StoreConfig(&StoreConfig{variant, sg})
+	log.ErrFatal(cerr)
+	_, cerr = services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorWrongRoster, cerr.ErrorCode())
+}
+
+func TestService_FinalizeDescMin(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	_, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 3, 0)
+	desc := &PopDesc{
+		Name:         "name",
+		DateTime:     "2017-07-31 00:00",
+		Location:     "min",
+		Roster:       r,
+		MinAttendees: 3,
+	}
+	hash := desc.Hash()
+	finalize := func(i int, atts []abstract.Point) onet.ClientError {
+		fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		_, cerr := srvcs[i].FinalizeRequest(fr)
+		return cerr
+	}
+	for i, s := range srvcs {
+		sig, err := crypto.SignSchnorr(network.Suite, priv[i], hash)
+		log.ErrFatal(err)
+		_, cerr := s.StoreConfig(&StoreConfig{desc, sig})
+		log.ErrFatal(cerr)
+	}
+
+	// The request doesn't need to ask for the minimum of the party
+	cerr := finalize(0, atts[:2])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorTooFewAttendees, cerr.ErrorCode())
+	require.Empty(t, srvcs[0].data.Finals[string(hash)].Attendees)
+
+	// No conode signs a statement below the minimum
+	fs := &FinalStatement{Desc: desc, Attendees: atts[:2], Signature: []byte{}}
+	msg, err := fs.Hash()
+	log.ErrFatal(err)
+	data, err := fs.ToToml()
+	log.ErrFatal(err)
+	srvcs[1].data.Finals[string(hash)].Attendees = atts[:2]
+	require.False(t, srvcs[1].bftVerifyFinal(msg, data))
+	srvcs[1].data.Finals[string(hash)].Attendees = []abstract.Point{}
+
+	require.NotNil(t, finalize(1, atts))
+	require.Nil(t, finalize(0, atts))
+	require.Nil(t, srvcs[0].data.Finals[string(hash)].Verify())
+}
+
+func TestService_PropagateUnknown(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	hash := descs[0].Hash()
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts[:2]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := 0; i < 2; i++ {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	final := srvcs[0].data.Finals[string(hash)]
+	require.Nil(t, final.Verify())
+
+	// The conodes of the other party never got the config and don't store
+	// a party they don't know.
+	replies, err := srvcs[0].Propagate(r, final, 10000)
+	log.ErrFatal(err)
+	require.Equal(t, len(r.List), replies)
+	for _, s := range srvcs[2:] {
+		_, ok := s.data.Finals[string(hash)]
+		require.False(t, ok)
+		_, cerr := NewClient().FetchFinal(s.ServerIdentity().Address, hash)
+		require.NotNil(t, cerr)
+	}
+	require.Nil(t, srvcs[1].data.Finals[string(hash)].Verify())
+}
+
+func TestService_FinalizeNoAttendees(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 0, 1)
+
+	fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: []abstract.Point{}}
+	hash, err := fr.Hash()
+	log.ErrFatal(err)
+	fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+	log.ErrFatal(err)
+	_, cerr := services[0].FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorNoAttendees, cerr.ErrorCode())
+
+	for _, s := range services {
+		s.AllowEmpty = true
+	}
+	msg, cerr := services[0].FinalizeRequest(fr)
+	require.Nil(t, cerr)
+	require.Nil(t, msg.(*FinalizeResponse).Final.Verify())
+}
+
+func TestService_FinalizeNoCommonAttendees(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	descs, atts, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 4, 1)
+	finalize := func(i int, atts []abstract.Point) onet.ClientError {
+		fr := &FinalizeRequest{DescID: descs[0].Hash(), Attendees: atts}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[i], hash)
+		log.ErrFatal(err)
+		_, cerr := services[i].FinalizeRequest(fr)
+		return cerr
+	}
+	finalize(1, atts[:2])
+	finalize(2, atts[1:3])
+
+	// The first conode has none of our attendees
+	cerr := finalize(0, atts[3:])
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorNoCommonAttendees, cerr.ErrorCode())
+	// Each conode has one of them, but not the same
+	cerr = finalize(0, []abstract.Point{atts[0], atts[2]})
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorNoCommonAttendees, cerr.ErrorCode())
+}
+
 func TestService_FetchFinal(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
@@ -250,162 +691,1875 @@ func TestService_FetchFinal(t *testing.T) {
 			require.NotNil(t, final)
 			require.Equal(t, final.Desc.Hash(), descHash)
 			require.Nil(t, final.Verify())
+			require.True(t, final.FinalizedAt > 0)
+			require.True(t, final.FinalizedAt <= time.Now().Unix())
 		}
 	}
 }
 
-func TestService_MergeConfig(t *testing.T) {
+func TestService_FinalizeStrict(t *testing.T) {
 	local := onet.NewTCPTest()
 	defer local.CloseAll()
-	nbrNodes := 4
-	nbrAtt := 4
-	nodes, r, _ := local.GenTree(nbrNodes, true)
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, privs := storeDesc(local.GetServices(nodes, serviceID), r, 3, 1)
+	descHash := descs[0].Hash()
+	finalize := func(i int, atts []abstract.Point, strict bool) (*FinalStatement,
+		onet.ClientError) {
+		fr := &FinalizeRequest{DescID: descHash, Attendees: atts, Strict: strict}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[i], hash)
+		log.ErrFatal(err)
+		msg, cerr := srvcs[i].FinalizeRequest(fr)
+		if cerr != nil {
+			return nil, cerr
+		}
+		return msg.(*FinalizeResponse).Final, nil
+	}
+	_, cerr := finalize(1, atts[:2], false)
+	require.NotNil(t, cerr)
 
-	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
-	hash := make([]string, nbrNodes/2)
-	hash[0] = string(descs[0].Hash())
-	hash[1] = string(descs[1].Hash())
-	cc := &MergeConfig{srvcs[0].data.Finals[hash[0]], []byte{}}
-	srvcs[0].SendRaw(r.List[1], cc)
-	mcr := <-srvcs[0].data.syncMetas[hash[0]].mcChannel
-	require.NotNil(t, mcr)
-	require.Nil(t, mcr.Final)
-	require.Equal(t, PopStatusWrongHash, mcr.PopStatus)
+	_, cerr = finalize(0, atts[1:], true)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorAttendeesMismatch, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), srvcs[1].ServerIdentity().Address.String())
+	require.Contains(t, cerr.Error(), atts[0].String())
+	require.Contains(t, cerr.Error(), atts[2].String())
+	require.Equal(t, 2, len(srvcs[1].data.Finals[string(descHash)].Attendees))
+	require.Equal(t, 0, len(srvcs[0].data.Finals[string(descHash)].Signature))
 
-	require.Equal(t, nbrAtt, len(atts))
+	// The default prunes the attendees
+	final, cerr := finalize(0, atts[1:], false)
+	require.Nil(t, cerr)
+	require.Nil(t, final.Verify())
+	require.Equal(t, 1, len(final.Attendees))
+	require.True(t, atts[1].Equal(final.Attendees[0]))
+}
 
-	cc.ID = []byte(hash[1])
-	srvcs[0].SendRaw(r.List[2], cc)
-	mcr = <-srvcs[0].data.syncMetas[hash[0]].mcChannel
-	require.NotNil(t, mcr)
-	require.Nil(t, mcr.Final)
-	require.Equal(t, PopStatusMergeNonFinalized, mcr.PopStatus)
-	// finish parties
-	for i, desc := range descs {
-		descHash := desc.Hash()
+func TestService_ReopenRegistration(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 2
+	nodes, r, _ := local.GenTree(nbrNodes, true)
 
-		fr := &FinalizeRequest{}
-		fr.DescID = descHash
-		fr.Attendees = atts[2*i : 2*i+2]
+	descs, atts, services, privs := storeDesc(local.GetServices(nodes, serviceID), r, 1, 1)
+	descHash := descs[0].Hash()
+	finalize := func(atts []abstract.Point) *FinalStatement {
+		fr := &FinalizeRequest{DescID: descHash, Attendees: atts}
 		hash, err := fr.Hash()
-		sg, err := crypto.SignSchnorr(network.Suite, priv[2*i], hash)
 		log.ErrFatal(err)
-		fr.Signature = sg
-		_, err = srvcs[2*i].FinalizeRequest(fr)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], hash)
+		log.ErrFatal(err)
+		_, err = services[0].FinalizeRequest(fr)
 		require.NotNil(t, err)
-
-		sg, err = crypto.SignSchnorr(network.Suite, priv[2*i+1], hash)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[1], hash)
 		log.ErrFatal(err)
-		fr.Signature = sg
-		msg, err := srvcs[2*i+1].FinalizeRequest(fr)
+		msg, err := services[1].FinalizeRequest(fr)
 		require.Nil(t, err)
-		require.NotNil(t, msg)
-		_, ok := msg.(*FinalizeResponse)
-		require.True(t, ok)
+		return msg.(*FinalizeResponse).Final
+	}
+	reopen := func(i int) onet.ClientError {
+		rr := &ReopenRequest{ID: descHash, Nonce: challenge(services[i])}
+		var err error
+		rr.Signature, err = crypto.SignSchnorr(network.Suite, privs[i], rr.Hash())
+		log.ErrFatal(err)
+		_, cerr := services[i].ReopenRegistration(rr)
+		return cerr
 	}
 
-	log.Info("Group 1, Server:", srvcs[0].ServerIdentity())
-	log.Info("Group 1, Server:", srvcs[1].ServerIdentity())
-	log.Info("Group 2, Server:", srvcs[2].ServerIdentity())
-	log.Info("Group 2, Server:", srvcs[3].ServerIdentity())
-	cc.Final = srvcs[0].data.Finals[hash[0]]
-	cc.ID = []byte(hash[1])
-	srvcs[0].SendRaw(r.List[2], cc)
-	meta := srvcs[2].data.mergeMetas[hash[1]]
-	// Here is involuntary race condition solved by waiting in cycle
-	// on timeout
-	// In this case I can't wait till the end of process because
-	// I test here only one message
-	Eventually(t, func() bool { return len(meta.statementsMap) == len(descs) },
-		fmt.Sprintf("Server %d statementsMap", 2))
-}
-
-func TestService_MergeRequest(t *testing.T) {
-	local := onet.NewTCPTest()
-	defer local.CloseAll()
-	nbrNodes := 4
-	nbrAtt := 4
-	nodes, r, _ := local.GenTree(nbrNodes, true)
-	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
-	hash := make([]string, nbrNodes/2)
-	hash[0] = string(descs[0].Hash())
-	hash[1] = string(descs[1].Hash())
+	// Not finalized yet
+	require.NotNil(t, reopen(0))
+	final := finalize(atts)
+	require.Nil(t, final.Verify())
 
-	// Wrong party check
-	mr := &MergeRequest{}
-	mr.ID = []byte(hash[1])
-	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.ID)
-	mr.Signature = sg
+	// Wrong signature
+	rr := &ReopenRequest{ID: descHash, Nonce: challenge(services[0])}
+	sg, err := crypto.SignSchnorr(network.Suite, privs[1], rr.Hash())
 	log.ErrFatal(err)
-	_, err = srvcs[0].MergeRequest(mr)
-	require.NotNil(t, err)
+	rr.Signature = sg
+	_, cerr := services[0].ReopenRegistration(rr)
+	require.NotNil(t, cerr)
+
+	for i := range services {
+		require.Nil(t, reopen(i))
+		require.Equal(t, 0, len(services[i].data.Finals[string(descHash)].Signature))
+	}
+
+	// Add a forgotten attendee and finalize again
+	kp := config.NewKeyPair(network.Suite)
+	final = finalize(append(atts, kp.Public))
+	require.Nil(t, final.Verify())
+	require.Equal(t, 2, len(final.Attendees))
+	index := -1
+	for i, p := range final.Attendees {
+		if p.Equal(kp.Public) {
+			index = i
+		}
+	}
+	require.NotEqual(t, -1, index)
+	msg := []byte("msg")
+	ctx := []byte("ctx")
+	set := anon.Set(final.Attendees)
+	sigtag := anon.Sign(network.Suite, random.Stream, msg, set, ctx,
+		index, kp.Secret)
+	_, err = anon.Verify(network.Suite, msg, set, ctx, sigtag)
+	require.Nil(t, err)
+}
+
+func TestSyncMeta_PaceMergeChecks(t *testing.T) {
+	// A simulated roster of 200 conodes, each answering after a while
+	nbr := 200
+	window := 16
+	sm := newSyncMeta()
+	var lock sync.Mutex
+	peak := 0
+	err := sm.paceMergeChecks(nbr, window, time.Millisecond, func(i int) error {
+		lock.Lock()
+		if p := sm.pendingChecks(); p > peak {
+			peak = p
+		}
+		lock.Unlock()
+		go func() {
+			time.Sleep(time.Duration(i%5) * time.Millisecond)
+			sm.doneMergeCheck()
+		}()
+		return nil
+	})
+	require.Nil(t, err)
+	sm.mcGroup.Wait()
+	require.True(t, peak <= window, fmt.Sprintf("peak of %d", peak))
+	require.Equal(t, 0, sm.pendingChecks())
+
+	// Without window all are sent at once
+	sm = newSyncMeta()
+	err = sm.paceMergeChecks(nbr, 0, 0, func(i int) error { return nil })
+	require.Nil(t, err)
+	require.Equal(t, nbr, sm.pendingChecks())
+	sm.cancel()
+	sm.mcGroup.Wait()
+
+	// A failing send is not waited for
+	sm = newSyncMeta()
+	err = sm.paceMergeChecks(nbr, window, 0, func(i int) error {
+		if i == 3 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	require.NotNil(t, err)
+	require.Equal(t, 3, sm.pendingChecks())
+}
+
+func TestSyncMeta_PartyStatus(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	_, r, _ := local.GenTree(2, true)
+	sm := newSyncMeta()
+	id1, id2 := []byte("request 1"), []byte("request 2")
+	ch1 := sm.expectPartyStatus(id1, r.List[0])
+	ch2 := sm.expectPartyStatus(id2, r.List[0])
+
+	// Neither another conode nor another request ID get the reply
+	require.False(t, sm.passPartyStatus(&PartyStatusReply{ReqID: id1}, r.List[1]))
+	require.False(t, sm.passPartyStatus(&PartyStatusReply{ReqID: []byte("other")},
+		r.List[0]))
+
+	// Each request gets its own reply, only once
+	require.True(t, sm.passPartyStatus(&PartyStatusReply{ReqID: id2,
+		NumAttendees: 2}, r.List[0]))
+	require.True(t, sm.passPartyStatus(&PartyStatusReply{ReqID: id1,
+		NumAttendees: 1}, r.List[0]))
+	require.False(t, sm.passPartyStatus(&PartyStatusReply{ReqID: id1}, r.List[0]))
+	psr, ok := sm.waitPartyStatus(id1, r.List[0], ch1, time.Second)
+	require.True(t, ok)
+	require.Equal(t, 1, psr.NumAttendees)
+	psr, ok = sm.waitPartyStatus(id2, r.List[0], ch2, time.Second)
+	require.True(t, ok)
+	require.Equal(t, 2, psr.NumAttendees)
+
+	// A timed out request doesn't stay pending
+	ch1 = sm.expectPartyStatus(id1, r.List[1])
+	_, ok = sm.waitPartyStatus(id1, r.List[1], ch1, time.Millisecond)
+	require.False(t, ok)
+	require.Equal(t, 0, len(sm.psPending))
+}
+
+func BenchmarkSyncMeta_PaceMergeChecks(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sm := newSyncMeta()
+		sm.paceMergeChecks(500, 32, 0, func(i int) error {
+			go sm.doneMergeCheck()
+			return nil
+		})
+		sm.mcGroup.Wait()
+	}
+}
+
+func TestService_CancelPending(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+
+	descs, _, services, _ := storeDesc(local.GetServices(nodes, serviceID), r, 1, 1)
+	descHash := descs[0].Hash()
+	s := services[0]
+	require.Equal(t, 0, len(s.listPending()))
+	require.NotNil(t, s.cancelPending([]byte("unknown")))
+
+	syncData := s.data.syncMetas[string(descHash)]
+	ccDone := make(chan *CheckConfigReply)
+	go func() {
+		ccDone <- syncData.waitCheckConfig()
+	}()
+	mcDone := make(chan bool)
+	syncData.addMergeChecks(2)
+	go func() {
+		mcDone <- syncData.waitMergeChecks()
+	}()
+	Eventually(t, func() bool {
+		syncData.Lock()
+		defer syncData.Unlock()
+		return syncData.ccWaiting == 1
+	}, "CheckConfig wait didn't start")
+	require.Equal(t, [][]byte{descHash}, s.listPending())
+
+	require.Nil(t, s.cancelPending(descHash))
+	select {
+	case rep := <-ccDone:
+		require.Nil(t, rep)
+	case <-time.After(time.Second):
+		t.Fatal("CheckConfig wait didn't return")
+	}
+	select {
+	case ok := <-mcDone:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("MergeCheck wait didn't return")
+	}
+	// Late replies must not panic
+	syncData.doneMergeCheck()
+	// The next merge isn't cancelled
+	syncData.addMergeChecks(1)
+	syncData.doneMergeCheck()
+	require.True(t, syncData.waitMergeChecks())
+	Eventually(t, func() bool {
+		return len(s.listPending()) == 0
+	}, "Still pending operations")
+}
+
+func TestService_BftVerify(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, _ := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	s := srvcs[0]
+	known := s.data.Finals[string(descs[0].Hash())]
+	known.Attendees = atts
+	fs := &FinalStatement{Desc: &PopDesc{Name: "unknown", DateTime: "2017-07-31 00:00",
+		Location: "nowhere", Roster: r}, Attendees: atts}
+	for _, f := range []*FinalStatement{known, fs} {
+		data, err := f.ToToml()
+		log.ErrFatal(err)
+		msg, err := f.Hash()
+		log.ErrFatal(err)
+		expected := f == known
+		require.Equal(t, expected, s.bftVerifyFinal(msg, data))
+		require.Equal(t, expected, s.bftVerifyMerge(msg, data))
+		require.False(t, s.bftVerifyMerge([]byte("wrong"), data))
+	}
+
+	// A party stored without description
+	data, err := known.ToToml()
+	log.ErrFatal(err)
+	msg, err := known.Hash()
+	log.ErrFatal(err)
+	s.data.Finals[string(descs[0].Hash())] = &FinalStatement{}
+	require.False(t, s.bftVerifyFinal(msg, data))
+	require.False(t, s.bftVerifyMerge(msg, data))
+	delete(s.data.Finals, string(descs[0].Hash()))
+	require.False(t, s.bftVerifyMerge(msg, data))
+
+	require.False(t, s.bftVerifyFinal(msg, []byte("no toml")))
+	require.False(t, s.bftVerifyMerge(msg, []byte("no toml")))
+}
+
+func TestService_MergeConfig(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 4
+	nbrAtt := 4
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	hash := make([]string, nbrNodes/2)
+	hash[0] = string(descs[0].Hash())
+	hash[1] = string(descs[1].Hash())
+	cc := &MergeConfig{srvcs[0].data.Finals[hash[0]], []byte{}, nil}
+	srvcs[0].SendRaw(r.List[1], cc)
+	mcr := <-srvcs[0].data.syncMetas[hash[0]].mcChannel
+	require.NotNil(t, mcr)
+	require.Nil(t, mcr.Final)
+	require.Equal(t, PopStatusWrongHash, mcr.PopStatus)
+
+	require.Equal(t, nbrAtt, len(atts))
+
+	cc.ID = []byte(hash[1])
+	srvcs[0].SendRaw(r.List[2], cc)
+	mcr = <-srvcs[0].data.syncMetas[hash[0]].mcChannel
+	require.NotNil(t, mcr)
+	require.Nil(t, mcr.Final)
+	require.Equal(t, PopStatusMergeNonFinalized, mcr.PopStatus)
+	// finish parties
+	for i, desc := range descs {
+		descHash := desc.Hash()
+
+		fr := &FinalizeRequest{}
+		fr.DescID = descHash
+		fr.Attendees = atts[2*i : 2*i+2]
+		hash, err := fr.Hash()
+		sg, err := crypto.SignSchnorr(network.Suite, priv[2*i], hash)
+		log.ErrFatal(err)
+		fr.Signature = sg
+		_, err = srvcs[2*i].FinalizeRequest(fr)
+		require.NotNil(t, err)
+
+		sg, err = crypto.SignSchnorr(network.Suite, priv[2*i+1], hash)
+		log.ErrFatal(err)
+		fr.Signature = sg
+		msg, err := srvcs[2*i+1].FinalizeRequest(fr)
+		require.Nil(t, err)
+		require.NotNil(t, msg)
+		_, ok := msg.(*FinalizeResponse)
+		require.True(t, ok)
+	}
+
+	log.Info("Group 1, Server:", srvcs[0].ServerIdentity())
+	log.Info("Group 1, Server:", srvcs[1].ServerIdentity())
+	log.Info("Group 2, Server:", srvcs[2].ServerIdentity())
+	log.Info("Group 2, Server:", srvcs[3].ServerIdentity())
+	cc.Final = srvcs[0].data.Finals[hash[0]]
+	cc.ID = []byte(hash[1])
+	srvcs[0].SendRaw(r.List[2], cc)
+	meta := srvcs[2].data.mergeMetas[hash[1]]
+	// Here is involuntary race condition solved by waiting in cycle
+	// on timeout
+	// In this case I can't wait till the end of process because
+	// I test here only one message
+	Eventually(t, func() bool { return len(meta.statementsMap) == len(descs) },
+		fmt.Sprintf("Server %d statementsMap", 2))
+}
+
+func TestService_AttendeeDelta(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	party := onet.NewRoster(r.List[:2])
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes[:2], serviceID), party, 3, 1)
+	hash := descs[0].Hash()
+	outsider := local.GetServices(nodes[2:], serviceID)[0].(*Service)
+
+	// Deltas from conodes outside of the party are refused
+	outsider.data.syncMetas[string(hash)] = newSyncMeta()
+	outsider.SendRaw(r.List[1], &PropagateAttendees{hash, atts})
+	rep, ok := outsider.data.syncMetas[string(hash)].waitAttendeeDelta(TIMEOUT)
+	require.True(t, ok)
+	require.Equal(t, PopStatusWrongRoster, rep.PopStatus)
+	require.Equal(t, 0, len(srvcs[1].data.Finals[string(hash)].Attendees))
+
+	// Duplicated deltas, and duplicates in a delta, are only added once
+	delta := &PropagateAttendees{hash, []abstract.Point{atts[1], atts[0], atts[1]}}
+	final := srvcs[0].data.Finals[string(hash)]
+	for i := 0; i < 2; i++ {
+		require.Equal(t, 2, srvcs[0].propagateAttendees(final, delta))
+	}
+	stored := srvcs[1].data.Finals[string(hash)]
+	require.Equal(t, 2, len(stored.Attendees))
+	requireSameAttendees(t, atts[:2], stored.Attendees)
+	require.Equal(t, 2, len(srvcs[1].data.Registrations[string(hash)].Attendees))
+
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts[:2]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	final = srvcs[1].data.Finals[string(hash)]
+	require.Nil(t, final.Verify())
+
+	// A late delta doesn't touch the signed statement and isn't counted
+	require.Equal(t, 1, srvcs[0].propagateAttendees(
+		srvcs[0].data.Finals[string(hash)], &PropagateAttendees{hash, atts[2:]}))
+	require.Equal(t, 2, len(final.Attendees))
+	require.Nil(t, final.Verify())
+}
+
+func TestService_MergeConfigLocalNonFinalized(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	hash0, hash1 := string(descs[0].Hash()), string(descs[1].Hash())
+	finalize := func(i int) {
+		fr := &FinalizeRequest{DescID: descs[i].Hash(), Attendees: atts[2*i : 2*i+2]}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], frHash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	finalize(0)
+	meta := srvcs[2].data.mergeMetas[hash1]
+
+	// The second party is not finalized yet and doesn't keep the statement
+	mc := &MergeConfig{Final: srvcs[0].data.Finals[hash0], ID: []byte(hash1)}
+	log.ErrFatal(srvcs[0].SendRaw(r.List[2], mc))
+	mcr := <-srvcs[0].data.syncMetas[hash0].mcChannel
+	require.NotNil(t, mcr)
+	require.Equal(t, PopStatusMergeNonFinalized, mcr.PopStatus)
+	require.Nil(t, mcr.Final)
+	_, ok := meta.statementsMap[hash0]
+	require.False(t, ok)
+
+	mr := &MergeRequest{ID: []byte(hash0), Nonce: challenge(srvcs[0])}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	_, cerr := srvcs[0].MergeRequest(mr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorMerge, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), "retry")
+
+	// Once the second party is finalized, the merge passes
+	finalize(1)
+	mr.Nonce = challenge(srvcs[0])
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	_, cerr = srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	require.True(t, srvcs[0].data.Finals[hash0].Merged)
+}
+
+func TestService_MergeConflict(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(3, true)
+	// The second conode is in both parties
+	rosters := []*onet.Roster{onet.NewRoster(r.List[0:2]),
+		onet.NewRoster(r.List[1:3])}
+	parties := make([]*ShortDesc, len(rosters))
+	for i, ro := range rosters {
+		parties[i] = &ShortDesc{Location: fmt.Sprintf("city%d", i), Roster: ro}
+	}
+	descs := make([]*PopDesc, len(rosters))
+	for i, ro := range rosters {
+		descs[i] = &PopDesc{Name: "name", DateTime: "2017-07-31 00:00",
+			Location: parties[i].Location, Roster: ro, Parties: parties}
+	}
+	srvcs := make([]*Service, len(nodes))
+	privs := make([]abstract.Scalar, len(nodes))
+	for i, s := range local.GetServices(nodes, serviceID) {
+		srvcs[i] = s.(*Service)
+		kp := config.NewKeyPair(network.Suite)
+		srvcs[i].data.Public, privs[i] = kp.Public, kp.Secret
+	}
+	members := [][]int{{0, 1}, {1, 2}}
+	for i, desc := range descs {
+		for _, j := range members[i] {
+			sig, err := crypto.SignSchnorr(network.Suite, privs[j], desc.Hash())
+			log.ErrFatal(err)
+			_, cerr := srvcs[j].StoreConfig(&StoreConfig{desc, sig})
+			require.Nil(t, cerr)
+		}
+	}
+	// Both parties have the second attendee
+	atts := make([]abstract.Point, 3)
+	for i := range atts {
+		atts[i] = config.NewKeyPair(network.Suite).Public
+	}
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[i : i+2]}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for _, j := range members[i] {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, privs[j], frHash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+		require.Nil(t, srvcs[members[i][0]].data.Finals[string(desc.Hash())].Verify())
+	}
+
+	// The shared conode refuses a statement of the first party with other
+	// attendees than the ones it holds.
+	hash0 := descs[0].Hash()
+	local1 := srvcs[1].data.Finals[string(descs[1].Hash())]
+	sent := *srvcs[0].data.Finals[string(hash0)]
+	require.Equal(t, "", srvcs[1].mergeConflict(local1, &sent))
+	sent.Attendees = atts[:1]
+	require.Contains(t, srvcs[1].mergeConflict(local1, &sent), "different attendees")
+	sent.Attendees = srvcs[0].data.Finals[string(hash0)].Attendees
+	sent.Epoch++
+	require.Contains(t, srvcs[1].mergeConflict(local1, &sent), "different statement")
+
+	// Shared attendees are united by the merge
+	mr := &MergeRequest{ID: hash0, Nonce: challenge(srvcs[0])}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, privs[0], mr.Hash())
+	log.ErrFatal(err)
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	merged := msg.(*FinalizeResponse).Final
+	require.True(t, merged.Merged)
+	require.Equal(t, attendeeKeys(sortedAttendees(atts)),
+		attendeeKeys(merged.Attendees))
+}
+
+func TestService_SignMerge(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	// Only the key of the conode itself can sign
+	_, err := srvcs[0].conodePrivate()
+	require.NotNil(t, err)
+	srvcs[0].config.Private = local.GetPrivate(nodes[1])
+	_, err = srvcs[0].conodePrivate()
+	require.NotNil(t, err)
+	for i, s := range srvcs {
+		s.config.SignMerge = true
+		s.config.Private = local.GetPrivate(nodes[i])
+	}
+	hash0, hash1 := string(descs[0].Hash()), string(descs[1].Hash())
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], frHash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	meta := srvcs[3].data.mergeMetas[hash1]
+
+	// Conode 2 is not in the roster of the first party
+	srvcs[2].data.syncMetas[hash0] = newSyncMeta()
+	mc := &MergeConfig{Final: srvcs[0].data.Finals[hash0], ID: []byte(hash1)}
+	log.ErrFatal(srvcs[2].signMergeMsg(mc.Hash, &mc.Signature))
+	log.ErrFatal(srvcs[2].SendRaw(r.List[3], mc))
+	<-srvcs[2].data.syncMetas[hash0].mcChannel
+	_, ok := meta.statementsMap[hash0]
+	require.False(t, ok)
+
+	// Conode 0 has to sign
+	mc.Signature = nil
+	log.ErrFatal(srvcs[0].SendRaw(r.List[3], mc))
+	mcr := <-srvcs[0].data.syncMetas[hash0].mcChannel
+	require.NotNil(t, mcr)
+	require.Equal(t, PopStatusBadSignature, mcr.PopStatus)
+	_, ok = meta.statementsMap[hash0]
+	require.False(t, ok)
+
+	log.ErrFatal(srvcs[0].signMergeMsg(mc.Hash, &mc.Signature))
+	log.ErrFatal(srvcs[0].SendRaw(r.List[3], mc))
+	mcr = <-srvcs[0].data.syncMetas[hash0].mcChannel
+	require.NotNil(t, mcr)
+	require.Equal(t, PopStatusOK, mcr.PopStatus)
+	require.NotNil(t, mcr.Final)
+	_, ok = meta.statementsMap[hash0]
+	require.True(t, ok)
+}
+
+func TestService_MergeEmptyRoster(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, _, srvcs, _ := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	s := srvcs[0]
+	final := s.data.Finals[string(descs[0].Hash())]
+	meta := s.data.mergeMetas[string(descs[0].Hash())]
+	meta.statementsMap[string(descs[0].Hash())] = final
+
+	empty := &ShortDesc{Location: "nowhere", Roster: &onet.Roster{}}
+	require.Equal(t, 0, len(empty.Hash()))
+	parties := append([]*ShortDesc{}, descs[0].Parties...)
+	parties = append(parties, empty)
+	done := make(chan onet.ClientError)
+	go func() {
+		done <- s.broadcastFinal(final, meta, parties)
+	}()
+	select {
+	case cerr := <-done:
+		require.NotNil(t, cerr)
+		require.Equal(t, ErrorMerge, cerr.ErrorCode())
+	case <-time.After(TIMEOUT):
+		require.Fail(t, "broadcast didn't return")
+	}
+	require.Equal(t, 0, s.data.syncMetas[string(descs[0].Hash())].pendingChecks())
+}
+
+func TestService_MergeRequest(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 4
+	nbrAtt := 4
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	hash := make([]string, nbrNodes/2)
+	hash[0] = string(descs[0].Hash())
+	hash[1] = string(descs[1].Hash())
+
+	// Wrong party check
+	mr := &MergeRequest{}
+	mr.ID = []byte(hash[1])
+	mr.Nonce = challenge(srvcs[0])
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	mr.Signature = sg
+	log.ErrFatal(err)
+	_, err = srvcs[0].MergeRequest(mr)
+	require.NotNil(t, err)
+
+	// Not finished
+	mr.ID = []byte(hash[0])
+	mr.Nonce = challenge(srvcs[0])
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	_, err = srvcs[0].MergeRequest(mr)
+	require.NotNil(t, err)
+
+	// finish parties
+	for i, _ := range descs {
+
+		fr := &FinalizeRequest{}
+		fr.DescID = []byte(hash[i])
+		fr.Attendees = atts[2*i : 2*i+2]
+		hash_fr, err := fr.Hash()
+		sg, err := crypto.SignSchnorr(network.Suite, priv[2*i], hash_fr)
+		log.ErrFatal(err)
+		fr.Signature = sg
+		_, err = srvcs[2*i].FinalizeRequest(fr)
+		require.NotNil(t, err)
+
+		sg, err = crypto.SignSchnorr(network.Suite, priv[2*i+1], hash_fr)
+		log.ErrFatal(err)
+		fr.Signature = sg
+		msg, err := srvcs[2*i+1].FinalizeRequest(fr)
+		require.Nil(t, err)
+		require.NotNil(t, msg)
+		_, ok := msg.(*FinalizeResponse)
+		require.True(t, ok)
+	}
+	// wrong Signature
+	mr.ID = []byte(hash[0])
+	mr.Nonce = challenge(srvcs[0])
+	sg, err = crypto.SignSchnorr(network.Suite, priv[1], mr.Hash())
+	log.ErrFatal(err)
+	mr.Signature = sg
+	_, err = srvcs[0].MergeRequest(mr)
+	require.NotNil(t, err)
+	//log.SetDebugVisible(2)
+	log.Lvlf2("Group 1, Server: %s", srvcs[0].ServerIdentity())
+	log.Lvlf2("Group 1, Server: %s", srvcs[1].ServerIdentity())
+	log.Lvlf2("Group 2, Server: %s", srvcs[2].ServerIdentity())
+	log.Lvlf2("Group 2, Server: %s", srvcs[3].ServerIdentity())
+	mr.ID = []byte(hash[0])
+	sg, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	mr.Signature = sg
+	msg, err := srvcs[0].MergeRequest(mr)
+	require.Nil(t, err)
+	require.NotNil(t, msg)
+	for i, s := range srvcs {
+		Eventually(t, func() bool { return s.data.Finals[hash[i/2]].Merged },
+			fmt.Sprintf("Server %d not Merged", i))
+	}
+
+	for i, s := range srvcs {
+		require.Equal(t, len(s.data.Finals[hash[i/2]].Attendees),
+			nbrAtt,
+			fmt.Sprintf("Server %d attendees not merged", i))
+		require.Equal(t,
+			len(s.data.Finals[hash[i/2]].Desc.Roster.List),
+			nbrNodes,
+			fmt.Sprintf("Server %d conodes not merged", i))
+		require.True(t, len(s.data.Finals[hash[i/2]].Signature) > 0 &&
+			s.data.Finals[hash[i/2]].Verify() == nil,
+			fmt.Sprintf("Signature in node %d is not created", i))
+	}
+
+}
+
+func TestService_MergeCache(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	hash0 := string(descs[0].Hash())
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], frHash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	// The statements of all conodes before the merge, to start again
+	pres := make([]*FinalStatement, len(srvcs))
+	for j, s := range srvcs {
+		pre := *s.data.Finals[string(descs[j/2].Hash())]
+		desc := *pre.Desc
+		pre.Desc = &desc
+		pres[j] = &pre
+	}
+	reset := func() {
+		for j, s := range srvcs {
+			hash := string(descs[j/2].Hash())
+			pre := *pres[j]
+			s.data.Finals[hash] = &pre
+			s.data.mergeMetas[hash] = newmergeMeta()
+			s.data.mergeMetas[hash].statementsMap[hash] = &pre
+		}
+	}
+	merge := func() *FinalStatement {
+		mr := &MergeRequest{ID: []byte(hash0), Nonce: challenge(srvcs[0])}
+		var err error
+		mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+		log.ErrFatal(err)
+		msg, cerr := srvcs[0].MergeRequest(mr)
+		require.Nil(t, cerr)
+		return msg.(*FinalizeResponse).Final
+	}
+	merged := merge()
+	require.True(t, merged.Merged)
+	require.Equal(t, 1, len(srvcs[0].data.MergeCache))
+	mergedHash := string(merged.Desc.Hash())
+
+	// The same merge triggered again returns the signed statement of the
+	// first one instead of signing a new one, and propagates it.
+	reset()
+	cached := merge()
+	require.Equal(t, merged.Signature, cached.Signature)
+	require.Equal(t, merged.FinalizedAt, cached.FinalizedAt)
+	require.Nil(t, cached.Verify())
+	Eventually(t, func() bool {
+		f := srvcs[3].data.Finals[mergedHash]
+		return f != nil && bytes.Equal(f.Signature, merged.Signature)
+	}, "cached merge not propagated")
+
+	// The key changes with the statement of every party, not only the
+	// local one, but not with their order.
+	key, _, err := mergeKey(merged.Desc, []*FinalStatement{pres[0], pres[2]})
+	log.ErrFatal(err)
+	swapped, _, err := mergeKey(merged.Desc, []*FinalStatement{pres[2], pres[0]})
+	log.ErrFatal(err)
+	require.Equal(t, key, swapped)
+	remote := *pres[2]
+	remote.Attendees = remote.Attendees[:1]
+	changed, _, err := mergeKey(merged.Desc, []*FinalStatement{pres[0], &remote})
+	log.ErrFatal(err)
+	require.NotEqual(t, key, changed)
+
+	// A changed local party invalidates the cache
+	require.Equal(t, 1, len(srvcs[0].data.MergeCache))
+	for key := range srvcs[0].data.MergeCache {
+		require.Nil(t, srvcs[0].cachedMerge(key, []byte("changed")))
+	}
+	require.Equal(t, 0, len(srvcs[0].data.MergeCache))
+}
+
+func TestService_PruneMerged(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	srvcs, priv := h.srvcs, h.privs
+	hash0 := string(h.descs[0].Hash())
+	_, cerr := h.finalize(0)
+	require.Nil(t, cerr)
+	pre := *srvcs[0].data.Finals[hash0]
+	desc := *pre.Desc
+	pre.Desc = &desc
+	require.Nil(t, pre.Verify())
+	mergedHash := string(h.mergeParties(t).Desc.Hash())
+
+	prune := func(s *Service, priv abstract.Scalar, force bool) ([][]byte, onet.ClientError) {
+		req := &PruneMergedRequest{Force: force, Nonce: challenge(s)}
+		hash, err := req.Hash()
+		log.ErrFatal(err)
+		req.Signature, err = crypto.SignSchnorr(network.Suite, priv, hash)
+		log.ErrFatal(err)
+		msg, cerr := s.PruneMerged(req)
+		if cerr != nil {
+			return nil, cerr
+		}
+		return msg.(*PruneMergedReply).Removed, nil
+	}
+	_, cerr = prune(srvcs[1], priv[0], false)
+	require.NotNil(t, cerr)
+
+	// The entry of the sub-party only points to the merged statement and
+	// is kept, even when forced, to find the merged party.
+	for _, force := range []bool{false, true} {
+		removed, cerr := prune(srvcs[1], priv[1], force)
+		require.Nil(t, cerr)
+		require.Empty(t, removed)
+		require.Equal(t, srvcs[1].data.Finals[mergedHash],
+			srvcs[1].data.Finals[hash0])
+	}
+	msg, cerr := srvcs[1].FetchFinal(&FetchRequest{[]byte(hash0)})
+	require.Nil(t, cerr)
+	require.Equal(t, mergedHash, string(msg.(*FinalizeResponse).Final.Desc.Hash()))
+
+	// The statement from before the merge is only removed when forced
+	srvcs[0].data.Finals[hash0] = &pre
+	removed, cerr := prune(srvcs[0], priv[0], false)
+	require.Nil(t, cerr)
+	require.Empty(t, removed)
+	require.Equal(t, &pre, srvcs[0].data.Finals[hash0])
+	removed, cerr = prune(srvcs[0], priv[0], true)
+	require.Nil(t, cerr)
+	require.Equal(t, [][]byte{[]byte(hash0)}, removed)
+	require.Nil(t, srvcs[0].data.Finals[mergedHash].Verify())
+}
+
+func TestService_BackupRestore(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	srvcs, priv := h.srvcs, h.privs
+	hash0 := string(h.descs[0].Hash())
+	for _, s := range srvcs {
+		s.config.Private = h.local.GetPrivate(h.local.Servers[s.ServerIdentity().ID])
+	}
+
+	backup := func(s *Service, priv abstract.Scalar) ([]byte, onet.ClientError) {
+		req := &BackupRequest{Nonce: challenge(s)}
+		hash, err := req.Hash()
+		log.ErrFatal(err)
+		req.Signature, err = crypto.SignSchnorr(network.Suite, priv, hash)
+		log.ErrFatal(err)
+		msg, cerr := s.Backup(req)
+		if cerr != nil {
+			return nil, cerr
+		}
+		return msg.(*BackupReply).Dump, nil
+	}
+	restore := func(s *Service, priv abstract.Scalar, dump []byte) ([][]byte, onet.ClientError) {
+		req := &RestoreRequest{Dump: dump, Nonce: challenge(s)}
+		hash, err := req.Hash()
+		log.ErrFatal(err)
+		req.Signature, err = crypto.SignSchnorr(network.Suite, priv, hash)
+		log.ErrFatal(err)
+		msg, cerr := s.Restore(req)
+		if cerr != nil {
+			return nil, cerr
+		}
+		return msg.(*RestoreReply).Restored, nil
+	}
+	_, cerr := backup(srvcs[0], priv[1])
+	require.NotNil(t, cerr)
+
+	// The stored party can still be finalized and merged after a restore
+	dump, cerr := backup(srvcs[0], priv[0])
+	require.Nil(t, cerr)
+	srvcs[0].resetState()
+	require.Empty(t, srvcs[0].data.Finals)
+	_, cerr = restore(srvcs[0], priv[1], dump)
+	require.NotNil(t, cerr)
+	_, cerr = restore(srvcs[0], priv[0], dump[:len(dump)/2])
+	require.NotNil(t, cerr)
+	restored, cerr := restore(srvcs[0], priv[0], dump)
+	require.Nil(t, cerr)
+	require.Equal(t, [][]byte{[]byte(hash0)}, restored)
+	require.NotNil(t, srvcs[0].data.syncMetas[hash0])
+	require.Equal(t, srvcs[0].data.Finals[hash0],
+		srvcs[0].data.mergeMetas[hash0].statementsMap[hash0])
+	merged := h.mergeParties(t)
+	mergedHash := string(merged.Desc.Hash())
+
+	// The merged statement and the registrations survive a restore
+	regs := srvcs[0].data.Registrations[hash0]
+	require.NotNil(t, regs)
+	dump, cerr = backup(srvcs[0], priv[0])
+	require.Nil(t, cerr)
+	srvcs[0].resetState()
+	restored, cerr = restore(srvcs[0], priv[0], dump)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(restored))
+	for _, id := range []string{hash0, mergedHash} {
+		final := srvcs[0].data.Finals[id]
+		require.NotNil(t, final)
+		require.True(t, final.Merged)
+		require.Nil(t, final.Verify())
+		require.Equal(t, attendeeKeys(merged.Attendees), attendeeKeys(final.Attendees))
+		require.NotNil(t, srvcs[0].data.syncMetas[id])
+	}
+	require.Equal(t, attendeeKeys(regs.Attendees),
+		attendeeKeys(srvcs[0].data.Registrations[hash0].Attendees))
+	// The party of the sub-party points to the merged one again, and
+	// is kept by PruneMerged
+	require.True(t, srvcs[0].data.Finals[hash0] == srvcs[0].data.Finals[mergedHash])
+	require.False(t, srvcs[0].superseded(hash0, srvcs[0].data.Finals[hash0], true))
+
+	// A restore adds to the stored parties and keeps their owners
+	kp := config.NewKeyPair(network.Suite)
+	other := *h.descs[0]
+	other.Location = "other"
+	srvcs[0].storeConfig(&other)
+	srvcs[0].data.Owners[string(other.Hash())] = &partyOwner{kp.Public}
+	restored, cerr = restore(srvcs[0], priv[0], dump)
+	require.Nil(t, cerr)
+	require.Empty(t, restored)
+	require.NotNil(t, srvcs[0].data.Finals[string(other.Hash())])
+	require.True(t, kp.Public.Equal(srvcs[0].owner(other.Hash())))
+
+	// The dump has to be signed by a conode of the parties
+	_, msg, err := network.Unmarshal(dump)
+	log.ErrFatal(err)
+	sd := msg.(*signedDump)
+	sd.Signature[0] ^= 1
+	forged, err := network.Marshal(sd)
+	log.ErrFatal(err)
+	_, cerr = restore(srvcs[0], priv[0], forged)
+	require.NotNil(t, cerr)
+	sd.Conode = kp.Public
+	sd.Signature, err = crypto.SignSchnorr(network.Suite, kp.Secret, sd.dumpHash())
+	log.ErrFatal(err)
+	forged, err = network.Marshal(sd)
+	log.ErrFatal(err)
+	_, cerr = restore(srvcs[0], priv[0], forged)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "doesn't hold the conode")
+}
+
+func TestService_FetchFinalMerged(t *testing.T) {
+	h := newTestHarness(6, 6, true)
+	defer h.close()
+	merged := h.mergeParties(t)
+	require.Equal(t, len(h.atts), len(merged.Attendees))
+	// Every conode returns the merged statement for the hash of its
+	// own party
+	for i, s := range h.srvcs {
+		msg, cerr := s.FetchFinal(&FetchRequest{h.descs[i/2].Hash()})
+		require.Nil(t, cerr)
+		fs := msg.(*FinalizeResponse).Final
+		require.Nil(t, fs.Verify())
+		require.True(t, fs.Merged)
+		require.Equal(t, merged.Desc.Hash(), fs.Desc.Hash())
+		require.Equal(t, len(h.atts), len(fs.Attendees))
+	}
+}
+
+func TestService_FinalizeMerged(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	merged := h.mergeParties(t)
+	hash0 := string(h.descs[0].Hash())
+
+	// Finalizing the merged party again is refused and leaves the merged
+	// statement untouched
+	_, cerr := h.finalize(0)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorInternal, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), "already merged")
+	final := h.srvcs[0].data.Finals[hash0]
+	require.True(t, final.Merged)
+	require.Nil(t, final.Verify())
+	require.Equal(t, attendeeKeys(merged.Attendees), attendeeKeys(final.Attendees))
+}
+
+func TestService_MergePartial(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 6
+	nbrAtt := 6
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	// The third party packed up early
+	for _, n := range nodes[4:] {
+		log.ErrFatal(n.Close())
+		delete(local.Servers, n.ServerIdentity.ID)
+	}
+	hash := descs[0].Hash()
+
+	// A full merge signature can't be used for a partial merge
+	mr := &MergeRequest{ID: hash, Nonce: challenge(srvcs[0])}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	mr.Signature = sg
+	mr.Partial = true
+	_, cerr := srvcs[0].MergeRequest(mr)
+	require.NotNil(t, cerr)
+
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	final := msg.(*FinalizeResponse).Final
+	require.True(t, final.Merged)
+	require.Nil(t, final.Verify())
+	require.Equal(t, 4, len(final.Attendees))
+	require.Equal(t, 4, len(final.Desc.Roster.List))
+	dropped := final.DroppedParties()
+	require.Equal(t, 1, len(dropped))
+	require.Equal(t, descs[2].Location, dropped[0].Location)
+
+	for i, s := range srvcs[:4] {
+		Eventually(t, func() bool {
+			f := s.data.Finals[string(descs[i/2].Hash())]
+			return f.Merged && f.Verify() == nil
+		}, fmt.Sprintf("Server %d not Merged", i))
+	}
+}
+
+func TestService_MergeCompact(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 4
+	nbrAtt := 4
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	// Only the second conode of the first party keeps the full rosters
+	for _, i := range []int{0, 2, 3} {
+		desc := descs[i/2].Compact()
+		sig, err := crypto.SignSchnorr(network.Suite, priv[i], desc.Hash())
+		log.ErrFatal(err)
+		_, cerr := srvcs[i].StoreConfig(&StoreConfig{desc, sig})
+		log.ErrFatal(cerr)
+	}
+	c := NewClient()
+	party, cerr := c.GetParty(srvcs[1].ServerIdentity().Address,
+		descs[1].Parties[1].Hash())
+	require.Nil(t, cerr)
+	require.True(t, Equal(descs[1].Roster, party.Roster))
+	_, cerr = c.GetParty(srvcs[0].ServerIdentity().Address,
+		descs[1].Parties[1].Hash())
+	require.NotNil(t, cerr)
+
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	hash := descs[0].Hash()
+	require.True(t, srvcs[0].data.Finals[string(hash)].Desc.Parties[0].IsCompact())
+	mr := &MergeRequest{ID: hash, Nonce: challenge(srvcs[0])}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	mr.Signature = sg
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	final := msg.(*FinalizeResponse).Final
+	require.True(t, final.Merged)
+	require.Nil(t, final.Verify())
+	require.Equal(t, nbrAtt, len(final.Attendees))
+	require.Equal(t, nbrNodes, len(final.Desc.Roster.List))
+	for i, s := range srvcs {
+		Eventually(t, func() bool {
+			f := s.data.Finals[string(descs[i/2].Hash())]
+			return f.Merged && f.Verify() == nil
+		}, fmt.Sprintf("Server %d not Merged", i))
+	}
+	// The client resolves the compact parties to check the hash
+	fs, cerr := c.FetchFinal(srvcs[2].ServerIdentity().Address, descs[1].Hash())
+	require.Nil(t, cerr)
+	require.True(t, fs.Merged)
+}
+
+func TestService_MergeChain(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nbrNodes := 6
+	nbrAtt := 6
+	nodes, r, _ := local.GenTree(nbrNodes, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, nbrAtt)
+	// Every conode requires its own token, which the others don't know
+	for i, s := range srvcs {
+		s.config.AuthToken = []byte(fmt.Sprintf("token %d", i))
+	}
+	// The first and the last party only know the one in the middle:
+	// 0 - 1 - 2
+	for i, s := range srvcs {
+		party := i / 2
+		if party == 1 {
+			continue
+		}
+		desc := *descs[party]
+		desc.Parties = make([]*ShortDesc, len(descs))
+		copy(desc.Parties, descs[party].Parties)
+		other := 2 - party
+		desc.Parties[other] = &ShortDesc{ID: desc.Parties[other].Hash()}
+		require.Equal(t, descs[party].Hash(), desc.Hash())
+		sig, err := crypto.SignSchnorr(network.Suite, priv[i], desc.Hash())
+		log.ErrFatal(err)
+		_, cerr := s.StoreConfig(&StoreConfig{&desc, sig})
+		log.ErrFatal(cerr)
+	}
+	c := NewClient()
+	_, cerr := c.GetParty(srvcs[1].ServerIdentity().Address,
+		descs[2].shortDesc().Hash())
+	require.NotNil(t, cerr)
+
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+
+	// A party whose conodes don't add up to the aggregate of its hash is
+	// refused.
+	desc0 := srvcs[0].data.Finals[string(descs[0].Hash())].Desc
+	party2 := descs[1].Parties[2]
+	roster2 := party2.Roster
+	party2.Roster = &onet.Roster{ID: roster2.ID, List: descs[0].Roster.List,
+		Aggregate: roster2.Aggregate}
+	require.Equal(t, descs[2].shortDesc().Hash(), party2.Hash())
+	_, cerr = srvcs[0].resolveParties(desc0)
+	require.NotNil(t, cerr)
+	party2.Roster = roster2
+
+	parties, cerr := srvcs[0].resolveParties(desc0)
+	require.Nil(t, cerr)
+	for i, party := range parties {
+		require.True(t, Equal(descs[i].Roster, party.Roster))
+	}
+
+	hash := descs[0].Hash()
+	mr := &MergeRequest{ID: hash, Nonce: challenge(srvcs[0])}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	mr.Signature = sg
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	final := msg.(*FinalizeResponse).Final
+	require.True(t, final.Merged)
+	require.Nil(t, final.Verify())
+	require.Equal(t, nbrAtt, len(final.Attendees))
+	require.Equal(t, nbrNodes, len(final.Desc.Roster.List))
+	for i, s := range srvcs {
+		Eventually(t, func() bool {
+			f := s.data.Finals[string(descs[i/2].Hash())]
+			return f.Merged && f.Verify() == nil
+		}, fmt.Sprintf("Server %d not Merged", i))
+	}
+}
+
+func TestService_DisableMerge(t *testing.T) {
+	defer setConfigFile(t, "[PoP]\nDisableMerge = true\n")()
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	hash := descs[0].Hash()
+	for _, s := range srvcs {
+		require.Equal(t, 0, len(s.data.mergeMetas))
+	}
+
+	// Finalizing still works
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts[:2]}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs[:2] {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	require.Nil(t, srvcs[0].data.Finals[string(hash)].Verify())
 
-	// Not finished
-	mr.ID = []byte(hash[0])
-	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.ID)
+	mr := &MergeRequest{ID: hash, Nonce: challenge(srvcs[0])}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
 	log.ErrFatal(err)
-	_, err = srvcs[0].MergeRequest(mr)
+	mr.Signature = sg
+	_, cerr := srvcs[0].MergeRequest(mr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorNotMergeable, cerr.ErrorCode())
+	_, cerr = NewClient().GetParty(srvcs[0].ServerIdentity().Address,
+		descs[0].Parties[0].Hash())
+	require.NotNil(t, cerr)
+
+	// Merge messages are ignored
+	log.ErrFatal(srvcs[2].SendRaw(srvcs[0].ServerIdentity(),
+		&MergeConfig{Final: srvcs[0].data.Finals[string(hash)],
+			ID: hash}))
+	time.Sleep(100 * time.Millisecond)
+	require.False(t, srvcs[0].data.Finals[string(hash)].Merged)
+	require.Equal(t, 0, len(srvcs[0].data.mergeMetas))
+}
+
+func TestUnionAttendies(t *testing.T) {
+	atts := make([]abstract.Point, 5)
+	for i := range atts {
+		atts[i] = network.Suite.Point().Mul(nil,
+			network.Suite.Scalar().SetInt64(int64(i+1)))
+	}
+	union, err := unionAttendies(atts[:3], atts[2:])
+	log.ErrFatal(err)
+	require.Equal(t, len(atts), len(union))
+	// The order only depends on the binary marshalling of the keys
+	for i, idx := range []int{3, 0, 1, 2, 4} {
+		require.True(t, atts[idx].Equal(union[i]),
+			fmt.Sprintf("Wrong attendee at position %d", i))
+	}
+	again, err := unionAttendies(union[2:], atts)
+	log.ErrFatal(err)
+	require.Equal(t, len(union), len(again))
+
+	// An attendee without a valid key is refused
+	_, err = unionAttendies(atts, []abstract.Point{nil})
+	require.NotNil(t, err)
+	_, err = sameAttendees(atts, []abstract.Point{nil})
 	require.NotNil(t, err)
+	draft := &FinalStatement{Desc: &PopDesc{}, Attendees: atts}
+	require.NotNil(t, draft.addAttendees([]abstract.Point{nil}))
+	require.Equal(t, atts, draft.Attendees)
 
-	// finish parties
-	for i, _ := range descs {
+	si := network.NewServerIdentity(atts[0],
+		network.NewAddress(network.PlainTCP, "0:2000"))
+	fs := &FinalStatement{
+		Desc: &PopDesc{
+			Name:     "name",
+			DateTime: "2017-07-31 00:00",
+			Location: "city",
+			Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+		},
+		Attendees: union,
+	}
+	hash, err := fs.Hash()
+	log.ErrFatal(err)
+	require.Equal(t,
+		"1cbf34080fdac28d15b257af6f084a3a8ed43ead62d31ccd329fd48e9c7fe561",
+		fmt.Sprintf("%x", hash))
+}
 
-		fr := &FinalizeRequest{}
-		fr.DescID = []byte(hash[i])
-		fr.Attendees = atts[2*i : 2*i+2]
-		hash_fr, err := fr.Hash()
-		sg, err := crypto.SignSchnorr(network.Suite, priv[2*i], hash_fr)
+func TestRotations_Apply(t *testing.T) {
+	atts := make([]abstract.Point, 4)
+	for i := range atts {
+		atts[i] = network.Suite.Point().Mul(nil,
+			network.Suite.Scalar().SetInt64(int64(i+1)))
+	}
+	rot := &rotations{Old: atts[:2], New: atts[2:]}
+	res, ws := rot.apply(atts[:2], nil)
+	require.Nil(t, ws)
+	requireSameAttendees(t, atts[2:], res)
+	// The old key is dropped with its weight if the new one is there
+	res, ws = rot.apply([]abstract.Point{atts[0], atts[2]}, []int{1, 2})
+	require.Equal(t, []int{2}, ws)
+	require.Equal(t, 1, len(res))
+	require.True(t, atts[2].Equal(res[0]))
+	// Chained rotations end with the last key
+	rot = &rotations{Old: atts[:2], New: atts[1:3]}
+	res, _ = rot.apply(atts[:1], nil)
+	require.True(t, atts[2].Equal(res[0]))
+}
+
+func TestService_TransferParty(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 2)
+	hash := descs[0].Hash()
+	dst := srvcs[0].ServerIdentity().Address
+	kp := config.NewKeyPair(network.Suite)
+	c := NewClient()
+
+	// Only the owner can transfer
+	require.NotNil(t, c.TransferParty(dst, hash, kp.Public, kp.Secret))
+	require.Nil(t, c.TransferParty(dst, hash, kp.Public, priv[0]))
+	require.NotNil(t, c.TransferParty(dst, hash, kp.Public, priv[0]))
+
+	// Only the owner can store the party again
+	require.NotNil(t, c.StoreConfig(dst, descs[0], priv[0]))
+	require.Nil(t, c.StoreConfig(dst, descs[0], kp.Secret))
+	_, cerr := c.StoreConfigs(dst, descs, priv[0])
+	require.NotNil(t, cerr)
+	_, cerr = c.StoreConfigs(dst, descs[1:], priv[0])
+	require.Nil(t, cerr)
+
+	// Every organizer only gets a backup of its own parties, and can't
+	// restore the parties of the other
+	srvcs[0].config.Private = local.GetPrivate(nodes[0])
+	orgs := []abstract.Scalar{kp.Secret, priv[0]}
+	for i, p := range orgs {
+		dump, cerr := c.Backup(dst, p)
+		require.Nil(t, cerr)
+		d, cerr := openDump(dump)
+		require.Nil(t, cerr)
+		require.Equal(t, 1, len(d.Finals))
+		require.NotNil(t, d.Finals[string(descs[i].Hash())])
+		_, cerr = c.Restore(dst, dump, orgs[1-i])
+		require.NotNil(t, cerr)
+		_, cerr = c.Restore(dst, dump, p)
+		require.Nil(t, cerr)
+	}
+
+	signedFR := func(desc *PopDesc, priv abstract.Scalar) *FinalizeRequest {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts}
+		frHash, err := fr.Hash()
 		log.ErrFatal(err)
-		fr.Signature = sg
-		_, err = srvcs[2*i].FinalizeRequest(fr)
-		require.NotNil(t, err)
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv, frHash)
+		log.ErrFatal(err)
+		return fr
+	}
+	_, cerr = srvcs[0].FinalizeRequest(signedFR(descs[0], priv[0]))
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "Invalid signature")
+	srvcs[1].FinalizeRequest(signedFR(descs[0], priv[1]))
+	_, cerr = srvcs[0].FinalizeRequest(signedFR(descs[0], kp.Secret))
+	require.Nil(t, cerr)
+	require.Nil(t, srvcs[0].data.Finals[string(hash)].Verify())
 
-		sg, err = crypto.SignSchnorr(network.Suite, priv[2*i+1], hash_fr)
+	// The other party still belongs to the linked organizer
+	srvcs[1].FinalizeRequest(signedFR(descs[1], priv[1]))
+	_, cerr = srvcs[0].FinalizeRequest(signedFR(descs[1], priv[0]))
+	require.Nil(t, cerr)
+}
+
+func TestService_TransferMerged(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	hash0 := string(h.descs[0].Hash())
+	for p := range h.descs {
+		_, cerr := h.finalize(p)
+		require.Nil(t, cerr)
+	}
+	kp := config.NewKeyPair(network.Suite)
+	h.srvcs[1].data.Owners[hash0] = &partyOwner{kp.Public}
+
+	// The merged party keeps the organizer of the local party
+	mr := &MergeRequest{ID: h.descs[0].Hash(), Nonce: challenge(h.srvcs[0])}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, h.privs[0], mr.Hash())
+	log.ErrFatal(err)
+	msg, cerr := h.srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	mergedHash := string(msg.(*FinalizeResponse).Final.Desc.Hash())
+	Eventually(t, func() bool { return h.srvcs[1].data.Finals[hash0].Merged },
+		"Server 1 not merged")
+	require.True(t, kp.Public.Equal(h.srvcs[1].owner([]byte(mergedHash))))
+	require.True(t, h.srvcs[0].data.Public.Equal(h.srvcs[0].owner([]byte(mergedHash))))
+}
+
+func TestService_Challenge(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, _, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 2, 1)
+	s := srvcs[0]
+	transfer := func(nonce []byte) *TransferRequest {
+		tr := &TransferRequest{ID: descs[0].Hash(), Public: s.data.Public,
+			Nonce: nonce}
+		hash, err := tr.Hash()
 		log.ErrFatal(err)
-		fr.Signature = sg
-		msg, err := srvcs[2*i+1].FinalizeRequest(fr)
-		require.Nil(t, err)
-		require.NotNil(t, msg)
-		_, ok := msg.(*FinalizeResponse)
-		require.True(t, ok)
+		tr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], hash)
+		log.ErrFatal(err)
+		return tr
 	}
-	// wrong Signature
-	mr.ID = []byte(hash[0])
-	sg, err = crypto.SignSchnorr(network.Suite, priv[1], mr.ID)
+
+	_, cerr := s.TransferParty(transfer(nil))
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+	tr := transfer(challenge(s))
+	_, cerr = s.TransferParty(tr)
+	require.Nil(t, cerr)
+	// Replay of the same request
+	_, cerr = s.TransferParty(tr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+
+	// Concurrent requests use their own nonce
+	tr = transfer(challenge(s))
+	tr2 := transfer(challenge(s))
+	_, cerr = s.TransferParty(tr2)
+	require.Nil(t, cerr)
+	_, cerr = s.TransferParty(tr)
+	require.Nil(t, cerr)
+	_, cerr = s.TransferParty(tr2)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+
+	// The oldest nonce is dropped when too many are requested
+	tr = transfer(challenge(s))
+	for i := 0; i < maxChallenges; i++ {
+		challenge(s)
+	}
+	require.Equal(t, maxChallenges, len(s.challenges))
+	_, cerr = s.TransferParty(tr)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+
+	s.challenges = nil
+	s.config.ChallengeTimeout = -time.Second
+	_, cerr = s.TransferParty(transfer(challenge(s)))
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorChallenge, cerr.ErrorCode())
+	// and the expired nonces are dropped
+	challenge(s)
+	challenge(s)
+	require.Equal(t, 1, len(s.challenges))
+}
+
+func TestService_Close(t *testing.T) {
+	// Only the services of this test are closed by the signal
+	shutdown.Lock()
+	shutdown.services = nil
+	shutdown.Unlock()
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() { exit = os.Exit }()
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(4, true)
+	descs, atts, srvcs, priv := storeDescMerge(local.GetServices(nodes, serviceID), r, 4)
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: atts[2*i : 2*i+2]}
+		hash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2 * i; j < 2*i+2; j++ {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], hash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	hash := descs[0].Hash()
+	s := srvcs[0]
+	mr := &MergeRequest{ID: hash, Nonce: challenge(s)}
+	sg, err := crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
 	log.ErrFatal(err)
 	mr.Signature = sg
-	_, err = srvcs[0].MergeRequest(mr)
+	done := make(chan onet.ClientError)
+	go func() {
+		_, cerr := s.MergeRequest(mr)
+		done <- cerr
+	}()
+	Eventually(t, func() bool { return s.data.mergeMetas[string(hash)].distrib },
+		"Merge didn't start")
+	log.ErrFatal(syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	require.Nil(t, <-done)
+	require.Equal(t, 0, <-exited)
+
+	msg, err := s.Load("storage")
+	log.ErrFatal(err)
+	final := msg.(*saveData).Finals[string(hash)]
+	require.True(t, final.Merged)
+	require.Equal(t, len(atts), len(final.Attendees))
+
+	fr := &FinalizeRequest{DescID: descs[1].Hash(), Attendees: atts}
+	_, cerr := s.FinalizeRequest(fr)
+	require.NotNil(t, cerr)
+	require.Contains(t, cerr.Error(), "shutting down")
+}
+
+func TestService_CompressStorage(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	descs, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 100, 1)
+	for _, s := range srvcs {
+		s.config.CompressStorage = true
+	}
+	hash := descs[0].Hash()
+	fr := &FinalizeRequest{DescID: hash, Attendees: atts}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	for i := range srvcs {
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+		log.ErrFatal(err)
+		srvcs[i].FinalizeRequest(fr)
+	}
+	s := srvcs[0]
+	s.save()
+	s.data = &saveData{}
+	log.ErrFatal(s.tryLoad())
+	final := s.data.Finals[string(hash)]
+	require.Equal(t, len(atts), len(final.Attendees))
+	require.Nil(t, final.Verify())
+	require.Nil(t, s.data.Attendees)
+}
+
+func TestService_ShortSignature(t *testing.T) {
+	h := newTestHarness(2, 2, false)
+	defer h.close()
+	s := h.srvcs[0]
+	s.tamperSignature = func(sig []byte) []byte {
+		return sig[:32]
+	}
+	_, cerr := h.finalize(0)
+	require.NotNil(t, cerr)
+	require.Equal(t, ErrorInternal, cerr.ErrorCode())
+	require.Contains(t, cerr.Error(), "BFT produced invalid signature")
+	require.Empty(t, s.data.Finals[string(h.descs[0].Hash())].Signature)
+
+	s.tamperSignature = nil
+	final, cerr := h.finalize(0)
+	require.Nil(t, cerr)
+	require.Nil(t, final.Verify())
+}
+
+func TestCompressAttendees(t *testing.T) {
+	atts := make([]abstract.Point, 10000)
+	for i := range atts {
+		atts[i] = config.NewKeyPair(network.Suite).Public
+	}
+	buf, err := compressAttendees(atts)
+	log.ErrFatal(err)
+	raw, err := network.Marshal(&FinalStatement{Attendees: atts})
+	log.ErrFatal(err)
+	log.Lvlf1("Compressed %d attendees to %d bytes instead of %d",
+		len(atts), len(buf), len(raw))
+	require.True(t, len(buf) < len(raw))
+
+	atts2, err := decompressAttendees(buf)
+	log.ErrFatal(err)
+	require.Equal(t, len(atts), len(atts2))
+	for i := range atts {
+		require.True(t, atts[i].Equal(atts2[i]))
+	}
+	atts2, err = decompressAttendees(nil)
 	require.NotNil(t, err)
-	//log.SetDebugVisible(2)
-	log.Lvlf2("Group 1, Server: %s", srvcs[0].ServerIdentity())
-	log.Lvlf2("Group 1, Server: %s", srvcs[1].ServerIdentity())
-	log.Lvlf2("Group 2, Server: %s", srvcs[2].ServerIdentity())
-	log.Lvlf2("Group 2, Server: %s", srvcs[3].ServerIdentity())
-	mr.ID = []byte(hash[0])
-	sg, err = crypto.SignSchnorr(network.Suite, priv[0], mr.ID)
+
+	buf, err = compressAttendees(atts[:0])
 	log.ErrFatal(err)
-	mr.Signature = sg
-	msg, err := srvcs[0].MergeRequest(mr)
-	require.Nil(t, err)
-	require.NotNil(t, msg)
-	for i, s := range srvcs {
-		Eventually(t, func() bool { return s.data.Finals[hash[i/2]].Merged },
-			fmt.Sprintf("Server %d not Merged", i))
+	atts2, err = decompressAttendees(buf)
+	log.ErrFatal(err)
+	require.Equal(t, 0, len(atts2))
+}
+
+func TestFinalStatement_VerifyMergeStatement(t *testing.T) {
+	conodes := []*eddsa.EdDSA{eddsa.NewEdDSA(random.Stream),
+		eddsa.NewEdDSA(random.Stream)}
+	finals := make([]*FinalStatement, len(conodes))
+	var parties []*ShortDesc
+	for i, c := range conodes {
+		si := network.NewServerIdentity(c.Public,
+			network.NewAddress(network.PlainTCP, fmt.Sprintf("0:%d", 2000+i)))
+		roster := onet.NewRoster([]*network.ServerIdentity{si})
+		parties = append(parties, &ShortDesc{Location: fmt.Sprintf("city%d", i),
+			Roster: roster})
+		finals[i] = &FinalStatement{
+			Desc: &PopDesc{
+				Name:     "name",
+				DateTime: "2017-07-31 00:00",
+				Location: fmt.Sprintf("city%d", i),
+				Roster:   roster,
+			},
+			Attendees: []abstract.Point{c.Public},
+		}
+	}
+	for i, f := range finals {
+		f.Desc.Parties = parties
+		h, err := f.Hash()
+		log.ErrFatal(err)
+		f.Signature, err = conodes[i].Sign(h)
+		log.ErrFatal(err)
+	}
+	require.Equal(t, PopStatusOK, finals[0].VerifyMergeStatement(finals[1]))
+
+	sig := finals[1].Signature
+	finals[1].Signature = []byte{}
+	require.Equal(t, PopStatusMergeNonFinalized,
+		finals[0].VerifyMergeStatement(finals[1]))
+	finals[1].Signature = append([]byte{}, sig...)
+	finals[1].Signature[0] ^= 0xff
+	require.Equal(t, PopStatusBadSignature,
+		finals[0].VerifyMergeStatement(finals[1]))
+}
+
+func TestMergeStatements(t *testing.T) {
+	newStatements := func() []*FinalStatement {
+		stmts := make([]*FinalStatement, 3)
+		for i := range stmts {
+			kp := config.NewKeyPair(network.Suite)
+			si := network.NewServerIdentity(kp.Public,
+				network.NewAddress(network.PlainTCP, fmt.Sprintf("0:%d", 2000+i)))
+			stmts[i] = &FinalStatement{
+				Desc: &PopDesc{
+					Name:     "name",
+					DateTime: "2017-07-31 00:00",
+					Location: fmt.Sprintf("city%d", i),
+					Roster:   onet.NewRoster([]*network.ServerIdentity{si}),
+				},
+				Attendees: []abstract.Point{kp.Public},
+				Signature: []byte{},
+			}
+		}
+		return stmts
+	}
+	merge := func(stmts []*FinalStatement) []byte {
+		meta := newmergeMeta()
+		for _, f := range stmts {
+			fc := *f
+			desc := *f.Desc
+			fc.Desc = &desc
+			meta.statementsMap[string(f.Desc.Hash())] = &fc
+		}
+		final := meta.statementsMap[string(stmts[0].Desc.Hash())]
+		log.ErrFatal(mergeStatements(final, meta.sortedStatements()))
+		buf, err := final.ToToml()
+		log.ErrFatal(err)
+		return buf
+	}
+	stmts := newStatements()
+	first := merge(stmts)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, first, merge(stmts))
+	}
+	require.Contains(t, string(first), "city0; city1; city2")
+}
+
+func TestService_OrderingPolicy(t *testing.T) {
+	local := onet.NewTCPTest()
+	defer local.CloseAll()
+	nodes, r, _ := local.GenTree(2, true)
+	_, atts, srvcs, priv := storeDesc(local.GetServices(nodes, serviceID), r, 5, 1)
+	sorted := sortedAttendees(atts)
+	reversed := make([]abstract.Point, len(sorted))
+	for i, a := range sorted {
+		reversed[len(sorted)-1-i] = a
+	}
+	for _, policy := range []int{OrderSorted, OrderInsertion} {
+		desc := &PopDesc{
+			Name:           "ordering",
+			DateTime:       "2017-07-31 00:00",
+			Location:       "city",
+			Roster:         r,
+			OrderingPolicy: policy,
+		}
+		require.Nil(t, desc.Validate())
+		for i, s := range srvcs {
+			sg, err := crypto.SignSchnorr(network.Suite, priv[i], desc.Hash())
+			log.ErrFatal(err)
+			_, cerr := s.StoreConfig(&StoreConfig{desc, sg})
+			require.Nil(t, cerr)
+		}
+		fr := &FinalizeRequest{DescID: desc.Hash(), Attendees: reversed}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		var msg network.Message
+		for i := len(srvcs) - 1; i >= 0; i-- {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[i], frHash)
+			log.ErrFatal(err)
+			msg, _ = srvcs[i].FinalizeRequest(fr)
+		}
+		require.NotNil(t, msg)
+		final := msg.(*FinalizeResponse).Final
+		require.Nil(t, final.Verify())
+		if policy == OrderSorted {
+			require.Equal(t, attendeeKeys(sorted), attendeeKeys(final.Attendees))
+		} else {
+			require.Equal(t, attendeeKeys(reversed), attendeeKeys(final.Attendees))
+		}
 	}
+	require.NotNil(t, (&PopDesc{Name: "ordering", DateTime: "2017-07-31 00:00",
+		Roster: r, OrderingPolicy: 5}).Validate())
 
+	// The index an attendee finds in the merged statement is the same on
+	// all conodes, as every conode sorts the united attendees.
+	local2 := onet.NewTCPTest()
+	defer local2.CloseAll()
+	nodes, r, _ = local2.GenTree(4, true)
+	kps := make([]*config.KeyPair, 4)
+	for i := range kps {
+		kps[i] = config.NewKeyPair(network.Suite)
+	}
+	descs, _, srvcs, priv := storeDescMerge(local2.GetServices(nodes, serviceID), r, 0)
+	for i, desc := range descs {
+		fr := &FinalizeRequest{DescID: desc.Hash(),
+			Attendees: []abstract.Point{kps[2*i+1].Public, kps[2*i].Public}}
+		frHash, err := fr.Hash()
+		log.ErrFatal(err)
+		for j := 2*i + 1; j >= 2*i; j-- {
+			fr.Signature, err = crypto.SignSchnorr(network.Suite, priv[j], frHash)
+			log.ErrFatal(err)
+			srvcs[j].FinalizeRequest(fr)
+		}
+	}
+	mr := &MergeRequest{ID: descs[0].Hash(), Nonce: challenge(srvcs[0])}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, priv[0], mr.Hash())
+	log.ErrFatal(err)
+	msg, cerr := srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	merged := msg.(*FinalizeResponse).Final
+	require.True(t, merged.Merged)
 	for i, s := range srvcs {
-		require.Equal(t, len(s.data.Finals[hash[i/2]].Attendees),
-			nbrAtt,
-			fmt.Sprintf("Server %d attendees not merged", i))
-		require.Equal(t,
-			len(s.data.Finals[hash[i/2]].Desc.Roster.List),
-			nbrNodes,
-			fmt.Sprintf("Server %d conodes not merged", i))
-		require.True(t, len(s.data.Finals[hash[i/2]].Signature) > 0 &&
-			s.data.Finals[hash[i/2]].Verify() == nil,
-			fmt.Sprintf("Signature in node %d is not created", i))
+		hash := string(descs[i/2].Hash())
+		Eventually(t, func() bool { return s.data.Finals[hash].Merged },
+			fmt.Sprintf("Server %d not merged", i))
+		require.Equal(t, attendeeKeys(merged.Attendees),
+			attendeeKeys(s.data.Finals[hash].Attendees))
+	}
+	c := NewClient()
+	ctx := []byte("ctx")
+	for _, kp := range kps {
+		index := indexOf(merged.Attendees, kp.Public)
+		require.True(t, index >= 0)
+		sig, tag, err := c.Sign(merged, index, kp.Secret, []byte("msg"), ctx)
+		log.ErrFatal(err)
+		_, err = c.Verify(srvcs[3].data.Finals[string(descs[1].Hash())],
+			[]byte("msg"), ctx, sig, tag)
+		require.Nil(t, err)
+	}
+	require.Equal(t, attendeeKeys(sortedAttendees(merged.Attendees)),
+		attendeeKeys(merged.Attendees))
+}
+
+func TestService_MergeOrderingPolicies(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	kps := []*config.KeyPair{config.NewKeyPair(network.Suite),
+		config.NewKeyPair(network.Suite)}
+	if keys := attendeeKeys([]abstract.Point{kps[0].Public,
+		kps[1].Public}); keys[0] < keys[1] {
+		kps[0], kps[1] = kps[1], kps[0]
+	}
+	h.atts[0], h.atts[1] = kps[0].Public, kps[1].Public
+	// The first party keeps the order of the registration, which is not
+	// sorted, the second one sorts its attendees. Both know the policy
+	// of the first one from the list of the parties.
+	h.descs[0].OrderingPolicy = OrderInsertion
+	h.descs[0].Parties[0].OrderingPolicy = OrderInsertion
+	h.storeDescs(t)
+	final, cerr := h.finalize(0)
+	require.Nil(t, cerr)
+	pre := &FinalStatement{Attendees: append([]abstract.Point{}, final.Attendees...)}
+	require.Equal(t, attendeeKeys(h.atts[:2]), attendeeKeys(pre.Attendees))
+
+	// A token signed with the order of the first party before the merge
+	c := NewClient()
+	ctx := []byte("ctx")
+	sig, tag, err := c.Sign(pre, 0, kps[0].Secret, []byte("msg"), ctx)
+	log.ErrFatal(err)
+
+	merged := h.mergeParties(t)
+	require.Nil(t, merged.Verify())
+	require.Equal(t, OrderSorted, merged.Desc.OrderingPolicy)
+	require.Equal(t, attendeeKeys(sortedAttendees(merged.Attendees)),
+		attendeeKeys(merged.Attendees))
+	for i, s := range h.srvcs {
+		f := s.data.Finals[string(h.descs[i/2].Hash())]
+		require.Nil(t, f.Verify())
+		require.Equal(t, merged.Origins, f.Origins)
 	}
+	require.Equal(t, 2, len(merged.Origins))
+	atts, err := merged.OriginAttendees(h.descs[0].shortDesc().Hash())
+	log.ErrFatal(err)
+	require.Equal(t, attendeeKeys(pre.Attendees), attendeeKeys(atts))
+	set, err := c.Verify(merged, []byte("msg"), ctx, sig, tag)
+	require.Nil(t, err)
+	require.Equal(t, attendeeKeys(pre.Attendees), attendeeKeys(set))
+
+	// Without the origins the token doesn't verify
+	stripped := *merged
+	stripped.Origins = nil
+	_, err = c.Verify(&stripped, []byte("msg"), ctx, sig, tag)
+	require.NotNil(t, err)
+
+	// The origins survive the export
+	buf, err := merged.ToToml()
+	log.ErrFatal(err)
+	imported, err := NewFinalStatementFromToml(buf)
+	log.ErrFatal(err)
+	require.Nil(t, imported.Verify())
+	_, err = c.Verify(imported, []byte("msg"), ctx, sig, tag)
+	require.Nil(t, err)
+}
+
+func TestService_MergeInsertionOrder(t *testing.T) {
+	h := newTestHarness(4, 4, true)
+	defer h.close()
+	for p := range h.descs {
+		h.descs[p].OrderingPolicy = OrderInsertion
+		h.descs[p].Parties[p].OrderingPolicy = OrderInsertion
+	}
+	h.storeDescs(t)
+	merged := h.mergeParties(t)
+	require.Nil(t, merged.Verify())
+	require.Equal(t, OrderInsertion, merged.Desc.OrderingPolicy)
+	for i, s := range h.srvcs {
+		f := s.data.Finals[string(h.descs[i/2].Hash())]
+		require.Equal(t, attendeeKeys(merged.Attendees), attendeeKeys(f.Attendees))
+	}
+
+	// The merged party is found with the hash of every party
+	c := NewClient()
+	for p, desc := range h.descs {
+		addr := h.roster.List[h.conodes(p)[0]].Address
+		fs, cerr := c.FetchFinal(addr, desc.Hash())
+		require.Nil(t, cerr)
+		require.Equal(t, merged.Desc.Hash(), fs.Desc.Hash())
+	}
+}
+
+// attendeeKeys returns the marshalled keys of the attendees, in their order.
+func attendeeKeys(atts []abstract.Point) []string {
+	keys := make([]string, len(atts))
+	for i, a := range atts {
+		key, err := attendeeKey(a)
+		log.ErrFatal(err)
+		keys[i] = key
+	}
+	return keys
+}
+
+// sortedAttendees returns the attendees sorted like in a party with the
+// OrderSorted policy.
+func sortedAttendees(atts []abstract.Point) []abstract.Point {
+	sorted, err := sortAttendees(atts)
+	log.ErrFatal(err)
+	return sorted
+}
+
+// requireSameAttendees fails the test if a1 and a2 don't hold the same
+// attendees.
+func requireSameAttendees(t *testing.T, a1, a2 []abstract.Point) {
+	same, err := sameAttendees(a1, a2)
+	require.Nil(t, err)
+	require.True(t, same)
+}
+
+// setConfigFile makes the services created afterwards read their
+// configuration from a file holding content. The returned function has to
+// be called once they are created.
+func setConfigFile(t *testing.T, content string) func() {
+	f, err := ioutil.TempFile("", "pop_config")
+	log.ErrFatal(err)
+	_, err = f.WriteString(content)
+	log.ErrFatal(err)
+	log.ErrFatal(f.Close())
+	log.ErrFatal(os.Setenv(ENVConfig, f.Name()))
+	return func() {
+		os.Unsetenv(ENVConfig)
+		os.Remove(f.Name())
+	}
+}
 
+// challenge returns a new nonce of the service to be signed with a request.
+func challenge(s *Service) []byte {
+	msg, cerr := s.GetChallenge(&GetChallenge{})
+	log.ErrFatal(cerr)
+	return msg.(*GetChallengeReply).Nonce
 }
 
 func storeDesc(srvcs []onet.Service, el *onet.Roster, nbr int,
@@ -497,6 +2651,136 @@ func storeDescMerge(srvcs []onet.Service, el *onet.Roster, nbr int) ([]*PopDesc,
 	return descs, atts, sret, privs
 }
 
+// testHarness holds conodes running the service with stored parties.
+type testHarness struct {
+	local  *onet.LocalTest
+	roster *onet.Roster
+	descs  []*PopDesc
+	atts   []abstract.Point
+	srvcs  []*Service
+	privs  []abstract.Scalar
+	merge  bool
+}
+
+// newTestHarness starts nbrNodes conodes and stores the parties with
+// nbrAtt generated attendees. If merge is true, every two conodes hold one
+// of the parties to be merged, see storeDescMerge, else all conodes hold
+// the same party. It has to be closed with close.
+func newTestHarness(nbrNodes, nbrAtt int, merge bool) *testHarness {
+	h := &testHarness{local: onet.NewTCPTest(), merge: merge}
+	nodes, r, _ := h.local.GenTree(nbrNodes, true)
+	h.roster = r
+	srvcs := h.local.GetServices(nodes, serviceID)
+	if merge {
+		h.descs, h.atts, h.srvcs, h.privs = storeDescMerge(srvcs, r, nbrAtt)
+	} else {
+		h.descs, h.atts, h.srvcs, h.privs = storeDesc(srvcs, r, nbrAtt, 1)
+	}
+	return h
+}
+
+// close stops all conodes of the harness.
+func (h *testHarness) close() {
+	h.local.CloseAll()
+}
+
+// conodes returns the indexes of the conodes holding the party p.
+func (h *testHarness) conodes(p int) []int {
+	if h.merge {
+		return []int{2 * p, 2*p + 1}
+	}
+	idx := make([]int, len(h.srvcs))
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// partyAttendees returns the attendees of the party p: an equal share of
+// the attendees for a merge, else all of them.
+func (h *testHarness) partyAttendees(p int) []abstract.Point {
+	if !h.merge {
+		return h.atts
+	}
+	share := len(h.atts) / len(h.descs)
+	return h.atts[p*share : (p+1)*share]
+}
+
+// resetState drops all parties with their merge and synchronisation data,
+// as if no config had been stored. The link
+// to the organizer is kept.
+func (s *Service) resetState() {
+	s.data.Finals = make(map[string]*FinalStatement)
+	s.data.Owners = make(map[string]*partyOwner)
+	s.data.Registrations = make(map[string]*registrations)
+	s.data.MergeCache = make(map[string]*mergeCache)
+	s.data.Revocations = make(map[string]*revocations)
+	s.data.Rotations = make(map[string]*rotations)
+	s.data.SkipBlocks = make(map[string]skipchain.SkipBlockID)
+	s.data.mergeMetas = make(map[string]*mergeMeta)
+	s.data.syncMetas = make(map[string]*syncMeta)
+	s.save()
+}
+
+// storeDescs stores the descriptions of the harness again on their
+// conodes, in place of the parties stored before.
+func (h *testHarness) storeDescs(t *testing.T) {
+	for _, s := range h.srvcs {
+		s.resetState()
+	}
+	for p, desc := range h.descs {
+		for _, c := range h.conodes(p) {
+			sg, err := crypto.SignSchnorr(network.Suite, h.privs[c], desc.Hash())
+			log.ErrFatal(err)
+			_, cerr := h.srvcs[c].StoreConfig(&StoreConfig{desc, sg})
+			require.Nil(t, cerr)
+		}
+	}
+}
+
+// finalize sends the FinalizeRequest for the party p with its attendees to
+// its conodes, the first one last, and returns the reply of the first one.
+func (h *testHarness) finalize(p int) (*FinalStatement, onet.ClientError) {
+	fr := &FinalizeRequest{DescID: h.descs[p].Hash(), Attendees: h.partyAttendees(p)}
+	frHash, err := fr.Hash()
+	log.ErrFatal(err)
+	conodes := h.conodes(p)
+	var msg network.Message
+	var cerr onet.ClientError
+	for i := len(conodes) - 1; i >= 0; i-- {
+		c := conodes[i]
+		fr.Signature, err = crypto.SignSchnorr(network.Suite, h.privs[c], frHash)
+		log.ErrFatal(err)
+		msg, cerr = h.srvcs[c].FinalizeRequest(fr)
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return msg.(*FinalizeResponse).Final, nil
+}
+
+// mergeParties finalizes all parties of a harness created with merge and
+// merges them, starting from the first conode. It returns the merged
+// statement of the first conode.
+func (h *testHarness) mergeParties(t *testing.T) *FinalStatement {
+	for p := range h.descs {
+		_, cerr := h.finalize(p)
+		require.Nil(t, cerr)
+	}
+	mr := &MergeRequest{ID: h.descs[0].Hash(), Nonce: challenge(h.srvcs[0])}
+	var err error
+	mr.Signature, err = crypto.SignSchnorr(network.Suite, h.privs[0], mr.Hash())
+	log.ErrFatal(err)
+	msg, cerr := h.srvcs[0].MergeRequest(mr)
+	require.Nil(t, cerr)
+	for i, s := range h.srvcs {
+		hash := string(h.descs[i/2].Hash())
+		Eventually(t, func() bool { return s.data.Finals[hash].Merged },
+			fmt.Sprintf("Server %d not merged", i))
+	}
+	return msg.(*FinalizeResponse).Final
+}
+
 const MAX_WAITING = 1000
 
 func Eventually(t *testing.T, f func() bool, msg string) {
@@ -5,6 +5,9 @@ This holds the messages used to communicate with the service over the network.
 */
 
 import (
+	"encoding/binary"
+	"errors"
+
 	"gopkg.in/dedis/crypto.v0/abstract"
 	"gopkg.in/dedis/onet.v1/crypto"
 	"gopkg.in/dedis/onet.v1/network"
@@ -14,7 +17,35 @@ import (
 func init() {
 	for _, msg := range []interface{}{
 		CheckConfig{}, CheckConfigReply{},
-		PinRequest{}, FetchRequest{}, MergeRequest{},
+		PinRequest{}, PinReply{}, FetchRequest{}, MergeRequest{},
+		ReopenRequest{},
+		StoreConfigsRequest{}, StoreConfigsReply{},
+		GetAggregateRequest{}, GetAggregateReply{},
+		GetPartyRequest{}, GetPartyReply{},
+		AuditRequest{}, AuditReply{},
+		TransferRequest{},
+		PreviewFinalizeRequest{}, PreviewFinalizeReply{},
+		RotateAttendeeRequest{},
+		GetChallenge{}, GetChallengeReply{},
+		FindPartyRequest{}, FindPartyReply{},
+		GetSubPartiesRequest{}, GetSubPartiesReply{},
+		RepropagateRequest{}, RepropagateReply{},
+		HasConfigRequest{}, HasConfigReply{},
+		IsRegisteredRequest{}, IsRegisteredReply{},
+		GetAttendeeInfoRequest{}, GetAttendeeInfoReply{},
+		ReconcileRequest{}, ReconcileReply{},
+		ReconcileAttendees{}, ReconcileAttendeesReply{},
+		RegisterAttendeesRequest{}, RegisterAttendeesReply{},
+		PropagateAttendees{}, PropagateAttendeesReply{},
+		FinalizeStatusRequest{}, FinalizeStatusReply{},
+		PartyStatus{}, PartyStatusReply{},
+		FetchHeaderRequest{}, FinalHeader{},
+		RevokeAttendeeRequest{},
+		PruneMergedRequest{}, PruneMergedReply{},
+		ListPendingRequest{}, ListPendingReply{},
+		CancelPendingRequest{}, CancelPendingReply{},
+		BackupRequest{}, BackupReply{}, RestoreRequest{}, RestoreReply{},
+		SkipchainEntry{}, SkipBlockRef{},
 	} {
 		network.RegisterMessage(msg)
 	}
@@ -23,30 +54,72 @@ func init() {
 const (
 	// PopStatusWrongHash - The different configs in the roster don't have the same hash
 	PopStatusWrongHash = iota
-	// PopStatusNoAttendees - No common attendees found
+	// PopStatusNoAttendees - The conode has no attendees yet
 	PopStatusNoAttendees
 	// PopStatusMergeError - Error in merge config
 	PopStatusMergeError
-	// PopStatusMergeNonFinalized - Attempt to merge not finalized party
+	// PopStatusMergeNonFinalized - Attempt to merge not finalized party,
+	// either the received one or the local one
 	PopStatusMergeNonFinalized
 	// PopStatusOK - Everything is OK
 	PopStatusOK
 )
 
+// The statuses added later are errors as well. They count down from -1, so
+// that they stay below PopStatusOK, like the errors above, for the conodes
+// that don't know them yet.
+const (
+	// PopStatusFinalized - The party is already finalized
+	PopStatusFinalized = -1 - iota
+	// PopStatusWrongRoster - The config is stored with a different roster
+	PopStatusWrongRoster
+	// PopStatusNoConfig - No config is stored at all
+	PopStatusNoConfig
+	// PopStatusAttendeesMismatch - The attendees differ in strict mode
+	PopStatusAttendeesMismatch
+	// PopStatusBadSignature - A final statement is signed, but its
+	// signature doesn't verify
+	PopStatusBadSignature
+	// PopStatusWeightsMismatch - The common attendees have different weights
+	PopStatusWeightsMismatch
+	// PopStatusNoCommonAttendees - The conode has attendees, but none of
+	// the requested ones
+	PopStatusNoCommonAttendees
+)
+
+// statusOK returns true if status doesn't indicate an error. Only
+// PopStatusOK does, every other status, even an unknown one, is an error.
+func statusOK(status int) bool {
+	return status == PopStatusOK
+}
+
 // CheckConfig asks whether the pop-config and the attendees are available.
+// Desc is used to detect if the other conode stored the same party with
+// a different roster.
 type CheckConfig struct {
 	PopHash   []byte
 	Attendees []abstract.Point
+	Desc      *PopDesc
+	// Strict asks not to prune the attendees if they differ
+	Strict bool
+	// DryRun asks only for the PopStatus, the attendees are not pruned
+	DryRun bool
+	// Weights holds the weights of the attendees, if any, which have to
+	// be the same on the other conode for the common attendees.
+	Weights []int
 }
 
 // CheckConfigReply sends back an integer for the Pop. 0 means no config yet,
 // other values are defined as constants.
 // If PopStatus == PopStatusOK, then the Attendees will be the common attendees between
-// the two nodes.
+// the two nodes. If PopStatus == PopStatusAttendeesMismatch, the Attendees are
+// all attendees of the replying node.
 type CheckConfigReply struct {
 	PopStatus int
 	PopHash   []byte
 	Attendees []abstract.Point
+	// DryRun is copied from the CheckConfig
+	DryRun bool
 }
 
 // MergeConfig asks if party is ready to merge
@@ -55,6 +128,23 @@ type MergeConfig struct {
 	Final *FinalStatement
 	// Hash of PopDesc party to merge with
 	ID []byte
+	// Signature of the sending conode, if Config.SignMerge is set
+	Signature crypto.SchnorrSig
+}
+
+// Hash returns the message the sending conode signs.
+func (mc *MergeConfig) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("MergeConfig"))
+	if mc.Final != nil {
+		fsHash, err := mc.Final.Hash()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(fsHash)
+	}
+	h.Write(mc.ID)
+	return h.Sum(nil), nil
 }
 
 type MergeConfigReply struct {
@@ -64,6 +154,30 @@ type MergeConfigReply struct {
 	PopHash []byte
 	// FinalStatement of party was asked to merge
 	Final *FinalStatement
+	// Signature of the replying conode, if Config.SignMerge is set
+	Signature crypto.SchnorrSig
+	// Conflict describes why the parties can't be merged if the conodes
+	// they share hold conflicting data
+	Conflict string
+}
+
+// Hash returns the message the replying conode signs.
+func (mcr *MergeConfigReply) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("MergeConfigReply"))
+	if err := binary.Write(h, binary.BigEndian, int64(mcr.PopStatus)); err != nil {
+		return nil, err
+	}
+	h.Write(mcr.PopHash)
+	if mcr.Final != nil {
+		fsHash, err := mcr.Final.Hash()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(fsHash)
+	}
+	h.Write([]byte(mcr.Conflict))
+	return h.Sum(nil), nil
 }
 
 // Message requesting fellows to merge and update their lists
@@ -98,6 +212,13 @@ type PinRequest struct {
 	Public abstract.Point
 }
 
+// PinReply confirms that the public key is linked to the conode. Relink is
+// true if the conode was already linked to a key before.
+type PinReply struct {
+	Conode *network.ServerIdentity
+	Relink bool
+}
+
 // StoreConfig presents a config to store
 type StoreConfig struct {
 	Desc      *PopDesc
@@ -111,12 +232,66 @@ type StoreConfigReply struct {
 	ID []byte
 }
 
+// AuthEnvelope holds a request sent to a service that requires a token,
+// see Config.AuthToken.
+type AuthEnvelope struct {
+	Token []byte
+	// Request is the protobuf-encoded request
+	Request []byte
+}
+
+// StoreConfigsRequest presents several configs to be stored at once, e.g.
+// all parties of a day. Either all of them are stored or none. A party that
+// is already finalized is never stored again.
+type StoreConfigsRequest struct {
+	Descs     []*PopDesc
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs, which covers the hashes of
+// all descriptions in their order.
+func (sr *StoreConfigsRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	if _, err := h.Write([]byte("store configs")); err != nil {
+		return nil, err
+	}
+	for _, desc := range sr.Descs {
+		if desc == nil {
+			return nil, errors.New("empty description")
+		}
+		if _, err := h.Write(desc.Hash()); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := h.Write(sr.Nonce); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// StoreConfigsReply holds the hashes of the stored configs, in the order
+// of the request.
+type StoreConfigsReply struct {
+	IDs [][]byte
+}
+
 // FinalizeRequest asks to finalize on the given descid-popconfig.
 // TODO: support more than one popconfig
 type FinalizeRequest struct {
 	DescID    []byte
 	Attendees []abstract.Point
 	Signature crypto.SchnorrSig
+	// Strict fails the finalization if the conodes don't have the same
+	// attendees, instead of keeping only the common ones.
+	Strict bool
+	// Weights optionally holds the weight of every attendee, in the order
+	// of Attendees.
+	Weights []int
+	// MinAttendees fails the finalization with ErrorTooFewAttendees if
+	// less attendees would be kept. Zero means no minimum.
+	MinAttendees int
 }
 
 func (fr *FinalizeRequest) Hash() ([]byte, error) {
@@ -135,14 +310,193 @@ func (fr *FinalizeRequest) Hash() ([]byte, error) {
 			return nil, err
 		}
 	}
+	if fr.Strict {
+		_, err = h.Write([]byte("strict"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(fr.Weights) > 0 {
+		_, err = h.Write([]byte("weights"))
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range fr.Weights {
+			err = binary.Write(h, binary.BigEndian, int64(w))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if fr.MinAttendees > 0 {
+		_, err = h.Write([]byte("min"))
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Write(h, binary.BigEndian, int64(fr.MinAttendees))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// PreviewFinalizeRequest asks which attendees a FinalizeRequest with the
+// same fields would keep, without finalizing.
+type PreviewFinalizeRequest struct {
+	DescID    []byte
+	Attendees []abstract.Point
+	Signature crypto.SchnorrSig
+	Strict    bool
+}
+
+// Hash returns the message the organizer signs. It differs from the one of
+// the FinalizeRequest, so that the signature can't be used to finalize.
+func (pr *PreviewFinalizeRequest) Hash() ([]byte, error) {
+	fr := &FinalizeRequest{DescID: pr.DescID, Attendees: pr.Attendees,
+		Strict: pr.Strict}
+	hash, err := fr.Hash()
+	if err != nil {
+		return nil, err
+	}
+	h := network.Suite.Hash()
+	h.Write([]byte("preview"))
+	h.Write(hash)
 	return h.Sum(nil), nil
 }
 
+// PreviewFinalizeReply holds the attendees the finalization would keep
+// and the ones it would drop, because some conodes don't have them.
+type PreviewFinalizeReply struct {
+	Attendees []abstract.Point
+	Dropped   []abstract.Point
+}
+
 // FinalizeResponse returns the FinalStatement if all conodes already received
 // a PopDesc and signed off. The FinalStatement holds the updated PopDesc, the
 // pruned attendees-public-key-list and the collective signature.
+// NumAttendees and NumConodes are the sizes of the attendees and the roster
+// of the FinalStatement. SkipBlockID is the block holding the hash of the
+// statement if the conode stores the statements on a skipchain, else nil.
 type FinalizeResponse struct {
-	Final *FinalStatement
+	Final        *FinalStatement
+	NumAttendees int
+	NumConodes   int
+	SkipBlockID  []byte
+}
+
+// newFinalizeResponse returns the response for the final statement with
+// the counts filled in.
+func newFinalizeResponse(fs *FinalStatement) *FinalizeResponse {
+	res := &FinalizeResponse{Final: fs, NumAttendees: len(fs.Attendees)}
+	if fs.Desc != nil && fs.Desc.Roster != nil {
+		res.NumConodes = len(fs.Desc.Roster.List)
+	}
+	return res
+}
+
+// SkipchainEntry is the data of a skipblock storing a final statement, see
+// Config.StoreOnSkipchain. Hash is the hash of the signed FinalStatement of the
+// party PopHash.
+type SkipchainEntry struct {
+	PopHash []byte
+	Hash    []byte
+}
+
+// SkipBlockRef is sent by the conode that stored a final statement on the
+// skipchain to the other conodes of the party, so that they return the
+// skipblock with the statement too.
+type SkipBlockRef struct {
+	PopHash []byte
+	ID      []byte
+}
+
+// HasConfigRequest asks whether the conode stored the party with the given
+// hash. Unlike CheckConfig it doesn't change anything on the conode.
+type HasConfigRequest struct {
+	ID []byte
+}
+
+// HasConfigReply tells whether the party is stored and whether it has
+// been signed.
+type HasConfigReply struct {
+	Exists    bool
+	Finalized bool
+}
+
+// FinalizeStatusRequest asks for the state of the party with the given
+// hash on all conodes of its roster. It needs no signature.
+type FinalizeStatusRequest struct {
+	ID []byte
+}
+
+// ConodeStatus is the state of a party on one conode.
+type ConodeStatus struct {
+	Conode *network.ServerIdentity
+	// Reachable is false if the conode didn't reply, the other fields
+	// are unknown then.
+	Reachable bool
+	Exists    bool
+	// NumAttendees is the number of attendees the conode got, usually
+	// with the FinalizeRequest of its organizer.
+	NumAttendees int
+	Finalized    bool
+}
+
+// FinalizeStatusReply holds the state of the party on every conode of the
+// roster, in the order of the roster.
+type FinalizeStatusReply struct {
+	Conodes []ConodeStatus
+}
+
+// PartyStatus asks another conode for the state of the party.
+type PartyStatus struct {
+	PopHash []byte
+	// ReqID is echoed in the reply, so that it goes to the request that
+	// asked for it.
+	ReqID []byte
+}
+
+// PartyStatusReply holds the state of the party on the replying conode.
+type PartyStatusReply struct {
+	PopHash      []byte
+	ReqID        []byte
+	Exists       bool
+	NumAttendees int
+	Finalized    bool
+	// Revoked holds the attendees the organizer revoked, which are still
+	// in the signed statement.
+	Revoked []abstract.Point
+}
+
+// IsRegisteredRequest asks whether the public key is in the attendees of
+// the party with the given hash.
+type IsRegisteredRequest struct {
+	ID     []byte
+	Public abstract.Point
+}
+
+// IsRegisteredReply tells whether the key is registered. Before the party
+// is finalized, the attendees are the ones of the last FinalizeRequest.
+type IsRegisteredReply struct {
+	Registered bool
+	Finalized  bool
+}
+
+// GetAttendeeInfoRequest asks when the public key has been registered for
+// the party with the given hash.
+type GetAttendeeInfoRequest struct {
+	ID     []byte
+	Public abstract.Point
+}
+
+// GetAttendeeInfoReply tells whether the key is registered, like
+// IsRegisteredReply. RegisteredAt is the unix time in seconds when the
+// conode first got the key from the organizer, or 0 if it never did, e.g.
+// for the attendees of merged parties. It is kept if the key is pruned.
+type GetAttendeeInfoReply struct {
+	Registered   bool
+	RegisteredAt int64
 }
 
 // FetchRequest asks to get FinalStatement
@@ -150,8 +504,504 @@ type FetchRequest struct {
 	ID []byte
 }
 
+// FetchHeaderRequest asks for the FinalHeader of a finalized party.
+type FetchHeaderRequest struct {
+	ID []byte
+}
+
+// FinalHeader holds all fields of a FinalStatement but the attendees, for
+// verifiers that already have them. The weights and the epoch are kept, as
+// they are part of the signed hash.
+type FinalHeader struct {
+	Desc        *PopDesc
+	Signature   []byte
+	Merged      bool
+	FinalizedAt int64
+	Weights     []int
+	Origins     []*AttendeeOrigin
+	Epoch       int
+}
+
 // MergeRequest asks to start merging process for given Party
 type MergeRequest struct {
 	ID        []byte
 	Signature crypto.SchnorrSig
+	// Partial drops the parties that can't be reached from the merge
+	// instead of failing.
+	Partial bool
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs. A partial merge signs a
+// different message, so that the signature of a merge can't be replayed
+// to start a partial merge.
+func (mr *MergeRequest) Hash() []byte {
+	h := network.Suite.Hash()
+	h.Write(mr.ID)
+	if mr.Partial {
+		h.Write([]byte("partial"))
+	}
+	h.Write(mr.Nonce)
+	return h.Sum(nil)
+}
+
+// ReopenRequest asks to clear the signature of a finalized party, so that
+// the registration of attendees can be done again.
+type ReopenRequest struct {
+	ID        []byte
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (rr *ReopenRequest) Hash() []byte {
+	h := network.Suite.Hash()
+	h.Write(rr.ID)
+	h.Write(rr.Nonce)
+	return h.Sum(nil)
+}
+
+// RepropagateRequest asks to send the signed final statement again to all
+// conodes of the party.
+type RepropagateRequest struct {
+	ID        []byte
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (rr *RepropagateRequest) Hash() []byte {
+	h := network.Suite.Hash()
+	h.Write([]byte("repropagate"))
+	h.Write(rr.ID)
+	h.Write(rr.Nonce)
+	return h.Sum(nil)
+}
+
+// RepropagateReply holds the number of conodes that stored the statement
+type RepropagateReply struct {
+	Replies int
+}
+
+// ReconcileRequest asks to unite the attendees registered on all conodes
+// of a party that is not finalized yet. Without Attendees, the conode only
+// returns the attendees of all conodes. With Attendees, it sends them to
+// all conodes, which only accept them if their organizer signed them. It
+// has to be signed by the organizer.
+type ReconcileRequest struct {
+	ID        []byte
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce     []byte
+	Attendees []abstract.Point
+}
+
+// Hash returns the message the organizer signs.
+func (rr *ReconcileRequest) Hash() []byte {
+	h := network.Suite.Hash()
+	h.Write([]byte("reconcile"))
+	h.Write(rr.ID)
+	h.Write(rr.Nonce)
+	for _, a := range rr.Attendees {
+		a.MarshalTo(h)
+	}
+	return h.Sum(nil)
+}
+
+// ReconcileReply holds the number of attendees of the party on all
+// conodes after the reconciliation. If the request had no attendees,
+// Attendees holds the ones of all conodes.
+type ReconcileReply struct {
+	NumAttendees int
+	Attendees    []abstract.Point
+}
+
+// ReconcileAttendees adds the attendees to the ones registered for the
+// party on the receiving conode, which replies with all its attendees.
+// Nonce and Signature are the ones of the ReconcileRequest of the
+// organizer holding the attendees.
+type ReconcileAttendees struct {
+	PopHash   []byte
+	Attendees []abstract.Point
+	Nonce     []byte
+	Signature crypto.SchnorrSig
+}
+
+// requestHash returns the hash of the ReconcileRequest the organizer signed.
+func (ra *ReconcileAttendees) requestHash() []byte {
+	rr := &ReconcileRequest{ID: ra.PopHash, Nonce: ra.Nonce,
+		Attendees: ra.Attendees}
+	return rr.Hash()
+}
+
+// ReconcileAttendeesReply holds the attendees of the replying conode if
+// PopStatus == PopStatusOK.
+type ReconcileAttendeesReply struct {
+	PopStatus int
+	PopHash   []byte
+	Attendees []abstract.Point
+}
+
+// RegisterAttendeesRequest adds attendees to a party that is not finalized
+// yet. If Propagate is set, the new attendees are sent to all conodes of
+// the party, so that they are not pruned by the finalization. It has to be
+// signed by the organizer.
+type RegisterAttendeesRequest struct {
+	ID        []byte
+	Attendees []abstract.Point
+	Propagate bool
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (rr *RegisterAttendeesRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("register"))
+	h.Write(rr.ID)
+	for _, a := range rr.Attendees {
+		if _, err := a.MarshalTo(h); err != nil {
+			return nil, err
+		}
+	}
+	if rr.Propagate {
+		h.Write([]byte("propagate"))
+	}
+	h.Write(rr.Nonce)
+	return h.Sum(nil), nil
+}
+
+// RegisterAttendeesReply holds the number of attendees of the party on the
+// conode and the number of conodes that received the new attendees, which
+// is 0 if they have not been propagated.
+type RegisterAttendeesReply struct {
+	NumAttendees int
+	NumConodes   int
+}
+
+// PropagateAttendees holds the attendees newly registered for a party,
+// which the conodes add to their draft of the party. It is only accepted
+// from a conode of the party, which got the attendees from its organizer.
+// Applying it more than once, or after the party is finalized, changes
+// nothing.
+type PropagateAttendees struct {
+	PopHash   []byte
+	Attendees []abstract.Point
+}
+
+// PropagateAttendeesReply tells whether the conode stored the attendees.
+// PopStatus is PopStatusOK if it holds all of them.
+type PropagateAttendeesReply struct {
+	PopStatus int
+	PopHash   []byte
+}
+
+// GetChallenge asks the conode for a new nonce, that has to be signed
+// together with the next administrative request, so that the signature
+// can't be replayed.
+type GetChallenge struct {
+}
+
+// GetChallengeReply holds the nonce, which is valid for Config.ChallengeTimeout
+// and can be used only once.
+type GetChallengeReply struct {
+	Nonce []byte
+}
+
+// TransferRequest hands a party to the organizer with the given public
+// key. It has to be signed by the current owner of the party.
+type TransferRequest struct {
+	ID        []byte
+	Public    abstract.Point
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the current owner signs, binding the party to
+// the new key.
+func (tr *TransferRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	_, err := h.Write(tr.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tr.Public.MarshalTo(h)
+	if err != nil {
+		return nil, err
+	}
+	_, err = h.Write(tr.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// RotateAttendeeRequest replaces the key of an attendee in the attendees
+// of a party that is not finalized yet. Proof is the signature of the old
+// key of RotationMsg, Signature the one of the organizer of Hash.
+type RotateAttendeeRequest struct {
+	ID        []byte
+	Old       abstract.Point
+	New       abstract.Point
+	Proof     crypto.SchnorrSig
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// RotationMsg returns the message an attendee signs with its old key to
+// move its registration for the party with the given hash to newKey. It
+// starts with a tag, so that the signature can't be taken for one of
+// another message.
+func RotationMsg(id []byte, newKey abstract.Point) ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("PoP rotation:"))
+	h.Write(id)
+	if _, err := newKey.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Hash returns the message the organizer signs.
+func (rr *RotateAttendeeRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("rotate attendee"))
+	h.Write(rr.ID)
+	for _, p := range []abstract.Point{rr.Old, rr.New} {
+		if _, err := p.MarshalTo(h); err != nil {
+			return nil, err
+		}
+	}
+	h.Write(rr.Proof)
+	h.Write(rr.Nonce)
+	return h.Sum(nil), nil
+}
+
+// RevokeAttendeeRequest removes an attendee from a finalized party. It is
+// answered with a FinalizeResponse once all conodes revoked the attendee.
+type RevokeAttendeeRequest struct {
+	ID        []byte
+	Attendee  abstract.Point
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (rr *RevokeAttendeeRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	h.Write([]byte("revoke attendee"))
+	h.Write(rr.ID)
+	if _, err := rr.Attendee.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	h.Write(rr.Nonce)
+	return h.Sum(nil), nil
+}
+
+// PruneMergedRequest asks the conode to remove the parties that have been
+// merged into another party it stores. The entries under the hashes of the
+// sub-parties that already hold the merged statement are kept, as they
+// lead to the merged party. The statements the sub-parties signed before
+// the merge, which are needed to verify the tokens created before the
+// merge, are only removed if Force is set.
+type PruneMergedRequest struct {
+	Force     bool
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (pr *PruneMergedRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	if _, err := h.Write([]byte("prune merged")); err != nil {
+		return nil, err
+	}
+	if pr.Force {
+		if _, err := h.Write([]byte("force")); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := h.Write(pr.Nonce); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// PruneMergedReply holds the hashes of the removed parties.
+type PruneMergedReply struct {
+	Removed [][]byte
+}
+
+// ListPendingRequest asks the conode for the parties that have finalize or
+// merge operations waiting for replies of other conodes.
+type ListPendingRequest struct {
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (lr *ListPendingRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	if _, err := h.Write([]byte("list pending")); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(lr.Nonce); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// ListPendingReply holds the hashes of the parties with pending operations.
+type ListPendingReply struct {
+	Pending [][]byte
+}
+
+// CancelPendingRequest asks the conode to abort the operations of the
+// party with the given ID that wait for replies of other conodes.
+type CancelPendingRequest struct {
+	ID        []byte
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (cr *CancelPendingRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	if _, err := h.Write([]byte("cancel pending")); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(cr.ID); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(cr.Nonce); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// CancelPendingReply is returned once the operations are cancelled.
+type CancelPendingReply struct {
+}
+
+// BackupRequest asks the conode for a dump of the parties it stores for
+// the organizer signing the request, see Client.Backup.
+type BackupRequest struct {
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (br *BackupRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	if _, err := h.Write([]byte("backup")); err != nil {
+		return nil, err
+	}
+	if _, err := h.Write(br.Nonce); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// BackupReply holds the versioned dump of the parties, which can be given
+// to RestoreRequest.
+type BackupReply struct {
+	Dump []byte
+}
+
+// RestoreRequest adds the parties of a dump returned by BackupRequest to
+// the ones stored on the conode.
+type RestoreRequest struct {
+	Dump      []byte
+	Signature crypto.SchnorrSig
+	// Nonce is the challenge of the conode, see GetChallenge
+	Nonce []byte
+}
+
+// Hash returns the message the organizer signs.
+func (rr *RestoreRequest) Hash() ([]byte, error) {
+	h := network.Suite.Hash()
+	for _, b := range [][]byte{[]byte("restore"), rr.Dump, rr.Nonce} {
+		if _, err := h.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// RestoreReply holds the hashes of the restored parties.
+type RestoreReply struct {
+	Restored [][]byte
+}
+
+// GetAggregateRequest asks for the aggregate public key of the roster of a
+// finalized party
+type GetAggregateRequest struct {
+	ID []byte
+}
+
+// GetAggregateReply holds the marshalled aggregate public key of the roster
+type GetAggregateReply struct {
+	Aggregate []byte
+}
+
+// GetPartyRequest asks for the location and the roster of a party given by
+// the hash of its ShortDesc
+type GetPartyRequest struct {
+	ID []byte
+}
+
+// GetPartyReply holds the resolved party
+type GetPartyReply struct {
+	Party *ShortDesc
+}
+
+// GetSubPartiesRequest asks for the parties a merged party consists of
+type GetSubPartiesRequest struct {
+	ID []byte
+}
+
+// SubParty is the hash of the ShortDesc of a merged party and its location.
+// The location is empty if the party is compact and its location is not
+// known to the conode.
+type SubParty struct {
+	ID       []byte
+	Location string
+}
+
+// GetSubPartiesReply holds the parties of the merged party
+type GetSubPartiesReply struct {
+	Parties []*SubParty
+}
+
+// FindPartyRequest asks for the parties with the given name and date. An
+// empty DateTime matches all dates.
+type FindPartyRequest struct {
+	Name     string
+	DateTime string
+}
+
+// FindPartyReply holds the hashes of all matching parties
+type FindPartyReply struct {
+	IDs [][]byte
+}
+
+// AuditRequest asks the conode to verify all stored final statements
+type AuditRequest struct {
+}
+
+// AuditReply holds the hashes of the finalized parties whose signature
+// doesn't verify anymore
+type AuditReply struct {
+	Failed [][]byte
 }
@@ -33,10 +33,13 @@ main(){
 	test OrgFinal1
 	test OrgFinal2
 	test OrgFinal3
+	test OrgExport
+	test OrgAttendees
 	test AtJoin
 	test AtSign
 	test AuthStore
 	test AtVerify
+	test AtVerifyRemote
 	test AtMultipleKey
 	test Merge
 	stopTest
@@ -178,6 +181,16 @@ testAtVerify(){
 	testOK runCl 1 attendee verify msg1 ctx1 ${sig[3]} ${tag[3]} ${pop_hash[3]}
 }
 
+testAtVerifyRemote(){
+	mkClSign
+	# cl4 only knows the party hash and the token
+	testFail runCl 4 attendee verify msg1 ctx1 ${sig[1]} ${tag[1]} ${pop_hash[1]}
+	testOK runCl 4 attendee verify -a ${addr[1]} msg1 ctx1 ${sig[1]} ${tag[1]} ${pop_hash[1]}
+	testOK runCl 4 attendee verify -a ${addr[2]} msg1 ctx1 ${sig[1]} ${tag[1]} ${pop_hash[1]}
+	testFail runCl 4 attendee verify -a ${addr[1]} msg1 ctx1 ${sig[1]} ${tag[1]} ${pop_hash[2]}
+	testFail runCl 4 attendee verify -a ${addr[1]} msg2 ctx1 ${sig[1]} ${tag[1]} ${pop_hash[1]}
+}
+
 testAuthStore(){
 	mkFinal
 	testFail runCl 1 auth store
@@ -274,6 +287,27 @@ mkFinal(){
 	runDbgCl 1 1 org final  ${pop_hash[3]} | tail -n +3 > final3.toml
 }
 
+testOrgExport(){
+	mkFinal
+	testFail runCl 1 org export
+	testFail runCl 1 org export bad_hash
+	testFail runCl 1 org export -f xml ${pop_hash[1]}
+	for f in toml json bin; do
+		testOK runCl 1 org export -f $f -o final.$f ${pop_hash[1]}
+		testOK runCl 4 org import final.$f
+	done
+	testGrep ${pop_hash[1]} runCl 4 org import final.json
+	testFail runCl 4 org import bad_file
+}
+
+testOrgAttendees(){
+	mkFinal
+	testFail runCl 1 org attendees
+	testFail runCl 1 org attendees bad_hash
+	testOK runCl 1 org attendees -o atts.txt ${pop_hash[1]}
+	testGrep "$( wc -l < atts.txt )" runCl 1 org attendees --count ${pop_hash[1]}
+}
+
 testOrgFinal3(){
 	mkConfig 3 3 2 1
 	runCl 1 org public ${pub[1]} ${pop_hash[1]}
@@ -319,7 +353,8 @@ testOrgFinal1(){
 	testFail runCl 1 org final
 	testFail runCl 1 org final bad_hash
 	testFail runCl 1 org final ${pop_hash[1]}
-	testOK runCl 2 org final ${pop_hash[1]}
+	testOK runCl 2 org final --out final_out.toml ${pop_hash[1]}
+	testOK [ -s final_out.toml ]
 }
 
 testOrgPublic2(){